@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// addressBook maps aliases to their email addresses, loaded from
+// --addressBookFile
+var addressBook map[string]rcpts
+
+// loadAddressBook reads the YAML (or JSON) address book file, in which an
+// alias may also list other aliases and ldap: recipients.
+func loadAddressBook(file string) (map[string]rcpts, error) {
+	bookBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address book file %s: %v", file, err)
+	}
+	return parseAddressBook(bookBytes, "file "+file)
+}
+
+// parseAddressBook parses the address book from the source, the file or
+// config file it is in.
+func parseAddressBook(bookBytes []byte, source string) (map[string]rcpts, error) {
+	var parsed map[string]contactAddresses
+	if err := yaml.Unmarshal(bookBytes, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse address book %s: %v", source, err)
+	}
+	book := make(map[string]rcpts, len(parsed))
+	for alias := range parsed {
+		addresses, err := resolveAlias(parsed, alias, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address book %s: %v", source, err)
+		}
+		book[alias] = uniqueRcpts(addresses)
+	}
+	return book, nil
+}
+
+// resolveAlias returns the email addresses of the alias, expanding any
+// aliases it lists. The aliases being resolved are used to detect cycles.
+func resolveAlias(parsed map[string]contactAddresses, alias string, resolving []string) (rcpts, error) {
+	for _, r := range resolving {
+		if r == alias {
+			return nil, fmt.Errorf("alias %s includes itself via %s", alias, strings.Join(append(resolving, alias), " -> "))
+		}
+	}
+	var addresses rcpts
+	for _, entry := range ldapRcptTokens(newRcpts(parsed[alias])) {
+		if _, ok := parsed[entry]; ok {
+			expanded, err := resolveAlias(parsed, entry, append(resolving, alias))
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, expanded...)
+			continue
+		}
+		if !strings.Contains(entry, "@") && !strings.HasPrefix(entry, ldapRcptPrefix) {
+			return nil, fmt.Errorf("alias %s lists unknown alias %s", alias, entry)
+		}
+		addresses = append(addresses, entry)
+	}
+	return addresses, nil
+}
+
+// expandAliases replaces the aliases in the recipients with their addresses.
+// Recipients that are neither an alias, an email address nor an ldap:
+// recipient are dropped.
+func expandAliases(recipients rcpts) rcpts {
+	if addressBook == nil {
+		return recipients
+	}
+	var expanded rcpts
+	for _, r := range recipients {
+		if addresses, ok := addressBook[r]; ok {
+			expanded = append(expanded, addresses...)
+			continue
+		}
+		if !strings.Contains(r, "@") && !strings.HasPrefix(r, ldapRcptPrefix) {
+			fmt.Printf("Unknown alias %s\n", r)
+			continue
+		}
+		expanded = append(expanded, r)
+	}
+	return expanded
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressBook(t *testing.T) {
+	file := writeRoutingRules(t, `oncall-db: dba@example.com, DB Lead <db-lead@example.com>
+netops:
+  - net1@example.com
+  - net2@example.com
+infra: [oncall-db, netops, net1@example.com]
+`)
+	defer os.Remove(file)
+	book, err := loadAddressBook(file)
+	assert.NoError(t, err)
+	assert.Equal(t, rcpts{"dba@example.com", "DB Lead <db-lead@example.com>", "net1@example.com", "net2@example.com"}, book["infra"])
+
+	addressBook = book
+	config.ToEmail = []string{"oncall-db, ops@example.com"}
+	config.CcEmail = []string{"netops", "unknown"}
+	defer func() {
+		addressBook = nil
+		config.ToEmail = nil
+		config.CcEmail = nil
+	}()
+	to, cc, _ := eventRcpts(corev2.FixtureEvent("foo", "bar"))
+	assert.Equal(t, rcpts{"dba@example.com", "DB Lead <db-lead@example.com>", "ops@example.com"}, to)
+	assert.Equal(t, rcpts{"net1@example.com", "net2@example.com"}, cc)
+
+	for _, invalid := range []string{"a: b\nb: [a]\n", "a: unknown\n", "a: [b@example.com\n"} {
+		assert.NoError(t, ioutil.WriteFile(file, []byte(invalid), 0600))
+		_, err := loadAddressBook(file)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	htemplate "html/template"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// ansiEscape matches ANSI escape sequences: CSI sequences such as colors and
+// cursor movement, OSC sequences such as terminal titles and hyperlinks, and
+// the other two character escapes. The first submatch is the parameters of a
+// CSI sequence, the second its final byte.
+var ansiEscape = regexp.MustCompile("\x1b\\[([0-9;:?]*)[ -/]*([@-~])|\x1b\\][^\x07\x1b]*(?:\x07|\x1b\\\\)|\x1b[@-Z\\\\-_]")
+
+// ansiColors are the colors of the standard (30-37) and bright (90-97) ANSI
+// foreground colors, also used for the background colors.
+var ansiColors = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+// removeANSI removes ANSI escape sequences from the text, for the StripANSI
+// template function and --stripANSI.
+func removeANSI(text string) string {
+	if !strings.Contains(text, "\x1b") {
+		return text
+	}
+	return ansiEscape.ReplaceAllString(text, "")
+}
+
+// stripEventANSI removes ANSI escape sequences from the output of the check
+// and its hooks.
+func stripEventANSI(event *corev2.Event) {
+	if event == nil || event.Check == nil {
+		return
+	}
+	event.Check.Output = removeANSI(event.Check.Output)
+	for _, hook := range event.Check.Hooks {
+		if hook != nil {
+			hook.Output = removeANSI(hook.Output)
+		}
+	}
+}
+
+// ansiStyle is the text style set by ANSI SGR (select graphic rendition)
+// sequences.
+type ansiStyle struct {
+	bold, italic, underline bool
+	fg, bg                  string
+}
+
+// apply updates the style with the SGR parameters.
+func (s *ansiStyle) apply(params string) {
+	if len(params) == 0 {
+		*s = ansiStyle{}
+		return
+	}
+	codes := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(codes[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case code == 0:
+			*s = ansiStyle{}
+		case code == 1:
+			s.bold = true
+		case code == 3:
+			s.italic = true
+		case code == 4:
+			s.underline = true
+		case code == 22:
+			s.bold = false
+		case code == 23:
+			s.italic = false
+		case code == 24:
+			s.underline = false
+		case code >= 30 && code <= 37:
+			s.fg = ansiColors[code-30]
+		case code >= 90 && code <= 97:
+			s.fg = ansiColors[code-90+8]
+		case code == 39:
+			s.fg = ""
+		case code >= 40 && code <= 47:
+			s.bg = ansiColors[code-40]
+		case code >= 100 && code <= 107:
+			s.bg = ansiColors[code-100+8]
+		case code == 49:
+			s.bg = ""
+		case (code == 38 || code == 48) && i+2 < len(codes) && codes[i+1] == "5":
+			// 256 color palette, only the first 16 colors are supported
+			if n, err := strconv.Atoi(codes[i+2]); err == nil && n >= 0 && n < 16 {
+				if code == 38 {
+					s.fg = ansiColors[n]
+				} else {
+					s.bg = ansiColors[n]
+				}
+			}
+			i += 2
+		case (code == 38 || code == 48) && i+4 < len(codes) && codes[i+1] == "2":
+			// 24 bit color
+			var rgb [3]int
+			for j := range rgb {
+				rgb[j], _ = strconv.Atoi(codes[i+2+j])
+			}
+			color := "rgb(" + strconv.Itoa(rgb[0]) + "," + strconv.Itoa(rgb[1]) + "," + strconv.Itoa(rgb[2]) + ")"
+			if code == 38 {
+				s.fg = color
+			} else {
+				s.bg = color
+			}
+			i += 4
+		}
+	}
+}
+
+// css returns the inline CSS for the style, or an empty string for the
+// default style.
+func (s ansiStyle) css() string {
+	var rules []string
+	if s.bold {
+		rules = append(rules, "font-weight: bold;")
+	}
+	if s.italic {
+		rules = append(rules, "font-style: italic;")
+	}
+	if s.underline {
+		rules = append(rules, "text-decoration: underline;")
+	}
+	if len(s.fg) > 0 {
+		rules = append(rules, "color: "+s.fg+";")
+	}
+	if len(s.bg) > 0 {
+		rules = append(rules, "background-color: "+s.bg+";")
+	}
+	return strings.Join(rules, " ")
+}
+
+// ansiToHTML converts the ANSI colors and text styles in the text to HTML
+// spans, escaping the text and removing any other escape sequences, for the
+// ANSIToHTML template function.
+func ansiToHTML(text string) htemplate.HTML {
+	var (
+		b     strings.Builder
+		style ansiStyle
+		open  bool
+	)
+	last := 0
+	for _, m := range ansiEscape.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(htemplate.HTMLEscapeString(text[last:m[0]]))
+		last = m[1]
+		// only SGR sequences, ending in m, change the style
+		if m[4] < 0 || text[m[4]:m[5]] != "m" {
+			continue
+		}
+		style.apply(text[m[2]:m[3]])
+		if open {
+			b.WriteString("</span>")
+			open = false
+		}
+		if css := style.css(); len(css) > 0 {
+			b.WriteString(`<span style="` + css + `">`)
+			open = true
+		}
+	}
+	b.WriteString(htemplate.HTMLEscapeString(text[last:]))
+	if open {
+		b.WriteString("</span>")
+	}
+	return htemplate.HTML(b.String())
+}
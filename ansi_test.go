@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveANSI(t *testing.T) {
+	assert.Equal(t, "CRITICAL: disk full", removeANSI("\x1b[1;31mCRITICAL\x1b[0m: disk full"))
+	assert.Equal(t, "progress done", removeANSI("progress\x1b[2K\x1b[1G done"))
+	assert.Equal(t, "title link", removeANSI("\x1b]0;title\x07title \x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\"))
+	assert.Equal(t, "plain", removeANSI("plain"))
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = "\x1b[32mOK\x1b[0m"
+	hook := corev2.FixtureHook("hook")
+	hook.Output = "\x1b[33mwarn\x1b[m"
+	event.Check.Hooks = []*corev2.Hook{hook}
+	stripEventANSI(event)
+	assert.Equal(t, "OK", event.Check.Output)
+	assert.Equal(t, "warn", event.Check.Hooks[0].Output)
+
+	event.Check.Output = "\x1b[31mdisk full\x1b[0m"
+	out, err := resolveTemplate("{{StripANSI .Check.Output}}", event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "disk full", out)
+}
+
+func TestANSIToHTML(t *testing.T) {
+	assert.Equal(t, `<span style="font-weight: bold; color: #cd3131;">CRITICAL</span>: &lt;disk&gt; full`,
+		string(ansiToHTML("\x1b[1;31mCRITICAL\x1b[0m: <disk> full")))
+	assert.Equal(t, `<span style="color: #0dbc79;">ok </span><span style="color: #0dbc79; background-color: #ffffff;">inverse</span>`,
+		string(ansiToHTML("\x1b[32mok \x1b[107minverse")))
+	assert.Equal(t, `<span style="color: rgb(255,128,0);">orange</span> <span style="background-color: #f14c4c;">red</span>`,
+		string(ansiToHTML("\x1b[38;2;255;128;0morange\x1b[39m \x1b[48;5;9mred\x1b[49m")))
+	assert.Equal(t, "moved", string(ansiToHTML("\x1b[2Kmoved")))
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = "\x1b[33mWARNING\x1b[0m"
+	out, err := resolveTemplate("<html><pre>{{ANSIToHTML .Check.Output}}</pre></html>", event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Equal(t, `<html><pre><span style="color: #e5e510;">WARNING</span></pre></html>`, out)
+}
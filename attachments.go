@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// truncateBody truncates a body larger than --maxBodySize, adding a notice
+// that the body was truncated, and returns the full body as an attachment.
+func truncateBody(body, contentType string) (string, *mailer.Part, error) {
+	filename := "body.txt"
+	if contentType == ContentHTML {
+		filename = "body.html"
+	}
+
+	converted, err := bodyEncoding.NewEncoder().String(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to convert body to %s: %v", config.Charset, err)
+	}
+	attachment := mailer.NewText(contentType, map[string]string{"charset": config.Charset}, []byte(converted))
+	attachment.Header.Add("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	// never split a character or, in HTML, a tag
+	end := int(config.MaxBodySize)
+	for end > 0 && !utf8.RuneStart(body[end]) {
+		end--
+	}
+	truncated := body[:end]
+	if contentType == ContentHTML {
+		if i := strings.LastIndex(truncated, "<"); i > strings.LastIndex(truncated, ">") {
+			truncated = truncated[:i]
+		}
+	}
+
+	notice := fmt.Sprintf("The body was truncated from %d to %d bytes, the full body is attached as %s.", len(body), len(truncated), filename)
+	if contentType == ContentHTML {
+		return truncated + "\n<p><b>" + html.EscapeString(notice) + "</b></p>\n", attachment, nil
+	}
+	return truncated + "\n\n[" + notice + "]\n", attachment, nil
+}
+
+// eventJSONAttachment returns the event (or events of a digest) as a
+// pretty-printed JSON attachment. The events are marshaled after their output
+// has had ANSI escape sequences stripped and sensitive values redacted, rather
+// than attached as read from stdin.
+func eventJSONAttachment(event *corev2.Event) (*mailer.Part, error) {
+	filename := "event.json"
+	var v interface{} = event
+	if len(digestEvents) > 0 {
+		filename = "events.json"
+		v = digestEvents
+	}
+	indented, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to format event JSON: %v", err)
+	}
+	attachment := mailer.NewText("application/json", nil, append(indented, '\n'))
+	attachment.Header.Add("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	return attachment, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestTruncateBody(t *testing.T) {
+	config.Charset = defaultCharset
+	bodyEncoding = unicode.UTF8
+	config.MaxBodySize = 9
+	defer func() {
+		config.Charset = ""
+		config.MaxBodySize = 0
+	}()
+
+	body, attachment, err := truncateBody("output: é and more", ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "output: \n\n[The body was truncated from 19 to 8 bytes, the full body is attached as body.txt.]\n", body)
+	entity := string(attachment.Bytes())
+	assert.Contains(t, entity, "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, entity, "Content-Disposition: attachment; filename=body.txt\r\n")
+	assert.Contains(t, entity, "output: =C3=A9 and more")
+
+	config.MaxBodySize = 13
+	body, attachment, err = truncateBody("<p>output: <b>é</b></p>", ContentHTML)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(body, "<p>output: \n<p><b>The body was truncated from 24 to 11 bytes"), body)
+	assert.Contains(t, string(attachment.Bytes()), "filename=body.html")
+}
+
+func TestComposeAndTransmitMaxBodySize(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.Charset = defaultCharset
+	config.MaxBodySize = 100
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.Charset = ""
+		config.MaxBodySize = 0
+	}()
+
+	output := strings.Repeat("chatty check output\n", 100)
+	err := composeAndTransmit(corev2.FixtureEvent("foo", "bar"), newMessageID(), rcpts{"ops@example.com"}, nil, nil, "subject", output, ContentPlain)
+	assert.NoError(t, err)
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-messages))
+	assert.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	inline, err := reader.NextPart()
+	assert.NoError(t, err)
+	inlineBody, err := ioutil.ReadAll(inline)
+	assert.NoError(t, err)
+	assert.Contains(t, string(inlineBody), "the full body is attached as body.txt")
+	assert.True(t, len(inlineBody) < len(output))
+
+	attached, err := reader.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "body.txt", attached.FileName())
+	attachedBody, err := ioutil.ReadAll(attached)
+	assert.NoError(t, err)
+	assert.Equal(t, output, string(attachedBody))
+}
+
+func TestEventJSONAttachment(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Entity.Redact = []string{"password"}
+	event.Check.Output = "login failed for password=hunter2"
+	eventJSON = []byte(`{"check":{"output":"login failed for password=hunter2"}}`)
+	defer func() { eventJSON = nil }()
+	redactEvent(event)
+
+	attachment, err := eventJSONAttachment(event)
+	assert.NoError(t, err)
+	entity := string(attachment.Bytes())
+	assert.Contains(t, entity, "Content-Type: application/json\r\n")
+	assert.Contains(t, entity, "Content-Disposition: attachment; filename=event.json\r\n")
+	// the redacted event is attached, not the one read from stdin
+	msg, err := mail.ReadMessage(strings.NewReader(entity))
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+	assert.NoError(t, err)
+	var attached corev2.Event
+	assert.NoError(t, json.Unmarshal(body, &attached))
+	assert.Equal(t, "foo", attached.Entity.Name)
+	assert.Equal(t, "login failed for password=REDACTED", attached.Check.Output)
+	assert.NotContains(t, string(body), "hunter2")
+
+	digestEvents = []*corev2.Event{corev2.FixtureEvent("foo", "bar"), corev2.FixtureEvent("foo", "baz")}
+	defer func() { digestEvents = nil }()
+	attachment, err = eventJSONAttachment(event)
+	assert.NoError(t, err)
+	entity = string(attachment.Bytes())
+	assert.Contains(t, entity, "filename=events.json")
+	assert.Contains(t, entity, `"name": "baz"`)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// the mechanisms --authMethod auto chooses from, strongest first
+var autoAuthMechanisms = []string{"XOAUTH2", "CRAM-MD5", "PLAIN", "LOGIN"}
+
+// autoAuth picks the strongest mechanism that both the server offers in its
+// EHLO response and the configured credentials allow, delegating to it.
+type autoAuth struct {
+	username, password, token, host string
+	auth                            smtp.Auth
+}
+
+// AutoAuth returns an smtp.Auth negotiating the mechanism with the server.
+// XOAUTH2 is only used with a token, and the others only with a password.
+// Without TLS only CRAM-MD5, which never sends the password, is used, unless
+// the server is localhost as with smtp.PlainAuth.
+func AutoAuth(username, password, token, host string) smtp.Auth {
+	return &autoAuth{username: username, password: password, token: token, host: host}
+}
+
+// Start chooses the mechanism and starts it.
+func (a *autoAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	offered := make(map[string]bool, len(server.Auth))
+	for _, mechanism := range server.Auth {
+		offered[strings.ToUpper(mechanism)] = true
+	}
+	encrypted := server.TLS || isLocalhost(server.Name)
+	for _, mechanism := range autoAuthMechanisms {
+		if !offered[mechanism] || (!encrypted && mechanism != "CRAM-MD5") {
+			continue
+		}
+		switch {
+		case mechanism == "XOAUTH2" && len(a.token) > 0:
+			a.auth = XOAUTH2Auth(a.username, a.token)
+		case mechanism == "XOAUTH2" || len(a.password) == 0:
+			continue
+		case mechanism == "CRAM-MD5":
+			a.auth = smtp.CRAMMD5Auth(a.username, a.password)
+		case mechanism == "PLAIN":
+			a.auth = smtp.PlainAuth("", a.username, a.password, a.host)
+		case mechanism == "LOGIN":
+			a.auth = LoginAuth(a.username, a.password)
+		}
+		debugf("the server offers AUTH %s, using %s", strings.Join(server.Auth, " "), mechanism)
+		return a.auth.Start(server)
+	}
+	if !encrypted {
+		return "", nil, fmt.Errorf("the server offers AUTH %s over an unencrypted connection, on which only CRAM-MD5 can be used",
+			strings.Join(server.Auth, " "))
+	}
+	return "", nil, fmt.Errorf("the server offers AUTH %s, none of which can be used with the configured credentials (supported: %s)",
+		strings.Join(server.Auth, " "), strings.Join(autoAuthMechanisms, ", "))
+}
+
+// isLocalhost reports whether the server name is the local host, to which
+// net/smtp sends credentials without TLS.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// Next continues the chosen mechanism.
+func (a *autoAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if a.auth == nil {
+		return nil, errors.New("no authentication mechanism was chosen")
+	}
+	return a.auth.Next(fromServer, more)
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism of Google and Microsoft, which
+// authenticates with an OAuth 2.0 access token.
+type xoauth2Auth struct {
+	username, token string
+}
+
+// XOAUTH2Auth returns an smtp.Auth authenticating the user with the OAuth
+// 2.0 bearer token.
+func XOAUTH2Auth(username, token string) smtp.Auth {
+	return &xoauth2Auth{username, token}
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+// Next answers the server's error challenge with an empty response, after
+// which it fails the authentication with its error.
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		debugf("XOAUTH2 error from server: %s", fromServer)
+		return []byte{}, nil
+	}
+	return nil, nil
+}
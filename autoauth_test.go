@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/smtp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoAuth(t *testing.T) {
+	tests := []struct {
+		offered         []string
+		password, token string
+		expected        string
+	}{
+		{[]string{"LOGIN", "PLAIN", "CRAM-MD5", "XOAUTH2"}, "s3cret", "t0ken", "XOAUTH2"},
+		{[]string{"LOGIN", "PLAIN", "CRAM-MD5", "XOAUTH2"}, "s3cret", "", "CRAM-MD5"},
+		{[]string{"login", "plain"}, "s3cret", "", "PLAIN"},
+		{[]string{"LOGIN", "NTLM"}, "s3cret", "", "LOGIN"},
+		{[]string{"PLAIN", "XOAUTH2"}, "", "t0ken", "XOAUTH2"},
+	}
+	for _, test := range tests {
+		auth := AutoAuth("sensu", test.password, test.token, "relay.example.com")
+		mechanism, _, err := auth.Start(&smtp.ServerInfo{Name: "relay.example.com", TLS: true, Auth: test.offered})
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, mechanism, test.offered)
+	}
+
+	auth := AutoAuth("sensu", "", "t0ken", "relay.example.com")
+	_, resp, err := auth.Start(&smtp.ServerInfo{TLS: true, Auth: []string{"XOAUTH2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "user=sensu\x01auth=Bearer t0ken\x01\x01", string(resp))
+	// the error challenge is answered so the server fails the authentication
+	resp, err = auth.Next([]byte(`{"status":"401"}`), true)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, resp)
+
+	_, _, err = AutoAuth("sensu", "", "t0ken", "").Start(&smtp.ServerInfo{TLS: true, Auth: []string{"PLAIN", "GSSAPI"}})
+	assert.EqualError(t, err, "the server offers AUTH PLAIN GSSAPI, none of which can be used with the configured credentials (supported: XOAUTH2, CRAM-MD5, PLAIN, LOGIN)")
+
+	// without TLS the password and token are only sent to localhost
+	mechanism, _, err := AutoAuth("sensu", "s3cret", "t0ken", "").Start(&smtp.ServerInfo{Name: "relay.example.com", Auth: []string{"LOGIN", "CRAM-MD5", "XOAUTH2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "CRAM-MD5", mechanism)
+	_, _, err = AutoAuth("sensu", "s3cret", "t0ken", "").Start(&smtp.ServerInfo{Name: "relay.example.com", Auth: []string{"LOGIN", "XOAUTH2"}})
+	assert.EqualError(t, err, "the server offers AUTH LOGIN XOAUTH2 over an unencrypted connection, on which only CRAM-MD5 can be used")
+	mechanism, _, err = AutoAuth("sensu", "s3cret", "", "").Start(&smtp.ServerInfo{Name: "localhost", Auth: []string{"LOGIN"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "LOGIN", mechanism)
+}
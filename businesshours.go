@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// businessHourRanges are the weekly time ranges parsed from --businessHours
+var businessHourRanges []*maintenanceWindow
+
+// parseBusinessHours parses the --businessHours time ranges, each an
+// optional day or range of days followed by the start and end time, e.g.
+// "mon-fri 09:00-17:00" or "sat 10:00-14:00". Ranges without days apply to
+// every day.
+func parseBusinessHours(specs []string) ([]*maintenanceWindow, error) {
+	var ranges []*maintenanceWindow
+	for _, spec := range specs {
+		for _, part := range strings.Split(spec, ",") {
+			fields := strings.Fields(part)
+			if len(fields) == 0 {
+				continue
+			}
+			window := &maintenanceWindow{Name: strings.Join(fields, " ")}
+			if len(fields) > 2 {
+				return nil, fmt.Errorf("invalid business hours %q", window.Name)
+			}
+			if len(fields) == 2 {
+				days, err := expandWeekdays(fields[0])
+				if err != nil {
+					return nil, fmt.Errorf("invalid business hours %q: %v", window.Name, err)
+				}
+				window.Days = days
+			}
+			times := strings.SplitN(fields[len(fields)-1], "-", 2)
+			if len(times) != 2 {
+				return nil, fmt.Errorf("invalid business hours %q", window.Name)
+			}
+			window.Start, window.End = times[0], times[1]
+			if err := window.validate(); err != nil {
+				return nil, fmt.Errorf("business hours %s %v", window.Name, err)
+			}
+			ranges = append(ranges, window)
+		}
+	}
+	return ranges, nil
+}
+
+// expandWeekdays returns the days of a day or range of days, e.g. mon-fri or
+// fri-mon.
+func expandWeekdays(spec string) ([]string, error) {
+	bounds := strings.SplitN(spec, "-", 2)
+	first, ok := parseWeekday(bounds[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid day %s", bounds[0])
+	}
+	last := first
+	if len(bounds) == 2 {
+		if last, ok = parseWeekday(bounds[1]); !ok {
+			return nil, fmt.Errorf("invalid day %s", bounds[1])
+		}
+	}
+	days := []string{first.String()}
+	for day := first; day != last; {
+		day = (day + 1) % 7
+		days = append(days, day.String())
+	}
+	return days, nil
+}
+
+// inBusinessHours reports whether the time is within the business hours.
+func inBusinessHours(now time.Time) bool {
+	for _, window := range businessHourRanges {
+		if window.active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferred reports whether the email for the event is deferred at the time,
+// as it is outside business hours and the event is not critical.
+func deferred(event *corev2.Event, now time.Time) bool {
+	return len(businessHourRanges) > 0 && eventCheck(event).Status != 2 && !inBusinessHours(now)
+}
+
+// suppressOutsideBusinessHours returns the reason for not sending an email
+// for the event now, queuing it until business hours, or an empty string if
+// it should be sent.
+func suppressOutsideBusinessHours(event *corev2.Event) (string, error) {
+	if !deferred(event, time.Now()) {
+		return "", nil
+	}
+	if err := queueEvent(event); err != nil {
+		return "", err
+	}
+	return "deferred until business hours", nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBusinessHours(t *testing.T) {
+	days, err := expandWeekdays("fri-mon")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Friday", "Saturday", "Sunday", "Monday"}, days)
+
+	ranges, err := parseBusinessHours([]string{"mon-fri 09:00-17:00, sat 10:00-14:00"})
+	assert.NoError(t, err)
+	assert.Len(t, ranges, 2)
+	templateLocation = time.UTC
+	defer func() { templateLocation = time.Local }()
+	businessHourRanges = ranges
+	defer func() { businessHourRanges = nil }()
+
+	// Friday 5 March 2021
+	assert.True(t, inBusinessHours(time.Date(2021, 3, 5, 9, 0, 0, 0, time.UTC)))
+	assert.False(t, inBusinessHours(time.Date(2021, 3, 5, 17, 0, 0, 0, time.UTC)))
+	assert.True(t, inBusinessHours(time.Date(2021, 3, 6, 13, 59, 0, 0, time.UTC)))
+	assert.False(t, inBusinessHours(time.Date(2021, 3, 7, 12, 0, 0, 0, time.UTC)))
+
+	event := corev2.FixtureEvent("foo", "bar")
+	sunday := time.Date(2021, 3, 7, 12, 0, 0, 0, time.UTC)
+	event.Check.Status = 1
+	assert.True(t, deferred(event, sunday))
+	event.Check.Status = 2
+	assert.False(t, deferred(event, sunday))
+
+	for _, invalid := range []string{"mon-fri", "someday 09:00-17:00", "mon 09:00", "mon 9am-5pm", "mon tue 09:00-17:00"} {
+		_, err := parseBusinessHours([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDeferOutsideBusinessHours(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	defer func() { config.StateDir = "" }()
+	// business hours are never now
+	businessHourRanges = []*maintenanceWindow{{schedule: &cronSchedule{}, duration: time.Minute}}
+	defer func() { businessHourRanges = nil }()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 1
+	reason, err := suppressOutsideBusinessHours(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "deferred until business hours", reason)
+	event.Check.Status = 2
+	reason, err = suppressOutsideBusinessHours(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	queued, err := loadQueuedEvents()
+	assert.NoError(t, err)
+	assert.Len(t, queued, 1)
+	assert.Equal(t, uint32(1), queued[0].Check.Status)
+	assert.True(t, held(queued[0], time.Now()))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+)
+
+// defaultCaptureAddress is the address the local SMTP server of capture mode
+// listens on by default, only reachable from the host itself.
+const defaultCaptureAddress = "127.0.0.1:2525"
+
+// serveCapture runs a local SMTP server writing the emails it receives to
+// --captureDir, until the handler is terminated. The handler, or any other
+// program, can then be pointed at it to see the emails it would send.
+func serveCapture() error {
+	listener, err := net.Listen("tcp", config.CaptureAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", config.CaptureAddress, err)
+	}
+	defer listener.Close()
+	fmt.Printf("Capturing the emails sent to %s in %s\n", listener.Addr(), config.CaptureDir)
+	server := &transport.Server{
+		Sender: transport.Dir(config.CaptureDir),
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		},
+	}
+	return server.Serve(listener)
+}
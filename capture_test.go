@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageSender(t *testing.T) {
+	memory := &transport.Memory{}
+	messageSender = memory
+	config.SmtpHost = "127.0.0.1"
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.EnvelopeFrom = "bounces@example.com"
+	config.ToEmail = []string{"ops@example.com"}
+	config.BccEmail = []string{"audit@example.com"}
+	config.SubjectTemplate = "{{.Entity.Name}}/{{.Check.Name}} is {{StatusName .Check.Status}}"
+	config.BodyTemplate = "{{.Check.Output}}"
+	defer func() {
+		messageSender = nil
+		config.SmtpHost = ""
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.EnvelopeFrom = ""
+		config.ToEmail = nil
+		config.BccEmail = nil
+		config.SubjectTemplate = ""
+		config.BodyTemplate = ""
+		emailBodyTemplate = ""
+	}()
+
+	event := corev2.FixtureEvent("server01", "check-disk")
+	event.Check.Status = 2
+	event.Check.Output = "disk full"
+	event.Check.Occurrences = 1
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, handleEvent(event))
+
+	messages := memory.Messages()
+	if !assert.Len(t, messages, 1) {
+		return
+	}
+	assert.Equal(t, "bounces@example.com", messages[0].From)
+	assert.Equal(t, []string{"ops@example.com", "audit@example.com"}, messages[0].To)
+	assert.Equal(t, eventID(event), messages[0].ID)
+	msg, err := mail.ReadMessage(strings.NewReader(string(messages[0].Data)))
+	assert.NoError(t, err)
+	assert.Equal(t, "server01/check-disk is CRITICAL", msg.Header.Get("Subject"))
+	assert.Empty(t, msg.Header.Get("Bcc"))
+	body, err := ioutil.ReadAll(msg.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "disk full")
+}
+
+func TestCaptureDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config.CaptureDir = dir
+	config.FromEmail = "sensu@example.com"
+	defer func() {
+		config.CaptureDir = ""
+		config.FromEmail = ""
+	}()
+	// no SMTP server is needed
+	assert.NoError(t, transmit("", rcpts{"ops@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n")))
+	files, err := transport.Dir(dir).Files()
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		msg, err := ioutil.ReadFile(files[0])
+		assert.NoError(t, err)
+		assert.Equal(t, "X-Envelope-From: <sensu@example.com>\r\nX-Envelope-To: <ops@example.com>\r\nSubject: disk\r\n\r\ndisk full\r\n", string(msg))
+	}
+
+	mode = modeCapture
+	config.CaptureDir = ""
+	defer func() { mode = "" }()
+	assert.EqualError(t, checkArgs(corev2.FixtureEvent("foo", "bar")), "capture mode requires --captureDir")
+}
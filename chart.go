@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	htemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// the Content-ID of the chart image embedded in the email
+const chartImageCID = "chart@sensu-email-handler"
+
+// the largest chart image that will be embedded
+const maxChartImageSize = 10 << 20
+
+// chartImage returns the URL HTML templates use to reference the embedded
+// chart image, for the ChartImage template function.
+func chartImage() htemplate.URL {
+	return htemplate.URL("cid:" + chartImageCID)
+}
+
+// fetchChartImage fetches the image from the URL, returning its content type
+// and data.
+func fetchChartImage(imageURL string) (string, []byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(imageURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch chart image: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to fetch chart image: %s", resp.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return "", nil, fmt.Errorf("chart image URL returned %q, not an image", resp.Header.Get("Content-Type"))
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxChartImageSize+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch chart image: %v", err)
+	}
+	if len(data) > maxChartImageSize {
+		return "", nil, fmt.Errorf("chart image is larger than %d bytes", maxChartImageSize)
+	}
+	return mediaType, data, nil
+}
+
+// embedChartImage wraps the MIME entity in a multipart/related entity along
+// with the chart image from --chartImageURL, when the body references it.
+// Failing to fetch the image does not prevent the email being sent.
+func embedChartImage(entity *mailer.Part, body string, event *corev2.Event) (*mailer.Part, error) {
+	if len(config.ChartImageURL) == 0 || !strings.Contains(body, string(chartImage())) {
+		return entity, nil
+	}
+	imageURL, err := resolveTemplate(config.ChartImageURL, event, ContentPlain)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, data, err := fetchChartImage(imageURL)
+	if err != nil {
+		fmt.Printf("Not embedding chart image: %v\n", err)
+		return entity, nil
+	}
+
+	image := mailer.NewBase64(mediaType, nil, data)
+	image.Header.Add("Content-ID", "<"+chartImageCID+">")
+	image.Header.Add("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": "chart"}))
+	return mailer.NewMultipart("related", nil, entity, image), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbedChartImage(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		if strings.HasSuffix(r.URL.Path, ".txt") {
+			w.Header().Set("Content-Type", "text/plain")
+		} else {
+			w.Header().Set("Content-Type", "image/png")
+		}
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer server.Close()
+	config.ChartImageURL = server.URL + "/render/{{.Entity.Name}}/{{.Check.Name}}.png"
+	defer func() { config.ChartImageURL = "" }()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	body, err := resolveTemplate(`<html><img src="{{ChartImage}}"></html>`, event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Equal(t, `<html><img src="cid:chart@sensu-email-handler"></html>`, body)
+
+	htmlEntity := mailer.Raw([]byte("Content-Type: text/html\r\n\r\nbody\r\n"))
+	entity, err := embedChartImage(htmlEntity, body, event)
+	assert.NoError(t, err)
+	assert.Equal(t, "/render/foo/bar.png", requested)
+	related := string(entity.Bytes())
+	assert.True(t, strings.HasPrefix(related, "Content-Type: multipart/related;"))
+	assert.Contains(t, related, "Content-Type: text/html\r\n\r\nbody\r\n")
+	assert.Contains(t, related, "Content-Type: image/png\r\n")
+	assert.Contains(t, related, "Content-ID: <chart@sensu-email-handler>\r\n")
+	assert.Contains(t, related, "\r\ncG5n\r\n")
+
+	// the image is only fetched when referenced
+	requested = ""
+	entity, err = embedChartImage(htmlEntity, "no chart", event)
+	assert.NoError(t, err)
+	assert.Equal(t, htmlEntity, entity)
+	assert.Empty(t, requested)
+
+	// the email is still sent without the image
+	config.ChartImageURL = server.URL + "/chart.txt"
+	entity, err = embedChartImage(htmlEntity, body, event)
+	assert.NoError(t, err)
+	assert.Equal(t, htmlEntity, entity)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// composeEmail resolves the subject and body templates for the event,
+// returning them along with the content type of the body.
+func composeEmail(event *corev2.Event) (string, string, string, error) {
+	if len(config.SensuAPIURL) > 0 {
+		related, relatedErr := fetchRelatedEvents(event)
+		if relatedErr != nil {
+			fmt.Printf("Not including related events: %v\n", relatedErr)
+		}
+		relatedEvents = related
+	}
+
+	subjectTemplate, bodyTemplate := selectTemplates(event)
+	flapping := flappingStarted(event)
+	if flapping {
+		subjectTemplate = config.FlappingSubjectTemplate
+	}
+	subject, subjectErr := resolveTemplate(subjectTemplate, event, ContentPlain)
+	if subjectErr != nil {
+		if !config.FallbackOnTemplateError {
+			return "", "", "", subjectErr
+		}
+		subject = fallbackSubject(event)
+	}
+
+	if escalated(event) {
+		subject = config.EscalationSubjectPrefix + subject
+	}
+	debugf("resolved subject %q", subject)
+
+	contentType := bodyContentType(bodyTemplate)
+	body, bodyErr := resolveTemplate(bodyTemplate, event, contentType)
+	if (subjectErr != nil || bodyErr != nil) && config.FallbackOnTemplateError {
+		body, contentType = fallbackBody(firstError(subjectErr, bodyErr), event), ContentPlain
+	} else if bodyErr != nil {
+		return "", "", "", bodyErr
+	}
+	if config.Silenced == SilencedNote && len(silences) > 0 {
+		body = addSilencedNote(body, contentType, silences)
+	}
+	if flapping {
+		body = addNote(body, contentType, []string{flappingDescription(event)})
+	}
+	debugf("resolved %s body of %d bytes", contentType, len(body))
+	return subject, body, contentType, nil
+}
+
+// composeAndTransmit does the work of deliverMessage.
+func composeAndTransmit(event *corev2.Event, messageID string, to, cc, bcc rcpts, subject, body, contentType string) error {
+	recipients := envelopeRcpts(to, cc, bcc)
+
+	var (
+		entity      *mailer.Part
+		entityErr   error
+		attachments []*mailer.Part
+	)
+	if config.MaxBodySize > 0 && int64(len(body)) > config.MaxBodySize {
+		var attachment *mailer.Part
+		body, attachment, entityErr = truncateBody(body, contentType)
+		if entityErr != nil {
+			return entityErr
+		}
+		attachments = append(attachments, attachment)
+	}
+	// the link is added after any truncation, so it is never cut off
+	unsubscribe := unsubscribeLink(event)
+	if len(unsubscribe) > 0 {
+		body = addUnsubscribeFooter(body, contentType, unsubscribe)
+	}
+	if config.AttachEventJSON {
+		attachment, err := eventJSONAttachment(event)
+		if err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if config.BodyFormat == BodyFormatMarkdown {
+		entity, entityErr = markdownEntity(body)
+	} else {
+		entity, entityErr = textEntity(body, contentType)
+	}
+	if entityErr != nil {
+		return entityErr
+	}
+	if len(digestEvents) == 0 {
+		entity, entityErr = embedChartImage(entity, body, event)
+		if entityErr != nil {
+			return entityErr
+		}
+	}
+	t := time.Now()
+	message := mailer.Compose(mailer.Email{
+		From:        config.FromHeader,
+		To:          to,
+		Cc:          cc,
+		Subject:     subject,
+		Date:        t,
+		MessageID:   messageID,
+		Body:        entity,
+		Attachments: attachments,
+	})
+	header := &message.Header
+	if config.PrecedenceBulk {
+		header.Add("Precedence", "bulk")
+	}
+	if err := addExtraHeaders(header, event); err != nil {
+		return err
+	}
+	addSensuHeaders(header, event)
+	if config.PriorityHeaders && event.Check != nil {
+		addPriorityHeaders(header, event.Check.Status)
+	}
+	// a digest covers many entities/checks, so is never threaded
+	if config.Threading && len(digestEvents) == 0 {
+		if err := addThreadHeaders(header, event); err != nil {
+			return err
+		}
+	}
+	if len(unsubscribe) > 0 {
+		header.Add("List-Unsubscribe", "<"+unsubscribe+">")
+	}
+
+	if smime != nil {
+		signed, signErr := smime.sign(message.Body)
+		if signErr != nil {
+			return signErr
+		}
+		message.Body = signed
+	}
+
+	if len(pgpKeys) > 0 || len(config.PGPKeyserver) > 0 {
+		keys := pgpKeys
+		if len(config.PGPKeyserver) > 0 {
+			addresses := make([]string, len(recipients))
+			for i, r := range recipients {
+				addresses[i] = smtpsend.EnvelopeAddress(r)
+			}
+			fetched, fetchErr := fetchPGPKeys(config.PGPKeyserver, addresses)
+			if fetchErr != nil {
+				return fetchErr
+			}
+			keys = append(keys, fetched...)
+		}
+		encrypted, encryptErr := pgpEncrypt(message.Body, keys)
+		if encryptErr != nil {
+			return encryptErr
+		}
+		message.Body = encrypted
+	}
+
+	msg := message.Bytes()
+
+	if dkimKey != nil {
+		signed, signErr := dkimSign(msg, dkimKey, config.DKIMDomain, config.DKIMSelector, t)
+		if signErr != nil {
+			return signErr
+		}
+		msg = signed
+	}
+
+	return deliverOrSpool(event, recipients, msg)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// profilesKey is the key of the config file holding the named profiles
+// selected with --profile.
+const profilesKey = "profiles"
+
+// configSection is the content of a file given in the config file, along
+// with where in the config file it is.
+type configSection struct {
+	source string
+	data   []byte
+}
+
+// configSections are the routing rules, contacts and address book given in
+// --configFile, in place of --routingRulesFile, --contactsFile and
+// --addressBookFile.
+var configSections struct {
+	routingRules configSection
+	contacts     configSection
+	addressBook  configSection
+}
+
+// commandLineArg returns the value of the flag given on the command line,
+// for --configFile and --profile, which are needed before the other flags
+// are parsed.
+func commandLineArg(args []string, flag string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return ""
+		case arg == "--"+flag && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, "--"+flag+"="):
+			return strings.TrimPrefix(arg, "--"+flag+"=")
+		}
+	}
+	return ""
+}
+
+// loadCommandLineConfigFile loads the --configFile and --profile given on the
+// command line.
+func loadCommandLineConfigFile(args []string) error {
+	file, name := commandLineArg(args, configFile), commandLineArg(args, profile)
+	if len(file) == 0 {
+		if len(name) > 0 {
+			return fmt.Errorf("--%s requires --%s", profile, configFile)
+		}
+		return nil
+	}
+	return loadConfigFile(file, name, emailConfigOptions)
+}
+
+// loadConfigFile reads the YAML (or JSON) config file, keyed by the option
+// names, making its values the defaults of the options. Environment
+// variables, flags and annotations then take precedence over them as they do
+// over the built-in defaults. The routingRules, contacts and addressBook keys
+// hold what would otherwise be in their files. The values of the profile, if
+// any, of the file's profiles replace those given for all profiles.
+func loadConfigFile(file, profileName string, options []*sensu.PluginConfigOption) error {
+	configBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", file, err)
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(configBytes, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", file, err)
+	}
+	var profiles map[string]map[string]interface{}
+	if profilesValue, ok := values[profilesKey]; ok {
+		delete(values, profilesKey)
+		converted, err := configValue(profilesValue, reflect.TypeOf(profiles))
+		if err != nil {
+			return fmt.Errorf("invalid %s in config file %s: expected a map of profile names to options", profilesKey, file)
+		}
+		profiles = converted.(map[string]map[string]interface{})
+	}
+	if err := applyConfigValues(values, "in config file "+file, options); err != nil {
+		return err
+	}
+	if len(profileName) == 0 {
+		return nil
+	}
+	profileValues, ok := profiles[profileName]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("config file %s has no profile %s, only: %s", file, profileName, strings.Join(names, ", "))
+	}
+	return applyConfigValues(profileValues, fmt.Sprintf("in profile %s of config file %s", profileName, file), options)
+}
+
+// applyConfigValues makes the values, from the source in the config file,
+// the defaults of the options.
+func applyConfigValues(values map[string]interface{}, source string, options []*sensu.PluginConfigOption) error {
+	byName := make(map[string]*sensu.PluginConfigOption, len(options))
+	for _, opt := range options {
+		byName[opt.Argument] = opt
+	}
+	for name, value := range values {
+		var section *configSection
+		switch name {
+		case "routingRules":
+			section = &configSections.routingRules
+		case "contacts":
+			section = &configSections.contacts
+		case "addressBook":
+			section = &configSections.addressBook
+		case configFile, profile, profilesKey:
+			return fmt.Errorf("%s cannot be set %s", name, source)
+		}
+		if section != nil {
+			// parsed along with the files they replace
+			sectionBytes, err := yaml.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %s: %v", name, source, err)
+			}
+			*section = configSection{source: source, data: sectionBytes}
+			continue
+		}
+		opt, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unknown option %s %s", name, source)
+		}
+		typ := reflect.TypeOf(opt.Value).Elem()
+		optValue, err := configValue(value, typ)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s: expected %s", name, source, typeDescription(typ))
+		}
+		opt.Default = optValue
+	}
+	return nil
+}
+
+// configValue converts a value of the config file to the type of an option.
+// A single value is accepted for options taking a list.
+func configValue(value interface{}, typ reflect.Type) (interface{}, error) {
+	if typ.Kind() == reflect.Slice {
+		if _, ok := value.([]interface{}); !ok {
+			value = []interface{}{value}
+		}
+	}
+	valueBytes, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	converted := reflect.New(typ)
+	if err := yaml.UnmarshalStrict(valueBytes, converted.Interface()); err != nil {
+		return nil, err
+	}
+	return converted.Elem().Interface(), nil
+}
+
+// typeDescription describes the values of an option type.
+func typeDescription(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list of strings"
+	case reflect.Map:
+		return "a map of strings"
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "an integer"
+	default:
+		return "a number"
+	}
+}
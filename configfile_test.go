@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	file := filepath.Join(dir, "email.yml")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestCommandLineArg(t *testing.T) {
+	assert.Equal(t, "/etc/email.yml", commandLineArg([]string{"-t", "ops@example.com", "--configFile", "/etc/email.yml"}, configFile))
+	assert.Equal(t, "/etc/email.yml", commandLineArg([]string{"--configFile=/etc/email.yml", "-v"}, configFile))
+	assert.Equal(t, "prod-pager", commandLineArg([]string{"--configFile=/etc/email.yml", "--profile", "prod-pager"}, profile))
+	assert.Equal(t, "", commandLineArg([]string{"-t", "ops@example.com"}, configFile))
+	assert.Equal(t, "", commandLineArg([]string{"--configFile"}, configFile))
+	assert.Equal(t, "", commandLineArg([]string{"--", "--configFile", "/etc/email.yml"}, configFile))
+
+	assert.EqualError(t, loadCommandLineConfigFile([]string{"--profile", "prod-pager"}), "--profile requires --configFile")
+	assert.NoError(t, loadCommandLineConfigFile([]string{"-t", "ops@example.com"}))
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func() {
+		configSections.routingRules, configSections.contacts, configSections.addressBook = configSection{}, configSection{}, configSection{}
+	}()
+
+	var host string
+	var port uint64
+	var to []string
+	var debug bool
+	options := []*sensu.PluginConfigOption{
+		{Argument: smtpHost, Default: "", Value: &host},
+		{Argument: smtpPort, Default: uint64(587), Value: &port},
+		{Argument: toEmail, Default: []string{}, Value: &to},
+		{Argument: verbose, Default: false, Value: &debug},
+	}
+	file := writeConfigFile(t, dir, `
+smtpHost: smtp.example.com
+smtpPort: 25
+toEmail: ops@example.com
+verbose: true
+routingRules:
+  rules:
+    - check: disk-*
+      to: [storage@example.com]
+contacts:
+  dba: dba@example.com
+`)
+	assert.NoError(t, loadConfigFile(file, "", options))
+	assert.Equal(t, "smtp.example.com", options[0].Default)
+	assert.Equal(t, uint64(25), options[1].Default)
+	assert.Equal(t, []string{"ops@example.com"}, options[2].Default)
+	assert.Equal(t, true, options[3].Default)
+	assert.Equal(t, "in config file "+file, configSections.routingRules.source)
+
+	rules, err := parseRoutingRules(configSections.routingRules.data, configSections.routingRules.source)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"storage@example.com"}, rules.Rules[0].Dest.To)
+	loaded, err := parseContacts(configSections.contacts.data, configSections.contacts.source)
+	assert.NoError(t, err)
+	assert.Equal(t, rcpts{"dba@example.com"}, loaded["dba"])
+
+	// JSON is YAML too
+	file = writeConfigFile(t, dir, `{"smtpHost": "relay.example.com", "toEmail": ["a@example.com", "b@example.com"]}`)
+	assert.NoError(t, loadConfigFile(file, "", options))
+	assert.Equal(t, "relay.example.com", options[0].Default)
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, options[2].Default)
+
+	for content, expected := range map[string]string{
+		"smtpHots: smtp.example.com\n":   "unknown option smtpHots in config file " + file,
+		"smtpPort: twenty-five\n":        "invalid smtpPort in config file " + file + ": expected a non-negative integer",
+		"configFile: other.yml\n":        "configFile cannot be set in config file " + file,
+		"- smtpHost: smtp.example.com\n": "failed to parse config file " + file + ": yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into map[string]interface {}",
+	} {
+		writeConfigFile(t, dir, content)
+		assert.EqualError(t, loadConfigFile(file, "", options), expected)
+	}
+	assert.EqualError(t, loadConfigFile(filepath.Join(dir, "missing.yml"), "", options),
+		"failed to read config file "+filepath.Join(dir, "missing.yml")+": open "+filepath.Join(dir, "missing.yml")+": no such file or directory")
+}
+
+func TestConfigFileSections(t *testing.T) {
+	config.SmtpHost = "smtp.example.com"
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	configSections.routingRules = configSection{source: "in config file email.yml", data: []byte("default:\n  to: [ops@example.com]\n")}
+	defer func() {
+		config.SmtpHost = ""
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		configSections.routingRules = configSection{}
+		routingRules = nil
+	}()
+	// the routing rules take the place of a destination address
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.NoError(t, checkArgs(event))
+	to, _, _ := eventRcpts(event)
+	assert.Equal(t, rcpts{"ops@example.com"}, to)
+
+	configSections.routingRules.data = []byte("rules: [{check: foo}]\n")
+	assert.EqualError(t, checkArgs(event), "routing rule 1 has no destinations")
+}
+
+func TestConfigFileProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { configSections.routingRules = configSection{} }()
+
+	var host, subject string
+	var to []string
+	options := []*sensu.PluginConfigOption{
+		{Argument: smtpHost, Default: "", Value: &host},
+		{Argument: subjectTemplate, Default: "", Value: &subject},
+		{Argument: toEmail, Default: []string{}, Value: &to},
+	}
+	file := writeConfigFile(t, dir, `
+smtpHost: smtp.example.com
+toEmail: ops@example.com
+profiles:
+  prod-pager:
+    toEmail: [pager@example.com]
+    subjectTemplate: "PAGE: {{.Entity.Name}}"
+  staging-digest:
+    routingRules:
+      default:
+        to: [staging@example.com]
+`)
+	assert.NoError(t, loadConfigFile(file, "prod-pager", options))
+	assert.Equal(t, "smtp.example.com", options[0].Default)
+	assert.Equal(t, "PAGE: {{.Entity.Name}}", options[1].Default)
+	assert.Equal(t, []string{"pager@example.com"}, options[2].Default)
+
+	assert.NoError(t, loadConfigFile(file, "staging-digest", options))
+	assert.Equal(t, "in profile staging-digest of config file "+file, configSections.routingRules.source)
+
+	assert.EqualError(t, loadConfigFile(file, "prod", options), "config file "+file+" has no profile prod, only: prod-pager, staging-digest")
+
+	writeConfigFile(t, dir, "profiles:\n  prod-pager:\n    toEmial: pager@example.com\n")
+	assert.EqualError(t, loadConfigFile(file, "prod-pager", options), "unknown option toEmial in profile prod-pager of config file "+file)
+	// only the selected profile is applied
+	assert.NoError(t, loadConfigFile(file, "", options))
+	writeConfigFile(t, dir, "profiles: [prod-pager]\n")
+	assert.EqualError(t, loadConfigFile(file, "", options), "invalid profiles in config file "+file+": expected a map of profile names to options")
+}
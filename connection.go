@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+)
+
+// --ipVersion values
+const (
+	IPVersionAny = "any"
+	IPVersion4   = "4"
+	IPVersion6   = "6"
+)
+
+// tlsVersions are the names of the TLS versions reported by --checkConnection
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsRootCAs are the CAs from --tlsCAFile, or nil to use the system CAs
+var tlsRootCAs *x509.CertPool
+
+// tlsCipherSuiteIDs are the cipher suites --tlsCipherSuites can enable, by
+// their IANA names. Those of TLS 1.3 are not configurable.
+var tlsCipherSuiteIDs = map[string]uint16{
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":                 tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// the names of the TLS 1.3 cipher suites, which are only reported
+var tls13CipherSuites = map[uint16]string{
+	tls.TLS_AES_128_GCM_SHA256:       "TLS_AES_128_GCM_SHA256",
+	tls.TLS_AES_256_GCM_SHA384:       "TLS_AES_256_GCM_SHA384",
+	tls.TLS_CHACHA20_POLY1305_SHA256: "TLS_CHACHA20_POLY1305_SHA256",
+}
+
+// the minimum version and cipher suites of SMTP TLS connections, from
+// --tlsMinVersion and --tlsCipherSuites, zero and nil for Go's defaults
+var (
+	smtpTLSMinVersion   uint16
+	smtpTLSCipherSuites []uint16
+)
+
+// parseTLSOptions sets the minimum version and cipher suites of SMTP TLS
+// connections.
+func parseTLSOptions(minVersion string, cipherSuites []string) error {
+	smtpTLSMinVersion, smtpTLSCipherSuites = 0, nil
+	if len(minVersion) > 0 {
+		for version, name := range tlsVersions {
+			if name == "TLS "+minVersion {
+				smtpTLSMinVersion = version
+			}
+		}
+		if smtpTLSMinVersion == 0 {
+			return fmt.Errorf("%s is not a valid TLS version, use '1.0', '1.1', '1.2' or '1.3'", minVersion)
+		}
+	}
+	for _, name := range cipherSuites {
+		id, ok := tlsCipherSuiteIDs[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("%s is not a supported TLS cipher suite", name)
+		}
+		smtpTLSCipherSuites = append(smtpTLSCipherSuites, id)
+	}
+	if len(smtpTLSCipherSuites) > 0 && smtpTLSMinVersion == tls.VersionTLS13 {
+		return errors.New("--tlsCipherSuites has no effect with --tlsMinVersion 1.3, whose cipher suites are not configurable")
+	}
+	return nil
+}
+
+// smtpTLSConfig returns the TLS config of STARTTLS with the SMTP server on
+// the host.
+func smtpTLSConfig(host string, skipVerify bool) *tls.Config {
+	return &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: skipVerify,
+		RootCAs:            tlsRootCAs,
+		MinVersion:         smtpTLSMinVersion,
+		CipherSuites:       smtpTLSCipherSuites,
+	}
+}
+
+// tlsCipherSuiteName returns the IANA name of the cipher suite.
+func tlsCipherSuiteName(id uint16) string {
+	if name, ok := tls13CipherSuites[id]; ok {
+		return name
+	}
+	for name, suite := range tlsCipherSuiteIDs {
+		if suite == id {
+			return name
+		}
+	}
+	return fmt.Sprintf("%#04x", id)
+}
+
+// loadCAFile returns a pool of the PEM encoded CA certificates in the file.
+func loadCAFile(file string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", file)
+	}
+	return pool, nil
+}
+
+// the network and local address of SMTP connections, from --ipVersion and
+// --localAddr
+var (
+	smtpNetwork   = "tcp"
+	smtpLocalAddr *net.TCPAddr
+)
+
+// parseSMTPSource sets the network and local address of SMTP connections.
+// Without --ipVersion the network follows the family of --localAddr, so
+// that a dual-stack relay is reached over the family the address can use.
+func parseSMTPSource(localAddr, ipVersion string) error {
+	smtpNetwork, smtpLocalAddr = "tcp", nil
+	switch ipVersion {
+	case IPVersion4, IPVersion6:
+		smtpNetwork = "tcp" + ipVersion
+	case IPVersionAny, "":
+	default:
+		return fmt.Errorf("%s is not a valid IP version, use '%s', '%s' or '%s'", ipVersion, IPVersionAny, IPVersion4, IPVersion6)
+	}
+	if len(localAddr) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(localAddr)
+	if ip == nil {
+		return fmt.Errorf("--localAddr %s is not an IP address", localAddr)
+	}
+	family := IPVersion6
+	if ip.To4() != nil {
+		family = IPVersion4
+	}
+	if smtpNetwork != "tcp" && smtpNetwork != "tcp"+family {
+		return fmt.Errorf("--localAddr %s is not an IPv%s address", localAddr, ipVersion)
+	}
+	smtpNetwork = "tcp" + family
+	smtpLocalAddr = &net.TCPAddr{IP: ip}
+	return nil
+}
+
+// dialSMTPServer opens a TCP connection to the SMTP server from the
+// configured source.
+func dialSMTPServer(address string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	if smtpLocalAddr != nil {
+		debugf("connecting from %s", smtpLocalAddr.IP)
+		dialer.LocalAddr = smtpLocalAddr
+	}
+	return dialer.Dial(smtpNetwork, address)
+}
+
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersions[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("TLS version %#x", version)
+}
+
+// checkSMTPConnection connects and authenticates to the SMTP server without
+// sending an email, printing what was negotiated.
+func checkSMTPConnection() error {
+	conn, err := dialSMTP()
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s:%d: %v", config.SmtpHost, config.SmtpPort, err)
+	}
+	defer conn.Close()
+
+	status := []string{fmt.Sprintf("connected to %s:%d", config.SmtpHost, config.SmtpPort)}
+	if state, ok := conn.TLSConnectionState(); ok {
+		status = append(status, "using STARTTLS ("+tlsVersionName(state.Version)+")")
+	} else {
+		status = append(status, "without TLS")
+	}
+	if ok, mechanisms := conn.Extension("AUTH"); ok && config.AuthMethod != AuthMethodNone {
+		status = append(status, fmt.Sprintf("authenticated as %s (%s auth, server supports %s)", config.SmtpUsername, config.AuthMethod, mechanisms))
+	} else {
+		status = append(status, "without authentication")
+	}
+
+	extensions := mailer.Extensions(conn)
+
+	if err := conn.Quit(); err != nil {
+		return err
+	}
+	fmt.Printf("OK: %s\n", strings.Join(status, ", "))
+	fmt.Printf("Server extensions: %s\n", strings.Join(extensions, " "))
+	return nil
+}
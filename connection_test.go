@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSMTPConnection(t *testing.T) {
+	port, _ := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+	}()
+	assert.NoError(t, checkSMTPConnection())
+
+	// the server only accepts one connection
+	assert.Error(t, checkSMTPConnection())
+}
+
+func TestHeloHost(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	hello := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost ESMTP")
+		line, _ := text.ReadLine()
+		hello <- line
+		_ = text.PrintfLine("250 localhost")
+		_, _ = text.ReadLine()
+		_ = text.PrintfLine("221 bye")
+	}()
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = uint64(listener.Addr().(*net.TCPAddr).Port)
+	config.AuthMethod = AuthMethodNone
+	config.HeloHost = "sensu-backend.example.com"
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.HeloHost = ""
+	}()
+	conn, err := dialSMTP()
+	assert.NoError(t, err)
+	assert.Equal(t, "EHLO sensu-backend.example.com", <-hello)
+	assert.NoError(t, conn.Quit())
+}
+
+func TestParseModeCheckConnection(t *testing.T) {
+	args := os.Args
+	defer func() {
+		os.Args = args
+		mode = ""
+	}()
+
+	os.Args = []string{"sensu-email-handler", "--checkConnection=false"}
+	assert.False(t, parseMode())
+	os.Args = []string{"sensu-email-handler", "-s", "smtp.example.com", "--checkConnection"}
+	assert.True(t, parseMode())
+	assert.Equal(t, modeCheckConnection, mode)
+	assert.Equal(t, []string{"sensu-email-handler", "-s", "smtp.example.com", "--checkConnection"}, os.Args)
+}
+
+func TestParseSMTPSource(t *testing.T) {
+	defer func() {
+		smtpNetwork, smtpLocalAddr = "tcp", nil
+	}()
+
+	assert.NoError(t, parseSMTPSource("", IPVersionAny))
+	assert.Equal(t, "tcp", smtpNetwork)
+	assert.Nil(t, smtpLocalAddr)
+
+	assert.NoError(t, parseSMTPSource("", IPVersion6))
+	assert.Equal(t, "tcp6", smtpNetwork)
+
+	// the network follows the family of the local address
+	assert.NoError(t, parseSMTPSource("127.0.0.1", IPVersionAny))
+	assert.Equal(t, "tcp4", smtpNetwork)
+	assert.Equal(t, "127.0.0.1", smtpLocalAddr.IP.String())
+
+	assert.EqualError(t, parseSMTPSource("127.0.0.1", IPVersion6), "--localAddr 127.0.0.1 is not an IPv6 address")
+	assert.EqualError(t, parseSMTPSource("relay.example.com", IPVersionAny), "--localAddr relay.example.com is not an IP address")
+	assert.EqualError(t, parseSMTPSource("", "5"), "5 is not a valid IP version, use 'any', '4' or '6'")
+
+	// connections are made from the local address
+	port, _ := startSMTPServer(t)
+	assert.NoError(t, parseSMTPSource("127.0.0.1", IPVersion4))
+	conn, err := dialSMTPServer(net.JoinHostPort("127.0.0.1", strconv.FormatUint(port, 10)))
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+	conn.Close()
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	defer func() {
+		smtpTLSMinVersion, smtpTLSCipherSuites = 0, nil
+	}()
+
+	assert.NoError(t, parseTLSOptions("", []string{}))
+	assert.Equal(t, uint16(0), smtpTLSConfig("smtp.example.com", false).MinVersion)
+	assert.Nil(t, smtpTLSConfig("smtp.example.com", false).CipherSuites)
+
+	assert.NoError(t, parseTLSOptions("1.2", []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", "tls_ecdhe_ecdsa_with_chacha20_poly1305_sha256"}))
+	tlsConfig := smtpTLSConfig("smtp.example.com", false)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305}, tlsConfig.CipherSuites)
+
+	assert.EqualError(t, parseTLSOptions("1.4", []string{}), "1.4 is not a valid TLS version, use '1.0', '1.1', '1.2' or '1.3'")
+	assert.EqualError(t, parseTLSOptions("", []string{"TLS_RSA_WITH_RC4_128_SHA"}), "TLS_RSA_WITH_RC4_128_SHA is not a supported TLS cipher suite")
+	assert.Error(t, parseTLSOptions("1.3", []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}))
+
+	assert.Equal(t, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", tlsCipherSuiteName(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256))
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", tlsCipherSuiteName(tls.TLS_AES_128_GCM_SHA256))
+	assert.Equal(t, "0x0005", tlsCipherSuiteName(0x0005))
+}
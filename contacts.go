@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// the label or annotation listing the contacts to notify for an event
+const contactsKey = "contacts"
+
+// contacts maps contact names to their email addresses, loaded from
+// --contactsFile
+var contacts map[string]rcpts
+
+// contactAddresses is the email address, or list of addresses, of a contact
+// in the contacts file.
+type contactAddresses []string
+
+func (c *contactAddresses) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var address string
+	if err := unmarshal(&address); err == nil {
+		*c = contactAddresses{address}
+		return nil
+	}
+	var addresses []string
+	if err := unmarshal(&addresses); err != nil {
+		return err
+	}
+	*c = addresses
+	return nil
+}
+
+// loadContacts reads the YAML (or JSON) contacts file.
+func loadContacts(file string) (map[string]rcpts, error) {
+	contactsBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contacts file %s: %v", file, err)
+	}
+	return parseContacts(contactsBytes, "file "+file)
+}
+
+// parseContacts parses the contacts from the source, the file or config file
+// they are in.
+func parseContacts(contactsBytes []byte, source string) (map[string]rcpts, error) {
+	var parsed map[string]contactAddresses
+	if err := yaml.Unmarshal(contactsBytes, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse contacts %s: %v", source, err)
+	}
+	loaded := make(map[string]rcpts, len(parsed))
+	for name, addresses := range parsed {
+		loaded[name] = newRcpts(addresses)
+	}
+	return loaded, nil
+}
+
+// eventContacts returns the names of the contacts listed in the check's and
+// entity's labels and annotations.
+func eventContacts(event *corev2.Event) []string {
+	var lists []string
+	for _, meta := range []corev2.ObjectMeta{eventCheck(event).ObjectMeta, event.Entity.ObjectMeta} {
+		lists = append(lists, meta.Labels[contactsKey], meta.Annotations[contactsKey])
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, list := range lists {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if len(name) > 0 && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// toRcpts returns the recipients of the email for the event: the addresses
+// of the event's contacts when a contacts file is configured, otherwise (or
+// if none of the contacts are known) the recipients for the event's status.
+func toRcpts(event *corev2.Event) rcpts {
+	var recipients rcpts
+	if contacts != nil {
+		seen := map[string]bool{}
+		for _, name := range eventContacts(event) {
+			addresses, ok := contacts[name]
+			if !ok {
+				fmt.Printf("Unknown contact %s for %s/%s\n", name, event.Entity.Name, eventCheck(event).Name)
+				continue
+			}
+			for _, address := range addresses {
+				if !seen[address] {
+					seen[address] = true
+					recipients = append(recipients, address)
+				}
+			}
+		}
+	}
+	if len(recipients) == 0 && event.Check == nil {
+		recipients = newRcpts(config.ToEmail)
+	} else if len(recipients) == 0 {
+		recipients = newRcpts(statusToEmail(event.Check.Status))
+	}
+	return recipients
+}
+
+// statusToEmail returns the --criticalToEmail, --warningToEmail or
+// --resolvedToEmail addresses for the status, falling back to --toEmail.
+func statusToEmail(status uint32) []string {
+	var addresses []string
+	switch status {
+	case 0:
+		addresses = config.ResolvedToEmail
+	case 1:
+		addresses = config.WarningToEmail
+	case 2:
+		addresses = config.CriticalToEmail
+	}
+	if len(addresses) == 0 {
+		return config.ToEmail
+	}
+	return addresses
+}
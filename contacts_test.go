@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContacts(t *testing.T) {
+	file, err := ioutil.TempFile("", "contacts")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("ops: ops@example.com\ndev:\n  - dev1@example.com\n  - Dev Two <dev2@example.com>\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	loaded, err := loadContacts(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]rcpts{
+		"ops": {"ops@example.com"},
+		"dev": {"dev1@example.com", "Dev Two <dev2@example.com>"},
+	}, loaded)
+
+	contacts = loaded
+	config.ToEmail = []string{"fallback@example.com"}
+	defer func() {
+		contacts = nil
+		config.ToEmail = nil
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.Equal(t, rcpts{"fallback@example.com"}, toRcpts(event))
+
+	event.Check.Labels = map[string]string{"contacts": "dev"}
+	event.Entity.Annotations = map[string]string{"contacts": "ops, dev, unknown"}
+	assert.Equal(t, []string{"dev", "ops", "unknown"}, eventContacts(event))
+	assert.Equal(t, rcpts{"dev1@example.com", "Dev Two <dev2@example.com>", "ops@example.com"}, toRcpts(event))
+
+	event.Check.Labels = nil
+	event.Entity.Annotations = map[string]string{"contacts": "unknown"}
+	assert.Equal(t, rcpts{"fallback@example.com"}, toRcpts(event))
+}
+
+func TestStatusToEmail(t *testing.T) {
+	config.ToEmail = []string{"team@example.com"}
+	config.CriticalToEmail = []string{"oncall@example.com", "team@example.com"}
+	config.ResolvedToEmail = []string{"oncall@example.com"}
+	defer func() {
+		config.ToEmail = nil
+		config.CriticalToEmail = nil
+		config.ResolvedToEmail = nil
+	}()
+
+	assert.Equal(t, []string{"oncall@example.com"}, statusToEmail(0))
+	assert.Equal(t, []string{"team@example.com"}, statusToEmail(1))
+	assert.Equal(t, []string{"oncall@example.com", "team@example.com"}, statusToEmail(2))
+	assert.Equal(t, []string{"team@example.com"}, statusToEmail(3))
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	assert.Equal(t, rcpts{"oncall@example.com", "team@example.com"}, toRcpts(event))
+}
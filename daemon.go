@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// maxDatagramSize is the largest event accepted over UDP.
+const maxDatagramSize = 65535
+
+// originalStdin is the stdin events are read from with --listen -, and init
+// reads its answers from, as the plugin SDK reads the sample event from
+// os.Stdin.
+var originalStdin io.Reader
+
+// parseListenAddress returns the network and address of a --listen address,
+// e.g. tcp://127.0.0.1:3030, udp://127.0.0.1:3030 or unix:///run/email.sock.
+// An address without a scheme is TCP, and - is stdin, with an empty network.
+func parseListenAddress(listen string) (string, string, error) {
+	if listen == "-" {
+		return "", "", nil
+	}
+	network, address := "tcp", listen
+	if parts := strings.SplitN(listen, "://", 2); len(parts) == 2 {
+		network, address = parts[0], parts[1]
+	}
+	switch network {
+	case "tcp", "udp":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("invalid --listen address %s: %v", listen, err)
+		}
+	case "unix":
+		if len(address) == 0 {
+			return "", "", fmt.Errorf("invalid --listen address %s: missing socket path", listen)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid --listen address %s: unsupported network %s", listen, network)
+	}
+	return network, address, nil
+}
+
+// serveEvents runs the handler persistently, handling each event received on
+// the --listen address in turn until the input ends or the handler is
+// stopped.
+func serveEvents() error {
+	network, address, err := parseListenAddress(config.Listen)
+	if err != nil {
+		return err
+	}
+	defer poolSMTP()()
+
+	inputs := make(chan []byte)
+	done := make(chan error, 1)
+	switch network {
+	case "":
+		go func() {
+			done <- decodeEvents(originalStdin, inputs)
+		}()
+	case "udp":
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		go func() {
+			done <- readDatagrams(conn, inputs)
+		}()
+	default:
+		if network == "unix" {
+			removeStaleSocket(address)
+		}
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		go func() {
+			done <- acceptEvents(listener, inputs)
+		}()
+	}
+	fmt.Printf("Handling events from %s\n", config.Listen)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case input := <-inputs:
+			if err := handleInput(input); err != nil {
+				fmt.Fprintf(os.Stderr, "Error handling event: %v\n", err)
+			}
+		case err := <-done:
+			return err
+		case sig := <-signals:
+			fmt.Printf("Stopping on %s\n", sig)
+			return nil
+		}
+	}
+}
+
+// decodeEvents sends each JSON value read from r to inputs, so the events may
+// be separated by newlines or not at all.
+func decodeEvents(r io.Reader, inputs chan<- []byte) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode event: %v", err)
+		}
+		inputs <- raw
+	}
+}
+
+// acceptEvents reads the events of each connection to the listener, as sent
+// by a Sensu TCP or unix socket handler.
+func acceptEvents(listener net.Listener, inputs chan<- []byte) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := decodeEvents(conn, inputs); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading from %s: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// readDatagrams reads an event from each datagram, as sent by a Sensu UDP
+// handler.
+func readDatagrams(conn net.PacketConn, inputs chan<- []byte) error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		input := make([]byte, n)
+		copy(input, buf[:n])
+		inputs <- input
+	}
+}
+
+// removeStaleSocket removes the socket left behind by a previous run, which
+// would otherwise prevent listening on it.
+func removeStaleSocket(path string) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+}
+
+// handleInput handles an event, or array of events for a digest, as the
+// handler does for an event read from stdin. Namespace settings apply only
+// while handling the event.
+func handleInput(input []byte) error {
+	trimmed := bytes.TrimSpace(input)
+	var events []*corev2.Event
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			return fmt.Errorf("failed to unmarshal events: %v", err)
+		}
+		if len(events) == 0 {
+			return errors.New("no events in input")
+		}
+	} else {
+		event := &corev2.Event{}
+		if err := json.Unmarshal(trimmed, event); err != nil {
+			return fmt.Errorf("failed to unmarshal event: %v", err)
+		}
+		events = []*corev2.Event{event}
+	}
+	for _, event := range events {
+		if err := event.Validate(); err != nil {
+			return fmt.Errorf("invalid event: %v", err)
+		}
+	}
+
+	// emitted with the settings of the handler, not the namespace
+	defer emitDeliveryMetrics()
+	saved := config
+	defer func() {
+		config = saved
+		digestEvents = nil
+		eventJSON = nil
+	}()
+	eventJSON = trimmed
+	if trimmed[0] == '[' {
+		digestEvents = events
+	}
+	if len(namespaceSettingsMap) > 0 {
+		if s, ok := lookupNamespace(namespaceSettingsMap, events[0].Entity.Namespace); ok {
+			s.apply()
+		}
+	}
+	scrubEvents(events[0])
+	_, err := handleEventResults(events[0])
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseListenAddress(t *testing.T) {
+	for listen, expected := range map[string][2]string{
+		"-":                      {"", ""},
+		"127.0.0.1:3030":         {"tcp", "127.0.0.1:3030"},
+		"udp://:3030":            {"udp", ":3030"},
+		"unix:///run/email.sock": {"unix", "/run/email.sock"},
+	} {
+		network, address, err := parseListenAddress(listen)
+		assert.NoError(t, err, listen)
+		assert.Equal(t, expected, [2]string{network, address}, listen)
+	}
+	for _, invalid := range []string{"3030", "http://127.0.0.1:3030", "unix://"} {
+		_, _, err := parseListenAddress(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	// the server accepts a single connection, so both emails must share it
+	port, messages := startSMTPServer(t)
+	config.Listen = "-"
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.ToEmail = []string{"ops@example.com"}
+	config.Charset = defaultCharset
+	config.SubjectTemplate = "{{.Check.Output}}"
+	config.BodyTemplate = "{{.Check.Output}}"
+	defer func() {
+		config.Listen = ""
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.ToEmail = nil
+		config.Charset = ""
+		config.SubjectTemplate = ""
+		config.BodyTemplate = ""
+		originalStdin = nil
+	}()
+
+	second := strings.Replace(sampleEvent, "CRITICAL: sample check output", "WARNING: second event", 1)
+	originalStdin = strings.NewReader(strings.Replace(sampleEvent, "\n", "", -1) + "\n" + second)
+	received := make(chan []string)
+	go func() {
+		received <- []string{<-messages, <-messages}
+	}()
+	assert.NoError(t, serveEvents())
+	msgs := <-received
+	assert.Contains(t, msgs[0], "CRITICAL: sample check output")
+	assert.Contains(t, msgs[1], "WARNING: second event")
+	assert.Nil(t, smtpPool)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DANE TLSA record fields (RFC 6698), of which SMTP only uses the DANE-TA
+// and DANE-EE certificate usages (RFC 7672)
+const (
+	daneTA = 2
+	daneEE = 3
+
+	daneSelectorCert = 0
+	daneSelectorSPKI = 1
+
+	daneMatchFull   = 0
+	daneMatchSHA256 = 1
+	daneMatchSHA512 = 2
+)
+
+const (
+	dnsTimeout = 5 * time.Second
+	// the UDP payload size advertised with EDNS0
+	dnsUDPSize = 4096
+)
+
+// resolvConf is the configuration of the resolver TLSA records, and with
+// --dane MX records, are looked up with, which must validate DNSSEC
+var resolvConf = "/etc/resolv.conf"
+
+// dnsPort is the port of the resolver
+var dnsPort = "53"
+
+// lookupTLSA resolves the TLSA records of a name, reporting whether the
+// resolver validated them with DNSSEC
+var lookupTLSA = dnsLookupTLSA
+
+// lookupSecureMX resolves the MX records of a domain, sorted by preference,
+// reporting whether the resolver validated them with DNSSEC
+var lookupSecureMX = dnsLookupMX
+
+// tlsaRecord is a TLSA record, associating a certificate or public key with
+// a TLS server.
+type tlsaRecord struct {
+	usage, selector, matchingType int
+	data                          []byte
+}
+
+func (r tlsaRecord) usable() bool {
+	return (r.usage == daneTA || r.usage == daneEE) && r.selector <= daneSelectorSPKI && r.matchingType <= daneMatchSHA512
+}
+
+// matches reports whether the record is of the certificate.
+func (r tlsaRecord) matches(cert *x509.Certificate) bool {
+	selected := cert.Raw
+	if r.selector == daneSelectorSPKI {
+		selected = cert.RawSubjectPublicKeyInfo
+	}
+	switch r.matchingType {
+	case daneMatchSHA256:
+		sum := sha256.Sum256(selected)
+		selected = sum[:]
+	case daneMatchSHA512:
+		sum := sha512.Sum512(selected)
+		selected = sum[:]
+	}
+	return bytes.Equal(selected, r.data)
+}
+
+// daneVerifier returns a TLS VerifyPeerCertificate function accepting the
+// certificate of the host when it matches a DANE-EE record, or chains to a
+// certificate matching a DANE-TA record. DANE-EE certificates are accepted
+// regardless of their names and validity, as RFC 7672 requires.
+func daneVerifier(host string, records []tlsaRecord) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		for _, record := range records {
+			switch record.usage {
+			case daneEE:
+				if record.matches(certs[0]) {
+					return nil
+				}
+			case daneTA:
+				for _, cert := range certs[1:] {
+					if !record.matches(cert) {
+						continue
+					}
+					roots := x509.NewCertPool()
+					roots.AddCert(cert)
+					if _, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates}); err == nil {
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("the certificate of %s matches none of its TLSA records", host)
+	}
+}
+
+// dnsResolvers returns the addresses of the nameservers in resolvConf.
+func dnsResolvers() []string {
+	var resolvers []string
+	if conf, err := dns.ClientConfigFromFile(resolvConf); err == nil {
+		for _, server := range conf.Servers {
+			resolvers = append(resolvers, net.JoinHostPort(server, dnsPort))
+		}
+	}
+	if len(resolvers) == 0 {
+		resolvers = []string{net.JoinHostPort("127.0.0.1", dnsPort)}
+	}
+	return resolvers
+}
+
+// dnsExchange queries the resolvers for the records of the name, asking for
+// their DNSSEC validation result.
+func dnsExchange(name string, qtype uint16) (*dns.Msg, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	// the AD bit asks for the validation result (RFC 6840 section 5.7), the
+	// DO bit for the DNSSEC records
+	query.AuthenticatedData = true
+	query.SetEdns0(dnsUDPSize, true)
+
+	var resp *dns.Msg
+	var err error
+	for _, resolver := range dnsResolvers() {
+		client := &dns.Client{Timeout: dnsTimeout, UDPSize: dnsUDPSize}
+		resp, _, err = client.Exchange(query, resolver)
+		if err == nil && resp.Truncated {
+			client.Net = "tcp"
+			resp, _, err = client.Exchange(query, resolver)
+		}
+		if err == nil {
+			break
+		}
+	}
+	return resp, err
+}
+
+// dnsLookupTLSA queries the resolvers for the TLSA records of the name,
+// reporting whether the resolver validated them with DNSSEC. The Go resolver
+// can't look them up.
+func dnsLookupTLSA(name string) ([]tlsaRecord, bool, error) {
+	resp, err := dnsExchange(name, dns.TypeTLSA)
+	if err != nil {
+		return nil, false, err
+	}
+	return tlsaRecords(resp)
+}
+
+// dnsLookupMX queries the resolvers for the MX records of the domain,
+// reporting whether the resolver validated them with DNSSEC, which the Go
+// resolver doesn't report. Like net.LookupMX, a domain without MX records is
+// a not found *net.DNSError.
+func dnsLookupMX(domain string) ([]*net.MX, bool, error) {
+	resp, err := dnsExchange(domain, dns.TypeMX)
+	if err != nil {
+		return nil, false, err
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return nil, false, fmt.Errorf("DNS query failed with %s", dns.RcodeToString[resp.Rcode])
+	}
+	var records []*net.MX
+	for _, rr := range resp.Answer {
+		if mx, ok := rr.(*dns.MX); ok {
+			records = append(records, &net.MX{Host: mx.Mx, Pref: mx.Preference})
+		}
+	}
+	if len(records) == 0 {
+		return nil, resp.AuthenticatedData, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Pref < records[j].Pref
+	})
+	return records, resp.AuthenticatedData, nil
+}
+
+// tlsaRecords returns the TLSA records of the response, and whether the
+// resolver validated them.
+func tlsaRecords(resp *dns.Msg) ([]tlsaRecord, bool, error) {
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, resp.AuthenticatedData, nil
+	default:
+		return nil, false, fmt.Errorf("DNS query failed with %s", dns.RcodeToString[resp.Rcode])
+	}
+	var records []tlsaRecord
+	for _, rr := range resp.Answer {
+		tlsa, ok := rr.(*dns.TLSA)
+		if !ok {
+			continue
+		}
+		data, err := hex.DecodeString(tlsa.Certificate)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid TLSA record %s: %v", tlsa, err)
+		}
+		records = append(records, tlsaRecord{
+			usage:        int(tlsa.Usage),
+			selector:     int(tlsa.Selector),
+			matchingType: int(tlsa.MatchingType),
+			data:         data,
+		})
+	}
+	return records, resp.AuthenticatedData, nil
+}
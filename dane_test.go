@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertificate returns a certificate for the host signed by the parent,
+// or self-signed without one.
+func testCertificate(t *testing.T, host string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestDANEVerifier(t *testing.T) {
+	ca, caKey := testCertificate(t, "Example CA", nil, nil)
+	leaf, _ := testCertificate(t, "mx.example.com", ca, caKey)
+	other, _ := testCertificate(t, "mx.example.com", nil, nil)
+	chain := [][]byte{leaf.Raw, ca.Raw}
+
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	ee := tlsaRecord{usage: daneEE, selector: daneSelectorSPKI, matchingType: daneMatchSHA256, data: spki[:]}
+	assert.NoError(t, daneVerifier("mx.example.com", []tlsaRecord{ee})(chain, nil))
+	// DANE-EE ignores the name
+	assert.NoError(t, daneVerifier("mx2.example.com", []tlsaRecord{ee})(chain, nil))
+	assert.EqualError(t, daneVerifier("mx.example.com", []tlsaRecord{ee})([][]byte{other.Raw}, nil),
+		"the certificate of mx.example.com matches none of its TLSA records")
+
+	ta := tlsaRecord{usage: daneTA, selector: daneSelectorCert, matchingType: daneMatchFull, data: ca.Raw}
+	assert.NoError(t, daneVerifier("mx.example.com", []tlsaRecord{ta})(chain, nil))
+	// DANE-TA checks the name
+	assert.Error(t, daneVerifier("mx2.example.com", []tlsaRecord{ta})(chain, nil))
+	assert.Error(t, daneVerifier("mx.example.com", []tlsaRecord{ta})([][]byte{other.Raw, other.Raw}, nil))
+
+	assert.False(t, tlsaRecord{usage: 1, selector: 0, matchingType: 1}.usable())
+}
+
+func TestDNSLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	dnsPort = port
+	defer func() {
+		resolvConf = "/etc/resolv.conf"
+		dnsPort = "53"
+	}()
+	assert.NoError(t, ioutil.WriteFile(resolvConf, []byte("search example.com\nnameserver 127.0.0.1\n"), 0600))
+
+	// a validating resolver, which only authenticates the records of
+	// example.com
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(query)
+		name := query.Question[0].Name
+		assert.True(t, query.AuthenticatedData)
+		assert.True(t, query.IsEdns0().Do())
+		switch name {
+		case "_25._tcp.mx.example.com.", "_25._tcp.mx.example.net.":
+			resp.AuthenticatedData = name == "_25._tcp.mx.example.com."
+			rr, _ := dns.NewRR(name + " 3600 IN TLSA 3 1 1 abcdef")
+			resp.Answer = append(resp.Answer, rr)
+		case "example.com.", "example.net.":
+			resp.AuthenticatedData = name == "example.com."
+			for _, mx := range []string{" 3600 IN MX 20 mx2.", " 3600 IN MX 10 mx."} {
+				rr, _ := dns.NewRR(name + mx + name)
+				resp.Answer = append(resp.Answer, rr)
+			}
+		case "_25._tcp.broken.example.com.", "broken.example.com.":
+			resp.Rcode = dns.RcodeServerFailure
+		default:
+			resp.AuthenticatedData = true
+			resp.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	defer server.Shutdown()
+
+	records, secure, err := dnsLookupTLSA("_25._tcp.mx.example.com")
+	assert.NoError(t, err)
+	assert.True(t, secure)
+	assert.Equal(t, []tlsaRecord{{usage: daneEE, selector: daneSelectorSPKI, matchingType: daneMatchSHA256, data: []byte{0xab, 0xcd, 0xef}}}, records)
+
+	// without the AD bit the records can't be trusted
+	records, secure, err = dnsLookupTLSA("_25._tcp.mx.example.net")
+	assert.NoError(t, err)
+	assert.False(t, secure)
+	assert.Len(t, records, 1)
+
+	records, secure, err = dnsLookupTLSA("_25._tcp.mail.example.com")
+	assert.NoError(t, err)
+	assert.True(t, secure)
+	assert.Empty(t, records)
+
+	_, _, err = dnsLookupTLSA("_25._tcp.broken.example.com")
+	assert.EqualError(t, err, "DNS query failed with SERVFAIL")
+
+	mxs, secure, err := dnsLookupMX("example.com")
+	assert.NoError(t, err)
+	assert.True(t, secure)
+	assert.Equal(t, []*net.MX{{Host: "mx.example.com.", Pref: 10}, {Host: "mx2.example.com.", Pref: 20}}, mxs)
+
+	_, secure, err = dnsLookupMX("example.net")
+	assert.NoError(t, err)
+	assert.False(t, secure)
+
+	_, secure, err = dnsLookupMX("mail.example.com")
+	var dnsErr *net.DNSError
+	assert.True(t, errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+	assert.True(t, secure)
+
+	_, _, err = dnsLookupMX("broken.example.com")
+	assert.EqualError(t, err, "DNS query failed with SERVFAIL")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// smtpTraceOut is the --smtpTraceFile, opened by the first SMTP connection
+var smtpTraceOut io.Writer
+
+// debugf prints a message when --verbose is set.
+func debugf(format string, args ...interface{}) {
+	if config.Verbose {
+		fmt.Printf("debug: "+format+"\n", args...)
+	}
+}
+
+// openSMTPTrace opens the --smtpTraceFile to append SMTP conversations to.
+func openSMTPTrace() error {
+	if smtpTraceOut != nil {
+		return nil
+	}
+	f, err := os.OpenFile(config.SmtpTraceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the SMTP trace file: %v", err)
+	}
+	smtpTraceOut = f
+	return nil
+}
+
+// tracef appends a timestamped line to the --smtpTraceFile.
+func tracef(format string, args ...interface{}) {
+	fmt.Fprintf(smtpTraceOut, time.Now().Format("2006-01-02T15:04:05.000Z07:00")+" "+format+"\n", args...)
+}
+
+// transcriptConn prints the SMTP conversation on a connection when --verbose
+// is set, redacting credentials and the message itself. Once STARTTLS has
+// been negotiated the conversation is encrypted and no longer printed.
+type transcriptConn struct {
+	net.Conn
+	// logf records the conversation, debugf if nil
+	logf func(format string, args ...interface{})
+	// a 334 challenge was received, so the next client line is a credential
+	credentialNext bool
+	// the message data is being sent, following a 354 response
+	inData    bool
+	dataBytes int
+	dataTail  []byte
+	startTLS  bool
+	encrypted bool
+}
+
+func (c *transcriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.encrypted || n == 0 {
+		return n, err
+	}
+	for _, line := range transcriptLines(b[:n]) {
+		c.printf("S: %s", line)
+		switch {
+		case strings.HasPrefix(line, "334"):
+			c.credentialNext = true
+		case strings.HasPrefix(line, "354"):
+			c.inData = true
+		case strings.HasPrefix(line, "220") && c.startTLS:
+			c.encrypted = true
+			if c.logf == nil {
+				debugf("negotiating TLS, the rest of the SMTP conversation is encrypted")
+			}
+		}
+	}
+	return n, err
+}
+
+func (c *transcriptConn) Write(b []byte) (int, error) {
+	if c.encrypted {
+		return c.Conn.Write(b)
+	}
+	if c.inData {
+		c.dataBytes += len(b)
+		c.dataTail = append(c.dataTail, b...)
+		if len(c.dataTail) > 5 {
+			c.dataTail = c.dataTail[len(c.dataTail)-5:]
+		}
+		if bytes.HasSuffix(c.dataTail, []byte("\r\n.\r\n")) {
+			c.printf("C: [%d bytes of message data]", c.dataBytes)
+			c.inData, c.dataBytes, c.dataTail = false, 0, nil
+		}
+		return c.Conn.Write(b)
+	}
+	for _, line := range transcriptLines(b) {
+		c.printf("C: %s", c.redact(line))
+		if strings.EqualFold(line, "STARTTLS") {
+			c.startTLS = true
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *transcriptConn) printf(format string, args ...interface{}) {
+	if c.logf != nil {
+		c.logf(format, args...)
+	} else {
+		debugf(format, args...)
+	}
+}
+
+// redact hides any credentials in a line sent by the client.
+func (c *transcriptConn) redact(line string) string {
+	if c.credentialNext {
+		c.credentialNext = false
+		return "[redacted]"
+	}
+	if fields := strings.Fields(line); len(fields) > 2 && strings.EqualFold(fields[0], "AUTH") {
+		return fields[0] + " " + fields[1] + " [redacted]"
+	}
+	return line
+}
+
+func transcriptLines(b []byte) []string {
+	return strings.Split(strings.TrimRight(string(b), "\r\n"), "\r\n")
+}
+
+// traceText records the conversation of the SMTP client in the
+// --smtpTraceFile above its transport, so that it can be followed once
+// STARTTLS has encrypted the connection. It has to be called again after
+// StartTLS, which replaces the text connection.
+func traceText(conn *smtp.Client) {
+	transcript := &transcriptConn{Conn: &textConn{r: conn.Text.R, w: conn.Text.W}, logf: tracef}
+	conn.Text.R = bufio.NewReader(transcript)
+	conn.Text.W = bufio.NewWriter(transcript)
+}
+
+// textConn adapts the buffered reader and writer of a text connection to a
+// net.Conn.
+type textConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *textConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *textConn) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	return n, err
+}
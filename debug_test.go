@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// discardConn is a net.Conn that reads the given data and discards writes.
+type discardConn struct {
+	net.Conn
+	data []byte
+}
+
+func (c *discardConn) Read(b []byte) (int, error) {
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func TestTranscriptConn(t *testing.T) {
+	conn := &transcriptConn{}
+	assert.Equal(t, "MAIL FROM:<sensu@example.com>", conn.redact("MAIL FROM:<sensu@example.com>"))
+	assert.Equal(t, "AUTH PLAIN [redacted]", conn.redact("AUTH PLAIN AHVzZXIAcGFzc3dvcmQ="))
+	assert.Equal(t, "AUTH LOGIN", conn.redact("AUTH LOGIN"))
+
+	// LOGIN auth credentials are sent in response to challenges
+	conn.Conn = &discardConn{data: []byte("334 VXNlcm5hbWU6\r\n")}
+	_, _ = conn.Read(make([]byte, 64))
+	assert.Equal(t, "[redacted]", conn.redact("dXNlcg=="))
+	assert.Equal(t, "QUIT", conn.redact("QUIT"))
+
+	// message data is not printed
+	conn.Conn = &discardConn{data: []byte("354 go ahead\r\n")}
+	_, _ = conn.Read(make([]byte, 64))
+	assert.True(t, conn.inData)
+	_, _ = conn.Write([]byte("Subject: foo\r\n\r\nbody\r\n"))
+	_, _ = conn.Write([]byte(".\r\n"))
+	assert.False(t, conn.inData)
+
+	// nothing is printed once TLS is negotiated
+	_, _ = conn.Write([]byte("STARTTLS\r\n"))
+	conn.Conn = &discardConn{data: []byte("220 ready\r\n")}
+	_, _ = conn.Read(make([]byte, 64))
+	assert.True(t, conn.encrypted)
+}
+
+func TestSMTPTraceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.SmtpTraceFile = filepath.Join(dir, "smtp.log")
+	defer func() {
+		config.SmtpTraceFile = ""
+		smtpTraceOut = nil
+	}()
+
+	// the conversation is traced through STARTTLS
+	port, _ := startSMTPServer(t, "STARTTLS", "AUTH PLAIN")
+	auth := smtp.PlainAuth("", "user", "s3cret", "127.0.0.1")
+	conn, err := connectSMTP("127.0.0.1", port, &tls.Config{InsecureSkipVerify: true}, true, auth)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Quit())
+	smtpTraceOut.(*os.File).Close()
+
+	trace, err := ioutil.ReadFile(config.SmtpTraceFile)
+	assert.NoError(t, err)
+	for _, line := range []string{
+		"S: 220 localhost ESMTP",
+		"C: STARTTLS",
+		"negotiated TLS 1.3",
+		"C: AUTH PLAIN [redacted]",
+		"S: 235 authenticated",
+		"C: QUIT",
+		"S: 221 bye",
+	} {
+		assert.Contains(t, string(trace), line)
+	}
+	assert.NotContains(t, string(trace), "AHVzZXIAczNjcmV0")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deliveryMetricsPrefix prefixes the names of the delivery metrics
+const deliveryMetricsPrefix = "sensu_email_handler"
+
+// deliveryStats counts the emails delivered since the delivery metrics were
+// last emitted.
+type deliveryStats struct {
+	sent, failed, spooled, retries int
+	// the time taken to transmit each email sent, including retries
+	durations   []time.Duration
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+// deliveryMetrics are the delivery metrics of the events handled since they
+// were last emitted
+var deliveryMetrics deliveryStats
+
+func (s *deliveryStats) empty() bool {
+	return s.sent == 0 && s.failed == 0 && s.spooled == 0 && s.retries == 0
+}
+
+func (s *deliveryStats) duration() float64 {
+	var total time.Duration
+	for _, d := range s.durations {
+		total += d
+	}
+	return total.Seconds()
+}
+
+// recordDelivery counts an email as sent, spooled or failed.
+func recordDelivery(duration time.Duration, err error) {
+	var spooled *spooledError
+	switch {
+	case err == nil:
+		deliveryMetrics.sent++
+		deliveryMetrics.durations = append(deliveryMetrics.durations, duration)
+		deliveryMetrics.lastSuccess = time.Now()
+	case errors.As(err, &spooled):
+		deliveryMetrics.spooled++
+		deliveryMetrics.lastFailure = time.Now()
+	default:
+		deliveryMetrics.failed++
+		deliveryMetrics.lastFailure = time.Now()
+	}
+}
+
+// emitDeliveryMetrics sends the delivery metrics to --statsdAddress and
+// --pushgatewayURL and adds them to the --metricsFile. Failing to emit them
+// doesn't fail the handler.
+func emitDeliveryMetrics() {
+	stats := deliveryMetrics
+	deliveryMetrics = deliveryStats{}
+	if stats.empty() {
+		return
+	}
+	if len(config.StatsdAddress) > 0 {
+		if err := sendStatsd(config.StatsdAddress, &stats); err != nil {
+			fmt.Printf("Failed to send delivery metrics to statsd: %v\n", err)
+		}
+	}
+	if len(config.PushgatewayURL) > 0 {
+		if err := pushDeliveryMetrics(config.PushgatewayURL, &stats); err != nil {
+			fmt.Printf("Failed to push delivery metrics: %v\n", err)
+		}
+	}
+	if len(config.MetricsFile) > 0 {
+		if err := updateMetricsFile(config.MetricsFile, &stats); err != nil {
+			fmt.Printf("Failed to update %s: %v\n", config.MetricsFile, err)
+		}
+	}
+}
+
+// sendStatsd sends the counts as statsd counters and the time taken to send
+// each email as a timer, in a single datagram.
+func sendStatsd(address string, stats *deliveryStats) error {
+	var b bytes.Buffer
+	for _, counter := range []struct {
+		name  string
+		count int
+	}{
+		{"emails_sent", stats.sent},
+		{"emails_failed", stats.failed},
+		{"emails_spooled", stats.spooled},
+		{"smtp_retries", stats.retries},
+	} {
+		if counter.count > 0 {
+			fmt.Fprintf(&b, "%s.%s:%d|c\n", deliveryMetricsPrefix, counter.name, counter.count)
+		}
+	}
+	for _, d := range stats.durations {
+		fmt.Fprintf(&b, "%s.send_duration:%d|ms\n", deliveryMetricsPrefix, d.Nanoseconds()/int64(time.Millisecond))
+	}
+	conn, err := net.DialTimeout("udp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+	return err
+}
+
+// pushDeliveryMetrics pushes the metrics of the run to a Prometheus
+// pushgateway, grouped by host name. The pushgateway keeps the last value
+// pushed, so the counts are those of the last run, while the last success
+// and failure timestamps are only replaced when there was one.
+func pushDeliveryMetrics(pushgatewayURL string, stats *deliveryStats) error {
+	var b bytes.Buffer
+	writeMetric(&b, "emails_sent", "gauge", "Emails sent by the last run", float64(stats.sent))
+	writeMetric(&b, "emails_failed", "gauge", "Emails that failed to send in the last run", float64(stats.failed))
+	writeMetric(&b, "emails_spooled", "gauge", "Emails spooled by the last run", float64(stats.spooled))
+	writeMetric(&b, "smtp_retries", "gauge", "SMTP retries of the last run", float64(stats.retries))
+	writeMetric(&b, "send_duration_seconds", "gauge", "Time taken to send the emails of the last run", stats.duration())
+	writeLastDelivery(&b, stats)
+
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	pushURL := strings.TrimSuffix(pushgatewayURL, "/") + "/metrics/job/" + deliveryMetricsPrefix + "/instance/" + url.PathEscape(host)
+	client := &http.Client{Timeout: 10 * time.Second}
+	// POST only replaces the metrics pushed
+	resp, err := client.Post(pushURL, "text/plain; version=0.0.4", &b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// updateMetricsFile adds the metrics to the totals in the metrics file, in
+// the Prometheus text format Sensu checks can collect with the
+// prometheus_text output metric format.
+func updateMetricsFile(file string, stats *deliveryStats) error {
+	totals := map[string]float64{}
+	if metricsBytes, err := ioutil.ReadFile(file); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(metricsBytes))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if value, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				totals[strings.TrimPrefix(fields[0], deliveryMetricsPrefix+"_")] = value
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	var b bytes.Buffer
+	writeMetric(&b, "emails_sent_total", "counter", "Emails sent", totals["emails_sent_total"]+float64(stats.sent))
+	writeMetric(&b, "emails_failed_total", "counter", "Emails that failed to send", totals["emails_failed_total"]+float64(stats.failed))
+	writeMetric(&b, "emails_spooled_total", "counter", "Emails spooled to be retried", totals["emails_spooled_total"]+float64(stats.spooled))
+	writeMetric(&b, "smtp_retries_total", "counter", "SMTP retries", totals["smtp_retries_total"]+float64(stats.retries))
+	fmt.Fprintf(&b, "# HELP %s_send_duration_seconds Time taken to send emails\n", deliveryMetricsPrefix)
+	fmt.Fprintf(&b, "# TYPE %s_send_duration_seconds summary\n", deliveryMetricsPrefix)
+	fmt.Fprintf(&b, "%s_send_duration_seconds_sum %s\n", deliveryMetricsPrefix, metricValue(totals["send_duration_seconds_sum"]+stats.duration()))
+	fmt.Fprintf(&b, "%s_send_duration_seconds_count %s\n", deliveryMetricsPrefix, metricValue(totals["send_duration_seconds_count"]+float64(len(stats.durations))))
+	// the timestamps of earlier runs are kept
+	last := *stats
+	if last.lastSuccess.IsZero() && totals["last_success_timestamp_seconds"] > 0 {
+		last.lastSuccess = time.Unix(int64(totals["last_success_timestamp_seconds"]), 0)
+	}
+	if last.lastFailure.IsZero() && totals["last_failure_timestamp_seconds"] > 0 {
+		last.lastFailure = time.Unix(int64(totals["last_failure_timestamp_seconds"]), 0)
+	}
+	writeLastDelivery(&b, &last)
+	return writeStateFile(file, b.Bytes())
+}
+
+// writeLastDelivery writes the times of the last email sent and failed, if
+// any.
+func writeLastDelivery(b *bytes.Buffer, stats *deliveryStats) {
+	if !stats.lastSuccess.IsZero() {
+		writeMetric(b, "last_success_timestamp_seconds", "gauge", "Unix time an email was last sent", float64(stats.lastSuccess.Unix()))
+	}
+	if !stats.lastFailure.IsZero() {
+		writeMetric(b, "last_failure_timestamp_seconds", "gauge", "Unix time an email last failed to send", float64(stats.lastFailure.Unix()))
+	}
+}
+
+// writeMetric writes a metric in the Prometheus text format.
+func writeMetric(b *bytes.Buffer, name, metricType, help string, value float64) {
+	name = deliveryMetricsPrefix + "_" + name
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, metricType, name, metricValue(value))
+}
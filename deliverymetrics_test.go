@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordDelivery(t *testing.T) {
+	deliveryMetrics = deliveryStats{}
+	defer func() { deliveryMetrics = deliveryStats{} }()
+
+	recordDelivery(1500*time.Millisecond, nil)
+	recordDelivery(time.Second, &spooledError{errors.New("421 try again later")})
+	recordDelivery(time.Second, errors.New("550 rejected"))
+	assert.Equal(t, 1, deliveryMetrics.sent)
+	assert.Equal(t, 1, deliveryMetrics.spooled)
+	assert.Equal(t, 1, deliveryMetrics.failed)
+	assert.Equal(t, 1.5, deliveryMetrics.duration())
+	assert.False(t, deliveryMetrics.lastSuccess.IsZero())
+}
+
+func TestSendStatsd(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	stats := &deliveryStats{sent: 2, retries: 1, durations: []time.Duration{250 * time.Millisecond, time.Second}}
+	assert.NoError(t, sendStatsd(conn.LocalAddr().String(), stats))
+	datagram := make([]byte, 512)
+	n, _, err := conn.ReadFrom(datagram)
+	assert.NoError(t, err)
+	assert.Equal(t, "sensu_email_handler.emails_sent:2|c\nsensu_email_handler.smtp_retries:1|c\n"+
+		"sensu_email_handler.send_duration:250|ms\nsensu_email_handler.send_duration:1000|ms", string(datagram[:n]))
+}
+
+func TestPushDeliveryMetrics(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		bodyBytes, _ := ioutil.ReadAll(r.Body)
+		body = string(bodyBytes)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	host, _ := os.Hostname()
+	assert.NoError(t, pushDeliveryMetrics(server.URL+"/", &deliveryStats{failed: 1, lastFailure: time.Unix(1600000000, 0)}))
+	assert.Equal(t, "/metrics/job/sensu_email_handler/instance/"+host, path)
+	assert.Contains(t, body, "# TYPE sensu_email_handler_emails_failed gauge\nsensu_email_handler_emails_failed 1\n")
+	assert.Contains(t, body, "sensu_email_handler_last_failure_timestamp_seconds 1600000000\n")
+	assert.NotContains(t, body, "last_success")
+}
+
+func TestUpdateMetricsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "email.prom")
+
+	assert.NoError(t, updateMetricsFile(file, &deliveryStats{sent: 2, durations: []time.Duration{time.Second, 2 * time.Second}, lastSuccess: time.Unix(1600000000, 0)}))
+	assert.NoError(t, updateMetricsFile(file, &deliveryStats{sent: 1, failed: 1, durations: []time.Duration{time.Second}, lastFailure: time.Unix(1600000060, 0)}))
+	metrics, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	var values []string
+	for _, line := range strings.Split(strings.TrimSpace(string(metrics)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			values = append(values, line)
+		}
+	}
+	assert.Equal(t, []string{
+		"sensu_email_handler_emails_sent_total 3",
+		"sensu_email_handler_emails_failed_total 1",
+		"sensu_email_handler_emails_spooled_total 0",
+		"sensu_email_handler_smtp_retries_total 0",
+		"sensu_email_handler_send_duration_seconds_sum 4",
+		"sensu_email_handler_send_duration_seconds_count 3",
+		"sensu_email_handler_last_success_timestamp_seconds 1600000000",
+		"sensu_email_handler_last_failure_timestamp_seconds 1600000060",
+	}, values)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/sensu/sensu-email-handler/pkg/templates"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// digest is the data available to the digest subject and body templates.
+type digest struct {
+	// Group is the parent of the proxy entities the digest is for, with
+	// --proxyGroupLabel
+	Group string
+	// Events are all of the events in the digest, most severe first
+	Events []*corev2.Event
+	// Entities are the events grouped by entity, sorted by entity name
+	Entities []*digestEntity
+	// Counts is the number of events keyed by status name (e.g. CRITICAL)
+	Counts map[string]int
+}
+
+type digestEntity struct {
+	Name      string
+	Namespace string
+	// Events are the entity's events, most severe first
+	Events []*corev2.Event
+}
+
+const defaultDigestSubjectTemplate = "{{Translate \"Sensu Alert Digest\"}}{{if .Group}} - {{.Group}}{{end}} - {{len .Events}} {{Translate \"events\"}}"
+
+const defaultDigestBodyTemplate = `{{Translate "Sensu Alert Digest"}}{{if .Group}} - {{.Group}}{{end}} - {{len .Events}} {{Translate "events"}}
+{{range $status, $count := .Counts}}
+{{Translate $status}}: {{$count}}{{end}}
+{{range .Entities}}
+{{.Name}} ({{.Namespace}})
+{{range .Events}}  [{{Translate (StatusName .Check.Status)}}] {{.Check.Name}}: {{.Check.Output}}
+{{end}}{{end}}`
+
+// events read from stdin when it contains a JSON array, to be sent as a digest
+var digestEvents []*corev2.Event
+
+// the event, or array of events, as read from stdin
+var eventJSON []byte
+
+// prepareStdin reads the event(s) from stdin before the plugin SDK does. When
+// stdin contains a JSON array of events they are kept to be sent as a single
+// digest email and the first event is handed on to the SDK in place of stdin.
+func prepareStdin() error {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice != 0 {
+		return nil
+	}
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read STDIN: %v", err)
+	}
+
+	trimmed := bytes.TrimSpace(input)
+	eventJSON = trimmed
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return fmt.Errorf("failed to unmarshal STDIN data: %v", err)
+		}
+		if len(raw) == 0 {
+			return errors.New("no events in STDIN data")
+		}
+		events := make([]*corev2.Event, len(raw))
+		for i, r := range raw {
+			events[i] = &corev2.Event{}
+			if err := json.Unmarshal(r, events[i]); err != nil {
+				return fmt.Errorf("failed to unmarshal STDIN data: %v", err)
+			}
+			if err := events[i].Validate(); err != nil {
+				return fmt.Errorf("invalid event in STDIN data: %v", err)
+			}
+		}
+		digestEvents = events
+		input = raw[0]
+	}
+	return replaceStdin(input)
+}
+
+// replaceStdin replaces stdin with the input, for the plugin SDK to read.
+func replaceStdin(input []byte) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	go func() {
+		_, _ = w.Write(input)
+		w.Close()
+	}()
+	os.Stdin = r
+	return nil
+}
+
+// sendDigest sends a single email summarizing all of the events.
+func sendDigest(events []*corev2.Event) error {
+	return sendGroupDigest("", events)
+}
+
+// sendGroupDigest sends emails summarizing the events of the proxy entities
+// of the group, one to the recipients of each set of events routed alike, so
+// that no recipient receives the events routed to others.
+func sendGroupDigest(group string, events []*corev2.Event) error {
+	var included []*corev2.Event
+	for _, event := range events {
+		reason, err := suppressReason(event)
+		if err != nil {
+			return err
+		}
+		if len(reason) > 0 {
+			fmt.Printf("Not including %s/%s in digest: %s\n", event.Entity.Name, eventCheck(event).Name, reason)
+			continue
+		}
+		included = append(included, event)
+	}
+	if len(included) == 0 {
+		fmt.Println("Not sending digest email: no events to include")
+		return nil
+	}
+
+	var firstErr error
+	for _, destEvents := range splitByDestination(included) {
+		if err := sendDestinationDigest(group, destEvents); err != nil {
+			fmt.Printf("Failed to send digest email of %d events: %v\n", len(destEvents), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// splitByDestination groups the events by their to, cc and bcc recipients,
+// in the order the destinations first occur.
+func splitByDestination(events []*corev2.Event) [][]*corev2.Event {
+	var groups [][]*corev2.Event
+	index := map[string]int{}
+	for _, event := range events {
+		to, cc, bcc := eventRcpts(event)
+		key := fmt.Sprintf("%q %q %q", to, cc, bcc)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], event)
+	}
+	return groups
+}
+
+// sendDestinationDigest sends a single email summarizing the events, which
+// all have the same recipients.
+func sendDestinationDigest(group string, events []*corev2.Event) error {
+	d := newDigest(events)
+	d.Group = group
+	// the digest headers and attachment only cover the events sent
+	if len(digestEvents) > 0 {
+		all := digestEvents
+		digestEvents = d.Events
+		defer func() { digestEvents = all }()
+	}
+
+	subject, subjectErr := resolveTemplateData(config.DigestSubjectTemplate, d, ContentPlain)
+	if subjectErr != nil {
+		if !config.FallbackOnTemplateError {
+			return subjectErr
+		}
+		subject = fmt.Sprintf("Sensu Alert Digest - %d events", len(d.Events))
+	}
+
+	contentType := bodyContentType(digestBodyTemplate)
+	body, bodyErr := resolveTemplateData(digestBodyTemplate, d, contentType)
+	if (subjectErr != nil || bodyErr != nil) && config.FallbackOnTemplateError {
+		body, contentType = fallbackBody(firstError(subjectErr, bodyErr), d.Events...), ContentPlain
+	} else if bodyErr != nil {
+		return bodyErr
+	}
+
+	// the most severe event is used for any event based headers
+	sent, err := sendRateLimited(d.Events[0], subject, body, contentType)
+	if err != nil || !sent {
+		return err
+	}
+	for _, event := range events {
+		if err := recordSent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDigest groups the events by entity, ordering them by severity.
+func newDigest(events []*corev2.Event) *digest {
+	sorted := make([]*corev2.Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		si, sj := severity(eventCheck(sorted[i]).Status), severity(eventCheck(sorted[j]).Status)
+		if si != sj {
+			return si > sj
+		}
+		return eventCheck(sorted[i]).Name < eventCheck(sorted[j]).Name
+	})
+
+	d := &digest{
+		Events: sorted,
+		Counts: map[string]int{},
+	}
+	entities := map[string]*digestEntity{}
+	for _, event := range sorted {
+		d.Counts[templates.StatusName(eventCheck(event).Status)]++
+		key := event.Entity.Namespace + "/" + event.Entity.Name
+		entity, ok := entities[key]
+		if !ok {
+			entity = &digestEntity{Name: event.Entity.Name, Namespace: event.Entity.Namespace}
+			entities[key] = entity
+			d.Entities = append(d.Entities, entity)
+		}
+		entity.Events = append(entity.Events, event)
+	}
+	sort.SliceStable(d.Entities, func(i, j int) bool {
+		if d.Entities[i].Name != d.Entities[j].Name {
+			return d.Entities[i].Name < d.Entities[j].Name
+		}
+		return d.Entities[i].Namespace < d.Entities[j].Namespace
+	})
+	return d
+}
+
+// severity ranks a check status: critical, warning, unknown and finally OK.
+func severity(status uint32) int {
+	switch status {
+	case 0:
+		return 0
+	case 1:
+		return 2
+	case 2:
+		return 3
+	default:
+		return 1
+	}
+}
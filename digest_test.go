@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func digestFixture() []*corev2.Event {
+	events := []*corev2.Event{
+		corev2.FixtureEvent("web01", "disk"),
+		corev2.FixtureEvent("db01", "mysql"),
+		corev2.FixtureEvent("web01", "nginx"),
+		corev2.FixtureEvent("web01", "load"),
+	}
+	events[0].Check.Status = 1
+	events[0].Check.Output = "disk 85% full"
+	events[1].Check.Status = 2
+	events[1].Check.Output = "mysql down"
+	events[2].Check.Status = 2
+	events[2].Check.Output = "nginx down"
+	events[3].Check.Status = 0
+	events[3].Check.Output = "load ok"
+	return events
+}
+
+func TestNewDigest(t *testing.T) {
+	d := newDigest(digestFixture())
+	assert.Equal(t, "mysql", d.Events[0].Check.Name)
+	assert.Equal(t, "nginx", d.Events[1].Check.Name)
+	assert.Equal(t, "disk", d.Events[2].Check.Name)
+	assert.Equal(t, "load", d.Events[3].Check.Name)
+	assert.Equal(t, map[string]int{"CRITICAL": 2, "WARNING": 1, "OK": 1}, d.Counts)
+
+	assert.Len(t, d.Entities, 2)
+	assert.Equal(t, "db01", d.Entities[0].Name)
+	assert.Equal(t, "web01", d.Entities[1].Name)
+	assert.Len(t, d.Entities[1].Events, 3)
+
+	subject, err := resolveTemplateData(defaultDigestSubjectTemplate, d, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensu Alert Digest - 4 events", subject)
+	body, err := resolveTemplateData(defaultDigestBodyTemplate, d, ContentPlain)
+	assert.NoError(t, err)
+	assert.Contains(t, body, "CRITICAL: 2\n")
+	assert.Contains(t, body, "web01 (default)\n  [CRITICAL] nginx: nginx down\n  [WARNING] disk: disk 85% full\n  [OK] load: load ok\n")
+}
+
+func TestSendDigestByDestination(t *testing.T) {
+	file := writeRoutingRules(t, `
+rules:
+  - name: databases
+    check: mysql
+    to: [dba@example.com]
+default:
+  to: [ops@example.com]
+`)
+	defer os.Remove(file)
+	rules, err := loadRoutingRules(file)
+	assert.NoError(t, err)
+	routingRules = rules
+	memory := &transport.Memory{}
+	messageSender = memory
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.Charset = defaultCharset
+	config.DigestSubjectTemplate = defaultDigestSubjectTemplate
+	digestBodyTemplate = defaultDigestBodyTemplate
+	events := digestFixture()
+	digestEvents = events
+	defer func() {
+		routingRules = nil
+		messageSender = nil
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.Charset = ""
+		config.DigestSubjectTemplate = ""
+		digestEvents = nil
+	}()
+
+	// each recipient only receives the events routed to them
+	assert.NoError(t, sendDigest(events))
+	messages := memory.Messages()
+	if !assert.Len(t, messages, 2) {
+		return
+	}
+	assert.Equal(t, []string{"ops@example.com"}, messages[0].To)
+	assert.Equal(t, []string{"dba@example.com"}, messages[1].To)
+	for i, expected := range []struct {
+		subject, events string
+		absent          string
+	}{
+		{"Sensu Alert Digest - 3 events", "3", "mysql down"},
+		{"Sensu Alert Digest - 1 events", "1", "nginx down"},
+	} {
+		msg, err := mail.ReadMessage(strings.NewReader(string(messages[i].Data)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected.subject, msg.Header.Get("Subject"))
+		assert.Equal(t, expected.events, msg.Header.Get("X-Sensu-Digest"))
+		body, err := ioutil.ReadAll(msg.Body)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(body), expected.absent)
+	}
+	assert.Len(t, digestEvents, 4)
+}
+
+func TestPrepareStdin(t *testing.T) {
+	stdin := os.Stdin
+	defer func() {
+		os.Stdin = stdin
+		digestEvents = nil
+	}()
+
+	event, err := ioutil.ReadFile("event.json")
+	assert.NoError(t, err)
+	input := "[" + string(event) + "," + string(event) + "]"
+	f, err := ioutil.TempFile("", "events")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(input)
+	assert.NoError(t, err)
+	_, err = f.Seek(0, 0)
+	assert.NoError(t, err)
+	os.Stdin = f
+
+	assert.NoError(t, prepareStdin())
+	assert.Len(t, digestEvents, 2)
+
+	first, err := ioutil.ReadAll(os.Stdin)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(event), string(first))
+}
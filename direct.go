@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	"golang.org/x/net/idna"
+)
+
+// directDeliveryPort is the port of the MX hosts delivered to with
+// --directDelivery
+var directDeliveryPort uint64 = 25
+
+// lookupMX resolves the MX records of a domain, sorted by preference
+var lookupMX = net.LookupMX
+
+// undeliveredError reports the recipients an email could not be delivered
+// to with --directDelivery, after it was delivered to the others.
+type undeliveredError struct {
+	recipients rcpts
+	err        error
+}
+
+func (e *undeliveredError) Error() string {
+	return fmt.Sprintf("failed to deliver to %s: %v; the email was delivered to the other recipients", strings.Join(e.recipients, ", "), e.err)
+}
+
+func (e *undeliveredError) Unwrap() error {
+	return e.err
+}
+
+// undeliveredRecipients returns the recipients a failed transmission still
+// has to be retried for.
+func undeliveredRecipients(recipients rcpts, err error) rcpts {
+	var undelivered *undeliveredError
+	if errors.As(err, &undelivered) {
+		return undelivered.recipients
+	}
+	return recipients
+}
+
+// transmitDirect delivers the message to the MX hosts of each recipient
+// domain in turn. When it is only delivered to some of the domains, the
+// error is an *undeliveredError.
+func transmitDirect(envelope transport.Envelope, msg []byte) error {
+	var domains []string
+	byDomain := map[string]rcpts{}
+	for _, to := range envelope.To {
+		address := smtpsend.EnvelopeAddress(to)
+		domain := strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+		if _, ok := byDomain[domain]; !ok {
+			domains = append(domains, domain)
+		}
+		byDomain[domain] = append(byDomain[domain], to)
+	}
+
+	var undelivered rcpts
+	var firstErr error
+	for _, domain := range domains {
+		domainEnvelope := envelope
+		domainEnvelope.To = byDomain[domain]
+		if err := deliverToDomain(domain, domainEnvelope, msg); err != nil {
+			debugf("failed to deliver to %s: %v", domain, err)
+			undelivered = append(undelivered, byDomain[domain]...)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr == nil || len(undelivered) == len(envelope.To) {
+		return firstErr
+	}
+	return &undeliveredError{recipients: undelivered, err: firstErr}
+}
+
+// deliverToDomain delivers the message to the envelope recipients, all of
+// the domain, trying its MX hosts in order of preference until one accepts
+// it or rejects it permanently.
+func deliverToDomain(domain string, envelope transport.Envelope, msg []byte) error {
+	asciiDomain, err := idna.Lookup.ToASCII(domain)
+	if err != nil || len(asciiDomain) == 0 {
+		return &textproto.Error{Code: 553, Msg: fmt.Sprintf("invalid recipient domain %q", domain)}
+	}
+	hosts, secure, err := mxHosts(asciiDomain)
+	if err != nil {
+		return err
+	}
+	if config.DANE && !secure {
+		debugf("the MX records of %s are not DNSSEC signed, so its MX hosts aren't authenticated with DANE", asciiDomain)
+	}
+	policy, err := mtaSTSPolicyFor(asciiDomain)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, host := range hosts {
+		tlsConfig, requireTLS, err := directTLS(host, policy, config.DANE && secure)
+		if err != nil {
+			debugf("not delivering to %s: %v", host, err)
+			lastErr = err
+			continue
+		}
+		conn, err := connectSMTP(host, directDeliveryPort, tlsConfig, requireTLS, nil)
+		if err != nil {
+			debugf("failed to connect to %s: %v", host, err)
+			lastErr = fmt.Errorf("%s: %v", host, err)
+			continue
+		}
+		err = sendMessage(conn, envelope, msg)
+		if err == nil {
+			_ = conn.Quit()
+			return nil
+		}
+		conn.Close()
+		if permanentError(err) {
+			return err
+		}
+		debugf("failed to deliver to %s: %v", host, err)
+		lastErr = err
+	}
+	return lastErr
+}
+
+// directTLS returns the TLS config for the MX host and whether STARTTLS is
+// required. With dane, DNSSEC signed TLSA records of the host take
+// precedence over an MTA-STS policy (RFC 8461 section 2). Without either
+// TLS is opportunistic (RFC 7435), since MX hosts rarely have certificates
+// for their names.
+func directTLS(host string, policy *mtaSTSPolicy, dane bool) (*tls.Config, bool, error) {
+	tlsConfig := smtpTLSConfig(host, true)
+	if dane {
+		records, secure, err := lookupTLSA(fmt.Sprintf("_%d._tcp.%s", directDeliveryPort, host))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to look up the TLSA records of %s: %v", host, err)
+		}
+		if secure && len(records) > 0 {
+			var usable []tlsaRecord
+			for _, record := range records {
+				if record.usable() {
+					usable = append(usable, record)
+				}
+			}
+			// with no usable records TLS is still required, unauthenticated
+			if len(usable) > 0 {
+				tlsConfig.VerifyPeerCertificate = daneVerifier(host, usable)
+			}
+			debugf("%s has %d usable TLSA records", host, len(usable))
+			return tlsConfig, true, nil
+		}
+	}
+	if policy == nil {
+		return tlsConfig, config.RequireTLS, nil
+	}
+	if policy.Mode != mtaSTSEnforce {
+		if !policy.matches(host) {
+			debugf("%s is not an MX host of the MTA-STS policy, which is in testing mode", host)
+		}
+		return tlsConfig, config.RequireTLS, nil
+	}
+	if !policy.matches(host) {
+		return nil, false, fmt.Errorf("%s is not an MX host of the enforced MTA-STS policy", host)
+	}
+	return smtpTLSConfig(host, false), true, nil
+}
+
+// mxHosts returns the hosts to deliver to for the domain, the domain itself
+// when it has no MX records (RFC 5321 section 5.1), and whether they were
+// validated with DNSSEC. With --dane they're looked up with the validating
+// resolver, since the TLSA records of MX hosts only authenticate them when
+// the MX records are secure too (RFC 7672 section 2.2.1).
+func mxHosts(asciiDomain string) ([]string, bool, error) {
+	var records []*net.MX
+	var secure bool
+	var err error
+	if config.DANE {
+		records, secure, err = lookupSecureMX(asciiDomain)
+	} else {
+		records, err = lookupMX(asciiDomain)
+	}
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return []string{asciiDomain}, secure, nil
+		}
+		return nil, false, err
+	}
+	// a null MX (RFC 7505)
+	if len(records) == 1 && records[0].Host == "." {
+		return nil, false, &textproto.Error{Code: 556, Msg: asciiDomain + " does not accept mail"}
+	}
+	hosts := make([]string, len(records))
+	for i, record := range records {
+		hosts[i] = strings.TrimSuffix(record.Host, ".")
+	}
+	debugf("MX hosts of %s: %s", asciiDomain, strings.Join(hosts, ", "))
+	return hosts, secure, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransmitDirect(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.DirectDelivery = true
+	config.FromEmail = "sensu@example.com"
+	directDeliveryPort = port
+	lookupMX = func(domain string) ([]*net.MX, error) {
+		switch domain {
+		case "example.com":
+			// nothing listens on the preferred MX host
+			return []*net.MX{{Host: "127.0.0.2.", Pref: 10}, {Host: "127.0.0.1.", Pref: 20}}, nil
+		case "null.example.com":
+			return []*net.MX{{Host: ".", Pref: 0}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	defer func() {
+		config.DirectDelivery = false
+		config.FromEmail = ""
+		directDeliveryPort = 25
+		lookupMX = net.LookupMX
+	}()
+
+	recipients := rcpts{"ops@example.com", "Dev <dev@Example.com>", "nobody@null.example.com"}
+	err := transmit("", recipients, []byte("Subject: disk\r\n\r\ndisk full\r\n"))
+	var undelivered *undeliveredError
+	assert.True(t, errors.As(err, &undelivered))
+	assert.Equal(t, rcpts{"nobody@null.example.com"}, undeliveredRecipients(recipients, err))
+	assert.True(t, permanentError(err))
+	assert.Contains(t, <-messages, "disk full")
+
+	// without MX records the domain itself is the MX host
+	hosts, secure, err := mxHosts("mail.example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mail.example.org"}, hosts)
+	assert.False(t, secure)
+	hosts, _, err = mxHosts("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.2", "127.0.0.1"}, hosts)
+}
+
+func TestMXHostsDANE(t *testing.T) {
+	config.DANE = true
+	lookupSecureMX = func(domain string) ([]*net.MX, bool, error) {
+		switch domain {
+		case "example.com", "example.net":
+			return []*net.MX{{Host: "mx." + domain + ".", Pref: 10}}, domain == "example.com", nil
+		}
+		return nil, true, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	defer func() {
+		config.DANE = false
+		lookupSecureMX = dnsLookupMX
+	}()
+
+	hosts, secure, err := mxHosts("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mx.example.com"}, hosts)
+	assert.True(t, secure)
+
+	// DANE doesn't apply to the MX hosts of insecure MX records
+	_, secure, err = mxHosts("example.net")
+	assert.NoError(t, err)
+	assert.False(t, secure)
+
+	hosts, secure, err = mxHosts("mail.example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mail.example.org"}, hosts)
+	assert.True(t, secure)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// headers included in the DKIM signature, when present in the message
+var dkimSignedHeaders = []string{
+	"From",
+	"To",
+	"Subject",
+	"Date",
+	"Message-ID",
+	"In-Reply-To",
+	"References",
+	"MIME-Version",
+	"Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// loadDKIMKey reads a PEM encoded RSA or Ed25519 private key from file.
+func loadDKIMKey(file string) (crypto.Signer, error) {
+	keyBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DKIM private key file %s", file)
+	}
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in DKIM private key file %s", file)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("DKIM private key file %s does not contain an RSA or Ed25519 private key", file)
+}
+
+// dkimSign signs the message using relaxed/relaxed canonicalization and
+// returns it with the DKIM-Signature header prepended.
+func dkimSign(msg []byte, key crypto.Signer, domain, selector string, t time.Time) ([]byte, error) {
+	i := bytes.Index(msg, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, errors.New("message has no header/body separator")
+	}
+	header := string(msg[:i+2])
+	body := msg[i+4:]
+
+	var algorithm string
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return nil, errors.New("unsupported DKIM key type")
+	}
+
+	bodyHash := sha256.Sum256(dkimCanonicalBody(body))
+
+	fields := dkimHeaderFields(header)
+	var signed []string
+	var canonical strings.Builder
+	for _, name := range dkimSignedHeaders {
+		field, ok := fields[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		signed = append(signed, strings.ToLower(name))
+		canonical.WriteString(dkimCanonicalHeader(field))
+	}
+
+	sigValue := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		algorithm, domain, selector, t.Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	sigHeader := "DKIM-Signature: " + sigValue
+	canonical.WriteString(strings.TrimSuffix(dkimCanonicalHeader(sigHeader+"\r\n"), "\r\n"))
+
+	hash := sha256.Sum256([]byte(canonical.String()))
+	var (
+		sig []byte
+		err error
+	)
+	if algorithm == "ed25519-sha256" {
+		sig, err = key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	} else {
+		sig, err = key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DKIM signature: %v", err)
+	}
+
+	signedMsg := []byte(sigHeader + base64.StdEncoding.EncodeToString(sig) + "\r\n")
+	return append(signedMsg, msg...), nil
+}
+
+// dkimHeaderFields returns the (possibly folded) header fields keyed by their
+// lowercased names. When a header occurs more than once the last one wins, as
+// it is the first one a verifier will use.
+func dkimHeaderFields(header string) map[string]string {
+	fields := map[string]string{}
+	var current string
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		if i := strings.Index(current, ":"); i > 0 {
+			fields[strings.ToLower(strings.TrimSpace(current[:i]))] = current
+		}
+	}
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			current += line
+			continue
+		}
+		flush()
+		current = line
+	}
+	flush()
+	return fields
+}
+
+// dkimCanonicalHeader applies the "relaxed" header canonicalization algorithm
+// from RFC 6376 section 3.4.2 to a single header field.
+func dkimCanonicalHeader(field string) string {
+	i := strings.Index(field, ":")
+	name := strings.ToLower(strings.TrimSpace(field[:i]))
+	value := strings.Replace(field[i+1:], "\r\n", "", -1)
+	value = strings.Join(strings.Fields(value), " ")
+	return name + ":" + value + "\r\n"
+}
+
+// dkimCanonicalBody applies the "relaxed" body canonicalization algorithm
+// from RFC 6376 section 3.4.4.
+func dkimCanonicalBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		var b strings.Builder
+		wsp := false
+		for _, r := range line {
+			if r == ' ' || r == '\t' {
+				wsp = true
+				continue
+			}
+			if wsp {
+				b.WriteByte(' ')
+				wsp = false
+			}
+			b.WriteRune(r)
+		}
+		lines[i] = b.String()
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
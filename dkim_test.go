@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDKIMCanonicalization(t *testing.T) {
+	// examples from RFC 6376 section 3.4.5
+	assert.Equal(t, "a:X\r\n", dkimCanonicalHeader("A: X\r\n"))
+	assert.Equal(t, "b:Y Z\r\n", dkimCanonicalHeader("B : Y\t\r\n\tZ  \r\n"))
+	assert.Equal(t, " C\r\nD E\r\n", string(dkimCanonicalBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))))
+	assert.Equal(t, "", string(dkimCanonicalBody([]byte("\r\n"))))
+}
+
+func TestDKIMSign(t *testing.T) {
+	msg := []byte("From: sensu@example.com\r\n" +
+		"To: ops@example.com\r\n" +
+		"Subject: Sensu Alert\r\n" +
+		"X-Team: platform\r\n" +
+		"\r\n" +
+		"check output\r\n")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	for _, key := range []crypto.Signer{rsaKey, edKey} {
+		signed, err := dkimSign(msg, key, "example.com", "sensu", time.Unix(1598478983, 0))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(signed), string(msg)))
+
+		sigLine := strings.SplitN(string(signed), "\r\n", 2)[0]
+		assert.Contains(t, sigLine, "d=example.com; s=sensu; t=1598478983; h=from:to:subject;")
+
+		// verify the signature by recomputing the signed data
+		b64sig := regexp.MustCompile(`b=([^;]*)$`).FindStringSubmatch(sigLine)[1]
+		sig, err := base64.StdEncoding.DecodeString(b64sig)
+		assert.NoError(t, err)
+		data := dkimCanonicalHeader("From: sensu@example.com\r\n") +
+			dkimCanonicalHeader("To: ops@example.com\r\n") +
+			dkimCanonicalHeader("Subject: Sensu Alert\r\n") +
+			strings.TrimSuffix(dkimCanonicalHeader(strings.TrimSuffix(sigLine, b64sig)+"\r\n"), "\r\n")
+		hash := sha256.Sum256([]byte(data))
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			assert.NoError(t, rsa.VerifyPKCS1v15(&k.PublicKey, crypto.SHA256, hash[:], sig))
+		case ed25519.PrivateKey:
+			assert.True(t, ed25519.Verify(k.Public().(ed25519.PublicKey), hash[:], sig))
+		}
+	}
+}
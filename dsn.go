@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// checkDSN validates and normalizes --dsnNotify and --dsnReturn.
+func checkDSN() error {
+	if len(config.DSNNotify) > 0 {
+		conditions := strings.Split(strings.ToUpper(strings.Replace(config.DSNNotify, " ", "", -1)), ",")
+		for _, c := range conditions {
+			switch c {
+			case "SUCCESS", "FAILURE", "DELAY":
+			case "NEVER":
+				if len(conditions) > 1 {
+					return errors.New("--dsnNotify NEVER cannot be combined with other conditions")
+				}
+			default:
+				return fmt.Errorf("invalid --dsnNotify condition %q, must be SUCCESS, FAILURE, DELAY or NEVER", c)
+			}
+		}
+		config.DSNNotify = strings.Join(conditions, ",")
+	}
+	if len(config.DSNReturn) > 0 {
+		config.DSNReturn = strings.ToUpper(config.DSNReturn)
+		if config.DSNReturn != "FULL" && config.DSNReturn != "HDRS" {
+			return fmt.Errorf("invalid --dsnReturn %q, must be FULL or HDRS", config.DSNReturn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDSN(t *testing.T) {
+	defer func() {
+		config.DSNNotify = ""
+		config.DSNReturn = ""
+	}()
+	config.DSNNotify = "failure, delay"
+	config.DSNReturn = "hdrs"
+	assert.NoError(t, checkDSN())
+	assert.Equal(t, "FAILURE,DELAY", config.DSNNotify)
+	assert.Equal(t, "HDRS", config.DSNReturn)
+
+	config.DSNNotify = "NEVER,FAILURE"
+	assert.Error(t, checkDSN())
+	config.DSNNotify = "BOUNCE"
+	assert.Error(t, checkDSN())
+	config.DSNNotify = ""
+	config.DSNReturn = "BODY"
+	assert.Error(t, checkDSN())
+}
+
+func TestTransmitDSN(t *testing.T) {
+	config.SmtpHost = "127.0.0.1"
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.DSNNotify = "FAILURE,DELAY"
+	config.DSNReturn = "HDRS"
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.DSNNotify = ""
+		config.DSNReturn = ""
+	}()
+	event := corev2.FixtureEvent("foo", "bar")
+	event.ID = []byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	envelopeID := eventID(event)
+	assert.Equal(t, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", envelopeID)
+
+	for _, extensions := range [][]string{{"DSN"}, {"DSN", "PIPELINING"}} {
+		port, messages := startSMTPServer(t, extensions...)
+		config.SmtpPort = port
+		assert.NoError(t, transmit(envelopeID, rcpts{"ops+disk@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n")))
+		msg := <-messages
+		assert.Contains(t, msg, "X-Envelope: MAIL FROM:<sensu@example.com> BODY=8BITMIME RET=HDRS ENVID=6ba7b810-9dad-11d1-80b4-00c04fd430c8\n", extensions)
+		assert.Contains(t, msg, "X-Envelope: RCPT TO:<ops+disk@example.com> NOTIFY=FAILURE,DELAY ORCPT=rfc822;ops+2Bdisk@example.com\n", extensions)
+		assert.Contains(t, msg, "disk full")
+	}
+
+	// nor are they requested unless configured
+	config.DSNNotify = ""
+	config.DSNReturn = ""
+	port, messages := startSMTPServer(t, "DSN")
+	config.SmtpPort = port
+	assert.NoError(t, transmit(envelopeID, rcpts{"ops@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n")))
+	msg := <-messages
+	assert.Contains(t, msg, "X-Envelope: MAIL FROM:<sensu@example.com> BODY=8BITMIME\n")
+	assert.Contains(t, msg, "X-Envelope: RCPT TO:<ops@example.com>\n")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// fallbackAlert is the JSON posted to --fallbackWebhookURL for an email that
+// could not be sent. Text summarizes it for chat webhooks such as Slack's,
+// which ignore the other fields.
+type fallbackAlert struct {
+	Text        string   `json:"text"`
+	Subject     string   `json:"subject"`
+	Body        string   `json:"body,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	Recipients  []string `json:"recipients"`
+	Error       string   `json:"error"`
+	Namespace   string   `json:"namespace"`
+	Entity      string   `json:"entity"`
+	Check       string   `json:"check"`
+	Status      uint32   `json:"status"`
+	EventID     string   `json:"event_id,omitempty"`
+}
+
+// failedRecipients returns the recipients an email failed to be sent to,
+// which are all of them unless the error reports some as invalid or
+// undelivered.
+func failedRecipients(recipients rcpts, err error) rcpts {
+	var invalid *smtpsend.InvalidRecipientsError
+	if errors.As(err, &invalid) {
+		return invalid.Recipients
+	}
+	return undeliveredRecipients(recipients, err)
+}
+
+// newFallbackAlert returns the fallback alert for the email of the event that
+// failed to be sent to the recipients. A text body is included in the text.
+func newFallbackAlert(event *corev2.Event, recipients rcpts, subject, body, contentType string, err error) *fallbackAlert {
+	alert := &fallbackAlert{
+		Subject:     subject,
+		Body:        body,
+		ContentType: contentType,
+		Recipients:  recipients,
+		Error:       err.Error(),
+		Namespace:   event.Entity.Namespace,
+		Entity:      event.Entity.Name,
+		Check:       eventCheck(event).Name,
+		Status:      eventCheck(event).Status,
+		EventID:     eventID(event),
+	}
+	alert.Text = fallbackText(subject, recipients, err)
+	if !strings.HasPrefix(contentType, "text/html") && len(body) > 0 {
+		alert.Text += "\n\n" + body
+	}
+	return alert
+}
+
+func fallbackText(subject string, recipients []string, err error) string {
+	return fmt.Sprintf("Failed to email %q to %s: %v", subject, strings.Join(recipients, ", "), err)
+}
+
+// newSpooledFallbackAlert returns the fallback alert for a spooled email the
+// SMTP server rejected when --flushSpool retried it. Only its subject is
+// included, as the body is encoded in the message.
+func newSpooledFallbackAlert(spooled *spooledMessage, err error) *fallbackAlert {
+	var subject string
+	if msg, readErr := mail.ReadMessage(bytes.NewReader(spooled.Message)); readErr == nil {
+		subject = msg.Header.Get("Subject")
+		if decoded, decodeErr := new(mime.WordDecoder).DecodeHeader(subject); decodeErr == nil {
+			subject = decoded
+		}
+	}
+	return &fallbackAlert{
+		Text:       fallbackText(subject, spooled.Recipients, err),
+		Subject:    subject,
+		Recipients: spooled.Recipients,
+		Error:      err.Error(),
+		Namespace:  spooled.Namespace,
+		Entity:     spooled.Entity,
+		Check:      spooled.Check,
+		Status:     spooled.Status,
+		EventID:    spooled.EnvelopeID,
+	}
+}
+
+// postFallbackAlert posts the alert to --fallbackWebhookURL, printing the
+// outcome, as the email it stands in for has failed already.
+func postFallbackAlert(alert *fallbackAlert) {
+	if err := postFallbackWebhook(alert); err != nil {
+		fmt.Printf("Failed to post the email for %s/%s to the fallback webhook: %v\n", alert.Entity, alert.Check, err)
+		return
+	}
+	fmt.Printf("Posted the email for %s/%s to the fallback webhook\n", alert.Entity, alert.Check)
+}
+
+func postFallbackWebhook(alert *fallbackAlert) error {
+	alertBytes, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, config.FallbackWebhookURL, bytes.NewReader(alertBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the error of the client includes the URL, which is a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
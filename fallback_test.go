@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackWebhook(t *testing.T) {
+	alerts := make(chan *fallbackAlert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		alert := &fallbackAlert{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(alert))
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	rejected := &textproto.Error{Code: 554, Msg: "relay access denied"}
+	memory := &transport.Memory{Err: rejected}
+	messageSender = memory
+	config.FallbackWebhookURL = server.URL
+	config.FromEmail = "sensu@example.com"
+	defer func() {
+		messageSender = nil
+		config.FallbackWebhookURL = ""
+		config.FromEmail = ""
+		config.SpoolDir = ""
+	}()
+
+	event := corev2.FixtureEvent("server01", "disk")
+	event.Check.Status = 2
+	err := deliverMessage(event, newMessageID(), rcpts{"ops@example.com"}, nil, nil, "server01/disk is critical", "disk full", "text/plain")
+	assert.Equal(t, rejected, err)
+	alert := <-alerts
+	assert.Equal(t, "server01/disk is critical", alert.Subject)
+	assert.Equal(t, "disk full", alert.Body)
+	assert.Equal(t, []string{"ops@example.com"}, alert.Recipients)
+	assert.Equal(t, rejected.Error(), alert.Error)
+	assert.Equal(t, "server01", alert.Entity)
+	assert.Equal(t, "disk", alert.Check)
+	assert.Equal(t, uint32(2), alert.Status)
+	assert.Equal(t, eventID(event), alert.EventID)
+	assert.Equal(t, `Failed to email "server01/disk is critical" to ops@example.com: `+rejected.Error()+"\n\ndisk full", alert.Text)
+
+	// a spooled email is not lost
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.SpoolDir = dir
+	memory.Err = errors.New("connection refused")
+	assert.NoError(t, deliverMessage(event, newMessageID(), rcpts{"ops@example.com"}, nil, nil, "server01/disk is critical", "disk full", "text/plain"))
+	assert.Empty(t, alerts)
+}
+
+func TestFallbackWebhookFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	config.FallbackWebhookURL = server.URL
+	defer func() { config.FallbackWebhookURL = "" }()
+
+	err := postFallbackWebhook(&fallbackAlert{})
+	if assert.Error(t, err) {
+		assert.Equal(t, "403 Forbidden", err.Error())
+	}
+
+	// the URL, a secret, is not in the error
+	config.FallbackWebhookURL = "http://127.0.0.1:0/services/T000/B000/secret"
+	err = postFallbackWebhook(&fallbackAlert{})
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "secret")
+	}
+}
+
+func TestSpooledFallbackAlert(t *testing.T) {
+	spooled := &spooledMessage{
+		Namespace:  "default",
+		Entity:     "server01",
+		Check:      "disk",
+		Status:     2,
+		Recipients: []string{"ops@example.com"},
+		Message:    []byte("Subject: =?utf-8?q?disk_=C3=BCber_90%?=\r\n\r\ndisk full\r\n"),
+	}
+	alert := newSpooledFallbackAlert(spooled, errors.New("550 no such user"))
+	assert.Equal(t, "disk über 90%", alert.Subject)
+	assert.Empty(t, alert.Body)
+	assert.Equal(t, uint32(2), alert.Status)
+	assert.Equal(t, `Failed to email "disk über 90%" to ops@example.com: 550 no such user`, alert.Text)
+}
+
+func TestFailedRecipients(t *testing.T) {
+	recipients := rcpts{"ops@example.com", "lead@example.com"}
+	assert.Equal(t, recipients, failedRecipients(recipients, errors.New("connection refused")))
+	assert.Equal(t, rcpts{"lead@example.com"}, failedRecipients(recipients, &undeliveredError{recipients: rcpts{"lead@example.com"}}))
+}
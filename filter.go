@@ -0,0 +1,519 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// filterExpr is the parsed --filterExpression
+var filterExpr filterNode
+
+// filterNode is a node of a parsed filter expression, evaluated against the
+// event. Values are float64, string, bool or nil, or the field's own value
+// for other types of fields.
+type filterNode interface {
+	eval(event reflect.Value) (interface{}, error)
+}
+
+type filterLiteral struct {
+	value interface{}
+}
+
+func (n filterLiteral) eval(reflect.Value) (interface{}, error) {
+	return n.value, nil
+}
+
+// filterField is a field of the event, such as event.Check.Occurrences.
+// Field names are case insensitive, so the names of the event's JSON work
+// too, and map keys and slice indexes can also be given in brackets.
+type filterField struct {
+	path []string
+}
+
+func (n filterField) eval(event reflect.Value) (interface{}, error) {
+	v := event
+	for _, name := range n.path {
+		v = indirectValue(v)
+		if !v.IsValid() {
+			return nil, nil
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			v = v.FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, nil
+			}
+			v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		case reflect.Slice, reflect.Array:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= v.Len() {
+				return nil, nil
+			}
+			v = v.Index(i)
+		default:
+			return nil, fmt.Errorf("%s has no field %s", strings.Join(n.path, "."), name)
+		}
+	}
+	return filterValue(v), nil
+}
+
+type filterNot struct {
+	operand filterNode
+}
+
+func (n filterNot) eval(event reflect.Value) (interface{}, error) {
+	value, err := evalBool(n.operand, event)
+	return !value, err
+}
+
+// filterLogical is && or ||, only evaluating the right operand when needed.
+type filterLogical struct {
+	op          string
+	left, right filterNode
+}
+
+func (n filterLogical) eval(event reflect.Value) (interface{}, error) {
+	left, err := evalBool(n.left, event)
+	if err != nil || left == (n.op == "||") {
+		return left, err
+	}
+	return evalBool(n.right, event)
+}
+
+type filterComparison struct {
+	op          string
+	left, right filterNode
+	pattern     *regexp.Regexp
+}
+
+func (n filterComparison) eval(event reflect.Value) (interface{}, error) {
+	left, err := n.left.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	if n.pattern != nil {
+		s, ok := left.(string)
+		return ok && n.pattern.MatchString(s) == (n.op == "=~"), nil
+	}
+	right, err := n.right.eval(event)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return filterEqual(left, right)
+	case "!=":
+		equal, err := filterEqual(left, right)
+		return !equal, err
+	}
+	// ordering comparisons with a missing field are false
+	if left == nil || right == nil {
+		return false, nil
+	}
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %v %s %v", left, n.op, right)
+		}
+		if l < r {
+			cmp = -1
+		} else if l > r {
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %v %s %v", left, n.op, right)
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("cannot compare %v %s %v", left, n.op, right)
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// filterEqual compares values of the same type, values of different types
+// being unequal.
+func filterEqual(left, right interface{}) (bool, error) {
+	if left == nil || right == nil {
+		return left == right, nil
+	}
+	switch left.(type) {
+	case float64, string, bool:
+		return left == right, nil
+	}
+	return false, fmt.Errorf("cannot compare %v with %v", left, right)
+}
+
+// evalBool evaluates the node as a condition, a missing field being false.
+func evalBool(node filterNode, event reflect.Value) (bool, error) {
+	value, err := node.eval(event)
+	if err != nil || value == nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not true or false", value)
+	}
+	return b, nil
+}
+
+func indirectValue(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// filterValue converts the field's value to the types used by expressions.
+func filterValue(v reflect.Value) interface{} {
+	v = indirectValue(v)
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	if !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// checkFilterField returns an error if the path doesn't name a field of the
+// type, so mistyped fields are reported up front rather than never matching.
+func checkFilterField(t reflect.Type, path []string) error {
+	for i, name := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := t.FieldByNameFunc(func(field string) bool { return strings.EqualFold(field, name) })
+			if !ok || len(field.PkgPath) > 0 {
+				return fmt.Errorf("unknown field %s", strings.Join(path[:i+1], "."))
+			}
+			t = field.Type
+		case reflect.Map:
+			if t.Key().Kind() != reflect.String {
+				return fmt.Errorf("unknown field %s", strings.Join(path[:i+1], "."))
+			}
+			t = t.Elem()
+		case reflect.Slice, reflect.Array:
+			if _, err := strconv.Atoi(name); err != nil {
+				return fmt.Errorf("%s must be indexed by number", strings.Join(path[:i], "."))
+			}
+			t = t.Elem()
+		case reflect.Interface:
+			return nil
+		default:
+			return fmt.Errorf("unknown field %s", strings.Join(path[:i+1], "."))
+		}
+	}
+	return nil
+}
+
+// parseFilterExpression parses an expression such as
+// event.Check.Occurrences >= 3 && event.Entity.Labels.env == 'prod'.
+func parseFilterExpression(expression string) (filterNode, error) {
+	tokens, err := filterTokens(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// filterTokens splits the expression into identifiers, numbers, quoted
+// strings and operators.
+func filterTokens(expression string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case unicode.IsDigit(r):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case r == '\'' || r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, string(runes[i:j+1]))
+			i = j + 1
+		default:
+			if i+1 < len(runes) {
+				switch op := string(runes[i : i+2]); op {
+				case "==", "!=", "<=", ">=", "=~", "!~", "&&", "||":
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("<>!()[].-", r) {
+				return nil, fmt.Errorf("unexpected %q", r)
+			}
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right filterNode
+		right, err = p.and()
+		left = filterLogical{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	left, err := p.not()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right filterNode
+		right, err = p.not()
+		left = filterLogical{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *filterParser) not() (filterNode, error) {
+	if p.peek() == "!" {
+		p.next()
+		operand, err := p.not()
+		return filterNot{operand: operand}, err
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterNode, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	case "=~", "!~":
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		literal, ok := right.(filterLiteral)
+		pattern, isString := literal.value.(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("%s must be followed by a quoted regular expression", op)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return filterComparison{op: op, left: left, pattern: re}, nil
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return filterComparison{op: op, left: left, right: right}, nil
+}
+
+func (p *filterParser) operand() (filterNode, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, errors.New("unexpected end of expression")
+	case token == "(":
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return node, nil
+	case token == "-" || unicode.IsDigit(rune(token[0])):
+		number := token
+		if token == "-" {
+			number += p.next()
+		}
+		f, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", number)
+		}
+		return filterLiteral{value: f}, nil
+	case token[0] == '\'' || token[0] == '"':
+		return filterLiteral{value: unquoteFilterString(token)}, nil
+	case token == "true" || token == "false":
+		return filterLiteral{value: token == "true"}, nil
+	case token == "null" || token == "nil":
+		return filterLiteral{}, nil
+	case token == "event":
+		return p.field()
+	}
+	return nil, fmt.Errorf("unexpected %s", token)
+}
+
+// field parses the path following event, e.g. .Entity.Labels["app.name"].
+func (p *filterParser) field() (filterNode, error) {
+	var path []string
+	for {
+		switch p.peek() {
+		case ".":
+			p.next()
+			name := p.next()
+			if len(name) == 0 || !(unicode.IsLetter(rune(name[0])) || name[0] == '_') {
+				return nil, fmt.Errorf("invalid field name %q", name)
+			}
+			path = append(path, name)
+		case "[":
+			p.next()
+			key := p.next()
+			if len(key) > 0 && (key[0] == '\'' || key[0] == '"') {
+				key = unquoteFilterString(key)
+			} else if _, err := strconv.Atoi(key); err != nil {
+				return nil, fmt.Errorf("invalid index %s", key)
+			}
+			if p.next() != "]" {
+				return nil, errors.New("missing ]")
+			}
+			path = append(path, key)
+		default:
+			if err := checkFilterField(reflect.TypeOf(corev2.Event{}), path); err != nil {
+				return nil, err
+			}
+			return filterField{path: path}, nil
+		}
+	}
+}
+
+func unquoteFilterString(token string) string {
+	quote := token[0]
+	var b strings.Builder
+	for i := 1; i < len(token)-1; i++ {
+		// only the quote and backslash are escaped, so regular expressions
+		// can be written as is
+		if token[i] == '\\' && (token[i+1] == quote || token[i+1] == '\\') {
+			i++
+		}
+		b.WriteByte(token[i])
+	}
+	return b.String()
+}
+
+// matchesFilter reports whether the event matches the --filterExpression,
+// if set.
+func matchesFilter(event *corev2.Event) (bool, error) {
+	if filterExpr == nil {
+		return true, nil
+	}
+	matched, err := evalBool(filterExpr, reflect.ValueOf(event))
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate --%s: %v", filterExpression, err)
+	}
+	return matched, nil
+}
+
+// filterEvents returns the events matching the --filterExpression.
+func filterEvents(events []*corev2.Event) ([]*corev2.Event, error) {
+	if filterExpr == nil {
+		return events, nil
+	}
+	var matching []*corev2.Event
+	for _, event := range events {
+		matched, err := matchesFilter(event)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matching = append(matching, event)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterExpression(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Occurrences = 3
+	event.Check.Output = "disk /var is 95% full"
+	event.Entity.Labels = map[string]string{"env": "prod", "app.kubernetes.io/name": "web"}
+	event.Entity.Subscriptions = []string{"linux", "web"}
+
+	matches := map[string]bool{
+		"event.Check.Occurrences >= 3 && event.Entity.Labels.env == 'prod'":        true,
+		"event.check.occurrences > 3 || event.check.status == 0":                   true,
+		`event.Entity.Labels["app.kubernetes.io/name"] == "web"`:                   true,
+		"event.Entity.Labels.team == 'dba'":                                        false,
+		"event.Entity.Labels.team == null":                                         true,
+		"event.Entity.Labels.team > 'a'":                                           false,
+		"!(event.Check.Occurrences < 3) && event.Entity.Subscriptions[1] == 'web'": true,
+		"event.Check.Output =~ '\\d+% full' && event.Entity.Name !~ '^db'":         true,
+		"event.Check.Interval == 60 && event.Check.Publish == false":               false,
+		"event.Check.Occurrences == -1 || event.Entity.Subscriptions[5] == 'x'":    false,
+	}
+	for expression, expected := range matches {
+		expr, err := parseFilterExpression(expression)
+		if !assert.NoError(t, err, expression) {
+			continue
+		}
+		filterExpr = expr
+		matched, err := matchesFilter(event)
+		assert.NoError(t, err, expression)
+		assert.Equal(t, expected, matched, expression)
+	}
+
+	filterExpr, _ = parseFilterExpression("event.Check.Output > 3")
+	_, err := matchesFilter(event)
+	assert.Error(t, err)
+	filterExpr, _ = parseFilterExpression("event.Entity.Labels.env == 'prod'")
+	events, err := filterEvents([]*corev2.Event{event, corev2.FixtureEvent("web", "bar")})
+	assert.NoError(t, err)
+	assert.Equal(t, []*corev2.Event{event}, events)
+	filterExpr = nil
+
+	for _, invalid := range []string{
+		"event.Check.Occurences >= 3",
+		"event.Entity.Subscriptions.linux == 'x'",
+		"event.Check.Occurrences >=",
+		"(event.Check.Status == 0",
+		"check.status == 0",
+		"event.Check.Output == 'unterminated",
+		"event.Check.Output =~ '('",
+		"event.Check.Status = 0",
+	} {
+		_, err := parseFilterExpression(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestHandleFilteredEvent(t *testing.T) {
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = 1
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.ToEmail = []string{"ops@example.com"}
+	config.FilterExpression = "event.Entity.Labels.env == 'prod'"
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.ToEmail = nil
+		config.FilterExpression = ""
+		filterExpr = nil
+	}()
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.NoError(t, checkArgs(event))
+	// there's no SMTP server, so sending would fail
+	assert.NoError(t, handleEvent(event))
+	event.Entity.Labels = map[string]string{"env": "prod"}
+	assert.Error(t, handleEvent(event))
+
+	config.FilterExpression = "event.Entity.Labels.env =="
+	assert.Error(t, checkArgs(event))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// flapWindow is the number of state transitions in the 21 executions of the
+// check history that the flap score is computed over
+const flapWindow = 20
+
+// flapScore returns the percentage of state changes in the history, as
+// Nagios computes it: the transitions are weighted from 0.8 for the oldest to
+// 1.2 for the newest, so recent changes count more. A history shorter than
+// the window has no changes before it.
+func flapScore(history []corev2.CheckHistory) float64 {
+	var changes float64
+	for i := len(history) - 1; i > 0 && len(history)-i <= flapWindow; i-- {
+		if history[i].Status != history[i-1].Status {
+			age := float64(len(history) - 1 - i)
+			changes += 0.8 + 0.4*(flapWindow-1-age)/(flapWindow-1)
+		}
+	}
+	return changes / flapWindow * 100
+}
+
+// flapState returns the flap score of the history and whether the check is
+// flapping at its last execution and was at the one before. A check starts
+// flapping when the score reaches --highFlapThreshold and stops when it
+// drops to --lowFlapThreshold, so the thresholds are applied to the history
+// up to each execution in turn.
+func flapState(history []corev2.CheckHistory) (float64, bool, bool) {
+	var score float64
+	var flapping, wasFlapping bool
+	for n := 2; n <= len(history); n++ {
+		wasFlapping = flapping
+		score = flapScore(history[:n])
+		if flapping {
+			flapping = score > float64(config.LowFlapThreshold)
+		} else {
+			flapping = score >= float64(config.HighFlapThreshold)
+		}
+	}
+	return score, flapping, wasFlapping
+}
+
+// suppressWhileFlapping returns the reason for not sending an email with
+// --suppressFlapping while the check keeps flapping, or an empty string if
+// it should be sent. The email for the event that starts it flapping is
+// sent, as a flapping email.
+func suppressWhileFlapping(event *corev2.Event) string {
+	if !config.SuppressFlapping {
+		return ""
+	}
+	score, flapping, wasFlapping := flapState(eventCheck(event).History)
+	if !flapping || !wasFlapping {
+		return ""
+	}
+	return fmt.Sprintf("it is flapping (%.0f%% state change)", score)
+}
+
+// flappingStarted reports whether the check started flapping with the event,
+// with --suppressFlapping.
+func flappingStarted(event *corev2.Event) bool {
+	if !config.SuppressFlapping {
+		return false
+	}
+	_, flapping, wasFlapping := flapState(eventCheck(event).History)
+	return flapping && !wasFlapping
+}
+
+// flappingDescription explains the flapping email, which is the last until
+// the check is stable.
+func flappingDescription(event *corev2.Event) string {
+	score, _, _ := flapState(eventCheck(event).History)
+	return fmt.Sprintf("This check is flapping, with a %.0f%% state change in its recent executions. "+
+		"No more emails are sent until it is stable.", score)
+}
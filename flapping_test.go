@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// statusHistory returns a check history of the statuses, oldest first.
+func statusHistory(statuses ...uint32) []corev2.CheckHistory {
+	history := make([]corev2.CheckHistory, len(statuses))
+	for i, status := range statuses {
+		history[i].Status = status
+	}
+	return history
+}
+
+func TestFlapScore(t *testing.T) {
+	assert.Equal(t, 0.0, flapScore(nil))
+	assert.Equal(t, 0.0, flapScore(statusHistory(2, 2, 2)))
+	assert.InDelta(t, 6.0, flapScore(statusHistory(0, 0, 2)), 0.001)
+	assert.InDelta(t, 4.0, flapScore(statusHistory(0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2)), 0.001)
+	// only the last 20 transitions count
+	assert.InDelta(t, 0.0, flapScore(statusHistory(0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2)), 0.001)
+	assert.InDelta(t, 100.0, flapScore(statusHistory(0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0, 2, 0)), 0.001)
+}
+
+func TestSuppressWhileFlapping(t *testing.T) {
+	config.SuppressFlapping = true
+	config.LowFlapThreshold = 5
+	config.HighFlapThreshold = 20
+	config.FlappingSubjectTemplate = defaultFlappingSubjectTemplate
+	config.SubjectTemplate = defaultSubjectTemplate
+	defer func() {
+		config.SuppressFlapping = false
+		config.LowFlapThreshold = 0
+		config.HighFlapThreshold = 0
+		config.FlappingSubjectTemplate = ""
+		config.SubjectTemplate = ""
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.History = statusHistory(0, 0, 2, 0, 2)
+	assert.False(t, flappingStarted(event))
+	assert.Empty(t, suppressWhileFlapping(event))
+
+	// the fourth change starts it flapping, which is emailed once
+	event.Check.Status = 0
+	event.Check.History = statusHistory(0, 0, 2, 0, 2, 0)
+	assert.True(t, flappingStarted(event))
+	assert.Empty(t, suppressWhileFlapping(event))
+	subject, body, _, err := composeEmail(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensu Alert - foo/bar: flapping", subject)
+	assert.Contains(t, body, "This check is flapping, with a 23% state change in its recent executions.")
+
+	event.Check.Status = 2
+	event.Check.History = statusHistory(0, 0, 2, 0, 2, 0, 2)
+	assert.False(t, flappingStarted(event))
+	assert.Equal(t, "it is flapping (29% state change)", suppressWhileFlapping(event))
+
+	// it keeps flapping until the score drops to the low threshold
+	for i := 0; i < 12; i++ {
+		event.Check.History = append(event.Check.History, corev2.CheckHistory{Status: 2})
+	}
+	assert.NotEmpty(t, suppressWhileFlapping(event))
+	event.Check.History = append(event.Check.History, statusHistory(2, 2, 2, 2, 2, 2, 2, 2)...)
+	assert.Empty(t, suppressWhileFlapping(event))
+	assert.False(t, flappingStarted(event))
+	subject, _, _, err = composeEmail(event)
+	assert.NoError(t, err)
+	assert.NotContains(t, subject, "flapping")
+}
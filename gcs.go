@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// the Cloud Storage endpoint
+var gcsEndpoint = "https://storage.googleapis.com"
+
+const gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// newGCSRequest returns an authorized request for the object at the
+// gs://bucket/object URL. STORAGE_EMULATOR_HOST is honored, in which case no
+// credentials are needed.
+func newGCSRequest(templateURL string) (*http.Request, error) {
+	bucket, object, err := splitBucketURL(templateURL)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := gcsEndpoint
+	emulator := os.Getenv("STORAGE_EMULATOR_HOST")
+	if len(emulator) > 0 {
+		endpoint = emulator
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/"+encodeObjectPath(bucket)+"/"+encodeObjectPath(object), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(emulator) == 0 {
+		token, err := googleAccessToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// googleAccessToken returns an access token from the application default
+// credentials, found by the Google auth library the same way as by gcloud:
+// the GOOGLE_APPLICATION_CREDENTIALS file, the gcloud credentials file and
+// finally the metadata server.
+func googleAccessToken() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	creds, err := google.FindDefaultCredentials(ctx, gcsReadOnlyScope)
+	if err != nil {
+		return "", fmt.Errorf("no Google credentials found: %v", err)
+	}
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to get Google access token: %v", err)
+	}
+	return token.AccessToken, nil
+}
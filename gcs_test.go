@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchGCSTemplate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+			parts := strings.Split(r.Form.Get("assertion"), ".")
+			assert.Len(t, parts, 3)
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			assert.NoError(t, err)
+			hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], signature))
+			claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+			assert.NoError(t, err)
+			assert.Contains(t, string(claims), `"iss":"sensu@example.iam.gserviceaccount.com"`)
+			_, _ = w.Write([]byte(`{"access_token": "ya29.token", "expires_in": 3600}`))
+		case "/templates/email/alert.tmpl":
+			assert.Equal(t, "Bearer ya29.token", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte("{{.Entity.Name}}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	gcsEndpoint = server.URL
+	defer func() { gcsEndpoint = "https://storage.googleapis.com" }()
+
+	dir, err := ioutil.TempDir("", "gcs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	credsBytes, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "sensu@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	assert.NoError(t, err)
+	credsFile := filepath.Join(dir, "key.json")
+	assert.NoError(t, ioutil.WriteFile(credsFile, credsBytes, 0600))
+	defer setenv(t, "GOOGLE_APPLICATION_CREDENTIALS", credsFile, "STORAGE_EMULATOR_HOST", "")()
+
+	template, err := readTemplateFile("gs://templates/email/alert.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, "{{.Entity.Name}}", string(template))
+
+	_, err = readTemplateFile("gs://templates/missing.tmpl")
+	assert.EqualError(t, err, "failed to fetch template gs://templates/missing.tmpl: 404 Not Found")
+}
+
+func TestFetchGCSTemplateEmulator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Equal(t, "/templates/alert.tmpl", r.URL.Path)
+		_, _ = w.Write([]byte("{{.Check.Name}}"))
+	}))
+	defer server.Close()
+	defer setenv(t, "STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))()
+
+	template, err := readTemplateFile("gs://templates/alert.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, "{{.Check.Name}}", string(template))
+}
+
+func TestGoogleAccessTokenMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		switch r.URL.Path {
+		case "/computeMetadata/v1/project/project-id":
+			_, _ = w.Write([]byte("sensu"))
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			_, _ = w.Write([]byte(`{"access_token": "ya29.metadata", "token_type": "Bearer", "expires_in": 3600}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "gcloud")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer setenv(t,
+		"GOOGLE_APPLICATION_CREDENTIALS", "",
+		"XDG_CONFIG_HOME", dir,
+		"GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"),
+	)()
+
+	token, err := googleAccessToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "ya29.metadata", token)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	// wrap token flags
+	gssapiSentByAcceptor = 0x01
+	gssapiSealed         = 0x02
+	gssapiAcceptorSubkey = 0x04
+
+	// the SASL security layer of no protection (RFC 4752)
+	gssapiNoSecurityLayer = 0x01
+)
+
+// the GSS-API flags requested: mutual authentication, replay and sequence
+// detection and integrity
+var gssapiFlags = []int{gssapi.ContextFlagMutual, gssapi.ContextFlagReplay, gssapi.ContextFlagSequence, gssapi.ContextFlagInteg}
+
+// gssapiAuth implements the SASL GSSAPI mechanism (RFC 4752) with Kerberos,
+// for relays that only allow service accounts to authenticate with their
+// keytab or credential cache.
+type gssapiAuth struct {
+	host     string
+	key      types.EncryptionKey
+	subkey   bool
+	ctime    time.Time
+	cusec    int
+	seq      uint64
+	verified bool
+}
+
+// GSSAPIAuth returns an smtp.Auth authenticating with a Kerberos ticket for
+// the SMTP service of the host.
+func GSSAPIAuth(host string) smtp.Auth {
+	return &gssapiAuth{host: host}
+}
+
+// Start sends the AP-REQ for the SMTP service as the initial response.
+func (a *gssapiAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	cl, err := kerberosClient()
+	if err != nil {
+		return "", nil, err
+	}
+	defer cl.Destroy()
+	spn := "smtp/" + strings.ToLower(a.host)
+	ticket, key, err := cl.GetServiceTicket(spn)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get a Kerberos ticket for %s: %v", spn, err)
+	}
+	token, err := spnego.NewKRB5TokenAPREQ(cl, ticket, key, gssapiFlags, []int{flags.APOptionMutualRequired})
+	if err != nil {
+		return "", nil, err
+	}
+	// the AP-REP must echo the authenticator's time
+	if err := token.APReq.DecryptAuthenticator(key); err != nil {
+		return "", nil, err
+	}
+	authenticator := token.APReq.Authenticator
+	a.key, a.ctime, a.cusec, a.seq = key, authenticator.CTime, authenticator.Cusec, uint64(authenticator.SeqNumber)
+	apReq, err := token.Marshal()
+	if err != nil {
+		return "", nil, err
+	}
+	return "GSSAPI", apReq, nil
+}
+
+// Next verifies the server's AP-REP, then accepts the security layers it
+// offers, choosing none.
+func (a *gssapiAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	if !a.verified {
+		if err := a.verifyAPRep(fromServer); err != nil {
+			return nil, err
+		}
+		a.verified = true
+		return []byte{}, nil
+	}
+	layers, err := a.unwrap(fromServer)
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != 4 || layers[0]&gssapiNoSecurityLayer == 0 {
+		return nil, errors.New("the SMTP server requires a GSSAPI security layer")
+	}
+	return a.wrap([]byte{gssapiNoSecurityLayer, 0, 0, 0})
+}
+
+// verifyAPRep checks the server's AP-REP token mutually authenticates it,
+// keeping the subkey it chooses.
+func (a *gssapiAuth) verifyAPRep(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("the SMTP server sent no GSSAPI reply")
+	}
+	var token spnego.KRB5Token
+	if err := token.Unmarshal(b); err != nil {
+		return fmt.Errorf("invalid GSSAPI reply from the SMTP server: %v", err)
+	}
+	if token.IsKRBError() {
+		return fmt.Errorf("the SMTP server rejected the Kerberos ticket: %v", token.KRBError)
+	}
+	if !token.IsAPRep() {
+		return errors.New("invalid GSSAPI reply from the SMTP server")
+	}
+	plain, err := crypto.DecryptEncPart(token.APRep.EncPart, a.key, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the GSSAPI reply from the SMTP server: %v", err)
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(plain); err != nil {
+		return fmt.Errorf("invalid GSSAPI reply from the SMTP server: %v", err)
+	}
+	if part.CTime.Unix() != a.ctime.Unix() || part.Cusec != a.cusec {
+		return errors.New("the GSSAPI reply from the SMTP server does not match the request")
+	}
+	if len(part.Subkey.KeyValue) > 0 {
+		a.key, a.subkey = part.Subkey, true
+	}
+	return nil
+}
+
+// unwrap returns the payload of the server's wrap token, verifying its
+// checksum.
+func (a *gssapiAuth) unwrap(b []byte) ([]byte, error) {
+	if len(b) < gssapi.HdrLen {
+		return nil, errors.New("invalid GSSAPI wrap token from the SMTP server")
+	}
+	// undo the rotation of the body
+	b = append([]byte{}, b...)
+	body := b[gssapi.HdrLen:]
+	if rrc := int(binary.BigEndian.Uint16(b[6:])); rrc > 0 && len(body) > 0 {
+		rrc %= len(body)
+		copy(body, append(append([]byte{}, body[rrc:]...), body[:rrc]...))
+	}
+	var token gssapi.WrapToken
+	if err := token.Unmarshal(b, true); err != nil {
+		return nil, fmt.Errorf("invalid GSSAPI wrap token from the SMTP server: %v", err)
+	}
+	if token.Flags&gssapiSealed != 0 {
+		return nil, errors.New("the SMTP server sent a sealed GSSAPI wrap token, which is not supported")
+	}
+	if ok, _ := token.Verify(a.key, keyusage.GSSAPI_ACCEPTOR_SIGN); !ok {
+		return nil, errors.New("the GSSAPI wrap token from the SMTP server failed its integrity check")
+	}
+	return token.Payload, nil
+}
+
+// wrap returns a wrap token of the payload protected with a checksum.
+func (a *gssapiAuth) wrap(payload []byte) ([]byte, error) {
+	etype, err := crypto.GetEtype(a.key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	token := gssapi.WrapToken{
+		EC:        uint16(etype.GetHMACBitLength() / 8),
+		SndSeqNum: a.seq,
+		Payload:   payload,
+	}
+	if a.subkey {
+		token.Flags |= gssapiAcceptorSubkey
+	}
+	if err := token.SetCheckSum(a.key, keyusage.GSSAPI_INITIATOR_SIGN); err != nil {
+		return nil, err
+	}
+	return token.Marshal()
+}
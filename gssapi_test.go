@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// gssapiToken wraps the Kerberos message in a GSS-API token of the type.
+func gssapiToken(t *testing.T, tokenID []byte, message []byte) []byte {
+	oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asn1tools.AddASNAppTag(append(append(oid, tokenID...), message...), 0)
+}
+
+func TestGSSAPIAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("KRB5_CONFIG", filepath.Join(dir, "krb5.conf"))
+	defer os.Unsetenv("KRB5_CONFIG")
+
+	// the cache already holds a ticket for the SMTP service
+	serviceKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	copy(serviceKey.KeyValue, "service session key")
+	os.Setenv("KRB5CCNAME", testCredentialCache(t, dir, types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "sensu"),
+		testCredential{
+			server:  types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/EXAMPLE.COM"),
+			key:     types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)},
+			endTime: time.Now().Add(time.Hour),
+		},
+		testCredential{
+			server:  types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "smtp/relay.example.com"),
+			key:     serviceKey,
+			endTime: time.Now().Add(time.Hour),
+		}))
+	defer os.Unsetenv("KRB5CCNAME")
+
+	auth := GSSAPIAuth("Relay.example.com")
+	mechanism, b, err := auth.Start(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "GSSAPI", mechanism)
+
+	// accept the AP-REQ as the server would
+	var token spnego.KRB5Token
+	assert.NoError(t, token.Unmarshal(b))
+	assert.True(t, token.IsAPReq())
+	assert.Equal(t, "smtp/relay.example.com", token.APReq.Ticket.SName.PrincipalNameString())
+	assert.NoError(t, token.APReq.DecryptAuthenticator(serviceKey))
+	authenticator := token.APReq.Authenticator
+	assert.Equal(t, uint32(gssapi.ContextFlagMutual|gssapi.ContextFlagReplay|gssapi.ContextFlagSequence|gssapi.ContextFlagInteg), binary.LittleEndian.Uint32(authenticator.Cksum.Checksum[20:]))
+
+	// mutual authentication, choosing a subkey
+	subkey := types.EncryptionKey{KeyType: etypeID.AES128_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 16)}
+	copy(subkey.KeyValue, "acceptor subkey")
+	part, err := asn1.Marshal(messages.EncAPRepPart{CTime: authenticator.CTime, Cusec: authenticator.Cusec, Subkey: subkey})
+	assert.NoError(t, err)
+	encPart, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(part, asnAppTag.EncAPRepPart), serviceKey, keyusage.AP_REP_ENCPART, 0)
+	assert.NoError(t, err)
+	apRep, err := asn1.Marshal(messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: encPart})
+	assert.NoError(t, err)
+	resp, err := auth.Next(gssapiToken(t, []byte{0x02, 0x00}, asn1tools.AddASNAppTag(apRep, asnAppTag.APREP)), true)
+	assert.NoError(t, err)
+	assert.Empty(t, resp)
+
+	// offer no security layer, with a rotated wrap token signed with the subkey
+	wrap := gssapi.WrapToken{Flags: gssapiSentByAcceptor | gssapiAcceptorSubkey, EC: 12, SndSeqNum: 1, Payload: []byte{gssapiNoSecurityLayer, 0, 0x10, 0}}
+	assert.NoError(t, wrap.SetCheckSum(subkey, keyusage.GSSAPI_ACCEPTOR_SIGN))
+	wrapped, err := wrap.Marshal()
+	assert.NoError(t, err)
+	binary.BigEndian.PutUint16(wrapped[6:], 12)
+	body := wrapped[gssapi.HdrLen:]
+	wrapped = append(append(wrapped[:gssapi.HdrLen:gssapi.HdrLen], body[len(body)-12:]...), body[:len(body)-12]...)
+	resp, err = auth.Next(wrapped, true)
+	assert.NoError(t, err)
+	var reply gssapi.WrapToken
+	assert.NoError(t, reply.Unmarshal(resp, false))
+	assert.Equal(t, []byte{gssapiNoSecurityLayer, 0, 0, 0}, reply.Payload)
+	assert.Equal(t, byte(gssapiAcceptorSubkey), reply.Flags)
+	assert.Equal(t, uint64(authenticator.SeqNumber), reply.SndSeqNum)
+	ok, err := reply.Verify(subkey, keyusage.GSSAPI_INITIATOR_SIGN)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// a tampered token is rejected
+	wrapped[len(wrapped)-1] ^= 1
+	_, err = auth.Next(wrapped, true)
+	assert.EqualError(t, err, "the GSSAPI wrap token from the SMTP server failed its integrity check")
+}
+
+func TestGSSAPIAuthRejected(t *testing.T) {
+	auth := &gssapiAuth{}
+	skew := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "smtp/relay.example.com"),
+		"EXAMPLE.COM", errorcode.KRB_AP_ERR_SKEW, "")
+	krbErr, err := skew.Marshal()
+	assert.NoError(t, err)
+	_, err = auth.Next(gssapiToken(t, []byte{0x03, 0x00}, krbErr), true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the SMTP server rejected the Kerberos ticket: ")
+		assert.Contains(t, err.Error(), "KRB_AP_ERR_SKEW")
+	}
+	_, err = auth.Next(nil, true)
+	assert.EqualError(t, err, "the SMTP server sent no GSSAPI reply")
+}
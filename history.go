@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	htemplate "html/template"
+	"strings"
+
+	"github.com/sensu/sensu-email-handler/pkg/templates"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// historySymbol returns the character representing a check status in a
+// history timeline.
+func historySymbol(status uint32) byte {
+	switch status {
+	case 0:
+		return 'o'
+	case 1:
+		return 'W'
+	case 2:
+		return 'X'
+	default:
+		return '?'
+	}
+}
+
+// historyTimeline renders the check history, oldest first, as one character
+// per execution, e.g. "ooooXXXo", for the HistoryTimeline template function.
+func historyTimeline(history []corev2.CheckHistory) string {
+	var b strings.Builder
+	for _, h := range history {
+		b.WriteByte(historySymbol(h.Status))
+	}
+	return b.String()
+}
+
+const historyHTMLTable = `<table cellpadding="0" cellspacing="2" style="border-collapse: separate;">
+<tr>{{range .}}<td title="{{.Title}}" style="width: 12px; height: 12px; background-color: {{.Color}};"></td>{{end}}</tr>
+</table>
+`
+
+// historyHTML renders the check history, oldest first, as an HTML table with
+// a cell colored by status for each execution, for the HistoryHTMLTable
+// template function.
+func historyHTML(history []corev2.CheckHistory) (htemplate.HTML, error) {
+	if len(history) == 0 {
+		return "", nil
+	}
+	type cell struct {
+		Title string
+		Color string
+	}
+	cells := make([]cell, 0, len(history))
+	for _, h := range history {
+		cells = append(cells, cell{
+			Title: templates.StatusName(h.Status) + " " + unixTime(h.Executed).String(),
+			Color: templates.StatusColor(h.Status),
+		})
+	}
+	var buf bytes.Buffer
+	if err := htemplate.Must(htemplate.New("history").Parse(historyHTMLTable)).Execute(&buf, cells); err != nil {
+		return "", err
+	}
+	return htemplate.HTML(buf.String()), nil
+}
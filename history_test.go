@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryTimeline(t *testing.T) {
+	history := []corev2.CheckHistory{{Status: 0}, {Status: 0}, {Status: 1}, {Status: 2}, {Status: 2}, {Status: 3}, {Status: 0}}
+	assert.Equal(t, "ooWXX?o", historyTimeline(history))
+	assert.Empty(t, historyTimeline(nil))
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.History = history
+	out, err := resolveTemplate("History: {{HistoryTimeline .Check.History}}", event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "History: ooWXX?o", out)
+}
+
+func TestHistoryHTMLTable(t *testing.T) {
+	config.DateFormat = time.RFC3339
+	templateLocation = time.UTC
+	defer func() {
+		config.DateFormat = ""
+		templateLocation = time.Local
+	}()
+
+	html, err := historyHTML(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, html)
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.History = []corev2.CheckHistory{{Status: 0, Executed: 1600000000}, {Status: 2, Executed: 1600000060}}
+	out, err := resolveTemplate("<html>{{HistoryHTMLTable .Check.History}}</html>", event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Contains(t, out, `<td title="OK 2020-09-13T12:26:40Z" style="width: 12px; height: 12px; background-color: #2e7d32;"></td>`)
+	assert.Contains(t, out, `<td title="CRITICAL 2020-09-13T12:27:40Z" style="width: 12px; height: 12px; background-color: #c62828;"></td>`)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"golang.org/x/crypto/ssh/terminal"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultPasswordSecret is the name suggested for the Sensu secret holding
+// the SMTP password of the handler written by init.
+const defaultPasswordSecret = "email-smtp-password"
+
+// prompter asks the questions of init, reading the answers from in.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	// readPassword, if not nil, reads a password without echoing it
+	readPassword func() (string, error)
+}
+
+// ask asks the question, returning the answer, or def when there is none.
+func (p *prompter) ask(question, def string) (string, error) {
+	if len(def) > 0 {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || len(answer) == 0) {
+		if err == io.EOF {
+			return "", errors.New("init needs an answer to every question")
+		}
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if len(answer) == 0 {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// askValid asks the question until the answer is accepted by valid.
+func (p *prompter) askValid(question, def string, valid func(string) error) (string, error) {
+	for {
+		answer, err := p.ask(question, def)
+		if err != nil {
+			return "", err
+		}
+		if err := valid(answer); err != nil {
+			fmt.Fprintf(p.out, "  %v\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// askChoice asks the question until the answer is one of the choices.
+func (p *prompter) askChoice(question string, choices []string, def string) (string, error) {
+	return p.askValid(fmt.Sprintf("%s (%s)", question, strings.Join(choices, ", ")), def, func(answer string) error {
+		for _, choice := range choices {
+			if answer == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("choose one of %s", strings.Join(choices, ", "))
+	})
+}
+
+// askYesNo asks a yes or no question.
+func (p *prompter) askYesNo(question string, def bool) (bool, error) {
+	defAnswer := "n"
+	if def {
+		defAnswer = "y"
+	}
+	answer, err := p.askChoice(question, []string{"y", "n"}, defAnswer)
+	return answer == "y", err
+}
+
+// askPassword asks for a password, without echoing it when the input is a
+// terminal.
+func (p *prompter) askPassword(question string) (string, error) {
+	if p.readPassword == nil {
+		return p.askValid(question, "", required)
+	}
+	for {
+		fmt.Fprintf(p.out, "%s: ", question)
+		password, err := p.readPassword()
+		fmt.Fprintln(p.out)
+		if err != nil {
+			return "", err
+		}
+		if len(password) > 0 {
+			return password, nil
+		}
+		fmt.Fprintln(p.out, "  an answer is required")
+	}
+}
+
+func required(answer string) error {
+	if len(answer) == 0 {
+		return errors.New("an answer is required")
+	}
+	return nil
+}
+
+// handlerResource is the Sensu handler resource written by init.
+type handlerResource struct {
+	Type       string `yaml:"type"`
+	APIVersion string `yaml:"api_version"`
+	Metadata   struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Type          string          `yaml:"type"`
+		Command       string          `yaml:"command"`
+		Timeout       int             `yaml:"timeout"`
+		Filters       []string        `yaml:"filters"`
+		RuntimeAssets []string        `yaml:"runtime_assets"`
+		Secrets       []handlerSecret `yaml:"secrets,omitempty"`
+	} `yaml:"spec"`
+}
+
+type handlerSecret struct {
+	Name   string `yaml:"name"`
+	Secret string `yaml:"secret"`
+}
+
+// runInit asks for the SMTP server, credentials, addresses and template,
+// sends a test email for the event with them, and writes the Sensu handler
+// resource using them to out. The questions are written to prompts.
+func runInit(in io.Reader, out, prompts io.Writer, event *corev2.Event) error {
+	p := &prompter{in: bufio.NewReader(in), out: prompts}
+	if f, ok := in.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		p.readPassword = func() (string, error) {
+			password, err := terminal.ReadPassword(int(f.Fd()))
+			return string(password), err
+		}
+	}
+	fmt.Fprintln(prompts, "This creates a Sensu handler sending email with sensu-email-handler.")
+
+	host, err := p.askValid("SMTP host", config.SmtpHost, required)
+	if err != nil {
+		return err
+	}
+	portAnswer, err := p.askValid("SMTP port", strconv.FormatUint(config.SmtpPort, 10), func(answer string) error {
+		if port, err := strconv.ParseUint(answer, 10, 16); err != nil || port == 0 {
+			return errors.New("enter a port number")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	port, _ := strconv.ParseUint(portAnswer, 10, 16)
+	authMethods := []string{AuthMethodNone, AuthMethodPlain, AuthMethodLogin, AuthMethodNTLM, AuthMethodGSSAPI, AuthMethodAuto}
+	auth, err := p.askChoice("Authentication method", authMethods, config.AuthMethod)
+	if err != nil {
+		return err
+	}
+	var username, password, passwordSecret string
+	if auth != AuthMethodNone && auth != AuthMethodGSSAPI {
+		if username, err = p.askValid("SMTP username", config.SmtpUsername, required); err != nil {
+			return err
+		}
+		if password, err = p.askPassword("SMTP password"); err != nil {
+			return err
+		}
+		if passwordSecret, err = p.askValid("Sensu secret to keep the password in", defaultPasswordSecret, required); err != nil {
+			return err
+		}
+	}
+	from, err := p.askValid("From address", config.FromEmail, func(answer string) error {
+		_, err := mail.ParseAddress(answer)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	to, err := p.askValid("Recipients, separated by commas", strings.Join(config.ToEmail, ", "), required)
+	if err != nil {
+		return err
+	}
+	templateNames := []string{"default", "classic", "table", "compact-html"}
+	templateName := config.BodyTemplateName
+	if len(templateName) == 0 {
+		templateName = "default"
+	}
+	if templateName, err = p.askChoice("Body template", templateNames, templateName); err != nil {
+		return err
+	}
+	name, err := p.askValid("Handler name", "email", required)
+	if err != nil {
+		return err
+	}
+
+	config.SmtpHost = host
+	config.SmtpPort = port
+	config.AuthMethod = auth
+	config.SmtpUsername = username
+	config.SmtpPassword = password
+	config.FromEmail = from
+	config.ToEmail = []string{to}
+	config.BodyTemplateName = ""
+	if templateName != "default" {
+		config.BodyTemplateName = templateName
+	}
+	// the handler is made before checking the configuration adjusts it
+	resource := newHandlerResource(name, passwordSecret)
+	send, err := p.askYesNo("Send a test email now", true)
+	if err != nil {
+		return err
+	}
+	if send {
+		if err := sendInitTestEmail(event, prompts); err != nil {
+			fmt.Fprintf(prompts, "The test email failed: %v\n", err)
+			write, askErr := p.askYesNo("Write the handler anyway", false)
+			if askErr != nil {
+				return askErr
+			}
+			if !write {
+				return fmt.Errorf("the test email failed: %v", err)
+			}
+		}
+	}
+
+	resourceYAML, err := yaml.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "# Created by sensu-email-handler init, apply with sensuctl create --file <this file>.\n")
+	if len(passwordSecret) > 0 {
+		fmt.Fprintf(out, "# The SMTP password is read from the Sensu secret %s, which must be created first.\n", passwordSecret)
+	}
+	fmt.Fprintf(out, "---\n%s", resourceYAML)
+	return nil
+}
+
+// sendInitTestEmail checks the configuration init asked for, then sends a
+// test email with it, reporting the recipients to out.
+func sendInitTestEmail(event *corev2.Event, out io.Writer) error {
+	initMode := mode
+	mode = modeTest
+	defer func() { mode = initMode }()
+	if err := checkArgs(event); err != nil {
+		return err
+	}
+	return sendTestEmail(event, out)
+}
+
+// newHandlerResource returns the handler resource for the configuration, with
+// the SMTP password in the Sensu secret, if any.
+func newHandlerResource(name, passwordSecret string) *handlerResource {
+	args := []string{"sensu-email-handler", "-s", config.SmtpHost}
+	if config.SmtpPort != defaultSmtpPort {
+		args = append(args, "-P", strconv.FormatUint(config.SmtpPort, 10))
+	}
+	if config.AuthMethod != AuthMethodPlain {
+		args = append(args, "-a", config.AuthMethod)
+	}
+	if len(config.SmtpUsername) > 0 {
+		args = append(args, "-u", config.SmtpUsername)
+	}
+	args = append(args, "-f", config.FromEmail)
+	for _, to := range config.ToEmail {
+		args = append(args, "-t", to)
+	}
+	if len(config.BodyTemplateName) > 0 {
+		args = append(args, "--"+bodyTemplateName, config.BodyTemplateName)
+	}
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+
+	resource := &handlerResource{Type: "Handler", APIVersion: "core/v2"}
+	resource.Metadata.Name = name
+	resource.Spec.Type = "pipe"
+	resource.Spec.Command = strings.Join(args, " ")
+	resource.Spec.Timeout = 30
+	resource.Spec.Filters = []string{"is_incident", "not_silenced"}
+	resource.Spec.RuntimeAssets = []string{"sensu/sensu-email-handler"}
+	if len(passwordSecret) > 0 {
+		// Sensu passes the secret as the env var the password is read from
+		resource.Spec.Secrets = []handlerSecret{{Name: "SMTP_PASSWORD", Secret: passwordSecret}}
+	}
+	return resource
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// shellQuote quotes the argument for the shell Sensu runs commands with.
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
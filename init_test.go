@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestRunInit(t *testing.T) {
+	memory := &transport.Memory{}
+	messageSender = memory
+	config.SmtpPort = defaultSmtpPort
+	config.AuthMethod = AuthMethodPlain
+	defer func() {
+		messageSender = nil
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.SmtpUsername = ""
+		config.SmtpPassword = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.ToEmail = nil
+		config.BodyTemplateName = ""
+		emailBodyTemplate = defaultBodyTemplate
+	}()
+
+	answers := strings.Join([]string{
+		"smtp.example.com",
+		"port",
+		"2525",
+		"login",
+		"sensu",
+		"s3cret",
+		"",
+		"Sensu <sensu@example.com>",
+		"ops@example.com, lead@example.com",
+		"table",
+		"",
+		"y",
+	}, "\n") + "\n"
+	var out, prompts bytes.Buffer
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	assert.NoError(t, runInit(strings.NewReader(answers), &out, &prompts, event))
+	assert.Contains(t, prompts.String(), "SMTP port [587]: ")
+	assert.Contains(t, prompts.String(), "enter a port number")
+
+	messages := memory.Messages()
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, []string{"ops@example.com", "lead@example.com"}, messages[0].To)
+	}
+
+	assert.True(t, strings.HasPrefix(out.String(), "# Created by sensu-email-handler init"))
+	assert.NotContains(t, out.String(), "s3cret")
+	var resource handlerResource
+	assert.NoError(t, yaml.Unmarshal(out.Bytes(), &resource))
+	assert.Equal(t, "Handler", resource.Type)
+	assert.Equal(t, "email", resource.Metadata.Name)
+	assert.Equal(t, "sensu-email-handler -s smtp.example.com -P 2525 -a login -u sensu -f 'Sensu <sensu@example.com>' "+
+		"-t 'ops@example.com, lead@example.com' --bodyTemplateName table", resource.Spec.Command)
+	assert.Equal(t, []handlerSecret{{Name: "SMTP_PASSWORD", Secret: defaultPasswordSecret}}, resource.Spec.Secrets)
+}
+
+func TestRunInitTestFailure(t *testing.T) {
+	messageSender = &transport.Memory{Err: errors.New("connection refused")}
+	config.SmtpPort = defaultSmtpPort
+	config.AuthMethod = AuthMethodPlain
+	defer func() {
+		messageSender = nil
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.ToEmail = nil
+	}()
+
+	answers := "smtp.example.com\n\nnone\nsensu@example.com\nops@example.com\n\nalerts\ny\nn\n"
+	var out, prompts bytes.Buffer
+	err := runInit(strings.NewReader(answers), &out, &prompts, corev2.FixtureEvent("foo", "bar"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "connection refused")
+	}
+	assert.Empty(t, out.String())
+
+	// the handler can be written regardless
+	out.Reset()
+	answers = strings.Replace(answers, "y\nn\n", "y\ny\n", 1)
+	assert.NoError(t, runInit(strings.NewReader(answers), &out, &prompts, corev2.FixtureEvent("foo", "bar")))
+	assert.Contains(t, out.String(), "name: alerts")
+	assert.Contains(t, out.String(), "command: sensu-email-handler -s smtp.example.com -a none -f sensu@example.com -t ops@example.com\n")
+	assert.NotContains(t, out.String(), "secrets:")
+
+	// running out of answers is an error
+	assert.Error(t, runInit(strings.NewReader("smtp.example.com\n"), &out, &prompts, nil))
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "ops@example.com", shellQuote("ops@example.com"))
+	assert.Equal(t, "'Sensu <sensu@example.com>'", shellQuote("Sensu <sensu@example.com>"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepaliveTemplates(t *testing.T) {
+	config.KeepaliveSubjectTemplate = defaultKeepaliveSubjectTemplate
+	config.DateFormat = time.RFC3339
+	templateLocation = time.UTC
+	defer func() {
+		config.KeepaliveSubjectTemplate = ""
+		config.DateFormat = ""
+		templateLocation = time.Local
+		keepaliveBodyTemplate = ""
+		emailBodyTemplate = defaultBodyTemplate
+	}()
+
+	event := corev2.FixtureEvent("web01", corev2.KeepaliveCheckName)
+	event.Check.Status = 1
+	event.Check.Output = "No keepalive sent from web01 for 120 seconds (>= 120)"
+	event.Entity.LastSeen = 1600000000
+	event.Entity.SensuAgentVersion = "6.1.0"
+	event.Entity.System = corev2.System{
+		Hostname: "web01.example.com", OS: "linux", Platform: "ubuntu", PlatformVersion: "20.04", Arch: "amd64",
+		Network: corev2.Network{Interfaces: []corev2.NetworkInterface{{Name: "eth0", Addresses: []string{"10.0.0.5/24"}}}},
+	}
+	event.Entity.Subscriptions = []string{"linux", "entity:web01"}
+
+	subjectTemplate, bodyTemplate := selectTemplates(event)
+	assert.Equal(t, keepaliveTemplate, bodyTemplate)
+	subject, err := resolveTemplate(subjectTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensu Alert - web01 stopped reporting", subject)
+	body, err := resolveTemplate(bodyTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Contains(t, body, "Entity web01 stopped reporting at 2020-09-13T12:26:40Z.\n")
+	assert.Contains(t, body, "Platform:      ubuntu 20.04 (linux/amd64)\n")
+	assert.Contains(t, body, "Addresses:     10.0.0.5/24 \n")
+	assert.Contains(t, body, "Agent:         6.1.0\n")
+	assert.Contains(t, body, "Subscriptions: linux, entity:web01\n")
+	assert.Contains(t, body, "No keepalive sent from web01")
+
+	event.Check.Status = 0
+	event.Check.History = []corev2.CheckHistory{{Status: 1}, {Status: 0}}
+	subject, err = resolveTemplate(subjectTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensu Alert - web01 is reporting again", subject)
+
+	// the HTML template goes with an HTML body, and a template file overrides
+	// both
+	emailBodyTemplate = tableTemplate
+	_, bodyTemplate = selectTemplates(event)
+	assert.Equal(t, keepaliveHTMLTemplate, bodyTemplate)
+	_, err = resolveTemplate(bodyTemplate, event, ContentHTML)
+	assert.NoError(t, err)
+	keepaliveBodyTemplate = "{{.Entity.Name}} is down"
+	_, bodyTemplate = selectTemplates(event)
+	assert.Equal(t, keepaliveBodyTemplate, bodyTemplate)
+
+	other := corev2.FixtureEvent("web01", "disk")
+	_, bodyTemplate = selectTemplates(other)
+	assert.Equal(t, tableTemplate, bodyTemplate)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// kerberosClient returns a client requesting tickets with the key of
+// --kerberosKeytab, or else with the ticket granting ticket in the
+// credential cache, which also supplies the tickets it already holds.
+func kerberosClient() (*client.Client, error) {
+	if len(config.KerberosKeytab) > 0 {
+		kt, err := keytab.Load(config.KerberosKeytab)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Kerberos keytab: %v", err)
+		}
+		username, realm, err := keytabPrincipal(kt, config.KerberosPrincipal)
+		if err != nil {
+			return nil, err
+		}
+		krb5conf, err := kerberosConfig(realm)
+		if err != nil {
+			return nil, err
+		}
+		return client.NewWithKeytab(username, realm, kt, krb5conf, client.DisablePAFXFAST(true)), nil
+	}
+
+	file := credentialCacheFile()
+	ccache, err := credentials.LoadCCache(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Kerberos credential cache %s: %v", file, err)
+	}
+	krb5conf, err := kerberosConfig(ccache.GetClientRealm())
+	if err != nil {
+		return nil, err
+	}
+	cl, err := client.NewFromCCache(ccache, krb5conf, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("no ticket granting ticket for %s in %s, run kinit: %v", ccache.GetClientPrincipalName().PrincipalNameString(), file, err)
+	}
+	return cl, nil
+}
+
+// keytabPrincipal returns the username and realm of the principal, which
+// may leave out the realm, or of the first principal in the keytab if not
+// given.
+func keytabPrincipal(kt *keytab.Keytab, principal string) (string, string, error) {
+	for _, entry := range kt.Entries {
+		name := strings.Join(entry.Principal.Components, "/")
+		if len(principal) == 0 || principal == name || principal == name+"@"+entry.Principal.Realm {
+			return name, entry.Principal.Realm, nil
+		}
+	}
+	if len(principal) > 0 {
+		return "", "", fmt.Errorf("no key for %s in Kerberos keytab %s", principal, config.KerberosKeytab)
+	}
+	return "", "", fmt.Errorf("no keys in Kerberos keytab %s", config.KerberosKeytab)
+}
+
+// credentialCacheFile returns the file of the default credential cache, from
+// KRB5CCNAME or the default of /tmp/krb5cc_<uid>.
+func credentialCacheFile() string {
+	if name := os.Getenv("KRB5CCNAME"); len(name) > 0 {
+		return strings.TrimPrefix(name, "FILE:")
+	}
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
+
+// kerberosConfig returns the krb5.conf (or KRB5_CONFIG) configuration, if
+// any, with the KDCs of the realm replaced by those of --kerberosKDC. The
+// KDCs of realms without any are found with their _kerberos._tcp DNS SRV
+// records.
+func kerberosConfig(realm string) (*krbconfig.Config, error) {
+	file := os.Getenv("KRB5_CONFIG")
+	if len(file) == 0 {
+		file = "/etc/krb5.conf"
+	}
+	krb5conf := krbconfig.New()
+	if _, err := os.Stat(file); err == nil {
+		if krb5conf, err = krbconfig.Load(file); err != nil {
+			return nil, fmt.Errorf("invalid Kerberos configuration %s: %v", file, err)
+		}
+	}
+	if len(krb5conf.LibDefaults.DefaultRealm) == 0 {
+		krb5conf.LibDefaults.DefaultRealm = realm
+	}
+	krb5conf.LibDefaults.DNSLookupKDC = true
+	// the KDCs are always reached over TCP
+	krb5conf.LibDefaults.UDPPreferenceLimit = 1
+
+	if len(config.KerberosKDC) > 0 {
+		var kdcs []string
+		for _, kdc := range strings.Split(config.KerberosKDC, ",") {
+			kdc = strings.TrimSpace(kdc)
+			if _, _, err := net.SplitHostPort(kdc); err != nil {
+				kdc = net.JoinHostPort(kdc, "88")
+			}
+			kdcs = append(kdcs, kdc)
+		}
+		var realms []krbconfig.Realm
+		for _, r := range krb5conf.Realms {
+			if r.Realm != realm {
+				realms = append(realms, r)
+			}
+		}
+		krb5conf.Realms = append(realms, krbconfig.Realm{Realm: realm, KDC: kdcs})
+	}
+	return krb5conf, nil
+}
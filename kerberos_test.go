@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCredential is a ticket for a server along with its session key.
+type testCredential struct {
+	server  types.PrincipalName
+	key     types.EncryptionKey
+	endTime time.Time
+}
+
+// testTicket returns a ticket for the server, with an opaque encrypted part.
+func testTicket(t *testing.T, server types.PrincipalName) []byte {
+	ticket := messages.Ticket{
+		TktVNO:  5,
+		Realm:   "EXAMPLE.COM",
+		SName:   server,
+		EncPart: types.EncryptedData{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Cipher: []byte("ticket")},
+	}
+	b, err := ticket.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testCredentialCache writes a credential cache of the client's credentials.
+func testCredentialCache(t *testing.T, dir string, client types.PrincipalName, creds ...testCredential) string {
+	var ccache bytes.Buffer
+	data := func(s []byte) {
+		_ = binary.Write(&ccache, binary.BigEndian, uint32(len(s)))
+		ccache.Write(s)
+	}
+	principal := func(p types.PrincipalName) {
+		_ = binary.Write(&ccache, binary.BigEndian, []uint32{uint32(p.NameType), uint32(len(p.NameString))})
+		data([]byte("EXAMPLE.COM"))
+		for _, c := range p.NameString {
+			data([]byte(c))
+		}
+	}
+	ccache.Write([]byte{0x05, 0x04, 0, 0})
+	principal(client)
+	for _, cred := range creds {
+		principal(client)
+		principal(cred.server)
+		_ = binary.Write(&ccache, binary.BigEndian, uint16(cred.key.KeyType))
+		data(cred.key.KeyValue)
+		now := uint32(time.Now().Unix())
+		_ = binary.Write(&ccache, binary.BigEndian, []uint32{now, now, uint32(cred.endTime.Unix()), 0})
+		ccache.WriteByte(0)
+		_ = binary.Write(&ccache, binary.BigEndian, []uint32{0, 0, 0})
+		data(testTicket(t, cred.server))
+		data(nil)
+	}
+	file := filepath.Join(dir, "krb5cc")
+	if err := ioutil.WriteFile(file, ccache.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestKeytabPrincipal(t *testing.T) {
+	kt := keytab.New()
+	assert.NoError(t, kt.AddEntry("sensu", "EXAMPLE.COM", "s3cret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	assert.NoError(t, kt.AddEntry("host/sensu.example.com", "EXAMPLE.COM", "s3cret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	config.KerberosKeytab = "sensu.keytab"
+	defer func() { config.KerberosKeytab = "" }()
+
+	// the first principal, unless given
+	username, realm, err := keytabPrincipal(kt, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "sensu", username)
+	assert.Equal(t, "EXAMPLE.COM", realm)
+	for _, principal := range []string{"host/sensu.example.com", "host/sensu.example.com@EXAMPLE.COM"} {
+		username, _, err = keytabPrincipal(kt, principal)
+		assert.NoError(t, err)
+		assert.Equal(t, "host/sensu.example.com", username)
+	}
+
+	_, _, err = keytabPrincipal(kt, "other@EXAMPLE.COM")
+	assert.EqualError(t, err, "no key for other@EXAMPLE.COM in Kerberos keytab sensu.keytab")
+	_, _, err = keytabPrincipal(keytab.New(), "")
+	assert.EqualError(t, err, "no keys in Kerberos keytab sensu.keytab")
+}
+
+func TestKerberosConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krb5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "krb5.conf")
+	conf := "[libdefaults]\n  default_realm = EXAMPLE.COM\n\n[realms]\n" +
+		"  OTHER.COM = {\n    kdc = kdc.other.com\n  }\n" +
+		"  EXAMPLE.COM = {\n    kdc = kdc1.example.com\n    kdc = kdc2.example.com:8888\n    admin_server = kdc1.example.com\n  }\n"
+	if err := ioutil.WriteFile(file, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("KRB5_CONFIG", file)
+	defer os.Unsetenv("KRB5_CONFIG")
+
+	kdcs := func(realm string) []string {
+		krb5conf, err := kerberosConfig("EXAMPLE.COM")
+		assert.NoError(t, err)
+		count, byPreference, err := krb5conf.GetKDCs(realm, true)
+		assert.NoError(t, err)
+		var addresses []string
+		for i := 1; i <= count; i++ {
+			addresses = append(addresses, byPreference[i])
+		}
+		return addresses
+	}
+	assert.ElementsMatch(t, []string{"kdc1.example.com:88", "kdc2.example.com:8888"}, kdcs("EXAMPLE.COM"))
+
+	// --kerberosKDC replaces the KDCs of the client's realm
+	config.KerberosKDC = "kdc.example.com, 127.0.0.1:750"
+	defer func() { config.KerberosKDC = "" }()
+	assert.ElementsMatch(t, []string{"kdc.example.com:88", "127.0.0.1:750"}, kdcs("EXAMPLE.COM"))
+	assert.Equal(t, []string{"kdc.other.com:88"}, kdcs("OTHER.COM"))
+}
+
+func TestKerberosClientCredentialCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("KRB5_CONFIG", filepath.Join(dir, "krb5.conf"))
+	defer os.Unsetenv("KRB5_CONFIG")
+
+	// a cache without a ticket granting ticket needs a kinit
+	client := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "sensu")
+	file := testCredentialCache(t, dir, client)
+	os.Setenv("KRB5CCNAME", "FILE:"+file)
+	defer os.Unsetenv("KRB5CCNAME")
+	_, err = kerberosClient()
+	assert.EqualError(t, err, "no ticket granting ticket for sensu in "+file+", run kinit: TGT not found in CCache")
+
+	_, _, err = GSSAPIAuth("relay.example.com").Start(nil)
+	assert.Error(t, err)
+}
+
+// startKDC starts a KDC answering each request with the reply of the
+// handler, returning its address and the listener to close.
+func startKDC(t *testing.T, handler func(req []byte) []byte) (string, net.Listener) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			length := make([]byte, 4)
+			if _, err := io.ReadFull(conn, length); err == nil {
+				req := make([]byte, binary.BigEndian.Uint32(length))
+				if _, err := io.ReadFull(conn, req); err == nil {
+					rep := handler(req)
+					binary.BigEndian.PutUint32(length, uint32(len(rep)))
+					_, _ = conn.Write(append(length, rep...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), listener
+}
+
+func TestKerberosKeytabKDCError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keytab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("KRB5_CONFIG", filepath.Join(dir, "krb5.conf"))
+	defer os.Unsetenv("KRB5_CONFIG")
+
+	kt := keytab.New()
+	assert.NoError(t, kt.AddEntry("sensu", "EXAMPLE.COM", "s3cret", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	b, err := kt.Marshal()
+	assert.NoError(t, err)
+	config.KerberosKeytab = filepath.Join(dir, "sensu.keytab")
+	assert.NoError(t, ioutil.WriteFile(config.KerberosKeytab, b, 0600))
+	var asReq messages.ASReq
+	address, listener := startKDC(t, func(req []byte) []byte {
+		assert.NoError(t, asReq.Unmarshal(req))
+		krbErr := messages.NewKRBError(asReq.ReqBody.SName, "EXAMPLE.COM", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "")
+		rep, _ := krbErr.Marshal()
+		return rep
+	})
+	defer listener.Close()
+	config.KerberosKDC = address
+	defer func() {
+		config.KerberosKeytab = ""
+		config.KerberosKDC = ""
+	}()
+
+	_, _, err = GSSAPIAuth("relay.example.com").Start(nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "failed to get a Kerberos ticket for smtp/relay.example.com")
+		assert.Contains(t, err.Error(), "KDC_ERR_C_PRINCIPAL_UNKNOWN")
+	}
+	assert.Equal(t, "sensu", asReq.ReqBody.CName.PrincipalNameString())
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// the prefix of recipients resolved with LDAP, e.g. ldap:owner or
+// ldap:cn=dbas,ou=groups,dc=example,dc=com
+const ldapRcptPrefix = "ldap:"
+
+// the ldap: recipient resolving to the entity's owner
+const ldapOwner = "owner"
+
+// ldapTimeout bounds connecting to the LDAP server and each lookup.
+var ldapTimeout = 10 * time.Second
+
+// the maximum depth of nested groups resolved
+const ldapMaxGroupDepth = 5
+
+// ldapClient makes the lookups of the ldap: recipients.
+type ldapClient struct {
+	conn *ldap.Conn
+}
+
+// dialLDAP connects to the ldap:// or ldaps:// URL.
+func dialLDAP(ldapURL string) (*ldapClient, error) {
+	conn, err := ldap.DialURL(ldapURL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(&tls.Config{
+			InsecureSkipVerify: config.TLSSkipVerify,
+			RootCAs:            tlsRootCAs,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	return &ldapClient{conn: conn}, nil
+}
+
+func (c *ldapClient) close() {
+	_ = c.conn.Unbind()
+	c.conn.Close()
+}
+
+// bind authenticates with a simple bind.
+func (c *ldapClient) bind(dn, password string) error {
+	if err := c.conn.Bind(dn, password); err != nil {
+		return fmt.Errorf("LDAP bind as %s failed: %v", dn, err)
+	}
+	return nil
+}
+
+// search returns the entries matching the filter. A base that doesn't exist
+// has no entries.
+func (c *ldapClient) search(base string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	result, err := c.conn.Search(ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 0,
+		int(ldapTimeout/time.Second), false, filter, attributes, nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search of %s failed: %v", base, err)
+	}
+	return result.Entries, nil
+}
+
+// ldapRcptTokens joins the parts of the ldap: recipients split at the commas
+// of their DN, returning the recipients with the ldap: recipients joined.
+func ldapRcptTokens(recipients rcpts) rcpts {
+	var joined rcpts
+	for _, r := range recipients {
+		last := len(joined) - 1
+		if last >= 0 && strings.HasPrefix(strings.TrimSpace(joined[last]), ldapRcptPrefix) &&
+			!strings.HasPrefix(strings.TrimSpace(r), ldapRcptPrefix) && strings.Contains(r, "=") && !strings.Contains(r, "@") {
+			joined[last] += "," + strings.TrimSpace(r)
+			continue
+		}
+		joined = append(joined, r)
+	}
+	return joined
+}
+
+// expandLDAP replaces the ldap: recipients with the email addresses looked up
+// in LDAP: ldap:owner with those of the entity's owner label, and ldap:<DN>
+// with those of the entry, or of the members of the group, with the DN.
+// Recipients that can't be resolved are skipped.
+func expandLDAP(event *corev2.Event, recipients rcpts) rcpts {
+	tokens := ldapRcptTokens(recipients)
+	if len(nonLDAPRcpts(tokens)) == len(tokens) {
+		return recipients
+	}
+	if len(config.LDAPURL) == 0 {
+		fmt.Println("Skipping ldap: recipients as --ldapURL is not set")
+		return nonLDAPRcpts(tokens)
+	}
+
+	client, err := dialLDAP(config.LDAPURL)
+	if err == nil && len(config.LDAPBindDN) > 0 {
+		if err = client.bind(config.LDAPBindDN, config.LDAPBindPassword); err != nil {
+			client.close()
+		}
+	}
+	if err != nil {
+		fmt.Printf("Skipping ldap: recipients: %v\n", err)
+		return nonLDAPRcpts(tokens)
+	}
+	defer client.close()
+
+	var expanded rcpts
+	for _, r := range tokens {
+		if !strings.HasPrefix(r, ldapRcptPrefix) {
+			expanded = append(expanded, r)
+			continue
+		}
+		addresses, err := client.resolve(event, strings.TrimPrefix(r, ldapRcptPrefix))
+		if err != nil {
+			fmt.Printf("Failed to resolve %s: %v\n", r, err)
+			continue
+		}
+		if len(addresses) == 0 {
+			fmt.Printf("No email addresses found for %s\n", r)
+		}
+		debugf("resolved %s to %s", r, addresses)
+		expanded = append(expanded, addresses...)
+	}
+	return expanded
+}
+
+func nonLDAPRcpts(recipients rcpts) rcpts {
+	var remaining rcpts
+	for _, r := range recipients {
+		if !strings.HasPrefix(r, ldapRcptPrefix) {
+			remaining = append(remaining, r)
+		}
+	}
+	return remaining
+}
+
+// resolve returns the email addresses of the entity's owner or the DN.
+func (c *ldapClient) resolve(event *corev2.Event, target string) (rcpts, error) {
+	seen := map[string]bool{}
+	if !strings.EqualFold(target, ldapOwner) {
+		return c.resolveDN(target, 0, seen)
+	}
+	owner := event.Entity.Labels[config.LDAPOwnerLabel]
+	if len(owner) == 0 {
+		return nil, fmt.Errorf("entity %s has no %s label", event.Entity.Name, config.LDAPOwnerLabel)
+	}
+	return c.resolveFilter(strings.Replace(config.LDAPOwnerFilter, "%s", ldap.EscapeFilter(owner), -1), 0, seen)
+}
+
+// ldapAttributes are the attributes read from each entry.
+func ldapAttributes() []string {
+	return []string{config.LDAPMailAttribute, "member", "uniqueMember", "memberUid"}
+}
+
+func (c *ldapClient) resolveDN(dn string, depth int, seen map[string]bool) (rcpts, error) {
+	if seen[strings.ToLower(dn)] {
+		return nil, nil
+	}
+	seen[strings.ToLower(dn)] = true
+	entries, err := c.search(dn, ldap.ScopeBaseObject, "(objectClass=*)", ldapAttributes())
+	if err != nil {
+		return nil, err
+	}
+	return c.entriesAddresses(entries, depth, seen)
+}
+
+func (c *ldapClient) resolveFilter(filter string, depth int, seen map[string]bool) (rcpts, error) {
+	if len(config.LDAPBaseDN) == 0 {
+		return nil, errors.New("--ldapBaseDN is required to search LDAP")
+	}
+	entries, err := c.search(config.LDAPBaseDN, ldap.ScopeWholeSubtree, filter, ldapAttributes())
+	if err != nil {
+		return nil, err
+	}
+	return c.entriesAddresses(entries, depth, seen)
+}
+
+// entriesAddresses returns the email addresses of the entries, or for those
+// without any, of their members.
+func (c *ldapClient) entriesAddresses(entries []*ldap.Entry, depth int, seen map[string]bool) (rcpts, error) {
+	var addresses rcpts
+	for _, entry := range entries {
+		seen[strings.ToLower(entry.DN)] = true
+		if mail := entry.GetEqualFoldAttributeValues(config.LDAPMailAttribute); len(mail) > 0 {
+			addresses = append(addresses, mail...)
+			continue
+		}
+		if depth >= ldapMaxGroupDepth {
+			continue
+		}
+		for _, member := range append(entry.GetEqualFoldAttributeValues("member"), entry.GetEqualFoldAttributeValues("uniqueMember")...) {
+			// a uniqueMember may end with a #'...'B unique identifier
+			if i := strings.LastIndex(member, "#"); i > 0 {
+				member = member[:i]
+			}
+			memberAddresses, err := c.resolveDN(member, depth+1, seen)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, memberAddresses...)
+		}
+		for _, uid := range entry.GetEqualFoldAttributeValues("memberUid") {
+			memberAddresses, err := c.resolveFilter("(uid="+ldap.EscapeFilter(uid)+")", depth+1, seen)
+			if err != nil {
+				return nil, err
+			}
+			addresses = append(addresses, memberAddresses...)
+		}
+	}
+	return addresses, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// startLDAPServer starts a minimal LDAP server for the directory of entries
+// by DN, accepting binds with the password "secret". Subtree searches match
+// the entries with an attribute equal to any equality match in the filter.
+// It returns the server's URL and the listener to close.
+func startLDAPServer(t *testing.T, directory map[string]map[string][]string) (string, net.Listener) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := func(tag ber.Tag, code int) *ber.Packet {
+		op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+		op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+		op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+		return op
+	}
+	entry := func(dn string) *ber.Packet {
+		op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+		attributes := ber.NewSequence("")
+		for name, values := range directory[dn] {
+			attribute := ber.NewSequence("")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, v := range values {
+				vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+			}
+			attribute.AppendChild(vals)
+			attributes.AppendChild(attribute)
+		}
+		op.AppendChild(attributes)
+		return op
+	}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			message, err := ber.ReadPacket(conn)
+			if err != nil || len(message.Children) < 2 {
+				return
+			}
+			id := message.Children[0].Value
+			op := message.Children[1]
+			reply := func(op *ber.Packet) {
+				response := ber.NewSequence("")
+				response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+				response.AppendChild(op)
+				_, _ = conn.Write(response.Bytes())
+			}
+			switch op.Tag {
+			case ldap.ApplicationBindRequest:
+				if op.Children[2].Data.String() == "secret" {
+					reply(result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess))
+				} else {
+					reply(result(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials))
+				}
+			case ldap.ApplicationSearchRequest:
+				base := op.Children[0].Data.String()
+				if op.Children[1].Value.(int64) == ldap.ScopeBaseObject {
+					if _, ok := directory[base]; !ok {
+						reply(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject))
+						continue
+					}
+					reply(entry(base))
+				} else {
+					values := filterValues(op.Children[6])
+					for dn, attributes := range directory {
+						if matchesAny(attributes, values) {
+							reply(entry(dn))
+						}
+					}
+				}
+				reply(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+			default:
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return "ldap://" + listener.Addr().String(), listener
+}
+
+// filterValues returns the equality matches in the filter as attr=value.
+func filterValues(filter *ber.Packet) []string {
+	switch filter.Tag {
+	case ldap.FilterEqualityMatch:
+		return []string{filter.Children[0].Data.String() + "=" + filter.Children[1].Data.String()}
+	case ldap.FilterAnd, ldap.FilterOr, ldap.FilterNot:
+		var values []string
+		for _, child := range filter.Children {
+			values = append(values, filterValues(child)...)
+		}
+		return values
+	}
+	return nil
+}
+
+func matchesAny(attributes map[string][]string, values []string) bool {
+	for name, attributeValues := range attributes {
+		for _, v := range attributeValues {
+			for _, value := range values {
+				if name+"="+v == value {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func TestLDAPRcpts(t *testing.T) {
+	assert.Equal(t, rcpts{"ldap:cn=dbas,ou=groups,dc=example", "ops@example.com"},
+		newRcpts([]string{"ldap:cn=dbas, ou=groups, dc=example, ops@example.com"}))
+	assert.Equal(t, rcpts{"ops@example.com", "ldap:cn=dbas,ou=groups", "ldap:owner"},
+		ldapRcptTokens(rcpts{"ops@example.com", "ldap:cn=dbas", "ou=groups", "ldap:owner"}))
+
+	serverURL, listener := startLDAPServer(t, map[string]map[string][]string{
+		"cn=dbas,ou=groups,dc=example,dc=com": {
+			"member": {"uid=alice,ou=people,dc=example,dc=com", "cn=leads,ou=groups,dc=example,dc=com", "uid=gone,ou=people,dc=example,dc=com"},
+		},
+		"cn=leads,ou=groups,dc=example,dc=com":  {"memberUid": {"bob"}, "member": {"cn=dbas,ou=groups,dc=example,dc=com"}},
+		"uid=alice,ou=people,dc=example,dc=com": {"uid": {"alice"}, "mail": {"alice@example.com"}},
+		"uid=bob,ou=people,dc=example,dc=com":   {"uid": {"bob"}, "mail": {"bob@example.com"}},
+	})
+	defer listener.Close()
+	config.LDAPURL = serverURL
+	config.LDAPBindDN = "cn=sensu,dc=example,dc=com"
+	config.LDAPBindPassword = "secret"
+	config.LDAPBaseDN = "dc=example,dc=com"
+	config.LDAPOwnerLabel = "owner"
+	config.LDAPOwnerFilter = "(|(uid=%s)(cn=%s))"
+	config.LDAPMailAttribute = "mail"
+	config.ToEmail = []string{"ldap:cn=dbas", "ou=groups", "dc=example", "dc=com", "ops@example.com"}
+	config.CcEmail = []string{"ldap:owner"}
+	defer func() {
+		config.LDAPURL = ""
+		config.LDAPBindDN = ""
+		config.LDAPBindPassword = ""
+		config.LDAPBaseDN = ""
+		config.LDAPOwnerLabel = ""
+		config.LDAPOwnerFilter = ""
+		config.LDAPMailAttribute = ""
+		config.ToEmail = nil
+		config.CcEmail = nil
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Entity.Labels = map[string]string{"owner": "bob"}
+	to, cc, _ := eventRcpts(event)
+	assert.Equal(t, rcpts{"alice@example.com", "bob@example.com", "ops@example.com"}, to)
+	assert.Equal(t, rcpts{"bob@example.com"}, cc)
+
+	// without the owner label, or when the bind fails, the ldap: recipients
+	// are skipped
+	event.Entity.Labels = nil
+	_, cc, _ = eventRcpts(event)
+	assert.Empty(t, cc)
+	config.LDAPBindPassword = "wrong"
+	to, _, _ = eventRcpts(event)
+	assert.Equal(t, rcpts{"ops@example.com"}, to)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// translations are the translations of the messages in the built-in
+// templates, by language. English needs none, the messages are English.
+var translations = map[string]map[string]string{
+	"de": {
+		"Sensu Alert":            "Sensu-Alarm",
+		"Sensu Alert Digest":     "Sensu-Alarmübersicht",
+		"events":                 "Ereignisse",
+		"Entity":                 "Entität",
+		"Check":                  "Check",
+		"Namespace":              "Namespace",
+		"Status":                 "Status",
+		"Occurrences":            "Vorkommen",
+		"occurrences":            "Vorkommen",
+		"Executed":               "Ausgeführt",
+		"executed":               "ausgeführt",
+		"Last OK":                "Zuletzt OK",
+		"never":                  "nie",
+		"Dashboard":              "Dashboard",
+		"View in Sensu":          "In Sensu anzeigen",
+		"Silence":                "Stummschalten",
+		"Silence for":            "Stummschalten für",
+		"stopped reporting":      "stoppte die Meldungen",
+		"stopped reporting at":   "stoppte die Meldungen um",
+		"is reporting again":     "meldet sich wieder",
+		"Last seen":              "Zuletzt gesehen",
+		"Hostname":               "Hostname",
+		"Platform":               "Plattform",
+		"Addresses":              "Adressen",
+		"Agent":                  "Agent",
+		"Subscriptions":          "Subscriptions",
+		"System":                 "System",
+		"Architecture":           "Architektur",
+		"Output":                 "Ausgabe",
+		"Hook":                   "Hook",
+		"History":                "Verlauf",
+		"Other active alerts on": "Weitere aktive Alarme auf",
+		"Metrics":                "Metriken",
+		"OK":                     "OK",
+		"WARNING":                "WARNUNG",
+		"CRITICAL":               "KRITISCH",
+		"UNKNOWN":                "UNBEKANNT",
+		"passing":                "erfolgreich",
+		"failing":                "fehlgeschlagen",
+		"flapping":               "instabil",
+	},
+	"es": {
+		"Sensu Alert":            "Alerta de Sensu",
+		"Sensu Alert Digest":     "Resumen de alertas de Sensu",
+		"events":                 "eventos",
+		"Entity":                 "Entidad",
+		"Check":                  "Chequeo",
+		"Namespace":              "Espacio de nombres",
+		"Status":                 "Estado",
+		"Occurrences":            "Ocurrencias",
+		"occurrences":            "ocurrencias",
+		"Executed":               "Ejecutado",
+		"executed":               "ejecutado",
+		"Last OK":                "Último OK",
+		"never":                  "nunca",
+		"Dashboard":              "Panel",
+		"View in Sensu":          "Ver en Sensu",
+		"Silence":                "Silenciar",
+		"Silence for":            "Silenciar durante",
+		"stopped reporting":      "dejó de informar",
+		"stopped reporting at":   "dejó de informar a las",
+		"is reporting again":     "vuelve a informar",
+		"Last seen":              "Visto por última vez",
+		"Hostname":               "Nombre de host",
+		"Platform":               "Plataforma",
+		"Addresses":              "Direcciones",
+		"Agent":                  "Agente",
+		"Subscriptions":          "Suscripciones",
+		"System":                 "Sistema",
+		"Architecture":           "Arquitectura",
+		"Output":                 "Salida",
+		"Hook":                   "Hook",
+		"History":                "Historial",
+		"Other active alerts on": "Otras alertas activas en",
+		"Metrics":                "Métricas",
+		"OK":                     "OK",
+		"WARNING":                "ADVERTENCIA",
+		"CRITICAL":               "CRÍTICO",
+		"UNKNOWN":                "DESCONOCIDO",
+		"passing":                "correcto",
+		"failing":                "fallando",
+		"flapping":               "inestable",
+	},
+	"fr": {
+		"Sensu Alert":            "Alerte Sensu",
+		"Sensu Alert Digest":     "Synthèse des alertes Sensu",
+		"events":                 "événements",
+		"Entity":                 "Entité",
+		"Check":                  "Contrôle",
+		"Namespace":              "Espace de noms",
+		"Status":                 "Statut",
+		"Occurrences":            "Occurrences",
+		"occurrences":            "occurrences",
+		"Executed":               "Exécuté",
+		"executed":               "exécuté",
+		"Last OK":                "Dernier OK",
+		"never":                  "jamais",
+		"Dashboard":              "Tableau de bord",
+		"View in Sensu":          "Voir dans Sensu",
+		"Silence":                "Mettre en silence",
+		"Silence for":            "Mettre en silence pendant",
+		"stopped reporting":      "a cessé de répondre",
+		"stopped reporting at":   "a cessé de répondre à",
+		"is reporting again":     "répond de nouveau",
+		"Last seen":              "Vu pour la dernière fois",
+		"Hostname":               "Nom d'hôte",
+		"Platform":               "Plateforme",
+		"Addresses":              "Adresses",
+		"Agent":                  "Agent",
+		"Subscriptions":          "Abonnements",
+		"System":                 "Système",
+		"Architecture":           "Architecture",
+		"Output":                 "Sortie",
+		"Hook":                   "Hook",
+		"History":                "Historique",
+		"Other active alerts on": "Autres alertes actives sur",
+		"Metrics":                "Métriques",
+		"OK":                     "OK",
+		"WARNING":                "AVERTISSEMENT",
+		"CRITICAL":               "CRITIQUE",
+		"UNKNOWN":                "INCONNU",
+		"passing":                "réussi",
+		"failing":                "en échec",
+		"flapping":               "instable",
+	},
+	"ja": {
+		"Sensu Alert":            "Sensu アラート",
+		"Sensu Alert Digest":     "Sensu アラートダイジェスト",
+		"events":                 "件のイベント",
+		"Entity":                 "エンティティ",
+		"Check":                  "チェック",
+		"Namespace":              "ネームスペース",
+		"Status":                 "ステータス",
+		"Occurrences":            "発生回数",
+		"occurrences":            "回発生",
+		"Executed":               "実行日時",
+		"executed":               "実行日時",
+		"Last OK":                "最終正常日時",
+		"never":                  "なし",
+		"Dashboard":              "ダッシュボード",
+		"View in Sensu":          "Sensu で表示",
+		"Silence":                "サイレンス",
+		"Silence for":            "サイレンス期間",
+		"stopped reporting":      "の報告が停止しました",
+		"stopped reporting at":   "の報告が停止しました:",
+		"is reporting again":     "の報告が再開しました",
+		"Last seen":              "最終確認",
+		"Hostname":               "ホスト名",
+		"Platform":               "プラットフォーム",
+		"Addresses":              "アドレス",
+		"Agent":                  "エージェント",
+		"Subscriptions":          "サブスクリプション",
+		"System":                 "システム",
+		"Architecture":           "アーキテクチャ",
+		"Output":                 "出力",
+		"Hook":                   "フック",
+		"History":                "履歴",
+		"Other active alerts on": "その他のアクティブなアラート",
+		"Metrics":                "メトリクス",
+		"OK":                     "正常",
+		"WARNING":                "警告",
+		"CRITICAL":               "重大",
+		"UNKNOWN":                "不明",
+		"passing":                "正常",
+		"failing":                "異常",
+		"flapping":               "不安定",
+	},
+}
+
+// activeTranslations is the message catalog for --locale, merged with the
+// messages of --translationFile.
+var activeTranslations map[string]string
+
+// loadTranslations returns the message catalog for the locale, such as de,
+// de-AT or de_AT.UTF-8, falling back to its language, with the messages of
+// the translation file, if any, taking precedence. The translation file is a
+// JSON object mapping the English messages to their translations.
+func loadTranslations(locale, file string) (map[string]string, error) {
+	catalog := make(map[string]string)
+	if len(locale) > 0 {
+		tag := strings.ToLower(strings.Replace(locale, "_", "-", -1))
+		if i := strings.IndexAny(tag, ".@"); i >= 0 {
+			tag = tag[:i]
+		}
+		builtin, ok := translations[tag]
+		if !ok {
+			builtin, ok = translations[strings.SplitN(tag, "-", 2)[0]]
+		}
+		if !ok && tag != "en" && !strings.HasPrefix(tag, "en-") && len(file) == 0 {
+			return nil, fmt.Errorf("unsupported locale %s, use --%s to provide its translations", locale, translationFile)
+		}
+		for message, translation := range builtin {
+			catalog[message] = translation
+		}
+	}
+	if len(file) > 0 {
+		text, err := readTemplateFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read translation file %s: %v", file, err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(text, &messages); err != nil {
+			return nil, fmt.Errorf("failed to parse translation file %s: %v", file, err)
+		}
+		for message, translation := range messages {
+			catalog[message] = translation
+		}
+	}
+	return catalog, nil
+}
+
+// translate returns the translation of the message for the configured
+// locale, or the message itself if there is none, for the Translate template
+// function.
+func translate(message string) string {
+	if translation, ok := activeTranslations[message]; ok {
+		return translation
+	}
+	return message
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTranslations(t *testing.T) {
+	catalog, err := loadTranslations("de_AT.UTF-8", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "KRITISCH", catalog["CRITICAL"])
+
+	catalog, err = loadTranslations("en-GB", "")
+	assert.NoError(t, err)
+	assert.Empty(t, catalog)
+
+	_, err = loadTranslations("nl", "")
+	assert.EqualError(t, err, "unsupported locale nl, use --translationFile to provide its translations")
+
+	file, err := ioutil.TempFile("", "translations")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`{"Sensu Alert": "Sensu-melding", "CRITICAL": "KRITIEK"}`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	catalog, err = loadTranslations("nl", file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"Sensu Alert": "Sensu-melding", "CRITICAL": "KRITIEK"}, catalog)
+
+	catalog, err = loadTranslations("de", file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "KRITIEK", catalog["CRITICAL"])
+	assert.Equal(t, "WARNUNG", catalog["WARNING"])
+}
+
+func TestTranslatedTemplates(t *testing.T) {
+	catalog, err := loadTranslations("fr", "")
+	assert.NoError(t, err)
+	activeTranslations = catalog
+	defer func() { activeTranslations = nil }()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.State = "failing"
+	subject, err := resolveTemplate(defaultSubjectTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alerte Sensu - foo/bar: en échec", subject)
+
+	body, err := resolveTemplate(classicTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Contains(t, body, "Statut:      CRITIQUE (2)\n")
+	assert.Contains(t, body, "Dernier OK:  jamais\n")
+
+	out, err := resolveTemplate(`{{Translate "not translated"}}`, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "not translated", out)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive lock on the file, waiting until it is released
+// by any other process holding it. Solaris has no flock, so a POSIX record
+// lock of the whole file is used.
+func lockFile(f *os.File) error {
+	return unix.FcntlFlock(f.Fd(), unix.F_SETLKW, &unix.Flock_t{Type: unix.F_WRLCK, Whence: 0})
+}
+
+func unlockFile(f *os.File) error {
+	return unix.FcntlFlock(f.Fd(), unix.F_SETLK, &unix.Flock_t{Type: unix.F_UNLCK, Whence: 0})
+}
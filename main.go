@@ -1,40 +1,186 @@
 package main
 
 import (
-	"bytes"
+	"crypto"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	htemplate "html/template"
-	"io"
 	"io/ioutil"
-	"math"
-	"net/mail"
+	"net"
 	"net/smtp"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
-	ttemplate "text/template"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/google/uuid"
 	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	"github.com/sensu/sensu-email-handler/pkg/templates"
+	"github.com/sensu/sensu-email-handler/pkg/transport"
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
 )
 
 //HandlerConfig config options for email handler.
 type HandlerConfig struct {
 	sensu.PluginConfig
-	SmtpHost         string
-	SmtpUsername     string
-	SmtpPassword     string
-	SmtpPort         uint64
-	ToEmail          []string
-	FromEmail        string
-	FromHeader       string
-	AuthMethod       string
-	TLSSkipVerify    bool
-	Hookout          bool
-	BodyTemplateFile string
-	SubjectTemplate  string
+	SmtpHost                  string
+	SmtpUsername              string
+	SmtpPassword              string
+	SmtpOAuth2Token           string
+	SmtpUsernameFile          string
+	SmtpPasswordFile          string
+	VaultAddress              string
+	VaultAuthMethod           string
+	VaultToken                string
+	VaultRole                 string
+	VaultSecretID             string
+	VaultSecretPath           string
+	SmtpPort                  uint64
+	HeloHost                  string
+	LocalAddr                 string
+	IPVersion                 string
+	DirectDelivery            bool
+	RequireTLS                bool
+	MTASTS                    bool
+	DANE                      bool
+	ToEmail                   []string
+	FromEmail                 string
+	EnvelopeFrom              string
+	FromHeader                string
+	AuthMethod                string
+	NTLMDomain                string
+	KerberosKeytab            string
+	KerberosPrincipal         string
+	KerberosKDC               string
+	TLSSkipVerify             bool
+	TLSCAFile                 string
+	TLSMinVersion             string
+	TLSCipherSuites           []string
+	Hookout                   bool
+	BodyTemplate              string
+	StripANSI                 bool
+	RedactPatterns            []string
+	RedactDefaultFields       bool
+	TemplateDelims            string
+	Locale                    string
+	TranslationFile           string
+	FallbackOnTemplateError   bool
+	BodyTemplateFile          string
+	BodyTemplateSHA256        string
+	TemplatePartials          []string
+	TemplateCacheDir          string
+	TemplateCacheTTL          string
+	TemplateHeaders           []string
+	TemplateToken             string
+	TemplateUsername          string
+	TemplatePassword          string
+	SubjectTemplate           string
+	SubjectTemplateFile       string
+	ResolvedBodyTemplateFile  string
+	ResolvedSubjectTemplate   string
+	KeepaliveBodyTemplateFile string
+	KeepaliveSubjectTemplate  string
+	BodyFormat                string
+	ContentType               string
+	MaxBodySize               int64
+	AttachEventJSON           bool
+	BodyTemplateName          string
+	MinOccurrences            int64
+	BackoffOccurrences        bool
+	DigestSubjectTemplate     string
+	DigestBodyTemplateFile    string
+	StateDir                  string
+	DedupWindow               string
+	MaxEmailsPerHour          int64
+	ResolveOnlyAfterAlert     bool
+	OnStateChangeOnly         bool
+	SuppressFlapping          bool
+	LowFlapThreshold          int64
+	HighFlapThreshold         int64
+	FlappingSubjectTemplate   string
+	ProxyGroupLabel           string
+	ProxyGroupWindow          string
+	FilterExpression          string
+	ChartImageURL             string
+	DashboardURL              string
+	SilenceURL                string
+	SilenceDuration           string
+	SensuAPIURL               string
+	SensuAPIKey               string
+	Silenced                  string
+	AnnotateEvent             bool
+	EventFile                 string
+	CheckConnection           bool
+	FlushSpool                bool
+	SpoolDir                  string
+	SpoolMaxAge               string
+	SmtpRetries               int
+	FallbackWebhookURL        string
+	Listen                    string
+	Verbose                   bool
+	SmtpTraceFile             string
+	ConfigFile                string
+	Profile                   string
+	CaptureDir                string
+	CaptureAddress            string
+	RedisURL                  string
+	RedisKey                  string
+	RedisStream               bool
+	StatsdAddress             string
+	PushgatewayURL            string
+	MetricsFile               string
+	JSONResult                bool
+	FailOn                    string
+	CcEmail                   []string
+	BccEmail                  []string
+	SendIndividually          bool
+	VerifyRecipients          bool
+	DSNNotify                 string
+	DSNReturn                 string
+	CriticalToEmail           []string
+	WarningToEmail            []string
+	ResolvedToEmail           []string
+	ContactsFile              string
+	AddressBookFile           string
+	NamespacesFile            string
+	RoutingRulesFile          string
+	MaintenanceFile           string
+	BusinessHours             []string
+	EscalationToEmail         []string
+	EscalationOccurrences     int64
+	EscalationSubjectPrefix   string
+	DateFormat                string
+	Timezone                  string
+	Charset                   string
+	ExtraHeaders              []string
+	PriorityHeaders           bool
+	IncludeSystemInfo         bool
+	PrecedenceBulk            bool
+	Threading                 bool
+	UnsubscribeURL            string
+	MessageIDDomain           string
+	DKIMPrivateKeyFile        string
+	DKIMDomain                string
+	DKIMSelector              string
+	SMIMECertFile             string
+	SMIMEKeyFile              string
+	PGPPublicKeyFiles         []string
+	PGPKeyserver              string
+	LDAPURL                   string
+	LDAPBindDN                string
+	LDAPBindPassword          string
+	LDAPBaseDN                string
+	LDAPOwnerLabel            string
+	LDAPOwnerFilter           string
+	LDAPMailAttribute         string
 
 	// deprecated options
 	Insecure  bool
@@ -47,24 +193,161 @@ type loginAuth struct {
 
 type rcpts []string
 
-// used to handle getting text/template or html/template
-type templater interface {
-	Execute(wr io.Writer, data interface{}) error
-}
-
 const (
-	smtpHost         = "smtpHost"
-	smtpUsername     = "smtpUsername"
-	smtpPassword     = "smtpPassword"
-	smtpPort         = "smtpPort"
-	toEmail          = "toEmail"
-	fromEmail        = "fromEmail"
-	authMethod       = "authMethod"
-	tlsSkipVerify    = "tlsSkipVerify"
-	hookout          = "hookout"
-	bodyTemplateFile = "bodyTemplateFile"
-	subjectTemplate  = "subjectTemplate"
-	defaultSmtpPort  = 587
+	smtpHost                  = "smtpHost"
+	smtpUsername              = "smtpUsername"
+	smtpPassword              = "smtpPassword"
+	smtpOAuth2Token           = "smtpOAuth2Token"
+	smtpUsernameFile          = "smtpUsernameFile"
+	smtpPasswordFile          = "smtpPasswordFile"
+	ntlmDomain                = "ntlmDomain"
+	kerberosKeytab            = "kerberosKeytab"
+	kerberosPrincipal         = "kerberosPrincipal"
+	kerberosKDC               = "kerberosKDC"
+	vaultAddress              = "vaultAddress"
+	vaultAuthMethod           = "vaultAuthMethod"
+	vaultToken                = "vaultToken"
+	vaultRole                 = "vaultRole"
+	vaultSecretID             = "vaultSecretID"
+	vaultSecretPath           = "vaultSecretPath"
+	smtpPort                  = "smtpPort"
+	heloHost                  = "heloHost"
+	localAddr                 = "localAddr"
+	ipVersion                 = "ipVersion"
+	directDelivery            = "directDelivery"
+	requireTLS                = "requireTLS"
+	mtaSTS                    = "mtaSTS"
+	dane                      = "dane"
+	toEmail                   = "toEmail"
+	fromEmail                 = "fromEmail"
+	envelopeFrom              = "envelopeFrom"
+	authMethod                = "authMethod"
+	tlsSkipVerify             = "tlsSkipVerify"
+	tlsCAFile                 = "tlsCAFile"
+	tlsMinVersion             = "tlsMinVersion"
+	tlsCipherSuites           = "tlsCipherSuites"
+	hookout                   = "hookout"
+	bodyTemplate              = "bodyTemplate"
+	stripANSI                 = "stripANSI"
+	redactPattern             = "redactPattern"
+	redactDefaultFields       = "redactDefaultFields"
+	templateDelims            = "templateDelims"
+	locale                    = "locale"
+	translationFile           = "translationFile"
+	fallbackOnTemplateError   = "fallbackOnTemplateError"
+	bodyTemplateFile          = "bodyTemplateFile"
+	bodyTemplateSHA256        = "bodyTemplateSHA256"
+	templatePartials          = "templatePartials"
+	templateCacheDir          = "templateCacheDir"
+	templateCacheTTL          = "templateCacheTTL"
+	templateHeader            = "templateHeader"
+	templateToken             = "templateToken"
+	templateUsername          = "templateUsername"
+	templatePassword          = "templatePassword"
+	subjectTemplate           = "subjectTemplate"
+	subjectTemplateFile       = "subjectTemplateFile"
+	resolvedBodyTemplateFile  = "resolvedBodyTemplateFile"
+	resolvedSubjectTemplate   = "resolvedSubjectTemplate"
+	keepaliveBodyTemplateFile = "keepaliveBodyTemplateFile"
+	keepaliveSubjectTemplate  = "keepaliveSubjectTemplate"
+	bodyFormat                = "bodyFormat"
+	contentType               = "contentType"
+	maxBodySize               = "maxBodySize"
+	attachEventJSON           = "attachEventJSON"
+	bodyTemplateName          = "bodyTemplateName"
+	minOccurrences            = "minOccurrences"
+	backoffOccurrences        = "exponentialBackoffOccurrences"
+	digestSubjectTemplate     = "digestSubjectTemplate"
+	digestBodyTemplateFile    = "digestBodyTemplateFile"
+	stateDir                  = "stateDir"
+	dedupWindow               = "dedupWindow"
+	maxEmailsPerHour          = "maxEmailsPerHour"
+	resolveOnlyAfterAlert     = "resolveOnlyAfterAlert"
+	onStateChangeOnly         = "onStateChangeOnly"
+	suppressFlapping          = "suppressFlapping"
+	lowFlapThreshold          = "lowFlapThreshold"
+	highFlapThreshold         = "highFlapThreshold"
+	flappingSubjectTemplate   = "flappingSubjectTemplate"
+	proxyGroupLabel           = "proxyGroupLabel"
+	proxyGroupWindow          = "proxyGroupWindow"
+	filterExpression          = "filterExpression"
+	chartImageURL             = "chartImageURL"
+	dashboardURL              = "dashboardURL"
+	silenceURL                = "silenceURL"
+	silenceDuration           = "silenceDuration"
+	sensuAPIURL               = "sensuAPIURL"
+	sensuAPIKey               = "sensuAPIKey"
+	silenced                  = "silenced"
+	annotateEvent             = "annotateEvent"
+	eventFile                 = "eventFile"
+	checkConnection           = "checkConnection"
+	flushSpool                = "flushSpool"
+	spoolDir                  = "spoolDir"
+	spoolMaxAge               = "spoolMaxAge"
+	smtpRetries               = "smtpRetries"
+	fallbackWebhookURL        = "fallbackWebhookURL"
+	listen                    = "listen"
+	verbose                   = "verbose"
+	smtpTraceFile             = "smtpTraceFile"
+	configFile                = "configFile"
+	profile                   = "profile"
+	captureDir                = "captureDir"
+	captureAddress            = "captureAddress"
+	redisURL                  = "redisURL"
+	redisKey                  = "redisKey"
+	redisStream               = "redisStream"
+	statsdAddress             = "statsdAddress"
+	pushgatewayURL            = "pushgatewayURL"
+	metricsFile               = "metricsFile"
+	jsonResult                = "jsonResult"
+	failOn                    = "failOn"
+	ccEmail                   = "ccEmail"
+	bccEmail                  = "bccEmail"
+	sendIndividually          = "sendIndividually"
+	verifyRecipients          = "verifyRecipients"
+	dsnNotify                 = "dsnNotify"
+	dsnReturn                 = "dsnReturn"
+	criticalToEmail           = "criticalToEmail"
+	warningToEmail            = "warningToEmail"
+	resolvedToEmail           = "resolvedToEmail"
+	contactsFile              = "contactsFile"
+	addressBookFile           = "addressBookFile"
+	namespacesFile            = "namespacesFile"
+	routingRulesFile          = "routingRulesFile"
+	maintenanceFile           = "maintenanceFile"
+	businessHours             = "businessHours"
+	escalationToEmail         = "escalationToEmail"
+	escalationOccurrences     = "escalationOccurrences"
+	escalationSubjectPrefix   = "escalationSubjectPrefix"
+	dateFormat                = "dateFormat"
+	timezone                  = "timezone"
+	charset                   = "charset"
+	extraHeader               = "extraHeader"
+	priorityHeaders           = "priorityHeaders"
+	includeSystemInfo         = "includeSystemInfo"
+	precedenceBulk            = "precedenceBulk"
+	threading                 = "threading"
+	unsubscribeURL            = "unsubscribeURL"
+	messageIDDomain           = "messageIDDomain"
+	dkimPrivateKeyFile        = "dkimPrivateKeyFile"
+	dkimDomain                = "dkimDomain"
+	dkimSelector              = "dkimSelector"
+	smimeCertFile             = "smimeCertFile"
+	smimeKeyFile              = "smimeKeyFile"
+	pgpPublicKeyFile          = "pgpPublicKeyFile"
+	pgpKeyserver              = "pgpKeyserver"
+	ldapURL                   = "ldapURL"
+	ldapBindDN                = "ldapBindDN"
+	ldapBindPassword          = "ldapBindPassword"
+	ldapBaseDN                = "ldapBaseDN"
+	ldapOwnerLabel            = "ldapOwnerLabel"
+	ldapOwnerFilter           = "ldapOwnerFilter"
+	ldapMailAttribute         = "ldapMailAttribute"
+	defaultSmtpPort           = 587
+	defaultCharset            = "utf-8"
+	// the layout of time.Time.String, which UnixTime printed before
+	// --dateFormat was added
+	defaultDateFormat = "2006-01-02 15:04:05.999999999 -0700 MST"
 
 	// deprecated options
 	insecure        = "insecure"
@@ -72,15 +355,58 @@ const (
 )
 
 const (
-	AuthMethodNone  = "none"
-	AuthMethodPlain = "plain"
-	AuthMethodLogin = "login"
+	AuthMethodNone   = "none"
+	AuthMethodPlain  = "plain"
+	AuthMethodLogin  = "login"
+	AuthMethodNTLM   = "ntlm"
+	AuthMethodGSSAPI = "gssapi"
+	AuthMethodAuto   = "auto"
+)
+
+// How silences matching the event are handled with --silenced
+const (
+	SilencedTemplate = "template"
+	SilencedNote     = "note"
+	SilencedSuppress = "suppress"
+)
+
+// Email body formats
+const (
+	BodyFormatTemplate = "template"
+	BodyFormatMarkdown = "markdown"
+	BodyFormatHTML     = "html"
 )
 
+// headers set by the handler itself, which cannot be set with --extraHeader
+var reservedHeaders = []string{
+	"From",
+	"To",
+	"Cc",
+	"Bcc",
+	"Sender",
+	"Return-Path",
+	"Subject",
+	"Date",
+	"MIME-Version",
+	"Content-Type",
+	"Content-Transfer-Encoding",
+	"DKIM-Signature",
+	"Message-ID",
+	"In-Reply-To",
+	"References",
+	"Auto-Submitted",
+	"List-Unsubscribe",
+	"Precedence",
+	"X-Priority",
+	"Importance",
+}
+
 // Email body content
 const (
 	ContentHTML  = "text/html"
 	ContentPlain = "text/plain"
+	// ContentAuto detects HTML templates by their <html> tag
+	ContentAuto = "auto"
 )
 
 var (
@@ -90,100 +416,1232 @@ var (
 			Short:    "The Sensu Go Email handler for sending an email notification",
 			Keyspace: "sensu.io/plugins/email/config",
 		},
-	}
-
-	emailBodyTemplate = "{{.Check.Output}}"
-
-	emailConfigOptions = []*sensu.PluginConfigOption{
+	}
+
+	emailBodyTemplate = defaultBodyTemplate
+
+	// body template used for resolutions, if --resolvedBodyTemplateFile is set
+	resolvedBodyTemplate string
+
+	// body template used for keepalive events, if --keepaliveBodyTemplateFile
+	// is set, otherwise the built-in keepalive template is used
+	keepaliveBodyTemplate string
+
+	// body template used for digests
+	digestBodyTemplate = defaultDigestBodyTemplate
+
+	// parsed --dedupWindow
+	dedupDuration time.Duration
+
+	// parsed --proxyGroupWindow
+	proxyGroupDuration time.Duration
+
+	// parsed --spoolMaxAge
+	spoolMaxDuration time.Duration
+
+	// location used when rendering timestamps in templates
+	templateLocation = time.Local
+
+	// encoding used to convert the resolved body to the configured charset
+	bodyEncoding encoding.Encoding = unicode.UTF8
+
+	// key used to DKIM sign the email, if configured
+	dkimKey crypto.Signer
+
+	// certificate and key used to S/MIME sign the email, if configured
+	smime *smimeSigner
+
+	// public keys read from --pgpPublicKeyFile to encrypt the email to
+	pgpKeys openpgp.EntityList
+
+	// compiled --redactPattern regular expressions
+	redactPatterns []*regexp.Regexp
+
+	emailConfigOptions = []*sensu.PluginConfigOption{
+		{
+			Path:      smtpHost,
+			Argument:  smtpHost,
+			Shorthand: "s",
+			Default:   "",
+			Usage:     "The SMTP host to use to send to send email",
+			Value:     &config.SmtpHost,
+		},
+		{
+			Path:      smtpUsername,
+			Env:       "SMTP_USERNAME",
+			Argument:  smtpUsername,
+			Shorthand: "u",
+			Default:   "",
+			Usage:     "The SMTP username, if not in env SMTP_USERNAME, or secret:NAME for the Sensu secret NAME",
+			Value:     &config.SmtpUsername,
+		},
+		{
+			Path:      smtpPassword,
+			Env:       "SMTP_PASSWORD",
+			Argument:  smtpPassword,
+			Shorthand: "p",
+			Default:   "",
+			Usage:     "The SMTP password, if not in env SMTP_PASSWORD, or secret:NAME for the Sensu secret NAME",
+			Value:     &config.SmtpPassword,
+		},
+		{
+			Path:      smtpOAuth2Token,
+			Env:       "SMTP_OAUTH2_TOKEN",
+			Argument:  smtpOAuth2Token,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "An OAuth 2.0 access token for XOAUTH2 with --authMethod auto, if not in env SMTP_OAUTH2_TOKEN",
+			Value:     &config.SmtpOAuth2Token,
+		},
+		{
+			Env:       "SMTP_USERNAME_FILE",
+			Argument:  smtpUsernameFile,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "A file containing the SMTP username, if not in env SMTP_USERNAME_FILE",
+			Value:     &config.SmtpUsernameFile,
+		},
+		{
+			Env:       "SMTP_PASSWORD_FILE",
+			Argument:  smtpPasswordFile,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "A file containing the SMTP password, if not in env SMTP_PASSWORD_FILE",
+			Value:     &config.SmtpPasswordFile,
+		},
+		{
+			Env:       "VAULT_ADDR",
+			Argument:  vaultAddress,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The address of the Vault server to read the SMTP credentials from, if not in env VAULT_ADDR",
+			Value:     &config.VaultAddress,
+		},
+		{
+			Argument:  vaultAuthMethod,
+			Shorthand: "",
+			Default:   VaultAuthToken,
+			Secret:    true,
+			Usage:     "The Vault auth method, one of 'token', 'kubernetes', or 'approle'",
+			Value:     &config.VaultAuthMethod,
+		},
+		{
+			Env:       "VAULT_TOKEN",
+			Argument:  vaultToken,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The Vault token for token auth, if not in env VAULT_TOKEN",
+			Value:     &config.VaultToken,
+		},
+		{
+			Argument:  vaultRole,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The Vault role for kubernetes auth, or role ID for approle auth",
+			Value:     &config.VaultRole,
+		},
+		{
+			Env:       "VAULT_SECRET_ID",
+			Argument:  vaultSecretID,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The secret ID for Vault approle auth, if not in env VAULT_SECRET_ID",
+			Value:     &config.VaultSecretID,
+		},
+		{
+			Argument:  vaultSecretPath,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The path of the Vault secret with the SMTP 'username' and 'password' (e.g. secret/data/smtp)",
+			Value:     &config.VaultSecretPath,
+		},
+		{
+			Path:      smtpPort,
+			Argument:  smtpPort,
+			Shorthand: "P",
+			Default:   uint64(defaultSmtpPort),
+			Usage:     "The SMTP server port",
+			Value:     &config.SmtpPort,
+		},
+		{
+			Path:      heloHost,
+			Argument:  heloHost,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The host name to send in EHLO/HELO, defaults to the host name of the system",
+			Value:     &config.HeloHost,
+		},
+		{
+			Path:      localAddr,
+			Argument:  localAddr,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The local IP address to connect to the SMTP server from, for relays that allow senders by their address",
+			Value:     &config.LocalAddr,
+		},
+		{
+			Path:      ipVersion,
+			Argument:  ipVersion,
+			Shorthand: "",
+			Default:   IPVersionAny,
+			Usage:     "The IP version to connect to the SMTP server with, one of 'any', '4' or '6'",
+			Value:     &config.IPVersion,
+		},
+		{
+			Path:      directDelivery,
+			Argument:  directDelivery,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Deliver directly to the MX hosts of the recipient domains on port 25, instead of through --smtpHost (-s)",
+			Value:     &config.DirectDelivery,
+		},
+		{
+			Path:      requireTLS,
+			Argument:  requireTLS,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Refuse to send to SMTP servers that don't support STARTTLS, instead of sending in cleartext",
+			Value:     &config.RequireTLS,
+		},
+		{
+			Path:      mtaSTS,
+			Argument:  mtaSTS,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "With --directDelivery, honor the MTA-STS policies of the recipient domains, cached in --stateDir",
+			Value:     &config.MTASTS,
+		},
+		{
+			Path:      dane,
+			Argument:  dane,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "With --directDelivery, authenticate MX hosts with their DNSSEC signed TLSA records, which needs a validating resolver in /etc/resolv.conf",
+			Value:     &config.DANE,
+		},
+		{
+			Path:      toEmail,
+			Argument:  toEmail,
+			Shorthand: "t",
+			Default:   []string{},
+			Usage:     "The 'to' email address (accepts comma delimited and/or multiple flags)",
+			Value:     &config.ToEmail,
+		},
+		{
+			Path:      ccEmail,
+			Argument:  ccEmail,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "The 'cc' email address (accepts comma delimited and/or multiple flags)",
+			Value:     &config.CcEmail,
+		},
+		{
+			Path:      bccEmail,
+			Argument:  bccEmail,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "The 'bcc' email address (accepts comma delimited and/or multiple flags)",
+			Value:     &config.BccEmail,
+		},
+		{
+			Path:      sendIndividually,
+			Argument:  sendIndividually,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Send each recipient their own copy of the email, addressed only to them, instead of one email listing every recipient",
+			Value:     &config.SendIndividually,
+		},
+		{
+			Path:      verifyRecipients,
+			Argument:  verifyRecipients,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Probe each recipient with SMTP VRFY and RCPT, sending the email to the valid recipients and failing with the invalid ones instead of the whole email",
+			Value:     &config.VerifyRecipients,
+		},
+		{
+			Path:      dsnNotify,
+			Argument:  dsnNotify,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Request delivery status notifications for these conditions, a comma separated list of SUCCESS, FAILURE and DELAY, or NEVER (e.g. FAILURE,DELAY)",
+			Value:     &config.DSNNotify,
+		},
+		{
+			Path:      dsnReturn,
+			Argument:  dsnReturn,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Whether delivery status notifications return the full email (FULL) or only its headers (HDRS)",
+			Value:     &config.DSNReturn,
+		},
+		{
+			Path:      criticalToEmail,
+			Argument:  criticalToEmail,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "The 'to' email address for critical events, defaults to --toEmail (accepts comma delimited and/or multiple flags)",
+			Value:     &config.CriticalToEmail,
+		},
+		{
+			Path:      warningToEmail,
+			Argument:  warningToEmail,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "The 'to' email address for warning events, defaults to --toEmail (accepts comma delimited and/or multiple flags)",
+			Value:     &config.WarningToEmail,
+		},
+		{
+			Path:      resolvedToEmail,
+			Argument:  resolvedToEmail,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "The 'to' email address for resolved (OK) events, defaults to --toEmail (accepts comma delimited and/or multiple flags)",
+			Value:     &config.ResolvedToEmail,
+		},
+		{
+			Path:      contactsFile,
+			Argument:  contactsFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A YAML file mapping contact names to email addresses, events are sent to the contacts listed in their \"contacts\" label or annotation instead of --toEmail",
+			Value:     &config.ContactsFile,
+		},
+		{
+			Path:      addressBookFile,
+			Argument:  addressBookFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A YAML file mapping aliases to email addresses, recipients given as an alias (e.g. --toEmail oncall-db) are replaced by its addresses",
+			Value:     &config.AddressBookFile,
+		},
+		{
+			Argument:  namespacesFile,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "A YAML file mapping Sensu namespaces to the From address and SMTP relay used for their events",
+			Value:     &config.NamespacesFile,
+		},
+		{
+			Path:      routingRulesFile,
+			Argument:  routingRulesFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A YAML file of rules matching events by namespace, entity, check, labels and severity to the recipients of their emails",
+			Value:     &config.RoutingRulesFile,
+		},
+		{
+			Path:      maintenanceFile,
+			Argument:  maintenanceFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A YAML file of recurring maintenance windows during which emails for the matching events are suppressed or queued",
+			Value:     &config.MaintenanceFile,
+		},
+		{
+			Path:      businessHours,
+			Argument:  businessHours,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "Business hours (e.g. \"mon-fri 09:00-17:00\"), outside of which emails for non-critical events are deferred until they begin (accepts comma delimited and/or multiple flags)",
+			Value:     &config.BusinessHours,
+		},
+		{
+			Path:      escalationToEmail,
+			Argument:  escalationToEmail,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "An email address also sent emails for events that have reached --escalationOccurrences (accepts comma delimited and/or multiple flags)",
+			Value:     &config.EscalationToEmail,
+		},
+		{
+			Path:      escalationOccurrences,
+			Argument:  escalationOccurrences,
+			Shorthand: "",
+			Default:   int64(0),
+			Usage:     "The number of occurrences without resolution after which emails are also sent to --escalationToEmail",
+			Value:     &config.EscalationOccurrences,
+		},
+		{
+			Path:      escalationSubjectPrefix,
+			Argument:  escalationSubjectPrefix,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A prefix added to the subject of escalated emails, e.g. \"[ESCALATED] \"",
+			Value:     &config.EscalationSubjectPrefix,
+		},
+		{
+			Path:      fromEmail,
+			Argument:  fromEmail,
+			Shorthand: "f",
+			Default:   "",
+			Usage:     "The 'from' email address",
+			Value:     &config.FromEmail,
+		},
+		{
+			Path:      envelopeFrom,
+			Argument:  envelopeFrom,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The envelope sender (SMTP MAIL FROM) address, if different from the 'from' email address",
+			Value:     &config.EnvelopeFrom,
+		},
+		{
+			Path:      tlsSkipVerify,
+			Argument:  tlsSkipVerify,
+			Shorthand: "k",
+			Default:   false,
+			Usage:     "Do not verify TLS certificates",
+			Value:     &config.TLSSkipVerify,
+		},
+		{
+			Path:      tlsCAFile,
+			Argument:  tlsCAFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A PEM file of CA certificates to verify the TLS certificates of the SMTP server and template URLs with, instead of the system CAs",
+			Value:     &config.TLSCAFile,
+		},
+		{
+			Path:      tlsMinVersion,
+			Argument:  tlsMinVersion,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The minimum TLS version to negotiate with SMTP servers, one of '1.0', '1.1', '1.2' or '1.3'",
+			Value:     &config.TLSMinVersion,
+		},
+		{
+			Path:      tlsCipherSuites,
+			Argument:  tlsCipherSuites,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "The TLS 1.2 and earlier cipher suites to offer SMTP servers, by their IANA names (accepts comma delimited and/or multiple flags)",
+			Value:     &config.TLSCipherSuites,
+		},
+		{
+			Path:      authMethod,
+			Argument:  authMethod,
+			Shorthand: "a",
+			Default:   AuthMethodPlain,
+			Usage:     "The SMTP authentication method, one of 'none', 'plain', 'login', 'ntlm', 'gssapi' or 'auto' (the strongest the server offers)",
+			Value:     &config.AuthMethod,
+		},
+		{
+			Path:      ntlmDomain,
+			Argument:  ntlmDomain,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The Windows domain of the SMTP username for ntlm auth, which can also be given as DOMAIN\\username",
+			Value:     &config.NTLMDomain,
+		},
+		{
+			Argument:  kerberosKeytab,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A keytab to request the Kerberos ticket for gssapi auth with, instead of using the credential cache of KRB5CCNAME",
+			Value:     &config.KerberosKeytab,
+		},
+		{
+			Path:      kerberosPrincipal,
+			Argument:  kerberosPrincipal,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The principal of the --kerberosKeytab key to use, defaults to the first in the keytab",
+			Value:     &config.KerberosPrincipal,
+		},
+		{
+			Argument:  kerberosKDC,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Comma separated host[:port] of the KDCs for gssapi auth, defaults to those of the realm in krb5.conf or DNS",
+			Value:     &config.KerberosKDC,
+		},
+		{
+			Path:      hookout,
+			Argument:  hookout,
+			Shorthand: "H",
+			Default:   false,
+			Usage:     "Include output from check hook(s)",
+			Value:     &config.Hookout,
+		},
+		{
+			Path:      bodyTemplate,
+			Argument:  bodyTemplate,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A template to use for the body, overriding --bodyTemplateFile (-T), --bodyTemplateName and --hookout (-H)",
+			Value:     &config.BodyTemplate,
+		},
+		{
+			Path:      stripANSI,
+			Argument:  stripANSI,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Remove ANSI escape sequences, such as colors, from the check and hook output",
+			Value:     &config.StripANSI,
+		},
+		{
+			Path:      redactPattern,
+			Argument:  redactPattern,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "A regular expression whose matches in the check and hook output are replaced with REDACTED, or only its capturing groups if it has any (accepts comma delimited and/or multiple flags)",
+			Value:     &config.RedactPatterns,
+		},
+		{
+			Path:      redactDefaultFields,
+			Argument:  redactDefaultFields,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Mask the values of Sensu's default redact fields (password, secret, ...) in the check and hook output of entities without a redact list",
+			Value:     &config.RedactDefaultFields,
+		},
+		{
+			Path:      templateDelims,
+			Argument:  templateDelims,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The left and right delimiters of the templates given to the handler, separated by a comma (e.g. \"[[,]]\"), instead of {{ and }}",
+			Value:     &config.TemplateDelims,
+		},
+		{
+			Path:      locale,
+			Argument:  locale,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The language of the default and built-in templates (en, de, es, fr or ja), with messages translated by the Translate template function",
+			Value:     &config.Locale,
+		},
+		{
+			Path:      translationFile,
+			Argument:  translationFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A JSON file of message translations for the Translate template function, taking precedence over those of --locale",
+			Value:     &config.TranslationFile,
+		},
+		{
+			Path:      fallbackOnTemplateError,
+			Argument:  fallbackOnTemplateError,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Send a plain email with the check output and the error if the subject or body template fails to resolve",
+			Value:     &config.FallbackOnTemplateError,
+		},
+		{
+			Path:      bodyTemplateFile,
+			Argument:  bodyTemplateFile,
+			Shorthand: "T",
+			Default:   "",
+			Usage:     "A template file to use for the body",
+			Value:     &config.BodyTemplateFile,
+		},
+		{
+			Argument:  bodyTemplateSHA256,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The hex encoded SHA-256 checksum the body template file must match before it is used",
+			Value:     &config.BodyTemplateSHA256,
+		},
+		{
+			Path:      templatePartials,
+			Argument:  templatePartials,
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "Template files, or directories of them, that can be included in the templates by file name without extension (accepts comma delimited and/or multiple flags)",
+			Value:     &config.TemplatePartials,
+		},
+		{
+			Argument:  templateCacheDir,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A directory in which to cache templates fetched from http(s) URLs",
+			Value:     &config.TemplateCacheDir,
+		},
+		{
+			Path:      templateCacheTTL,
+			Argument:  templateCacheTTL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "How long a cached template is used before it is revalidated (e.g. 10m), requires --templateCacheDir",
+			Value:     &config.TemplateCacheTTL,
+		},
+		{
+			Argument:  templateHeader,
+			Shorthand: "",
+			Default:   []string{},
+			Secret:    true,
+			Usage:     "A header of the form \"Name: value\" to send when fetching templates from https URLs (may be given multiple times)",
+			Value:     &config.TemplateHeaders,
+		},
+		{
+			Env:       "TEMPLATE_TOKEN",
+			Argument:  templateToken,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "A bearer token to send when fetching templates from https URLs, if not in env TEMPLATE_TOKEN",
+			Value:     &config.TemplateToken,
+		},
+		{
+			Argument:  templateUsername,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The username for basic auth when fetching templates from https URLs",
+			Value:     &config.TemplateUsername,
+		},
+		{
+			Env:       "TEMPLATE_PASSWORD",
+			Argument:  templatePassword,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The password for basic auth when fetching templates from https URLs, if not in env TEMPLATE_PASSWORD",
+			Value:     &config.TemplatePassword,
+		},
+		{
+			Path:      subjectTemplate,
+			Argument:  subjectTemplate,
+			Shorthand: "S",
+			Default:   defaultSubjectTemplate,
+			Usage:     "A template to use for the subject",
+			Value:     &config.SubjectTemplate,
+		},
+		{
+			Path:      subjectTemplateFile,
+			Argument:  subjectTemplateFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A template file to use for the subject, instead of --subjectTemplate (-S)",
+			Value:     &config.SubjectTemplateFile,
+		},
+		{
+			Path:      resolvedBodyTemplateFile,
+			Argument:  resolvedBodyTemplateFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A template file to use for the body of resolution emails, defaults to the body template",
+			Value:     &config.ResolvedBodyTemplateFile,
+		},
+		{
+			Path:      resolvedSubjectTemplate,
+			Argument:  resolvedSubjectTemplate,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A template to use for the subject of resolution emails, defaults to the subject template",
+			Value:     &config.ResolvedSubjectTemplate,
+		},
+		{
+			Path:      keepaliveBodyTemplateFile,
+			Argument:  keepaliveBodyTemplateFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A template file to use for the body of keepalive emails, instead of the built-in keepalive template",
+			Value:     &config.KeepaliveBodyTemplateFile,
+		},
+		{
+			Path:      keepaliveSubjectTemplate,
+			Argument:  keepaliveSubjectTemplate,
+			Shorthand: "",
+			Default:   defaultKeepaliveSubjectTemplate,
+			Usage:     "A template to use for the subject of keepalive emails",
+			Value:     &config.KeepaliveSubjectTemplate,
+		},
+		{
+			Path:      bodyFormat,
+			Argument:  bodyFormat,
+			Shorthand: "",
+			Default:   BodyFormatTemplate,
+			Usage:     "The format of the body template, one of 'template', 'markdown' (rendered to HTML with a plain text alternative) or 'html' (always HTML, escaping event values)",
+			Value:     &config.BodyFormat,
+		},
+		{
+			Path:      contentType,
+			Argument:  contentType,
+			Shorthand: "",
+			Default:   ContentAuto,
+			Usage:     "The content type of the body template, one of 'text/plain', 'text/html' or 'auto' (HTML if the template contains an <html> tag)",
+			Value:     &config.ContentType,
+		},
+		{
+			Path:      maxBodySize,
+			Argument:  maxBodySize,
+			Shorthand: "",
+			Default:   int64(0),
+			Usage:     "The maximum size in bytes of the body, larger bodies are truncated and attached in full (0 for no limit)",
+			Value:     &config.MaxBodySize,
+		},
+		{
+			Path:      attachEventJSON,
+			Argument:  attachEventJSON,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Attach the event (or the events of a digest) to the email as JSON",
+			Value:     &config.AttachEventJSON,
+		},
+		{
+			Path:      bodyTemplateName,
+			Argument:  bodyTemplateName,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The name of a built-in template to use for the body, one of 'classic', 'table', or 'compact-html'",
+			Value:     &config.BodyTemplateName,
+		},
+		{
+			Path:      includeSystemInfo,
+			Argument:  includeSystemInfo,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Include the entity's system facts (OS, platform, architecture and network addresses) in the default and built-in templates",
+			Value:     &config.IncludeSystemInfo,
+		},
+		{
+			Path:      chartImageURL,
+			Argument:  chartImageURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A URL (which may use template values) of an image to embed in HTML emails that reference {{ChartImage}}, e.g. a Grafana render URL",
+			Value:     &config.ChartImageURL,
+		},
+		{
+			Path:      dashboardURL,
+			Argument:  dashboardURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The base URL of the Sensu web UI (e.g. https://sensu.example.com:3000), used for the .DashboardLink template field",
+			Value:     &config.DashboardURL,
+		},
+		{
+			Path:      silenceURL,
+			Argument:  silenceURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A URL template to silence the check for --silenceDuration, e.g. a Sensu web UI deep link, used for the .SilenceLink template field",
+			Value:     &config.SilenceURL,
+		},
+		{
+			Path:      silenceDuration,
+			Argument:  silenceDuration,
+			Shorthand: "",
+			Default:   "2h",
+			Usage:     "How long the --silenceURL link silences the check for",
+			Value:     &config.SilenceDuration,
+		},
+		{
+			Argument:  sensuAPIURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The URL of the Sensu backend API (e.g. http://sensu.example.com:8080), used to list other active alerts on the entity in the .RelatedEvents template field",
+			Value:     &config.SensuAPIURL,
+		},
+		{
+			Env:       "SENSU_API_KEY",
+			Argument:  sensuAPIKey,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The Sensu API key, if not in env SENSU_API_KEY",
+			Value:     &config.SensuAPIKey,
+		},
+		{
+			Path:      silenced,
+			Argument:  silenced,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Query the Sensu API for silences matching the event for the .Silenced template field (template), also noting them in the email (note) or not sending the email (suppress)",
+			Value:     &config.Silenced,
+		},
+		{
+			Path:      annotateEvent,
+			Argument:  annotateEvent,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Annotate the event with the outcome and message ID of the email via the Sensu API",
+			Value:     &config.AnnotateEvent,
+		},
+		{
+			Path:      eventFile,
+			Argument:  eventFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A JSON file containing the event to use with the validate and test commands instead of a sample event",
+			Value:     &config.EventFile,
+		},
+		{
+			Path:      checkConnection,
+			Argument:  checkConnection,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Connect and authenticate to the SMTP server without reading an event or sending an email, exiting 2 on failure (for use as a Sensu check)",
+			Value:     &config.CheckConnection,
+		},
+		{
+			Path:      flushSpool,
+			Argument:  flushSpool,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Retry the emails in --spoolDir without reading an event, exiting 1 if any remain (for use as a Sensu check or cron job)",
+			Value:     &config.FlushSpool,
+		},
+		{
+			Argument:  spoolDir,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A directory to spool emails to when the SMTP server can't be reached, to be retried with --flushSpool",
+			Value:     &config.SpoolDir,
+		},
+		{
+			Argument:  spoolMaxAge,
+			Shorthand: "",
+			Default:   "24h",
+			Usage:     "How long --flushSpool retries a spooled email before dropping it (e.g. 24h), 0 to retry it until it is sent",
+			Value:     &config.SpoolMaxAge,
+		},
+		{
+			Path:      smtpRetries,
+			Argument:  smtpRetries,
+			Shorthand: "",
+			Default:   0,
+			Usage:     "The number of times to retry sending an email after a temporary failure, waiting 1s before the first retry and twice as long before each one after it",
+			Value:     &config.SmtpRetries,
+		},
+		{
+			Env:       "FALLBACK_WEBHOOK_URL",
+			Argument:  fallbackWebhookURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A webhook (e.g. a Slack incoming webhook) to POST the subject, body and event of an email to when it could not be sent, after any retries, and was not spooled",
+			Value:     &config.FallbackWebhookURL,
+		},
+		{
+			Path:      listen,
+			Argument:  listen,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Run persistently, handling the events received on this address (tcp://host:port, udp://host:port, unix:///path, or - for events on stdin) and reusing SMTP connections",
+			Value:     &config.Listen,
+		},
+		{
+			Path:      verbose,
+			Argument:  verbose,
+			Shorthand: "v",
+			Default:   false,
+			Usage:     "Print each step of composing and sending the email, including the SMTP conversation (with credentials redacted)",
+			Value:     &config.Verbose,
+		},
+		{
+			Argument:  smtpTraceFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A file to append each SMTP conversation to, with credentials redacted and the message data omitted",
+			Value:     &config.SmtpTraceFile,
+		},
+		{
+			Argument:  configFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A YAML or JSON file of option values, keyed by option name, which environment variables, flags and annotations override, that may also hold the routingRules, contacts and addressBook",
+			Value:     &config.ConfigFile,
+		},
+		{
+			Argument:  profile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The profile of --configFile to use, whose options take the place of those given for all profiles",
+			Value:     &config.Profile,
+		},
+		{
+			Argument:  captureDir,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Write the emails to .eml files in this directory instead of sending them, or in capture mode those received by the local SMTP server",
+			Value:     &config.CaptureDir,
+		},
+		{
+			Argument:  captureAddress,
+			Shorthand: "",
+			Default:   defaultCaptureAddress,
+			Usage:     "The address the local SMTP server of capture mode listens on",
+			Value:     &config.CaptureAddress,
+		},
+		{
+			Env:       "REDIS_URL",
+			Argument:  redisURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Publish the emails to a Redis list or stream at this URL (redis://[[user]:password@]host[:port][/db], or rediss:// for TLS) for an external drainer to send, instead of sending them",
+			Value:     &config.RedisURL,
+		},
+		{
+			Path:      redisKey,
+			Argument:  redisKey,
+			Shorthand: "",
+			Default:   defaultRedisKey,
+			Usage:     "The Redis list or stream --redisURL publishes the emails to",
+			Value:     &config.RedisKey,
+		},
+		{
+			Path:      redisStream,
+			Argument:  redisStream,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Publish the emails to a Redis stream with XADD, rather than a list with RPUSH",
+			Value:     &config.RedisStream,
+		},
+		{
+			Argument:  statsdAddress,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The host:port of a statsd server to send delivery metrics to over UDP",
+			Value:     &config.StatsdAddress,
+		},
+		{
+			Argument:  pushgatewayURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The URL of a Prometheus pushgateway to push the delivery metrics of each run to",
+			Value:     &config.PushgatewayURL,
+		},
+		{
+			Argument:  metricsFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A file to keep delivery metric totals in, in the Prometheus text format",
+			Value:     &config.MetricsFile,
+		},
+		{
+			Path:      jsonResult,
+			Argument:  jsonResult,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Print a JSON line with the result of each email sent (recipients, subject, message ID, SMTP response code, duration and any error)",
+			Value:     &config.JSONResult,
+		},
+		{
+			Path:      failOn,
+			Argument:  failOn,
+			Shorthand: "",
+			Default:   FailOnAny,
+			Usage:     "Fail when the email can't be sent to any of the recipients (any), exiting with status 2 when it was sent to others, or only when it can't be sent to all of them (all)",
+			Value:     &config.FailOn,
+		},
+		{
+			Path:      minOccurrences,
+			Argument:  minOccurrences,
+			Shorthand: "",
+			Default:   int64(0),
+			Usage:     "Do not send an email until the event has occurred this many times",
+			Value:     &config.MinOccurrences,
+		},
+		{
+			Path:      backoffOccurrences,
+			Argument:  backoffOccurrences,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Once --minOccurrences is reached, only send emails on an exponential schedule (1st, 2nd, 4th, 8th... occurrence after it)",
+			Value:     &config.BackoffOccurrences,
+		},
+		{
+			Path:      digestSubjectTemplate,
+			Argument:  digestSubjectTemplate,
+			Shorthand: "",
+			Default:   defaultDigestSubjectTemplate,
+			Usage:     "A template to use for the subject of digest emails (sent when a JSON array of events is provided)",
+			Value:     &config.DigestSubjectTemplate,
+		},
+		{
+			Path:      digestBodyTemplateFile,
+			Argument:  digestBodyTemplateFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A template file to use for the body of digest emails (sent when a JSON array of events is provided)",
+			Value:     &config.DigestBodyTemplateFile,
+		},
+		{
+			Argument:  stateDir,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A directory in which to record the emails sent for each entity/check",
+			Value:     &config.StateDir,
+		},
+		{
+			Path:      dedupWindow,
+			Argument:  dedupWindow,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Suppress an email if one for the same entity/check and status was sent within this duration (e.g. 30m), requires --stateDir",
+			Value:     &config.DedupWindow,
+		},
+		{
+			Path:      maxEmailsPerHour,
+			Argument:  maxEmailsPerHour,
+			Shorthand: "",
+			Default:   int64(0),
+			Usage:     "The maximum number of emails sent to each recipient per hour, further emails are dropped and summarized once allowed again (requires --stateDir)",
+			Value:     &config.MaxEmailsPerHour,
+		},
+		{
+			Path:      resolveOnlyAfterAlert,
+			Argument:  resolveOnlyAfterAlert,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Only send a resolution email if an email for a non-OK status was sent for the entity/check, requires --stateDir",
+			Value:     &config.ResolveOnlyAfterAlert,
+		},
+		{
+			Path:      onStateChangeOnly,
+			Argument:  onStateChangeOnly,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Only send an email when the check's status changes, going by the status last emailed with --stateDir or else the check history",
+			Value:     &config.OnStateChangeOnly,
+		},
+		{
+			Path:      suppressFlapping,
+			Argument:  suppressFlapping,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Send a single email when the check starts flapping, going by its check history, and none while it keeps flapping",
+			Value:     &config.SuppressFlapping,
+		},
+		{
+			Path:      lowFlapThreshold,
+			Argument:  lowFlapThreshold,
+			Shorthand: "",
+			Default:   int64(5),
+			Usage:     "The percentage of state change below which a flapping check is stable again, with --suppressFlapping",
+			Value:     &config.LowFlapThreshold,
+		},
+		{
+			Path:      highFlapThreshold,
+			Argument:  highFlapThreshold,
+			Shorthand: "",
+			Default:   int64(20),
+			Usage:     "The percentage of state change at which a check starts flapping, with --suppressFlapping",
+			Value:     &config.HighFlapThreshold,
+		},
+		{
+			Path:      flappingSubjectTemplate,
+			Argument:  flappingSubjectTemplate,
+			Shorthand: "",
+			Default:   defaultFlappingSubjectTemplate,
+			Usage:     "A template to use for the subject of the email sent when a check starts flapping, with --suppressFlapping",
+			Value:     &config.FlappingSubjectTemplate,
+		},
+		{
+			Path:      proxyGroupLabel,
+			Argument:  proxyGroupLabel,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A label naming the parent (e.g. the poller) of proxy entities, whose events are held for --proxyGroupWindow and sent as one email per parent (requires --stateDir)",
+			Value:     &config.ProxyGroupLabel,
+		},
+		{
+			Path:      proxyGroupWindow,
+			Argument:  proxyGroupWindow,
+			Shorthand: "",
+			Default:   "1m",
+			Usage:     "How long the events of proxy entities with the same --proxyGroupLabel are collected before being sent",
+			Value:     &config.ProxyGroupWindow,
+		},
+		{
+			Path:      filterExpression,
+			Argument:  filterExpression,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Only send emails for events matching the expression, e.g. \"event.Check.Occurrences >= 3 && event.Entity.Labels.env == 'prod'\"",
+			Value:     &config.FilterExpression,
+		},
+		{
+			Path:      dateFormat,
+			Argument:  dateFormat,
+			Shorthand: "d",
+			Default:   defaultDateFormat,
+			Usage:     "The layout used when printing timestamps from the UnixTime template function",
+			Value:     &config.DateFormat,
+		},
+		{
+			Path:      timezone,
+			Argument:  timezone,
+			Shorthand: "z",
+			Default:   "",
+			Usage:     "The IANA timezone (e.g. America/New_York) timestamps in templates are rendered in, defaults to the local timezone",
+			Value:     &config.Timezone,
+		},
+		{
+			Path:      charset,
+			Argument:  charset,
+			Shorthand: "c",
+			Default:   defaultCharset,
+			Usage:     "The character set used for the email body",
+			Value:     &config.Charset,
+		},
+		{
+			Path:      extraHeader,
+			Argument:  extraHeader,
+			Shorthand: "e",
+			Default:   []string{},
+			Usage:     "An additional header to add to the email, e.g. \"X-Team: platform\" (value may be a template, accepts multiple flags)",
+			Value:     &config.ExtraHeaders,
+		},
+		{
+			Path:      priorityHeaders,
+			Argument:  priorityHeaders,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Set the X-Priority and Importance headers based on the event status",
+			Value:     &config.PriorityHeaders,
+		},
+		{
+			Path:      precedenceBulk,
+			Argument:  precedenceBulk,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Set the Precedence: bulk header, so auto-responders and mailing list software don't reply to the email",
+			Value:     &config.PrecedenceBulk,
+		},
+		{
+			Path:      threading,
+			Argument:  threading,
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Set In-Reply-To and References headers so mail clients thread all emails for an entity/check together (requires --stateDir)",
+			Value:     &config.Threading,
+		},
 		{
-			Path:      smtpHost,
-			Argument:  smtpHost,
-			Shorthand: "s",
+			Path:      unsubscribeURL,
+			Argument:  unsubscribeURL,
+			Shorthand: "",
 			Default:   "",
-			Usage:     "The SMTP host to use to send to send email",
-			Value:     &config.SmtpHost,
+			Usage:     "A URL template, e.g. a Sensu silencing page for the entity/check, set as the List-Unsubscribe header and linked in a footer",
+			Value:     &config.UnsubscribeURL,
 		},
 		{
-			Path:      smtpUsername,
-			Env:       "SMTP_USERNAME",
-			Argument:  smtpUsername,
-			Shorthand: "u",
+			Path:      messageIDDomain,
+			Argument:  messageIDDomain,
+			Shorthand: "",
 			Default:   "",
-			Usage:     "The SMTP username, if not in env SMTP_USERNAME",
-			Value:     &config.SmtpUsername,
+			Usage:     "The domain of generated Message-IDs, defaults to the domain of the 'from' email address",
+			Value:     &config.MessageIDDomain,
 		},
 		{
-			Path:      smtpPassword,
-			Env:       "SMTP_PASSWORD",
-			Argument:  smtpPassword,
-			Shorthand: "p",
+			Path:      dkimPrivateKeyFile,
+			Argument:  dkimPrivateKeyFile,
+			Shorthand: "",
 			Default:   "",
-			Usage:     "The SMTP password, if not in env SMTP_PASSWORD",
-			Value:     &config.SmtpPassword,
+			Usage:     "A PEM encoded RSA or Ed25519 private key file used to DKIM sign the email",
+			Value:     &config.DKIMPrivateKeyFile,
 		},
 		{
-			Path:      smtpPort,
-			Argument:  smtpPort,
-			Shorthand: "P",
-			Default:   uint64(defaultSmtpPort),
-			Usage:     "The SMTP server port",
-			Value:     &config.SmtpPort,
+			Path:      dkimDomain,
+			Argument:  dkimDomain,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The DKIM signing domain, defaults to the domain of the 'from' email address",
+			Value:     &config.DKIMDomain,
 		},
 		{
-			Path:      toEmail,
-			Argument:  toEmail,
-			Shorthand: "t",
+			Path:      dkimSelector,
+			Argument:  dkimSelector,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The DKIM selector",
+			Value:     &config.DKIMSelector,
+		},
+		{
+			Path:      smimeCertFile,
+			Argument:  smimeCertFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "A PEM encoded certificate (and optional intermediates) used to S/MIME sign the email",
+			Value:     &config.SMIMECertFile,
+		},
+		{
+			Path:      smimeKeyFile,
+			Argument:  smimeKeyFile,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The PEM encoded private key for the S/MIME certificate",
+			Value:     &config.SMIMEKeyFile,
+		},
+		{
+			Path:      pgpPublicKeyFile,
+			Argument:  pgpPublicKeyFile,
+			Shorthand: "",
 			Default:   []string{},
-			Usage:     "The 'to' email address (accepts comma delimited and/or multiple flags)",
-			Value:     &config.ToEmail,
+			Usage:     "An ASCII armored PGP public key file to encrypt the email to (accepts multiple flags)",
+			Value:     &config.PGPPublicKeyFiles,
 		},
 		{
-			Path:      fromEmail,
-			Argument:  fromEmail,
-			Shorthand: "f",
+			Argument:  pgpKeyserver,
+			Shorthand: "",
 			Default:   "",
-			Usage:     "The 'from' email address",
-			Value:     &config.FromEmail,
+			Usage:     "An HKP keyserver URL (e.g. https://keys.openpgp.org) used to look up the PGP public key of each recipient to encrypt the email to",
+			Value:     &config.PGPKeyserver,
 		},
 		{
-			Path:      tlsSkipVerify,
-			Argument:  tlsSkipVerify,
-			Shorthand: "k",
-			Default:   false,
-			Usage:     "Do not verify TLS certificates",
-			Value:     &config.TLSSkipVerify,
+			Argument:  ldapURL,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The ldap:// or ldaps:// URL of the LDAP server to look up ldap: recipients in",
+			Value:     &config.LDAPURL,
 		},
 		{
-			Path:      authMethod,
-			Argument:  authMethod,
-			Shorthand: "a",
-			Default:   AuthMethodPlain,
-			Usage:     "The SMTP authentication method, one of 'none', 'plain', or 'login'",
-			Value:     &config.AuthMethod,
+			Argument:  ldapBindDN,
+			Shorthand: "",
+			Default:   "",
+			Usage:     "The DN to bind to the LDAP server as, binding anonymously if not set",
+			Value:     &config.LDAPBindDN,
 		},
 		{
-			Path:      hookout,
-			Argument:  hookout,
-			Shorthand: "H",
-			Default:   false,
-			Usage:     "Include output from check hook(s)",
-			Value:     &config.Hookout,
+			Path:      ldapBindPassword,
+			Env:       "LDAP_BIND_PASSWORD",
+			Argument:  ldapBindPassword,
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "The password of --ldapBindDN, if not in env LDAP_BIND_PASSWORD",
+			Value:     &config.LDAPBindPassword,
 		},
 		{
-			Path:      bodyTemplateFile,
-			Argument:  bodyTemplateFile,
-			Shorthand: "T",
+			Argument:  ldapBaseDN,
+			Shorthand: "",
 			Default:   "",
-			Usage:     "A template file to use for the body",
-			Value:     &config.BodyTemplateFile,
+			Usage:     "The DN to search for entity owners and group members under (e.g. dc=example,dc=com)",
+			Value:     &config.LDAPBaseDN,
 		},
 		{
-			Path:      subjectTemplate,
-			Argument:  subjectTemplate,
-			Shorthand: "S",
-			Default:   "Sensu Alert - {{.Entity.Name}}/{{.Check.Name}}: {{.Check.State}}",
-			Usage:     "A template to use for the subject",
-			Value:     &config.SubjectTemplate,
+			Path:      ldapOwnerLabel,
+			Argument:  ldapOwnerLabel,
+			Shorthand: "",
+			Default:   "owner",
+			Usage:     "The entity label with the owner the ldap:owner recipient is looked up by",
+			Value:     &config.LDAPOwnerLabel,
+		},
+		{
+			Path:      ldapOwnerFilter,
+			Argument:  ldapOwnerFilter,
+			Shorthand: "",
+			Default:   "(|(uid=%s)(sAMAccountName=%s)(cn=%s))",
+			Usage:     "The LDAP filter finding the user or group of the entity owner, with %s replaced by the owner",
+			Value:     &config.LDAPOwnerFilter,
+		},
+		{
+			Path:      ldapMailAttribute,
+			Argument:  ldapMailAttribute,
+			Shorthand: "",
+			Default:   "mail",
+			Usage:     "The LDAP attribute with the email address of users and groups",
+			Value:     &config.LDAPMailAttribute,
 		},
 
 		// deprecated options
@@ -207,185 +1665,824 @@ var (
 )
 
 func main() {
+	var err error
+	if parseMode() {
+		// with --listen - the events, and with init the answers, are read
+		// from the original stdin
+		originalStdin = os.Stdin
+		eventJSON = []byte(sampleEvent)
+		err = replaceStdin(eventJSON)
+	} else {
+		err = prepareStdin()
+	}
+	// the config file supplies the defaults of the flags, so is read before
+	// they are parsed
+	if err == nil {
+		err = loadCommandLineConfigFile(os.Args[1:])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing %s: %v\n", config.Name, err)
+		os.Exit(1)
+	}
 	goHandler := sensu.NewGoHandler(&config.PluginConfig, emailConfigOptions, checkArgs, sendEmail)
 	goHandler.Execute()
 }
 
-func checkArgs(_ *corev2.Event) error {
-	if len(config.SmtpHost) == 0 {
-		return errors.New("missing smtp host")
+func sendEmail(event *corev2.Event) error {
+	// a partial failure exits with its own status once the SMTP connection
+	// is closed and the delivery metrics are emitted
+	exitStatus := 0
+	defer func() {
+		if exitStatus != 0 {
+			os.Exit(exitStatus)
+		}
+	}()
+	// every email sent in this run, e.g. queued digests, rate limit
+	// summaries or spooled emails, shares the SMTP connection
+	defer poolSMTP()()
+	defer emitDeliveryMetrics()
+	scrubEvents(event)
+	switch mode {
+	case modeValidate:
+		return validateTemplates(event)
+	case modeTest:
+		return sendTestEmail(event, os.Stdout)
+	case modeInit:
+		return runInit(originalStdin, os.Stdout, os.Stderr, event)
+	case modeCheckConnection:
+		if err := checkSMTPConnection(); err != nil {
+			fmt.Printf("CRITICAL: %v\n", err)
+			os.Exit(2)
+		}
+		return nil
+	case modeFlushSpool:
+		flushed, remaining, err := flushSpoolDir()
+		if err != nil {
+			fmt.Printf("CRITICAL: %v\n", err)
+			os.Exit(2)
+		}
+		if remaining > 0 {
+			fmt.Printf("WARNING: flushed %d spooled emails, %d remain in %s\n", flushed, remaining, config.SpoolDir)
+			os.Exit(1)
+		}
+		fmt.Printf("OK: flushed %d spooled emails\n", flushed)
+		return nil
+	case modeDaemon:
+		return serveEvents()
+	case modeCapture:
+		return serveCapture()
+	}
+	status, err := handleEventResults(event)
+	if status > 1 {
+		fmt.Fprintf(os.Stderr, "Error executing %s: %v\n", config.Name, err)
+		exitStatus = status
+		return nil
+	}
+	return err
+}
+
+// handleEventResults handles the event, printing the outcome for each
+// recipient, and returns the exit status and error of handling it.
+func handleEventResults(event *corev2.Event) (int, error) {
+	recipientResults = nil
+	err := handleEvent(event)
+	printRecipientResults()
+	return deliveryExitStatus(recipientResults, err)
+}
+
+// scrubEvents strips ANSI escape codes from and redacts the event and any
+// digest events.
+func scrubEvents(event *corev2.Event) {
+	if config.StripANSI {
+		stripEventANSI(event)
+		for _, e := range digestEvents {
+			stripEventANSI(e)
+		}
+	}
+	redactEvent(event)
+	for _, e := range digestEvents {
+		redactEvent(e)
+	}
+}
+
+// handleEvent sends the email for the event, or the digest of the digest
+// events, unless it is suppressed.
+func handleEvent(event *corev2.Event) error {
+	if err := flushQueuedEvents(time.Now(), append(digestEvents, event)...); err != nil {
+		return err
+	}
+	if len(digestEvents) > 0 {
+		events, filterErr := filterEvents(digestEvents)
+		if filterErr != nil {
+			return filterErr
+		}
+		if len(events) == 0 {
+			fmt.Printf("Not sending digest: no events match --%s\n", filterExpression)
+			return nil
+		}
+		digestEvents = events
+		return sendDigest(digestEvents)
+	}
+	matched, filterErr := matchesFilter(event)
+	if filterErr != nil {
+		return filterErr
 	}
-	if config.SmtpPort > math.MaxUint16 {
-		return errors.New("smtp port is out of range")
+	if !matched {
+		fmt.Printf("Not sending email for %s/%s: it does not match --%s\n", event.Entity.Name, eventCheck(event).Name, filterExpression)
+		return nil
 	}
-	if len(config.ToEmail) == 0 {
-		return errors.New("missing destination email address")
+	grouped, groupErr := holdProxyEvent(event, time.Now())
+	if groupErr != nil {
+		return groupErr
 	}
-	if len(config.FromEmail) == 0 {
-		return errors.New("from email is empty")
+	if err := flushProxyGroups(time.Now()); err != nil || grouped {
+		return err
 	}
 
-	// translate deprecated options to replacements
-	if config.LoginAuth {
-		config.AuthMethod = AuthMethodLogin
+	reason, suppressErr := suppressReason(event)
+	if suppressErr != nil {
+		return suppressErr
 	}
-	if config.Insecure {
-		config.SmtpPort = 25
-		config.AuthMethod = AuthMethodNone
-		config.TLSSkipVerify = true
+	if len(reason) > 0 {
+		fmt.Printf("Not sending email for %s/%s: %s\n", event.Entity.Name, eventCheck(event).Name, reason)
+		return nil
 	}
 
-	switch config.AuthMethod {
-	case AuthMethodPlain, AuthMethodNone, AuthMethodLogin:
-	case "":
-		config.AuthMethod = AuthMethodPlain
-	default:
-		return fmt.Errorf("%s is not a valid auth method", config.AuthMethod)
+	subject, body, contentType, composeErr := composeEmail(event)
+	if composeErr != nil {
+		return composeErr
 	}
-	if config.AuthMethod != AuthMethodNone {
-		if len(config.SmtpUsername) == 0 {
-			return errors.New("smtp username is empty")
-		}
-		if len(config.SmtpPassword) == 0 {
-			return errors.New("smtp password is empty")
+
+	sent, sendErr := sendRateLimited(event, subject, body, contentType)
+	if sendErr != nil || !sent {
+		return sendErr
+	}
+	return recordSent(event)
+}
+
+// deliverEmail composes the message from the resolved subject and body and
+// sends it to the to, cc and bcc recipients, or a copy to each of them with
+// --sendIndividually. The event is used to resolve any additional headers.
+// The copies share the Message-ID of the email, which with --threading the
+// next email for the entity/check replies to.
+func deliverEmail(event *corev2.Event, to, cc, bcc rcpts, subject, body, contentType string) error {
+	messageID := newMessageID()
+	sent, err := deliverCopies(event, messageID, to, cc, bcc, subject, body, contentType)
+	// a spooled email will be sent by --flushSpool, so is replied to as well
+	if sent && config.Threading && len(digestEvents) == 0 && mode != modeTest {
+		if threadErr := recordThread(event, messageID); threadErr != nil {
+			fmt.Printf("Failed to record the email for threading: %v\n", threadErr)
 		}
 	}
+	return err
+}
+
+// deliverNotice sends an email about the handler itself, such as a summary
+// of the emails suppressed by rate limiting, to the recipient. It is not part
+// of the thread of the emails for the event.
+func deliverNotice(event *corev2.Event, recipient, subject, body string) error {
+	_, err := deliverCopies(event, newMessageID(), rcpts{recipient}, nil, nil, subject, body, ContentPlain)
+	return err
+}
 
-	if config.Hookout && len(config.BodyTemplateFile) > 0 {
-		return errors.New("--hookout (-H) and --bodyTemplateFile (-T) are mutually exclusive")
+// deliverCopies sends the message with the Message-ID to the to, cc and bcc
+// recipients, or a copy to each of them with --sendIndividually, reporting
+// whether it was sent to, or spooled for, all of them or any copy.
+func deliverCopies(event *corev2.Event, messageID string, to, cc, bcc rcpts, subject, body, contentType string) (bool, error) {
+	if !config.SendIndividually {
+		err := deliverMessage(event, messageID, to, cc, bcc, subject, body, contentType)
+		return err == nil, err
 	}
-	if config.Hookout {
-		emailBodyTemplate = "{{.Check.Output}}\n{{range .Check.Hooks}}Hook Name:  {{.Name}}\nHook Command:  {{.Command}}\n\n{{.Output}}\n\n{{end}}"
-	} else if len(config.BodyTemplateFile) > 0 {
-		templateBytes, fileErr := ioutil.ReadFile(config.BodyTemplateFile)
-		if fileErr != nil {
-			return fmt.Errorf("failed to read specified template file %s", config.BodyTemplateFile)
+	// a failure to send one copy doesn't stop the others
+	recipients := envelopeRcpts(to, cc, bcc)
+	var failed int
+	var firstErr error
+	for _, r := range recipients {
+		if err := deliverMessage(event, messageID, rcpts{r}, nil, nil, subject, body, contentType); err != nil {
+			fmt.Printf("Failed to send email to %s: %v\n", r, err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
-		emailBodyTemplate = string(templateBytes)
 	}
+	if firstErr != nil {
+		return failed < len(recipients), fmt.Errorf("failed to send email to %d of %d recipients: %v", failed, len(recipients), firstErr)
+	}
+	return true, nil
+}
 
-	fromAddr, addrErr := mail.ParseAddress(config.FromEmail)
-	if addrErr != nil {
-		return addrErr
+// deliverMessage composes and sends a single message with the Message-ID to
+// the to, cc and bcc recipients. A spooled message counts as sent.
+func deliverMessage(event *corev2.Event, messageID string, to, cc, bcc rcpts, subject, body, contentType string) error {
+	start := time.Now()
+	err := composeAndTransmit(event, messageID, to, cc, bcc, subject, body, contentType)
+	if config.JSONResult {
+		printResult(event, envelopeRcpts(to, cc, bcc), subject, messageID, start, err)
 	}
-	config.FromEmail = fromAddr.Address
-	config.FromHeader = fromAddr.String()
-	return nil
+	recordRecipientResults(envelopeRcpts(to, cc, bcc), err)
+	// test emails are for the sample event or --eventFile
+	if config.AnnotateEvent && mode != modeTest {
+		if annotateErr := annotateDelivery(event, messageID, err); annotateErr != nil {
+			fmt.Printf("Failed to annotate the event with the delivery result: %v\n", annotateErr)
+		}
+	}
+	var spooled *spooledError
+	if errors.As(err, &spooled) {
+		return nil
+	}
+	if err != nil && len(config.FallbackWebhookURL) > 0 {
+		postFallbackAlert(newFallbackAlert(event, failedRecipients(envelopeRcpts(to, cc, bcc), err), subject, body, contentType, err))
+	}
+	return err
 }
 
-func sendEmail(event *corev2.Event) error {
-	var contentType string
+// messageSender, if not nil, replaces the sender selected by the
+// configuration, e.g. with a *transport.Memory in tests.
+var messageSender transport.Sender
 
-	smtpAddress := fmt.Sprintf("%s:%d", config.SmtpHost, config.SmtpPort)
-	subject, subjectErr := resolveTemplate(config.SubjectTemplate, event, ContentPlain)
-	if subjectErr != nil {
-		return subjectErr
+// transmit sends the composed message to the recipients.
+func transmit(envelopeID string, recipients rcpts, msg []byte) error {
+	sender := messageSender
+	if sender == nil {
+		sender = configuredSender()
 	}
+	return sender.Send(transport.Envelope{ID: envelopeID, From: envelopeSender(), To: recipients}, msg)
+}
 
-	if strings.Contains(emailBodyTemplate, "<html") {
-		contentType = ContentHTML
-	} else {
-		contentType = ContentPlain
+// configuredSender returns the sender writing the messages to --captureDir,
+// publishing them to the Redis queue of --redisURL, delivering them to the MX
+// hosts of the recipient domains with --directDelivery, or sending them via
+// the SMTP server.
+func configuredSender() transport.Sender {
+	switch {
+	case len(config.CaptureDir) > 0:
+		return transport.Dir(config.CaptureDir)
+	case redisQueue != nil:
+		return redisQueue
+	case config.DirectDelivery:
+		return transport.SenderFunc(transmitDirect)
+	default:
+		return transport.SenderFunc(transmitSMTP)
 	}
+}
 
-	body, bodyErr := resolveTemplate(emailBodyTemplate, event, contentType)
-	if bodyErr != nil {
-		return bodyErr
+// envelopeSender returns the address bounces are sent to, --envelopeFrom or
+// else the From address.
+func envelopeSender() string {
+	if len(config.EnvelopeFrom) > 0 {
+		return config.EnvelopeFrom
 	}
+	return config.FromEmail
+}
 
-	recipients := newRcpts(config.ToEmail)
+// transmitSMTP sends the message via the SMTP server.
+func transmitSMTP(envelope transport.Envelope, msg []byte) error {
+	conn, err := openSMTP()
+	if err != nil {
+		return err
+	}
+	// the session is still usable after recipients were rejected
+	sendErr := sendMessage(conn, envelope, msg)
+	var invalid *smtpsend.InvalidRecipientsError
+	if sendErr != nil && !errors.As(sendErr, &invalid) {
+		conn.Close()
+		return sendErr
+	}
+	if err := releaseSMTP(conn); err != nil {
+		return err
+	}
+	return sendErr
+}
 
-	t := time.Now()
+// sendMessage sends the message over the connection. With --verifyRecipients
+// it is sent to the recipients the server accepts, returning an
+// *smtpsend.InvalidRecipientsError for any others.
+func sendMessage(conn *smtp.Client, envelope transport.Envelope, msg []byte) error {
+	return smtpsend.Send(conn, envelope.From, envelope.To, msg, smtpsend.Options{
+		EnvelopeID:       envelope.ID,
+		DSNNotify:        config.DSNNotify,
+		DSNReturn:        config.DSNReturn,
+		VerifyRecipients: config.VerifyRecipients,
+		Logf:             debugf,
+	})
+}
 
-	msg := []byte("From: " + config.FromHeader + "\r\n" +
-		"To: " + recipients.String() + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"Date: " + t.Format(time.RFC1123Z) + "\r\n" +
-		"Content-Type: " + contentType + "\r\n" +
-		"\r\n" +
-		body + "\r\n")
+// readCredentialFile returns the credential in the file, with any trailing
+// newline removed. The credential must not also have been given directly.
+func readCredentialFile(file, given, flag string) (string, error) {
+	if len(given) > 0 {
+		return "", fmt.Errorf("--%s and --%sFile are mutually exclusive", flag, flag)
+	}
+	credential, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	return strings.TrimRight(string(credential), "\r\n"), nil
+}
 
+// dialSMTP connects to the SMTP server, upgrading the connection with
+// STARTTLS and authenticating when the server supports them.
+func dialSMTP() (*smtp.Client, error) {
 	var auth smtp.Auth
 	switch config.AuthMethod {
 	case AuthMethodPlain:
 		auth = smtp.PlainAuth("", config.SmtpUsername, config.SmtpPassword, config.SmtpHost)
 	case AuthMethodLogin:
 		auth = LoginAuth(config.SmtpUsername, config.SmtpPassword)
+	case AuthMethodNTLM:
+		auth = NTLMAuth(config.NTLMDomain, config.SmtpUsername, config.SmtpPassword)
+	case AuthMethodGSSAPI:
+		auth = GSSAPIAuth(config.SmtpHost)
+	case AuthMethodAuto:
+		auth = AutoAuth(config.SmtpUsername, config.SmtpPassword, config.SmtpOAuth2Token, config.SmtpHost)
 	}
+	tlsConfig := smtpTLSConfig(config.SmtpHost, config.TLSSkipVerify)
+	return connectSMTP(config.SmtpHost, config.SmtpPort, tlsConfig, config.RequireTLS, auth)
+}
 
-	conn, err := smtp.Dial(smtpAddress)
+// connectSMTP connects to the SMTP server on the host, upgrading the
+// connection with STARTTLS using the TLS config, which is required with
+// requireTLS, and authenticating with the auth, if not nil, when the server
+// supports them.
+func connectSMTP(host string, port uint64, tlsConfig *tls.Config, requireTLS bool, auth smtp.Auth) (*smtp.Client, error) {
+	smtpAddress := net.JoinHostPort(host, strconv.FormatUint(port, 10))
+	debugf("connecting to %s", smtpAddress)
+	netConn, err := dialSMTPServer(smtpAddress)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if config.Verbose {
+		netConn = &transcriptConn{Conn: netConn}
+	}
+	tracing := len(config.SmtpTraceFile) > 0
+	if tracing {
+		if err := openSMTPTrace(); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		tracef("connecting to %s", smtpAddress)
+		// the greeting is read by NewClient, before the text connection can
+		// be traced
+		netConn = &transcriptConn{Conn: netConn, logf: tracef}
+	}
+	return mailer.Connect(netConn, host, mailer.ConnectOptions{
+		HeloHost:   config.HeloHost,
+		TLSConfig:  tlsConfig,
+		RequireTLS: requireTLS,
+		Auth:       auth,
+		Username:   config.SmtpUsername,
+		AuthMethod: config.AuthMethod,
+		Negotiated: func(conn *smtp.Client, state tls.ConnectionState) {
+			debugf("negotiated %s with cipher suite %s", tlsVersionName(state.Version), tlsCipherSuiteName(state.CipherSuite))
+			if tracing {
+				// StartTLS repeats EHLO before the text connection can be
+				// traced again
+				tracef("negotiated %s with cipher suite %s, server supports %s", tlsVersionName(state.Version),
+					tlsCipherSuiteName(state.CipherSuite), strings.Join(mailer.Extensions(conn), " "))
+				traceText(conn)
+			}
+		},
+		Logf: debugf,
+	})
+}
+
+// suppressReason returns the reason for not sending an email for the event,
+// or an empty string if it should be sent.
+func suppressReason(event *corev2.Event) (string, error) {
+	if reason := suppressAnnotated(event); len(reason) > 0 {
+		return reason, nil
+	}
+	if reason := suppressOccurrences(event); len(reason) > 0 {
+		return reason, nil
+	}
+	if reason, err := suppressMaintenance(event); err != nil || len(reason) > 0 {
+		return reason, err
+	}
+	if reason := suppressWhileFlapping(event); len(reason) > 0 {
+		return reason, nil
+	}
+	if reason := suppressSilenced(event); len(reason) > 0 {
+		return reason, nil
+	}
+	if reason, err := suppressOutsideBusinessHours(event); err != nil || len(reason) > 0 {
+		return reason, err
+	}
+	if reason, err := suppressUnalertedResolution(event); err != nil || len(reason) > 0 {
+		return reason, err
+	}
+	if reason, err := suppressUnchangedStatus(event); err != nil || len(reason) > 0 {
+		return reason, err
+	}
+	return suppressDuplicate(event)
+}
+
+// eventCheck returns the check of the event, or an empty check for metrics
+// events, which have none, so that their check name is empty and their
+// status OK.
+func eventCheck(event *corev2.Event) *corev2.Check {
+	if event.Check == nil {
+		return &corev2.Check{}
 	}
-	defer conn.Close()
+	return event.Check
+}
+
+// escalated reports whether the event has occurred --escalationOccurrences
+// times without being resolved.
+func escalated(event *corev2.Event) bool {
+	check := eventCheck(event)
+	return config.EscalationOccurrences > 0 && check.Status != 0 &&
+		check.Occurrences >= config.EscalationOccurrences
+}
 
-	if ok, _ := conn.Extension("STARTTLS"); ok {
-		tlsConfig := &tls.Config{
-			ServerName:         config.SmtpHost,
-			InsecureSkipVerify: config.TLSSkipVerify,
+// suppressOccurrences returns the reason for not sending an email for the
+// event based on its occurrences, or an empty string if it should be sent.
+// Resolutions, and metrics events, which have no check, are always sent.
+func suppressOccurrences(event *corev2.Event) string {
+	if event.Check == nil || event.Check.Status == 0 {
+		return ""
+	}
+	if config.MinOccurrences == 0 && !config.BackoffOccurrences {
+		return ""
+	}
+	occurrences := event.Check.Occurrences
+	min := config.MinOccurrences
+	if min < 1 {
+		min = 1
+	}
+	if occurrences < min {
+		return fmt.Sprintf("%d occurrences is less than the minimum of %d", occurrences, min)
+	}
+	if config.BackoffOccurrences {
+		// send on the 1st, 2nd, 4th, 8th... occurrence since reaching the minimum
+		n := occurrences - min + 1
+		if n&(n-1) != 0 {
+			return fmt.Sprintf("occurrence %d is not on the exponential backoff schedule", occurrences)
 		}
-		if err := conn.StartTLS(tlsConfig); err != nil {
-			return err
+	}
+	return ""
+}
+
+// selectTemplates returns the subject and body templates to use for the event,
+// preferring the resolved templates (when configured) for resolutions. A
+// keepalive event has its own templates, which cover its resolution too.
+func selectTemplates(event *corev2.Event) (string, string) {
+	if event.Check != nil && event.Check.Name == corev2.KeepaliveCheckName {
+		return keepaliveTemplates()
+	}
+	subject, body := config.SubjectTemplate, emailBodyTemplate
+	if !event.IsResolution() {
+		return subject, body
+	}
+	if len(config.ResolvedSubjectTemplate) > 0 {
+		subject = config.ResolvedSubjectTemplate
+	}
+	if len(resolvedBodyTemplate) > 0 {
+		body = resolvedBodyTemplate
+	}
+	return subject, body
+}
+
+// bodyContentType returns the content type of the body produced by the
+// template, HTML templates being parsed with html/template. Unless the body
+// format is html or the content type is given, templates are only HTML if
+// they contain an <html> tag.
+func bodyContentType(bodyTemplate string) string {
+	switch config.BodyFormat {
+	case BodyFormatHTML:
+		return ContentHTML
+	case BodyFormatMarkdown:
+		return ContentPlain
+	}
+	if config.ContentType == ContentPlain || config.ContentType == ContentHTML {
+		return config.ContentType
+	}
+	if strings.Contains(bodyTemplate, "<html") {
+		return ContentHTML
+	}
+	return ContentPlain
+}
+
+// templateEvent is the data the event templates are executed against, the
+// event along with values computed for it.
+type templateEvent struct {
+	corev2.Event
+	// DashboardLink is the URL of the event in the Sensu web UI, if
+	// --dashboardURL is set
+	DashboardLink string
+	// SilenceLink is the URL to silence the check for SilenceDuration, if
+	// --silenceURL is set
+	SilenceLink     string
+	SilenceDuration string
+	// RelatedEvents are the other non-OK events for the entity, if
+	// --sensuAPIURL is set
+	RelatedEvents []*corev2.Event
+	// Silenced are the active silences matching the event, if --silenced is
+	// set
+	Silenced []*corev2.Silenced
+	// FlapScore is the percentage of state change in the check history
+	FlapScore float64
+	// IncludeSystemInfo is whether the built-in templates include the
+	// entity's system facts, with --includeSystemInfo
+	IncludeSystemInfo bool
+	// Status is the name of the check status, e.g. CRITICAL
+	Status string
+	// IsResolved is whether the event is a resolution
+	IsResolved bool
+	// DurationSinceLastOK is how long the check had not been OK when it was
+	// executed, or zero if it is OK or has never been OK
+	DurationSinceLastOK time.Duration
+	// NumOccurrences is the number of occurrences with thousands separators
+	NumOccurrences string
+	// NamespaceEntityCheck is the namespace/entity/check the event is for
+	NamespaceEntityCheck string
+}
+
+// HookOutput returns the output of the check hook with the name, or an empty
+// string if no such hook ran, so that templates can include the results of
+// selected hooks.
+func (e templateEvent) HookOutput(name string) string {
+	if e.Check == nil {
+		return ""
+	}
+	for _, hook := range e.Check.Hooks {
+		if hook != nil && hook.Name == name {
+			return hook.Output
+		}
+	}
+	return ""
+}
+
+func resolveTemplate(templateValue string, event *corev2.Event, contentType string) (string, error) {
+	data := templateEvent{
+		Event:             *event,
+		DashboardLink:     dashboardLink(event),
+		SilenceLink:       silenceLink(event),
+		SilenceDuration:   config.SilenceDuration,
+		RelatedEvents:     relatedEvents,
+		Silenced:          silences,
+		IncludeSystemInfo: config.IncludeSystemInfo,
+		IsResolved:        event.IsResolution(),
+	}
+	// metrics events have no check, so only the fields of the entity and
+	// metrics are set
+	if event.Check != nil {
+		data.FlapScore = flapScore(event.Check.History)
+		data.Status = templates.StatusName(event.Check.Status)
+		data.DurationSinceLastOK = durationSinceLastOK(event.Check)
+		data.NumOccurrences = formatThousands(event.Check.Occurrences)
+		data.NamespaceEntityCheck = path.Join(event.Entity.Namespace, event.Entity.Name, event.Check.Name)
+	} else {
+		data.NamespaceEntityCheck = path.Join(event.Entity.Namespace, event.Entity.Name)
+	}
+	return resolveTemplateData(templateValue, data, contentType)
+}
+
+// durationSinceLastOK returns how long the check had not been OK when it was
+// executed.
+func durationSinceLastOK(check *corev2.Check) time.Duration {
+	if check.Status == 0 || check.LastOK == 0 || check.Executed < check.LastOK {
+		return 0
+	}
+	return time.Duration(check.Executed-check.LastOK) * time.Second
+}
+
+// formatThousands formats the number with commas separating the thousands.
+func formatThousands(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return sign + digits
+}
+
+// dashboardLink returns the URL of the event in the Sensu web UI.
+func dashboardLink(event *corev2.Event) string {
+	if len(config.DashboardURL) == 0 {
+		return ""
+	}
+	// metrics events have no check, so link to their entity
+	if event.Check == nil {
+		return strings.TrimSuffix(config.DashboardURL, "/") + "/" +
+			url.PathEscape(event.Entity.Namespace) + "/entities/" +
+			url.PathEscape(event.Entity.Name)
+	}
+	return strings.TrimSuffix(config.DashboardURL, "/") + "/" +
+		url.PathEscape(event.Entity.Namespace) + "/events/" +
+		url.PathEscape(event.Entity.Name) + "/" +
+		url.PathEscape(event.Check.Name)
+}
+
+// resolveTemplateData executes the template against arbitrary data, such as
+// the events making up a digest.
+func resolveTemplateData(templateValue string, data interface{}, contentType string) (string, error) {
+	opts := templates.Options{
+		HTML:       contentType == ContentHTML,
+		LeftDelim:  templateLeftDelim,
+		RightDelim: templateRightDelim,
+		Funcs:      templateFuncs(),
+		Builtins:   builtinPartials,
+		Partials:   templatePartialList,
+	}
+	if isHandlerTemplate(templateValue) {
+		opts.LeftDelim, opts.RightDelim, opts.Partials = "{{", "}}", nil
+	}
+	return templates.Resolve(templateValue, data, opts)
+}
+
+// parseExtraHeader splits an extra header of the form "Name: value" into its
+// name and (unresolved) value.
+func parseExtraHeader(header string) (string, string, error) {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("extra header %q is not of the form \"Name: value\"", header)
+	}
+	name := strings.TrimSpace(parts[0])
+	if len(name) == 0 || strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' }) >= 0 {
+		return "", "", fmt.Errorf("extra header %q has an invalid name", header)
+	}
+	for _, reserved := range reservedHeaders {
+		if strings.EqualFold(name, reserved) {
+			return "", "", fmt.Errorf("extra header %s is reserved and cannot be overridden", name)
 		}
 	}
+	return name, strings.TrimSpace(parts[1]), nil
+}
 
-	if ok, _ := conn.Extension("AUTH"); ok && auth != nil {
-		if err := conn.Auth(auth); err != nil {
+// addExtraHeaders resolves the value templates of the configured extra
+// headers and adds them to the message header.
+func addExtraHeaders(header *mailer.Header, event *corev2.Event) error {
+	for _, h := range config.ExtraHeaders {
+		name, value, err := parseExtraHeader(h)
+		if err != nil {
 			return err
 		}
+		resolved, err := resolveTemplate(value, event, ContentPlain)
+		if err != nil {
+			return fmt.Errorf("failed to resolve extra header %s: %v", name, err)
+		}
+		header.Add(name, resolved)
 	}
+	return nil
+}
 
-	if err := conn.Mail(config.FromEmail); err != nil {
-		return err
+// addSensuHeaders adds the X-Sensu-* headers identifying the event, for mail
+// filtering and ticket correlation, except those set with --extraHeader. A
+// digest covers many entities/checks, so only has its namespace and the
+// number of events.
+func addSensuHeaders(header *mailer.Header, event *corev2.Event) {
+	fields := [][2]string{{"X-Sensu-Namespace", event.Entity.Namespace}}
+	if len(digestEvents) > 0 {
+		fields = append(fields, [2]string{"X-Sensu-Digest", strconv.Itoa(len(digestEvents))})
+	} else {
+		fields = append(fields, [2]string{"X-Sensu-Entity", event.Entity.Name})
+		if event.Check != nil {
+			fields = append(fields,
+				[2]string{"X-Sensu-Check", event.Check.Name},
+				[2]string{"X-Sensu-Status", strconv.FormatUint(uint64(event.Check.Status), 10)})
+		}
+		fields = append(fields, [2]string{"X-Sensu-Event-ID", eventID(event)})
 	}
-	if err := recipients.rcpt(conn); err != nil {
-		return err
+	for _, f := range fields {
+		if len(f[1]) > 0 && len(header.Get(f[0])) == 0 {
+			header.Add(f[0], f[1])
+		}
 	}
+}
 
-	data, err := conn.Data()
+// eventID returns the event's ID as a UUID string, or an empty string if the
+// event has no ID.
+func eventID(event *corev2.Event) string {
+	id, err := uuid.FromBytes(event.ID)
 	if err != nil {
-		return err
+		return ""
 	}
-	if _, err := data.Write(msg); err != nil {
-		return err
+	return id.String()
+}
+
+// addPriorityHeaders maps the event status to the X-Priority and Importance
+// headers: critical is high, warning (and unknown) is normal and resolved is low.
+func addPriorityHeaders(header *mailer.Header, status uint32) {
+	switch status {
+	case 0:
+		header.Add("X-Priority", "5 (Lowest)")
+		header.Add("Importance", "low")
+	case 2:
+		header.Add("X-Priority", "1 (Highest)")
+		header.Add("Importance", "high")
+	default:
+		header.Add("X-Priority", "3 (Normal)")
+		header.Add("Importance", "normal")
 	}
-	if err := data.Close(); err != nil {
+}
+
+// addThreadHeaders adds In-Reply-To and References headers pointing at the
+// last email sent for the event's entity/check, and at the first email of
+// their thread, as recorded in --stateDir, so that every email for the same
+// entity/check is placed in the same conversation. The first email has
+// neither.
+func addThreadHeaders(header *mailer.Header, event *corev2.Event) error {
+	state, err := loadCheckState(event)
+	if err != nil || state == nil || len(state.MessageID) == 0 {
 		return err
 	}
+	header.Add("In-Reply-To", state.MessageID)
+	references := state.MessageID
+	if len(state.ThreadID) > 0 && state.ThreadID != state.MessageID {
+		references = state.ThreadID + " " + state.MessageID
+	}
+	header.Add("References", references)
+	return nil
+}
 
-	return conn.Quit()
+// newMessageID returns a unique message ID.
+func newMessageID() string {
+	return fmt.Sprintf("<%s@%s>", uuid.New().String(), generatedIDDomain())
 }
 
-func resolveTemplate(templateValue string, event *corev2.Event, contentType string) (string, error) {
-	var (
-		resolved bytes.Buffer
-		tmpl     templater
-		err      error
-	)
-	if contentType == ContentHTML {
-		// parse using html/template
-		tmpl, err = htemplate.New("test").Funcs(htemplate.FuncMap{
-			"UnixTime":      func(i int64) time.Time { return time.Unix(i, 0) },
-			"UUIDFromBytes": uuid.FromBytes,
-		}).Parse(templateValue)
-	} else {
-		// default parse using text/template
-		tmpl, err = ttemplate.New("test").Funcs(ttemplate.FuncMap{
-			"UnixTime":      func(i int64) time.Time { return time.Unix(i, 0) },
-			"UUIDFromBytes": uuid.FromBytes,
-		}).Parse(templateValue)
+// generatedIDDomain returns the domain used in generated message IDs, which is
+// --messageIDDomain or else the domain of the from address.
+func generatedIDDomain() string {
+	if len(config.MessageIDDomain) > 0 {
+		return config.MessageIDDomain
+	}
+	return fromDomain()
+}
+
+// fromDomain returns the domain of the from address.
+func fromDomain() string {
+	if i := strings.LastIndex(config.FromEmail, "@"); i >= 0 {
+		return config.FromEmail[i+1:]
 	}
+	return "localhost"
+}
 
+// textEntity returns a single part MIME entity containing the body, converted
+// to the configured charset.
+func textEntity(body, contentType string) (*mailer.Part, error) {
+	converted, err := bodyEncoding.NewEncoder().String(body)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to convert body to %s: %v", config.Charset, err)
+	}
+	return mailer.NewText(contentType, map[string]string{"charset": config.Charset}, []byte(converted)), nil
+}
+
+// templateFuncs returns the functions available to both the subject and body
+// templates, regardless of which template package is used to parse them.
+func templateFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"UnixTime":         unixTime,
+		"InTimezone":       inTimezone,
+		"Translate":        translate,
+		"UUIDFromBytes":    uuid.FromBytes,
+		"StatusName":       templates.StatusName,
+		"StatusColor":      templates.StatusColor,
+		"MetricsTable":     metricsTable,
+		"MetricsHTMLTable": metricsHTML,
+		"ChartImage":       chartImage,
+		"HistoryTimeline":  historyTimeline,
+		"HistoryHTMLTable": historyHTML,
+		"StripANSI":        removeANSI,
+		"ANSIToHTML":       ansiToHTML,
+		"Truncate":         truncate,
+		"Wrap":             wrap,
 	}
+}
+
+// templateTime is a time.Time that prints itself using the configured
+// dateFormat, while still allowing templates to call .Format directly.
+type templateTime struct {
+	time.Time
+}
+
+func (t templateTime) String() string {
+	return t.Format(config.DateFormat)
+}
+
+func unixTime(i int64) templateTime {
+	return templateTime{time.Unix(i, 0).In(templateLocation)}
+}
 
-	err = tmpl.Execute(&resolved, *event)
+// inTimezone returns the timestamp in the IANA timezone, for the InTimezone
+// template function, e.g. {{InTimezone "Asia/Tokyo" (UnixTime .Check.Executed)}}.
+func inTimezone(name string, t templateTime) (templateTime, error) {
+	loc, err := time.LoadLocation(name)
 	if err != nil {
-		return "", err
+		return t, fmt.Errorf("invalid timezone %s: %v", name, err)
 	}
+	return templateTime{t.In(loc)}, nil
+}
 
-	return resolved.String(), nil
+// envelopeRcpts returns all of the to, cc and bcc recipients.
+func envelopeRcpts(to, cc, bcc rcpts) rcpts {
+	all := make(rcpts, 0, len(to)+len(cc)+len(bcc))
+	all = append(all, to...)
+	all = append(all, cc...)
+	return append(all, bcc...)
 }
 
 //newRcpts trims "spaces" and checks each toEmails for commas.
@@ -395,7 +2492,8 @@ func newRcpts(toEmails []string) rcpts {
 	ntos := []string{}
 
 	for i, t := range toEmails {
-		ts := strings.Split(t, ",")
+		// the commas of an ldap: recipient's DN don't separate recipients
+		ts := ldapRcptTokens(strings.Split(t, ","))
 		tos[i] = strings.TrimSpace(ts[0])
 		if len(ts) == 1 {
 			continue
@@ -413,15 +2511,6 @@ func newRcpts(toEmails []string) rcpts {
 	return rcpts(tos)
 }
 
-func (r rcpts) rcpt(c *smtp.Client) error {
-	for _, to := range r {
-		if err := c.Rcpt(to); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (r rcpts) String() string {
 	return strings.Join(r, ",")
 }
@@ -1,12 +1,26 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	"github.com/sensu/sensu-email-handler/pkg/transport"
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
 )
 
 var tcRcpts = []struct {
@@ -54,3 +68,687 @@ func TestResolveTemplate(t *testing.T) {
 	expected = fmt.Sprintf("<html>Entity: foo Check: bar Executed: %s</html>", executedFormatted)
 	assert.Equal(t, templout, expected)
 }
+
+func TestDashboardLink(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar baz")
+	assert.Empty(t, dashboardLink(event))
+
+	config.DashboardURL = "https://sensu.example.com:3000/"
+	defer func() { config.DashboardURL = "" }()
+	assert.Equal(t, "https://sensu.example.com:3000/default/events/foo/bar%20baz", dashboardLink(event))
+
+	templout, err := resolveTemplate(`<a href="{{.DashboardLink}}">{{.Check.Name}}</a>`, event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Equal(t, `<a href="https://sensu.example.com:3000/default/events/foo/bar%20baz">bar baz</a>`, templout)
+}
+
+func TestHookOutput(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	disk := corev2.FixtureHook("check-disk-hook")
+	disk.Output = "/var is 95% full"
+	procs := corev2.FixtureHook("check-procs-hook")
+	procs.Output = "<defunct> processes: 3"
+	event.Check.Hooks = []*corev2.Hook{disk, procs}
+
+	templout, err := resolveTemplate(`Disk: {{.HookOutput "check-disk-hook"}} Missing: {{.HookOutput "missing"}}`, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Disk: /var is 95% full Missing: ", templout)
+	templout, err = resolveTemplate(`<p>{{.HookOutput "check-procs-hook"}}</p>`, event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>&lt;defunct&gt; processes: 3</p>", templout)
+	templout, err = resolveTemplate(`{{range .Check.Hooks}}{{.Name}} {{end}}`, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "check-disk-hook check-procs-hook ", templout)
+}
+
+func TestComputedTemplateFields(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.Occurrences = 1234567
+	event.Check.LastOK = 1598478983
+	event.Check.Executed = 1598478983 + 3723
+
+	templout, err := resolveTemplate("{{.Status}} {{.IsResolved}} {{.DurationSinceLastOK}} {{.NumOccurrences}} {{.NamespaceEntityCheck}}", event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "CRITICAL false 1h2m3s 1,234,567 default/foo/bar", templout)
+
+	event.Check.Status = 0
+	event.Check.Occurrences = 12
+	event.Check.History = []corev2.CheckHistory{{Status: 2}, {Status: 0}}
+	templout, err = resolveTemplate("{{.Status}} {{.IsResolved}} {{.DurationSinceLastOK}} {{.NumOccurrences}}", event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK true 0s 12", templout)
+
+	assert.Equal(t, "-1,000", formatThousands(-1000))
+	assert.Equal(t, "999", formatThousands(999))
+}
+
+func TestUnixTime(t *testing.T) {
+	config.DateFormat = "2006-01-02 15:04 MST"
+	loc, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	templateLocation = loc
+	defer func() {
+		config.DateFormat = ""
+		templateLocation = time.Local
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Executed = 1598478983
+	templout, err := resolveTemplate("Executed: {{UnixTime .Check.Executed}}", event, "text/plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "Executed: 2020-08-26 17:56 EDT", templout)
+	templout, err = resolveTemplate("Executed: {{(UnixTime .Check.Executed).Format \"15:04\"}}", event, "text/html")
+	assert.NoError(t, err)
+	assert.Equal(t, "Executed: 17:56", templout)
+	templout, err = resolveTemplate("Executed: {{InTimezone \"Asia/Tokyo\" (UnixTime .Check.Executed)}}", event, "text/plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "Executed: 2020-08-27 06:56 JST", templout)
+	_, err = resolveTemplate("{{InTimezone \"Mars/Olympus_Mons\" (UnixTime .Check.Executed)}}", event, "text/plain")
+	assert.Error(t, err)
+
+	// the default is the layout UnixTime printed before --dateFormat
+	config.DateFormat = defaultDateFormat
+	templout, err = resolveTemplate("Executed: {{UnixTime .Check.Executed}}", event, "text/plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "Executed: "+time.Unix(1598478983, 0).In(loc).String(), templout)
+}
+
+func TestEnvelopeAddress(t *testing.T) {
+	r := newRcpts([]string{"José <jose@example.com>, email2@example.com"})
+	assert.Equal(t, "jose@example.com", smtpsend.EnvelopeAddress(r[0]))
+	assert.Equal(t, "email2@example.com", smtpsend.EnvelopeAddress(r[1]))
+}
+
+func TestTextEntity(t *testing.T) {
+	config.Charset = "iso-8859-1"
+	enc, err := ianaindex.MIME.Encoding(config.Charset)
+	assert.NoError(t, err)
+	bodyEncoding = enc
+	defer func() {
+		config.Charset = ""
+		bodyEncoding = unicode.UTF8
+	}()
+
+	entity, err := textEntity("Entity: café\nStatus: critical", ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Content-Type: text/plain; charset=iso-8859-1\r\n"+
+		"Content-Transfer-Encoding: quoted-printable\r\n"+
+		"\r\n"+
+		"Entity: caf=E9\r\nStatus: critical", string(entity.Bytes()))
+}
+
+func TestAddExtraHeaders(t *testing.T) {
+	config.ExtraHeaders = []string{"X-Team: platform", "X-Sensu-Check:{{.Check.Name}}"}
+	defer func() { config.ExtraHeaders = nil }()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	var header mailer.Header
+	err := addExtraHeaders(&header, event)
+	assert.NoError(t, err)
+	assert.Equal(t, "X-Team: platform\r\nX-Sensu-Check: bar\r\n", string(header.Bytes()))
+
+	_, _, err = parseExtraHeader("X-Team platform")
+	assert.Error(t, err)
+	_, _, err = parseExtraHeader("Bad Name: value")
+	assert.Error(t, err)
+	_, _, err = parseExtraHeader("subject: value")
+	assert.Error(t, err)
+	_, _, err = parseExtraHeader("Auto-Submitted: no")
+	assert.Error(t, err)
+	_, _, err = parseExtraHeader("cc: attacker@example.com")
+	assert.Error(t, err)
+	_, _, err = parseExtraHeader("Precedence: list")
+	assert.Error(t, err)
+	_, _, err = parseExtraHeader("X-Priority: 1")
+	assert.Error(t, err)
+}
+
+func TestAddPriorityHeaders(t *testing.T) {
+	for status, expected := range map[uint32]string{
+		0: "X-Priority: 5 (Lowest)\r\nImportance: low\r\n",
+		1: "X-Priority: 3 (Normal)\r\nImportance: normal\r\n",
+		2: "X-Priority: 1 (Highest)\r\nImportance: high\r\n",
+		3: "X-Priority: 3 (Normal)\r\nImportance: normal\r\n",
+	} {
+		var header mailer.Header
+		addPriorityHeaders(&header, status)
+		assert.Equal(t, expected, string(header.Bytes()))
+	}
+}
+
+func TestAddSensuHeaders(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.ID = []byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	var header mailer.Header
+	header.Add("X-Sensu-Check", "custom")
+	addSensuHeaders(&header, event)
+	assert.Equal(t, "X-Sensu-Check: custom\r\nX-Sensu-Namespace: default\r\nX-Sensu-Entity: foo\r\n"+
+		"X-Sensu-Status: 2\r\nX-Sensu-Event-ID: 6ba7b810-9dad-11d1-80b4-00c04fd430c8\r\n", string(header.Bytes()))
+
+	digestEvents = []*corev2.Event{event, event}
+	defer func() { digestEvents = nil }()
+	header = mailer.Header{}
+	addSensuHeaders(&header, event)
+	assert.Equal(t, "X-Sensu-Namespace: default\r\nX-Sensu-Digest: 2\r\n", string(header.Bytes()))
+}
+
+func TestAddThreadHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	defer func() { config.StateDir = "" }()
+
+	// the first email starts the thread
+	event := corev2.FixtureEvent("foo", "bar")
+	var header mailer.Header
+	assert.NoError(t, addThreadHeaders(&header, event))
+	assert.Empty(t, header.Bytes())
+
+	assert.NoError(t, recordThread(event, "<1@example.com>"))
+	header = mailer.Header{}
+	assert.NoError(t, addThreadHeaders(&header, event))
+	assert.Equal(t, "In-Reply-To: <1@example.com>\r\nReferences: <1@example.com>\r\n", string(header.Bytes()))
+
+	// later emails reply to the last one, in the thread of the first
+	assert.NoError(t, recordThread(event, "<2@example.com>"))
+	assert.NoError(t, recordSent(event))
+	header = mailer.Header{}
+	assert.NoError(t, addThreadHeaders(&header, event))
+	assert.Equal(t, "In-Reply-To: <2@example.com>\r\nReferences: <1@example.com> <2@example.com>\r\n", string(header.Bytes()))
+
+	other := corev2.FixtureEvent("foo", "baz")
+	header = mailer.Header{}
+	assert.NoError(t, addThreadHeaders(&header, other))
+	assert.Empty(t, header.Bytes())
+}
+
+func TestDeliverEmailThreading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	memory := &transport.Memory{}
+	messageSender = memory
+	config.StateDir = dir
+	config.Threading = true
+	config.SendIndividually = true
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	defer func() {
+		messageSender = nil
+		config.StateDir = ""
+		config.Threading = false
+		config.SendIndividually = false
+		config.FromEmail = ""
+		config.FromHeader = ""
+	}()
+
+	// the copies are the same email, recorded once for the next to reply to
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.NoError(t, deliverEmail(event, rcpts{"ops@example.com", "dev@example.com"}, nil, nil, "subject", "body", ContentPlain))
+	messages := memory.Messages()
+	if !assert.Len(t, messages, 2) {
+		return
+	}
+	var messageIDs []string
+	for _, m := range messages {
+		msg, err := mail.ReadMessage(strings.NewReader(string(m.Data)))
+		assert.NoError(t, err)
+		messageIDs = append(messageIDs, msg.Header.Get("Message-ID"))
+	}
+	assert.Equal(t, messageIDs[0], messageIDs[1])
+	state, err := loadCheckState(event)
+	assert.NoError(t, err)
+	assert.Equal(t, messageIDs[0], state.MessageID)
+	assert.Equal(t, messageIDs[0], state.ThreadID)
+
+	// notices, such as rate limiting summaries, are not replied to
+	assert.NoError(t, deliverNotice(event, "ops@example.com", "2 emails suppressed", "summary"))
+	state, err = loadCheckState(event)
+	assert.NoError(t, err)
+	assert.Equal(t, messageIDs[0], state.MessageID)
+}
+
+func TestBodyContentType(t *testing.T) {
+	defer func() {
+		config.BodyFormat = ""
+		config.ContentType = ""
+	}()
+
+	fragment := "<p>{{.Check.Output}}</p>"
+	config.BodyFormat = BodyFormatTemplate
+	config.ContentType = ContentAuto
+	assert.Equal(t, ContentHTML, bodyContentType("<html>"+fragment+"</html>"))
+	assert.Equal(t, ContentPlain, bodyContentType(fragment))
+
+	// a pinned content type is used whatever the template contains
+	config.ContentType = ContentPlain
+	assert.Equal(t, ContentPlain, bodyContentType("Output: {{.Check.Output}} <html>"))
+	config.ContentType = ContentHTML
+	assert.Equal(t, ContentHTML, bodyContentType(fragment))
+	config.ContentType = ContentAuto
+	config.BodyFormat = BodyFormatMarkdown
+	assert.Equal(t, ContentPlain, bodyContentType("<html>"+fragment+"</html>"))
+
+	// event values are always escaped in the html body format
+	config.BodyFormat = BodyFormatHTML
+	contentType := bodyContentType(fragment)
+	assert.Equal(t, ContentHTML, contentType)
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = `<script>alert("x")</script></p><img src=x onerror=alert(1)>`
+	body, err := resolveTemplate(fragment, event, contentType)
+	assert.NoError(t, err)
+	assert.NotContains(t, body, "<script>")
+	assert.NotContains(t, body, "<img")
+	assert.Equal(t, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;&lt;/p&gt;&lt;img src=x onerror=alert(1)&gt;</p>", body)
+}
+
+func TestSelectTemplates(t *testing.T) {
+	config.SubjectTemplate = "alert subject"
+	config.ResolvedSubjectTemplate = "resolved subject"
+	resolvedBodyTemplate = "resolved body"
+	defer func() {
+		config.SubjectTemplate = ""
+		config.ResolvedSubjectTemplate = ""
+		resolvedBodyTemplate = ""
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.History = []corev2.CheckHistory{{Status: 0}, {Status: 2}}
+	subject, body := selectTemplates(event)
+	assert.Equal(t, "alert subject", subject)
+	assert.Equal(t, emailBodyTemplate, body)
+
+	event.Check.Status = 0
+	event.Check.History = []corev2.CheckHistory{{Status: 2}, {Status: 0}}
+	subject, body = selectTemplates(event)
+	assert.Equal(t, "resolved subject", subject)
+	assert.Equal(t, "resolved body", body)
+
+	config.ResolvedSubjectTemplate = ""
+	subject, _ = selectTemplates(event)
+	assert.Equal(t, "alert subject", subject)
+}
+
+func TestSuppressOccurrences(t *testing.T) {
+	defer func() {
+		config.MinOccurrences = 0
+		config.BackoffOccurrences = false
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	sent := func() []int64 {
+		var occurrences []int64
+		for i := int64(1); i <= 20; i++ {
+			event.Check.Occurrences = i
+			if len(suppressOccurrences(event)) == 0 {
+				occurrences = append(occurrences, i)
+			}
+		}
+		return occurrences
+	}
+
+	assert.Len(t, sent(), 20)
+	config.MinOccurrences = 3
+	assert.Equal(t, int64(3), sent()[0])
+	assert.Len(t, sent(), 18)
+	config.BackoffOccurrences = true
+	assert.Equal(t, []int64{3, 4, 6, 10, 18}, sent())
+
+	// resolutions are always sent
+	event.Check.Status = 0
+	event.Check.Occurrences = 1
+	assert.Empty(t, suppressOccurrences(event))
+
+	// as are metrics events, which have no check
+	metrics := &corev2.Event{Entity: corev2.FixtureEntity("foo"), Metrics: corev2.FixtureMetrics()}
+	assert.Empty(t, suppressOccurrences(metrics))
+
+	// without --minOccurrences nothing is suppressed, even without occurrences
+	config.MinOccurrences = 0
+	config.BackoffOccurrences = false
+	event.Check.Status = 2
+	event.Check.Occurrences = 0
+	assert.Empty(t, suppressOccurrences(event))
+}
+
+// startSMTPServer starts a minimal SMTP server accepting a single connection,
+// returning its port and a channel receiving the message data.
+func startSMTPServer(t *testing.T, extensions ...string) (uint64, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan string, 1)
+	var dsn, startTLS bool
+	for _, ext := range extensions {
+		dsn = dsn || ext == "DSN"
+		startTLS = startTLS || ext == "STARTTLS"
+	}
+	var tlsConfig *tls.Config
+	if startTLS {
+		cert, key := testCertificate(t, "localhost", nil, nil)
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost ESMTP")
+		var sender, envelope string
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+			case "EHLO", "HELO":
+				_ = text.PrintfLine("250-localhost")
+				for _, ext := range extensions {
+					_ = text.PrintfLine("250-%s", ext)
+				}
+				_ = text.PrintfLine("250 8BITMIME")
+			case "MAIL":
+				sender = strings.Fields(strings.TrimPrefix(line[len(verb)+1:], "FROM:"))[0]
+				envelope = "X-Envelope: " + line + "\n"
+				_ = text.PrintfLine("250 ok")
+			case "DATA":
+				_ = text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotBytes()
+				// add the envelope sender as a delivering server would, and the
+				// envelope commands for the DSN tests
+				if !dsn {
+					envelope = ""
+				}
+				messages <- "Return-Path: " + sender + "\n" + envelope + string(data)
+				_ = text.PrintfLine("250 queued")
+			case "RCPT", "VRFY":
+				// unknown users are rejected
+				if strings.Contains(line, "unknown") {
+					_ = text.PrintfLine("550 no such user")
+				} else {
+					if verb == "RCPT" {
+						envelope += "X-Envelope: " + line + "\n"
+					}
+					_ = text.PrintfLine("250 ok")
+				}
+			case "STARTTLS":
+				_ = text.PrintfLine("220 ready")
+				conn = tls.Server(conn, tlsConfig)
+				text = textproto.NewConn(conn)
+			case "AUTH":
+				_ = text.PrintfLine("235 authenticated")
+			case "QUIT":
+				_ = text.PrintfLine("221 bye")
+				return
+			default:
+				_ = text.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return uint64(listener.Addr().(*net.TCPAddr).Port), messages
+}
+
+func TestEnvelopeRcpts(t *testing.T) {
+	assert.Equal(t, rcpts{"a@example.com", "b@example.com", "c@example.com"},
+		envelopeRcpts(rcpts{"a@example.com"}, rcpts{"b@example.com"}, rcpts{"c@example.com"}))
+	assert.Empty(t, envelopeRcpts(nil, nil, nil))
+}
+
+func TestReadCredentialFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "password")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("s3cret\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	password, err := readCredentialFile(file.Name(), "", smtpPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", password)
+
+	_, err = readCredentialFile(file.Name(), "given", smtpPassword)
+	assert.EqualError(t, err, "--smtpPassword and --smtpPasswordFile are mutually exclusive")
+	_, err = readCredentialFile(file.Name()+".missing", "", smtpPassword)
+	assert.Error(t, err)
+}
+
+func TestTransmitEnvelopeFrom(t *testing.T) {
+	config.SmtpHost = "127.0.0.1"
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.EnvelopeFrom = ""
+	}()
+
+	port, messages := startSMTPServer(t)
+	config.SmtpPort = port
+	assert.NoError(t, transmit("", rcpts{"ops@example.com"}, []byte("From: <sensu@example.com>\r\n\r\nbody\r\n")))
+	assert.Contains(t, <-messages, "Return-Path: <sensu@example.com>\n")
+
+	port, messages = startSMTPServer(t)
+	config.SmtpPort = port
+	config.EnvelopeFrom = "bounces@example.com"
+	assert.NoError(t, transmit("", rcpts{"ops@example.com"}, []byte("From: <sensu@example.com>\r\n\r\nbody\r\n")))
+	msg := <-messages
+	assert.Contains(t, msg, "Return-Path: <bounces@example.com>\n")
+	assert.Contains(t, msg, "From: <sensu@example.com>\n")
+}
+
+func TestComposeAndTransmitHeaders(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "Sensu <sensu@example.com>"
+	config.MessageIDDomain = "alerts.example.com"
+	config.PrecedenceBulk = true
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.MessageIDDomain = ""
+		config.PrecedenceBulk = false
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	subject := "Sensu Alert - " + strings.Repeat("très longue entité/", 8) + "check"
+	messageID := newMessageID()
+	assert.Regexp(t, `^<[0-9a-f-]+@alerts\.example\.com>$`, messageID)
+	assert.NoError(t, composeAndTransmit(event, messageID, rcpts{"ops@example.com"}, nil, nil, subject, "first line\n.leading dot\n", ContentPlain))
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-messages))
+	assert.NoError(t, err)
+	for _, header := range []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"} {
+		assert.NotEmpty(t, msg.Header.Get(header), header)
+	}
+	assert.Equal(t, messageID, msg.Header.Get("Message-ID"))
+	assert.Equal(t, "auto-generated", msg.Header.Get("Auto-Submitted"))
+	assert.Equal(t, "bulk", msg.Header.Get("Precedence"))
+	date, err := msg.Header.Date()
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, time.Minute)
+	decoded, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, subject, decoded)
+	body, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, "first line\n.leading dot\n", string(body))
+}
+
+func TestSendIndividually(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.SendIndividually = true
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.SendIndividually = false
+	}()
+	// the server accepts a single connection, which the copies share
+	defer poolSMTP()()
+
+	received := make(chan []string)
+	go func() {
+		received <- []string{<-messages, <-messages}
+	}()
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.NoError(t, deliverEmail(event, rcpts{"ops@example.com"}, nil, rcpts{"audit@example.com"}, "subject", "body", ContentPlain))
+	msgs := <-received
+	recipients := []string{"ops@example.com", "audit@example.com"}
+	for i, to := range recipients {
+		msg, err := mail.ReadMessage(strings.NewReader(msgs[i]))
+		assert.NoError(t, err)
+		assert.Contains(t, msg.Header.Get("To"), to)
+		assert.NotContains(t, msg.Header.Get("To"), recipients[1-i])
+		assert.Empty(t, msg.Header.Get("Cc"))
+	}
+}
+
+func TestMessageIDDomain(t *testing.T) {
+	config.FromEmail = "sensu@example.com"
+	defer func() {
+		config.FromEmail = ""
+		config.MessageIDDomain = ""
+	}()
+
+	assert.Equal(t, "example.com", generatedIDDomain())
+	assert.Regexp(t, `^<[0-9a-f-]+@example\.com>$`, newMessageID())
+	assert.NotEqual(t, newMessageID(), newMessageID(), "message ids should be unique")
+	config.MessageIDDomain = "mx.example.org"
+	assert.Equal(t, "mx.example.org", generatedIDDomain())
+	assert.Equal(t, "example.com", fromDomain())
+}
+
+func TestHeaderInjection(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.SubjectTemplate = "{{.Entity.Name}}/{{.Check.Name}}: {{.Check.Output}}"
+	config.ExtraHeaders = []string{"X-Sensu-Output: {{.Check.Output}}"}
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.SubjectTemplate = ""
+		config.ExtraHeaders = nil
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = "CRITICAL\r\nBcc: attacker@example.com\r\n\r\ninjected body"
+	subject, body, contentType, err := composeEmail(event)
+	assert.Equal(t, "CRITICAL\r\nBcc: attacker@example.com\r\n\r\ninjected body", body)
+	assert.NoError(t, err)
+	err = composeAndTransmit(event, newMessageID(), rcpts{"ops@example.com"}, nil, nil, subject, body, contentType)
+	assert.NoError(t, err)
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-messages))
+	assert.NoError(t, err)
+	assert.Empty(t, msg.Header.Get("Bcc"))
+	assert.Equal(t, "foo/bar: CRITICAL Bcc: attacker@example.com injected body", msg.Header.Get("Subject"))
+	assert.Equal(t, "CRITICAL Bcc: attacker@example.com injected body", msg.Header.Get("X-Sensu-Output"))
+	msgBody, err := ioutil.ReadAll(msg.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(msgBody), "\n\ninjected body")
+}
+
+func TestTransmitSMTPUTF8(t *testing.T) {
+	config.SmtpHost = "127.0.0.1"
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@exämple.com"
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+	}()
+	msg := []byte("From: <sensu@xn--exmple-cua.com>\r\n\r\nbody\r\n")
+
+	port, messages := startSMTPServer(t)
+	config.SmtpPort = port
+	assert.NoError(t, transmit("", rcpts{"ops@exämple.com"}, msg))
+	assert.Contains(t, <-messages, "Return-Path: <sensu@xn--exmple-cua.com>\n")
+
+	port, _ = startSMTPServer(t)
+	config.SmtpPort = port
+	assert.Error(t, transmit("", rcpts{"jösé@example.com"}, msg))
+
+	port, messages = startSMTPServer(t, "SMTPUTF8")
+	config.SmtpPort = port
+	assert.NoError(t, transmit("", rcpts{"jösé@example.com"}, msg))
+	assert.Contains(t, <-messages, "Return-Path: <sensu@exämple.com>\n")
+}
+
+func TestUnannotatedOptions(t *testing.T) {
+	// the options deciding where alerts and credentials go cannot be set
+	// with annotations
+	unannotated := map[string]bool{
+		namespacesFile:     true,
+		templateToken:      true,
+		templateUsername:   true,
+		templatePassword:   true,
+		templateHeader:     true,
+		bodyTemplateSHA256: true,
+		sensuAPIURL:        true,
+		sensuAPIKey:        true,
+		spoolDir:           true,
+		smtpTraceFile:      true,
+		statsdAddress:      true,
+		pushgatewayURL:     true,
+		metricsFile:        true,
+		captureDir:         true,
+		captureAddress:     true,
+		configFile:         true,
+		profile:            true,
+		fallbackWebhookURL: true,
+		redisURL:           true,
+		smtpUsernameFile:   true,
+		smtpPasswordFile:   true,
+		vaultAddress:       true,
+		vaultAuthMethod:    true,
+		vaultToken:         true,
+		vaultRole:          true,
+		vaultSecretID:      true,
+		vaultSecretPath:    true,
+		ldapURL:            true,
+		ldapBindDN:         true,
+		ldapBaseDN:         true,
+		kerberosKeytab:     true,
+		kerberosKDC:        true,
+		stateDir:           true,
+		templateCacheDir:   true,
+		pgpKeyserver:       true,
+	}
+	for _, opt := range emailConfigOptions {
+		if unannotated[opt.Argument] {
+			assert.Empty(t, opt.Path, opt.Argument)
+		}
+	}
+}
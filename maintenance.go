@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// maintenanceWindows are loaded from --maintenanceFile
+var maintenanceWindows []*maintenanceWindow
+
+// the actions taken for events during a maintenance window
+const (
+	maintenanceSuppress = "suppress"
+	maintenanceQueue    = "queue"
+)
+
+type maintenanceConfig struct {
+	Windows []*maintenanceWindow `yaml:"windows"`
+}
+
+// maintenanceWindow is a recurring period during which the emails for the
+// matching events are suppressed, or queued to be sent once it is over. The
+// period is either a cron schedule with a duration, or the time range from
+// start to end on the given days.
+type maintenanceWindow struct {
+	Name     string       `yaml:"name"`
+	Match    routingMatch `yaml:",inline"`
+	Cron     string       `yaml:"cron"`
+	Duration string       `yaml:"duration"`
+	Days     []string     `yaml:"days"`
+	Start    string       `yaml:"start"`
+	End      string       `yaml:"end"`
+	Timezone string       `yaml:"timezone"`
+	Action   string       `yaml:"action"`
+
+	schedule *cronSchedule
+	duration time.Duration
+	// weekdays the window starts on, all if none are given
+	weekdays [7]bool
+	// minutes since midnight the window starts and ends at
+	start, end int
+	location   *time.Location
+}
+
+// loadMaintenanceWindows reads and validates the YAML maintenance windows
+// file.
+func loadMaintenanceWindows(file string) ([]*maintenanceWindow, error) {
+	windowsBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read maintenance file %s: %v", file, err)
+	}
+	parsed := &maintenanceConfig{}
+	if err := yaml.UnmarshalStrict(windowsBytes, parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse maintenance file %s: %v", file, err)
+	}
+	for i, window := range parsed.Windows {
+		if len(window.Name) == 0 {
+			window.Name = fmt.Sprintf("%d", i+1)
+		}
+		if err := window.validate(); err != nil {
+			return nil, fmt.Errorf("maintenance window %s %v", window.Name, err)
+		}
+	}
+	return parsed.Windows, nil
+}
+
+func (w *maintenanceWindow) validate() error {
+	for _, pattern := range []string{w.Match.Namespace, w.Match.Entity, w.Match.Check} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("has an invalid pattern %q", pattern)
+		}
+	}
+	switch w.Action {
+	case "":
+		w.Action = maintenanceSuppress
+	case maintenanceSuppress, maintenanceQueue:
+	default:
+		return fmt.Errorf("has an invalid action %s, use suppress or queue", w.Action)
+	}
+	if len(w.Timezone) > 0 {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return fmt.Errorf("has an invalid timezone %s: %v", w.Timezone, err)
+		}
+		w.location = loc
+	}
+
+	if len(w.Cron) > 0 {
+		if len(w.Days)+len(w.Start)+len(w.End) > 0 {
+			return errors.New("has both a cron schedule and days or times")
+		}
+		schedule, err := parseCronSchedule(w.Cron)
+		if err != nil {
+			return fmt.Errorf("has an invalid cron schedule %q: %v", w.Cron, err)
+		}
+		w.schedule = schedule
+		duration, err := time.ParseDuration(w.Duration)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("has an invalid duration %q", w.Duration)
+		}
+		w.duration = duration
+		return nil
+	}
+
+	if len(w.Duration) > 0 {
+		return errors.New("has a duration without a cron schedule")
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(w.Start); err != nil {
+		return fmt.Errorf("has an invalid start %q", w.Start)
+	}
+	if w.end, err = parseTimeOfDay(w.End); err != nil {
+		return fmt.Errorf("has an invalid end %q", w.End)
+	}
+	for _, day := range w.Days {
+		weekday, ok := parseWeekday(day)
+		if !ok {
+			return fmt.Errorf("has an invalid day %s", day)
+		}
+		w.weekdays[weekday] = true
+	}
+	if len(w.Days) == 0 {
+		for i := range w.weekdays {
+			w.weekdays[i] = true
+		}
+	}
+	return nil
+}
+
+// active reports whether the window is in effect at the time.
+func (w *maintenanceWindow) active(now time.Time) bool {
+	loc := w.location
+	if loc == nil {
+		loc = templateLocation
+	}
+	now = now.In(loc)
+	if w.schedule != nil {
+		// the window is active if it started within the last duration
+		t := now.Truncate(time.Minute)
+		for start := t; now.Sub(start) < w.duration; start = start.Add(-time.Minute) {
+			if w.schedule.matches(start) {
+				return true
+			}
+		}
+		return false
+	}
+	// a window ending at or before its start ends the next day, so it may
+	// have started yesterday
+	for _, days := range []int{0, -1} {
+		y, m, d := now.AddDate(0, 0, days).Date()
+		start := time.Date(y, m, d, w.start/60, w.start%60, 0, 0, loc)
+		if !w.weekdays[start.Weekday()] {
+			continue
+		}
+		end := time.Date(y, m, d, w.end/60, w.end%60, 0, 0, loc)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !now.Before(start) && now.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeMaintenanceWindow returns the first maintenance window matching the
+// event that is in effect at the time, or nil if there is none.
+func activeMaintenanceWindow(event *corev2.Event, now time.Time) *maintenanceWindow {
+	for _, window := range maintenanceWindows {
+		if window.Match.matches(event) && window.active(now) {
+			return window
+		}
+	}
+	return nil
+}
+
+// suppressMaintenance returns the reason for not sending an email for the
+// event during a maintenance window, queuing the event if the window's
+// action is queue, or an empty string if it should be sent.
+func suppressMaintenance(event *corev2.Event) (string, error) {
+	window := activeMaintenanceWindow(event, time.Now())
+	if window == nil {
+		return "", nil
+	}
+	if window.Action == maintenanceQueue {
+		if err := queueEvent(event); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("queued during maintenance window %s", window.Name), nil
+	}
+	return fmt.Sprintf("suppressed during maintenance window %s", window.Name), nil
+}
+
+// cronSchedule is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// whether the day of month or week is *, as when both are restricted a
+	// day matching either matches
+	domStar, dowStar bool
+}
+
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("expected 5 fields")
+	}
+	s := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 is also Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField returns the bit set of the values of a comma separated list
+// of *, values and ranges, each with an optional /step.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %s", part)
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %s", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %s", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%s is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matches reports whether the schedule fires at the minute of the time.
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// parseTimeOfDay parses a 24 hour HH:MM time into the minutes since
+// midnight.
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseWeekday parses a day name, e.g. sat or Saturday.
+func parseWeekday(day string) (time.Weekday, bool) {
+	day = strings.ToLower(day)
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if day == name || day == name[:3] {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronSchedule(t *testing.T) {
+	s, err := parseCronSchedule("*/15 2-4 * * 6,7")
+	assert.NoError(t, err)
+	// Saturday and Sunday
+	assert.True(t, s.matches(time.Date(2021, 3, 6, 2, 30, 0, 0, time.UTC)))
+	assert.True(t, s.matches(time.Date(2021, 3, 7, 4, 45, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 3, 6, 2, 31, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 3, 8, 2, 30, 0, 0, time.UTC)))
+
+	// a restricted day of month or week matches either
+	s, err = parseCronSchedule("0 0 1 * 1")
+	assert.NoError(t, err)
+	assert.True(t, s.matches(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, s.matches(time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 3, 9, 0, 0, 0, 0, time.UTC)))
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * * * mon", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := parseCronSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	file := writeRoutingRules(t, `windows:
+  - name: patching
+    namespace: prod
+    labels:
+      env: prod
+    days: [sat, Sunday]
+    start: "23:00"
+    end: "01:30"
+    timezone: Europe/Berlin
+  - name: backups
+    cron: "0 3 * * *"
+    duration: 1h
+    timezone: UTC
+    action: queue
+`)
+	defer os.Remove(file)
+	windows, err := loadMaintenanceWindows(file)
+	assert.NoError(t, err)
+	assert.Len(t, windows, 2)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	patching := windows[0]
+	assert.True(t, patching.active(time.Date(2021, 3, 6, 23, 0, 0, 0, berlin)))
+	// started on Sunday night, still active on Monday
+	assert.True(t, patching.active(time.Date(2021, 3, 8, 1, 29, 0, 0, berlin)))
+	assert.False(t, patching.active(time.Date(2021, 3, 8, 1, 30, 0, 0, berlin)))
+	assert.False(t, patching.active(time.Date(2021, 3, 5, 23, 30, 0, 0, berlin)))
+
+	backups := windows[1]
+	assert.True(t, backups.active(time.Date(2021, 3, 5, 3, 59, 59, 0, time.UTC)))
+	assert.False(t, backups.active(time.Date(2021, 3, 5, 4, 0, 0, 0, time.UTC)))
+	assert.False(t, backups.active(time.Date(2021, 3, 5, 2, 59, 0, 0, time.UTC)))
+
+	maintenanceWindows = windows
+	defer func() { maintenanceWindows = nil }()
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Entity.Namespace = "prod"
+	event.Entity.Labels = map[string]string{"env": "prod"}
+	assert.Equal(t, patching, activeMaintenanceWindow(event, time.Date(2021, 3, 7, 0, 0, 0, 0, berlin)))
+	event.Entity.Labels["env"] = "staging"
+	assert.Nil(t, activeMaintenanceWindow(event, time.Date(2021, 3, 7, 0, 0, 0, 0, berlin)))
+
+	for _, invalid := range []string{
+		"windows:\n  - cron: \"0 3 * * *\"\n",
+		"windows:\n  - start: \"25:00\"\n    end: \"01:00\"\n",
+		"windows:\n  - start: \"01:00\"\n    end: \"02:00\"\n    days: [someday]\n",
+		"windows:\n  - start: \"01:00\"\n    end: \"02:00\"\n    action: drop\n",
+		"windows:\n  - cron: \"0 3 * * *\"\n    duration: 1h\n    days: [sat]\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(file, []byte(invalid), 0600))
+		_, err := loadMaintenanceWindows(file)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMaintenanceQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	port, messages := startSMTPServer(t)
+	config.StateDir = dir
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.ToEmail = []string{"ops@example.com"}
+	config.Charset = defaultCharset
+	config.DigestSubjectTemplate = defaultDigestSubjectTemplate
+	window := &maintenanceWindow{Name: "always", Start: "00:00", End: "00:00", Action: maintenanceQueue}
+	assert.NoError(t, window.validate())
+	maintenanceWindows = []*maintenanceWindow{window}
+	defer func() {
+		config.StateDir = ""
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.ToEmail = nil
+		config.Charset = ""
+		config.DigestSubjectTemplate = ""
+		maintenanceWindows = nil
+	}()
+
+	disk := corev2.FixtureEvent("server01", "disk")
+	disk.Check.Status = 2
+	disk.Check.Occurrences = 1
+	disk.Check.Output = "disk full"
+	reason, err := suppressReason(disk)
+	assert.NoError(t, err)
+	assert.Equal(t, "queued during maintenance window always", reason)
+	load := corev2.FixtureEvent("server01", "load")
+	load.Check.Status = 1
+	load.Check.Occurrences = 1
+	_, err = suppressReason(load)
+	assert.NoError(t, err)
+	disk.Check.Output = "disk still full"
+	_, err = suppressReason(disk)
+	assert.NoError(t, err)
+
+	queued, err := loadQueuedEvents()
+	assert.NoError(t, err)
+	assert.Len(t, queued, 2)
+
+	// nothing is sent while the window is active, and the event being
+	// handled supersedes its queued event
+	assert.NoError(t, flushQueuedEvents(time.Now(), load))
+	queued, err = loadQueuedEvents()
+	assert.NoError(t, err)
+	assert.Len(t, queued, 1)
+
+	maintenanceWindows = []*maintenanceWindow{{Name: "over", Action: maintenanceQueue, schedule: &cronSchedule{}, duration: time.Minute}}
+	assert.NoError(t, flushQueuedEvents(time.Now()))
+	msg := <-messages
+	assert.Contains(t, msg, "Sensu Alert Digest - 1 events")
+	assert.Contains(t, msg, "disk still full")
+	_, err = os.Stat(queueFile())
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownEntity renders the Markdown body to HTML and returns a
+// multipart/alternative MIME entity with the raw Markdown as the plain text
+// part and the rendered HTML as the preferred part.
+func markdownEntity(body string) (*mailer.Part, error) {
+	var html bytes.Buffer
+	html.WriteString("<html>\n<body>\n")
+	if err := markdown.Convert([]byte(body), &html); err != nil {
+		return nil, fmt.Errorf("failed to render markdown body: %v", err)
+	}
+	html.WriteString("</body>\n</html>\n")
+
+	plainPart, err := textEntity(body, ContentPlain)
+	if err != nil {
+		return nil, err
+	}
+	htmlPart, err := textEntity(html.String(), ContentHTML)
+	if err != nil {
+		return nil, err
+	}
+	return mailer.NewMultipart("alternative", nil, plainPart, htmlPart), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkdownEntity(t *testing.T) {
+	config.Charset = defaultCharset
+	defer func() { config.Charset = "" }()
+
+	body := "# foo/bar\n\n**State**: failing\n\n<script>alert(1)</script>\n"
+	entity, err := markdownEntity(body)
+	assert.NoError(t, err)
+
+	parts := strings.SplitN(string(entity.Bytes()), "\r\n\r\n", 2)
+	mediaType, params, err := mime.ParseMediaType(strings.TrimPrefix(parts[0], "Content-Type: "))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(strings.NewReader(parts[1]), params["boundary"])
+	plain, err := reader.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", plain.Header.Get("Content-Type"))
+	plainBody, err := ioutil.ReadAll(plain)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(body, "\n", "\r\n", -1), string(plainBody))
+
+	html, err := reader.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", html.Header.Get("Content-Type"))
+	htmlBody, err := ioutil.ReadAll(html)
+	assert.NoError(t, err)
+	assert.Contains(t, string(htmlBody), "<h1>foo/bar</h1>")
+	assert.Contains(t, string(htmlBody), "<strong>State</strong>: failing")
+	assert.NotContains(t, string(htmlBody), "<script>")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	htemplate "html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// metricsTable renders the metric points as a plain text table, for the
+// MetricsTable template function.
+func metricsTable(metrics *corev2.Metrics) string {
+	if metrics == nil || len(metrics.Points) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = w.Write([]byte("NAME\tVALUE\tTAGS\tTIMESTAMP\n"))
+	for _, point := range metrics.Points {
+		_, _ = w.Write([]byte(strings.Join([]string{
+			point.Name,
+			metricValue(point.Value),
+			metricTags(point.Tags),
+			metricTime(point.Timestamp).String(),
+		}, "\t") + "\n"))
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+const metricsHTMLTable = `<table cellpadding="4" cellspacing="0" style="border-collapse: collapse;">
+<tr><th style="border: 1px solid #dddddd;">Name</th><th style="border: 1px solid #dddddd;">Value</th><th style="border: 1px solid #dddddd;">Tags</th><th style="border: 1px solid #dddddd;">Timestamp</th></tr>
+{{range .}}<tr><td style="border: 1px solid #dddddd;">{{.Name}}</td><td style="border: 1px solid #dddddd;">{{.Value}}</td><td style="border: 1px solid #dddddd;">{{.Tags}}</td><td style="border: 1px solid #dddddd;">{{.Timestamp}}</td></tr>
+{{end}}</table>
+`
+
+// metricsHTML renders the metric points as an HTML table, for the
+// MetricsHTMLTable template function.
+func metricsHTML(metrics *corev2.Metrics) (htemplate.HTML, error) {
+	if metrics == nil || len(metrics.Points) == 0 {
+		return "", nil
+	}
+	type row struct {
+		Name, Value, Tags, Timestamp string
+	}
+	rows := make([]row, 0, len(metrics.Points))
+	for _, point := range metrics.Points {
+		rows = append(rows, row{
+			Name:      point.Name,
+			Value:     metricValue(point.Value),
+			Tags:      metricTags(point.Tags),
+			Timestamp: metricTime(point.Timestamp).String(),
+		})
+	}
+	var buf bytes.Buffer
+	if err := htemplate.Must(htemplate.New("metrics").Parse(metricsHTMLTable)).Execute(&buf, rows); err != nil {
+		return "", err
+	}
+	return htemplate.HTML(buf.String()), nil
+}
+
+func metricValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// metricTags returns the tags as name=value pairs sorted by name.
+func metricTags(tags []*corev2.MetricTag) string {
+	pairs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		pairs = append(pairs, tag.Name+"="+tag.Value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// metricTime converts a metric point timestamp to a time. Timestamps are
+// meant to be nanoseconds, but depending on the output metric format they are
+// often seconds or milliseconds, so the unit is inferred from the magnitude.
+func metricTime(timestamp int64) templateTime {
+	var t time.Time
+	switch {
+	case timestamp > 1e17:
+		t = time.Unix(0, timestamp)
+	case timestamp > 1e14:
+		t = time.Unix(0, timestamp*int64(time.Microsecond))
+	case timestamp > 1e11:
+		t = time.Unix(0, timestamp*int64(time.Millisecond))
+	default:
+		t = time.Unix(timestamp, 0)
+	}
+	return templateTime{t.In(templateLocation)}
+}
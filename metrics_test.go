@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsTable(t *testing.T) {
+	config.DateFormat = time.RFC3339
+	templateLocation = time.UTC
+	defer func() {
+		config.DateFormat = ""
+		templateLocation = time.Local
+	}()
+
+	assert.Empty(t, metricsTable(nil))
+
+	metrics := &corev2.Metrics{Points: []*corev2.MetricPoint{
+		{Name: "cpu.idle", Value: 97.5, Timestamp: 1600000000, Tags: []*corev2.MetricTag{{Name: "cpu", Value: "0"}, {Name: "az", Value: "a"}}},
+		{Name: "mem.free", Value: 1024, Timestamp: 1600000000000000000},
+	}}
+	out := metricsTable(metrics)
+	assert.Contains(t, out, "NAME")
+	assert.Contains(t, out, "cpu.idle  97.5   az=a, cpu=0  2020-09-13T12:26:40Z")
+	assert.Contains(t, out, "mem.free  1024")
+
+	html, err := metricsHTML(metrics)
+	assert.NoError(t, err)
+	assert.Contains(t, string(html), "<td style=\"border: 1px solid #dddddd;\">cpu.idle</td>")
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Metrics = metrics
+	out, err = resolveTemplate("{{MetricsHTMLTable .Metrics}}", event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<table")
+	assert.Contains(t, out, "mem.free")
+}
+
+func TestMetricTime(t *testing.T) {
+	expected := time.Unix(1600000000, 0)
+	assert.True(t, expected.Equal(metricTime(1600000000).Time))
+	assert.True(t, expected.Equal(metricTime(1600000000000).Time))
+	assert.True(t, expected.Equal(metricTime(1600000000000000).Time))
+	assert.True(t, expected.Equal(metricTime(1600000000000000000).Time))
+}
+
+func TestMetricsEvent(t *testing.T) {
+	memory := &transport.Memory{}
+	messageSender = memory
+	config.SmtpHost = "127.0.0.1"
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.ToEmail = []string{"ops@example.com"}
+	config.SubjectTemplate = "{{.Entity.Name}} metrics"
+	config.BodyTemplate = "{{MetricsTable .Metrics}}"
+	config.PriorityHeaders = true
+	defer func() {
+		messageSender = nil
+		config.SmtpHost = ""
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.ToEmail = nil
+		config.SubjectTemplate = ""
+		config.BodyTemplate = ""
+		config.PriorityHeaders = false
+		emailBodyTemplate = ""
+	}()
+
+	// metrics events have no check
+	event := &corev2.Event{Entity: corev2.FixtureEntity("server01"), Metrics: &corev2.Metrics{
+		Points: []*corev2.MetricPoint{{Name: "cpu.user", Value: 12.5, Timestamp: 1600000000}},
+	}}
+	assert.NoError(t, checkArgs(event))
+	assert.NoError(t, handleEvent(event))
+
+	messages := memory.Messages()
+	if !assert.Len(t, messages, 1) {
+		return
+	}
+	assert.Equal(t, []string{"ops@example.com"}, messages[0].To)
+	msg, err := mail.ReadMessage(strings.NewReader(string(messages[0].Data)))
+	assert.NoError(t, err)
+	assert.Equal(t, "server01 metrics", msg.Header.Get("Subject"))
+	assert.Equal(t, "server01", msg.Header.Get("X-Sensu-Entity"))
+	assert.Empty(t, msg.Header.Get("X-Sensu-Check"))
+	body, err := ioutil.ReadAll(msg.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "cpu.user  12.5")
+}
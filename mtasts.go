@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MTA-STS policy modes (RFC 8461)
+const (
+	mtaSTSEnforce = "enforce"
+	mtaSTSTesting = "testing"
+	mtaSTSNone    = "none"
+
+	// the longest a policy may be cached for
+	mtaSTSMaxAge = 31557600
+
+	// the largest policy fetched
+	mtaSTSMaxPolicySize = 64 << 10
+)
+
+// mtaSTSPolicyURL is the URL of the policy of a domain
+var mtaSTSPolicyURL = "https://mta-sts.%s/.well-known/mta-sts.txt"
+
+// mtaSTSClient fetches policies, which must not be redirected
+var mtaSTSClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// lookupTXT resolves the TXT records of a name
+var lookupTXT = net.LookupTXT
+
+// mtaSTSPolicy is the MTA-STS policy of a domain, as cached in the state
+// directory.
+type mtaSTSPolicy struct {
+	ID      string   `json:"id"`
+	Mode    string   `json:"mode"`
+	MX      []string `json:"mx"`
+	Expires int64    `json:"expires"`
+}
+
+// matches reports whether the MX host is one of the policy's, whose patterns
+// may start with a wildcard label.
+func (p *mtaSTSPolicy) matches(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.MX {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if i := strings.Index(host, "."); i > 0 && host[i:] == pattern[1:] {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func mtaSTSPolicyFile(domain string) string {
+	return filepath.Join(config.StateDir, fmt.Sprintf("mta-sts-%x.json", sha256.Sum256([]byte(domain))))
+}
+
+// mtaSTSPolicyFor returns the MTA-STS policy of the domain with --mtaSTS, or
+// nil if it has none. With --stateDir policies are cached until they expire,
+// so that a policy in force can't be dropped by tampering with DNS.
+func mtaSTSPolicyFor(domain string) (*mtaSTSPolicy, error) {
+	if !config.MTASTS {
+		return nil, nil
+	}
+	var cached *mtaSTSPolicy
+	if len(config.StateDir) > 0 {
+		if policyBytes, err := ioutil.ReadFile(mtaSTSPolicyFile(domain)); err == nil {
+			cached = &mtaSTSPolicy{}
+			if err := json.Unmarshal(policyBytes, cached); err != nil || cached.Expires < time.Now().Unix() {
+				cached = nil
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read MTA-STS policy: %v", err)
+		}
+	}
+
+	id, err := mtaSTSRecordID(domain)
+	if err != nil || len(id) == 0 || (cached != nil && cached.ID == id) {
+		if cached != nil {
+			debugf("using the cached MTA-STS policy of %s", domain)
+		}
+		return activeMTASTSPolicy(cached), nil
+	}
+	policy, err := fetchMTASTSPolicy(domain)
+	if err != nil {
+		// a policy that can't be fetched is ignored, unless one is cached
+		debugf("failed to fetch the MTA-STS policy of %s: %v", domain, err)
+		return activeMTASTSPolicy(cached), nil
+	}
+	policy.ID = id
+	debugf("MTA-STS policy of %s: mode %s, mx %s", domain, policy.Mode, strings.Join(policy.MX, ", "))
+	if len(config.StateDir) > 0 {
+		policyBytes, err := json.Marshal(policy)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeStateFile(mtaSTSPolicyFile(domain), policyBytes); err != nil {
+			return nil, err
+		}
+	}
+	return activeMTASTSPolicy(policy), nil
+}
+
+// activeMTASTSPolicy returns the policy unless it is nil or of mode none.
+func activeMTASTSPolicy(policy *mtaSTSPolicy) *mtaSTSPolicy {
+	if policy == nil || policy.Mode == mtaSTSNone {
+		return nil
+	}
+	return policy
+}
+
+// mtaSTSRecordID returns the policy ID of the domain's _mta-sts TXT record,
+// or an empty string if it has none.
+func mtaSTSRecordID(domain string) (string, error) {
+	records, err := lookupTXT("_mta-sts." + domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	var id string
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v=STSv1;") && record != "v=STSv1" {
+			continue
+		}
+		// more than one record means no policy
+		if len(id) > 0 {
+			return "", nil
+		}
+		for _, field := range strings.Split(record, ";") {
+			parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(parts) == 2 && parts[0] == "id" {
+				id = parts[1]
+			}
+		}
+	}
+	return id, nil
+}
+
+// fetchMTASTSPolicy fetches the policy of the domain from its policy host.
+func fetchMTASTSPolicy(domain string) (*mtaSTSPolicy, error) {
+	resp, err := mtaSTSClient.Get(fmt.Sprintf(mtaSTSPolicyURL, domain))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy request failed: %s", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		return nil, fmt.Errorf("policy has content type %q instead of text/plain", contentType)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, mtaSTSMaxPolicySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > mtaSTSMaxPolicySize {
+		return nil, errors.New("policy is too large")
+	}
+	return parseMTASTSPolicy(body)
+}
+
+// parseMTASTSPolicy parses the key: value lines of a policy.
+func parseMTASTSPolicy(body []byte) (*mtaSTSPolicy, error) {
+	policy := &mtaSTSPolicy{}
+	var version string
+	maxAge := -1
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "version":
+			version = value
+		case "mode":
+			policy.Mode = value
+		case "mx":
+			policy.MX = append(policy.MX, value)
+		case "max_age":
+			age, err := strconv.Atoi(value)
+			if err != nil || age < 0 {
+				return nil, fmt.Errorf("invalid policy max_age %q", value)
+			}
+			maxAge = age
+		}
+	}
+	if version != "STSv1" {
+		return nil, fmt.Errorf("unsupported policy version %q", version)
+	}
+	switch policy.Mode {
+	case mtaSTSEnforce, mtaSTSTesting:
+		if len(policy.MX) == 0 {
+			return nil, errors.New("policy has no mx")
+		}
+	case mtaSTSNone:
+	default:
+		return nil, fmt.Errorf("invalid policy mode %q", policy.Mode)
+	}
+	if maxAge < 0 {
+		return nil, errors.New("policy has no max_age")
+	}
+	if maxAge > mtaSTSMaxAge {
+		maxAge = mtaSTSMaxAge
+	}
+	policy.Expires = time.Now().Unix() + int64(maxAge)
+	return policy, nil
+}
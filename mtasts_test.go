@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	policy, err := parseMTASTSPolicy([]byte("version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.example.net\r\nmax_age: 604800\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, mtaSTSEnforce, policy.Mode)
+	assert.True(t, policy.matches("mail.example.com"))
+	assert.True(t, policy.matches("MX1.example.net."))
+	// wildcards match a single label
+	assert.False(t, policy.matches("a.b.example.net"))
+	assert.False(t, policy.matches("example.net"))
+	assert.False(t, policy.matches("mail.example.org"))
+
+	_, err = parseMTASTSPolicy([]byte("version: STSv1\nmode: enforce\nmax_age: 86400\n"))
+	assert.EqualError(t, err, "policy has no mx")
+	_, err = parseMTASTSPolicy([]byte("version: STSv2\nmode: none\nmax_age: 86400\n"))
+	assert.EqualError(t, err, `unsupported policy version "STSv2"`)
+}
+
+func TestMTASTSPolicyFor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var fetches int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: 86400\n")
+	}))
+	defer server.Close()
+	txt := []string{"v=STSv1; id=20200101"}
+	client := mtaSTSClient
+	config.MTASTS = true
+	config.StateDir = dir
+	mtaSTSClient = server.Client()
+	mtaSTSPolicyURL = server.URL + "/%s"
+	lookupTXT = func(name string) ([]string, error) {
+		if name != "_mta-sts.example.com" {
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		}
+		return txt, nil
+	}
+	defer func() {
+		config.MTASTS = false
+		config.StateDir = ""
+		mtaSTSClient = client
+		mtaSTSPolicyURL = "https://mta-sts.%s/.well-known/mta-sts.txt"
+		lookupTXT = net.LookupTXT
+	}()
+
+	policy, err := mtaSTSPolicyFor("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mx.example.com"}, policy.MX)
+	assert.Equal(t, 1, fetches)
+
+	// the cached policy is used while its ID is unchanged, or the record is
+	// removed
+	_, err = mtaSTSPolicyFor("example.com")
+	assert.NoError(t, err)
+	txt = nil
+	policy, err = mtaSTSPolicyFor("example.com")
+	assert.NoError(t, err)
+	assert.NotNil(t, policy)
+	assert.Equal(t, 1, fetches)
+	txt = []string{"v=STSv1; id=20200102"}
+	policy, err = mtaSTSPolicyFor("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "20200102", policy.ID)
+	assert.Equal(t, 2, fetches)
+
+	policy, err = mtaSTSPolicyFor("example.org")
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+}
+
+func TestDirectDeliveryMTASTS(t *testing.T) {
+	port, _ := startSMTPServer(t)
+
+	// an enforced policy skips other MX hosts and refuses cleartext
+	policy := &mtaSTSPolicy{Mode: mtaSTSEnforce, MX: []string{"127.0.0.1"}}
+	_, _, err := directTLS("mx.example.org", policy, false)
+	assert.EqualError(t, err, "mx.example.org is not an MX host of the enforced MTA-STS policy")
+	tlsConfig, requireTLS, err := directTLS("127.0.0.1", policy, false)
+	assert.NoError(t, err)
+	assert.True(t, requireTLS)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	conn, err := connectSMTP("127.0.0.1", port, tlsConfig, requireTLS, nil)
+	assert.Nil(t, conn)
+	assert.EqualError(t, err, "127.0.0.1 does not support STARTTLS, which is required")
+
+	// testing mode policies are not enforced
+	tlsConfig, requireTLS, err = directTLS("mx.example.org", &mtaSTSPolicy{Mode: mtaSTSTesting, MX: []string{"127.0.0.1"}}, false)
+	assert.NoError(t, err)
+	assert.False(t, requireTLS)
+	assert.True(t, strings.HasPrefix(tlsConfig.ServerName, "mx."))
+}
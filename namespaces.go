@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// namespaceSettingsMap is loaded from --namespacesFile
+var namespaceSettingsMap map[string]namespaceSettings
+
+// namespaceSettings are the From address and SMTP relay used for the events
+// of a namespace, read from --namespacesFile. Empty fields keep the value of
+// the corresponding flag.
+type namespaceSettings struct {
+	From         string `yaml:"from"`
+	EnvelopeFrom string `yaml:"envelopeFrom"`
+	SmtpHost     string `yaml:"smtpHost"`
+	SmtpPort     uint64 `yaml:"smtpPort"`
+	SmtpUsername string `yaml:"smtpUsername"`
+	SmtpPassword string `yaml:"smtpPassword"`
+	AuthMethod   string `yaml:"authMethod"`
+}
+
+// loadNamespaceSettings reads the YAML (or JSON) namespaces file, mapping
+// namespace names, or globs such as tenant-*, to their settings.
+func loadNamespaceSettings(file string) (map[string]namespaceSettings, error) {
+	settingsBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read namespaces file %s: %v", file, err)
+	}
+	var settings map[string]namespaceSettings
+	if err := yaml.UnmarshalStrict(settingsBytes, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse namespaces file %s: %v", file, err)
+	}
+	for pattern := range settings {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("namespaces file %s has an invalid pattern %q", file, pattern)
+		}
+	}
+	return settings, nil
+}
+
+// lookupNamespace returns the settings for the namespace: those of its name
+// if listed, otherwise those of the first (in sorted order) matching glob.
+func lookupNamespace(settings map[string]namespaceSettings, namespace string) (namespaceSettings, bool) {
+	if s, ok := settings[namespace]; ok {
+		return s, true
+	}
+	patterns := make([]string, 0, len(settings))
+	for pattern := range settings {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return settings[pattern], true
+		}
+	}
+	return namespaceSettings{}, false
+}
+
+// apply overrides the From address and SMTP relay configuration with the
+// namespace's settings. The SMTP credentials are replaced whenever the relay
+// or any credential is set, so the credentials of one relay are never sent to
+// another.
+func (s namespaceSettings) apply() {
+	if len(s.From) > 0 {
+		config.FromEmail = s.From
+	}
+	if len(s.EnvelopeFrom) > 0 {
+		config.EnvelopeFrom = s.EnvelopeFrom
+	}
+	if len(s.SmtpHost) > 0 || len(s.SmtpUsername) > 0 || len(s.SmtpPassword) > 0 {
+		if len(s.SmtpHost) > 0 {
+			config.SmtpHost = s.SmtpHost
+		}
+		config.SmtpUsername = s.SmtpUsername
+		config.SmtpPassword = s.SmtpPassword
+		config.SmtpUsernameFile = ""
+		config.SmtpPasswordFile = ""
+		config.VaultSecretPath = ""
+	}
+	if s.SmtpPort > 0 {
+		config.SmtpPort = s.SmtpPort
+	}
+	if len(s.AuthMethod) > 0 {
+		config.AuthMethod = s.AuthMethod
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceSettings(t *testing.T) {
+	file, err := ioutil.TempFile("", "namespaces")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`acme:
+  from: ACME Alerts <alerts@acme.example>
+  smtpHost: smtp.acme.example
+  smtpPort: 587
+  smtpUsername: acme
+  smtpPassword: acme-secret
+"tenant-*":
+  from: alerts@tenants.example
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	settings, err := loadNamespaceSettings(file.Name())
+	assert.NoError(t, err)
+	_, ok := lookupNamespace(settings, "default")
+	assert.False(t, ok)
+	s, ok := lookupNamespace(settings, "tenant-blue")
+	assert.True(t, ok)
+	assert.Equal(t, namespaceSettings{From: "alerts@tenants.example"}, s)
+
+	saved := config
+	defer func() { config = saved }()
+	config.FromEmail = "sensu@example.com"
+	config.SmtpHost = "smtp.example.com"
+	config.SmtpPort = 25
+	config.SmtpUsername = "sensu"
+	config.SmtpPasswordFile = "/etc/sensu/smtp-password"
+
+	s.apply()
+	assert.Equal(t, "alerts@tenants.example", config.FromEmail)
+	assert.Equal(t, "smtp.example.com", config.SmtpHost)
+	assert.Equal(t, "sensu", config.SmtpUsername)
+
+	s, _ = lookupNamespace(settings, "acme")
+	s.apply()
+	assert.Equal(t, "ACME Alerts <alerts@acme.example>", config.FromEmail)
+	assert.Equal(t, "smtp.acme.example", config.SmtpHost)
+	assert.Equal(t, uint64(587), config.SmtpPort)
+	assert.Equal(t, "acme", config.SmtpUsername)
+	assert.Equal(t, "acme-secret", config.SmtpPassword)
+	assert.Empty(t, config.SmtpPasswordFile)
+
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte("acme:\n  relay: smtp.acme.example\n"), 0600))
+	_, err = loadNamespaceSettings(file.Name())
+	assert.Error(t, err)
+}
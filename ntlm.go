@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/smtp"
+	"strings"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+// ntlmAuth implements NTLMv2 authentication for SMTP servers, such as
+// Exchange connectors, that offer no other mechanism.
+type ntlmAuth struct {
+	username, password string
+}
+
+// NTLMAuth returns an smtp.Auth authenticating the user of the domain with
+// NTLMv2. A username of the form DOMAIN\user gives the domain too.
+func NTLMAuth(domain, username, password string) smtp.Auth {
+	if len(domain) > 0 && !strings.Contains(username, `\`) {
+		username = domain + `\` + username
+	}
+	return &ntlmAuth{username, password}
+}
+
+// Start sends the negotiate message as the initial response.
+func (a *ntlmAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	msg, err := ntlmssp.NewNegotiateMessage("", "")
+	return "NTLM", msg, err
+}
+
+// Next answers the server's challenge with the authenticate message.
+func (a *ntlmAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return ntlmssp.NewAuthenticateMessage(fromServer, a.username, a.password, nil)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNTLMAuth(t *testing.T) {
+	// NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_NTLM | NTLMSSP_NEGOTIATE_TARGET_INFO
+	const challengeFlags = 0x00000001 | 0x00000200 | 0x00800000
+	utf16le := func(s string) []byte {
+		encoded := utf16.Encode([]rune(s))
+		b := make([]byte, 2*len(encoded))
+		for i, r := range encoded {
+			binary.LittleEndian.PutUint16(b[2*i:], r)
+		}
+		return b
+	}
+
+	for _, auth := range []*ntlmAuth{
+		NTLMAuth("", `EXAMPLE\sensu`, "s3cret").(*ntlmAuth),
+		NTLMAuth("EXAMPLE", "sensu", "s3cret").(*ntlmAuth),
+	} {
+		mechanism, negotiate, err := auth.Start(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "NTLM", mechanism)
+		assert.Equal(t, "NTLMSSP\x00\x01\x00\x00\x00", string(negotiate[:12]))
+
+		// a challenge with the server's time in its target info
+		targetInfo, _ := hex.DecodeString("07000800" + "0011223344556677" + "00000000")
+		challenge := make([]byte, 48)
+		copy(challenge, "NTLMSSP\x00\x02")
+		binary.LittleEndian.PutUint32(challenge[20:], challengeFlags)
+		copy(challenge[24:], "\x01\x02\x03\x04\x05\x06\x07\x08")
+		binary.LittleEndian.PutUint16(challenge[40:], uint16(len(targetInfo)))
+		binary.LittleEndian.PutUint16(challenge[42:], uint16(len(targetInfo)))
+		binary.LittleEndian.PutUint32(challenge[44:], 48)
+		challenge = append(challenge, targetInfo...)
+		msg, err := auth.Next(challenge, true)
+		assert.NoError(t, err)
+		assert.Equal(t, "NTLMSSP\x00\x03\x00\x00\x00", string(msg[:12]))
+
+		field := func(offset int) []byte {
+			length := int(binary.LittleEndian.Uint16(msg[offset:]))
+			start := int(binary.LittleEndian.Uint32(msg[offset+4:]))
+			return msg[start : start+length]
+		}
+		assert.Equal(t, utf16le("EXAMPLE"), field(28))
+		assert.Equal(t, utf16le("sensu"), field(36))
+		// the NTLMv2 response covers the server's time, the password is
+		// never sent
+		nt := field(20)
+		assert.Equal(t, "0011223344556677", hex.EncodeToString(nt[24:32]))
+		assert.NotContains(t, string(msg), string(utf16le("s3cret")))
+
+		_, err = auth.Next([]byte("not a challenge"), true)
+		assert.Error(t, err)
+		msg, err = auth.Next(nil, false)
+		assert.NoError(t, err)
+		assert.Nil(t, msg)
+	}
+}
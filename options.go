@@ -0,0 +1,588 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// checkArgs validates the options, loading the templates, keys and other
+// state they refer to.
+func checkArgs(event *corev2.Event) error {
+	// init asks for the configuration, checking it before the test email
+	if mode == modeInit {
+		return nil
+	}
+	// the namespace's From address and relay take the place of the flags
+	if len(config.NamespacesFile) > 0 {
+		settings, nsErr := loadNamespaceSettings(config.NamespacesFile)
+		if nsErr != nil {
+			return nsErr
+		}
+		namespaceSettingsMap = settings
+		// spooled emails are sent through the relay of their own namespace,
+		// as are the emails for each event received with --listen
+		if event != nil && event.Entity != nil && mode != modeFlushSpool && mode != modeDaemon {
+			if s, ok := lookupNamespace(settings, event.Entity.Namespace); ok {
+				s.apply()
+			}
+		}
+	}
+	if mode == modeCapture && len(config.CaptureDir) == 0 {
+		return errors.New("capture mode requires --captureDir")
+	}
+	// validating templates and capturing emails require no SMTP
+	// configuration, and checking the connection or flushing the spool no
+	// addresses
+	if mode != modeValidate && mode != modeCapture {
+		if config.DirectDelivery && len(config.SmtpHost) > 0 {
+			return errors.New("--directDelivery and --smtpHost (-s) are mutually exclusive")
+		}
+		if (config.MTASTS || config.DANE) && !config.DirectDelivery {
+			return errors.New("--mtaSTS and --dane require --directDelivery")
+		}
+		if config.DirectDelivery && len(config.RedisURL) > 0 {
+			return errors.New("--directDelivery and --redisURL are mutually exclusive")
+		}
+		if len(config.SmtpHost) == 0 && ((!config.DirectDelivery && len(config.CaptureDir) == 0 && len(config.RedisURL) == 0) || mode == modeCheckConnection) {
+			return errors.New("missing smtp host")
+		}
+		if mode != modeCheckConnection && mode != modeFlushSpool {
+			if len(config.ToEmail) == 0 && len(config.ContactsFile) == 0 && len(config.RoutingRulesFile) == 0 &&
+				len(configSections.contacts.data) == 0 && len(configSections.routingRules.data) == 0 {
+				return errors.New("missing destination email address")
+			}
+			if len(config.FromEmail) == 0 {
+				return errors.New("from email is empty")
+			}
+		}
+	}
+	if config.SmtpPort > math.MaxUint16 {
+		return errors.New("smtp port is out of range")
+	}
+	if len(config.HeloHost) == 0 {
+		// net/smtp would otherwise send localhost, which strict relays reject
+		if hostname, err := os.Hostname(); err == nil {
+			config.HeloHost = hostname
+		}
+	} else if strings.ContainsAny(config.HeloHost, " \t\r\n") {
+		return fmt.Errorf("--%s %q is not a host name", heloHost, config.HeloHost)
+	}
+	if config.SmtpRetries < 0 {
+		return errors.New("--smtpRetries must not be negative")
+	}
+	if err := checkDSN(); err != nil {
+		return err
+	}
+	if mode == modeDaemon {
+		if _, _, err := parseListenAddress(config.Listen); err != nil {
+			return err
+		}
+	}
+	if mode == modeFlushSpool && len(config.SpoolDir) == 0 {
+		return errors.New("--flushSpool requires --spoolDir")
+	}
+	if len(config.SpoolDir) > 0 {
+		if err := os.MkdirAll(config.SpoolDir, 0700); err != nil {
+			return fmt.Errorf("failed to create spool directory %s: %v", config.SpoolDir, err)
+		}
+	}
+	if len(config.SpoolMaxAge) > 0 {
+		maxAge, durationErr := time.ParseDuration(config.SpoolMaxAge)
+		if durationErr != nil || maxAge < 0 {
+			return fmt.Errorf("invalid spool max age %s", config.SpoolMaxAge)
+		}
+		spoolMaxDuration = maxAge
+	}
+	if len(config.CaptureDir) > 0 {
+		if err := os.MkdirAll(config.CaptureDir, 0700); err != nil {
+			return fmt.Errorf("failed to create capture directory %s: %v", config.CaptureDir, err)
+		}
+	}
+
+	// translate deprecated options to replacements
+	if config.LoginAuth {
+		config.AuthMethod = AuthMethodLogin
+	}
+	if config.Insecure {
+		config.SmtpPort = 25
+		config.AuthMethod = AuthMethodNone
+		config.TLSSkipVerify = true
+	}
+
+	switch config.AuthMethod {
+	case AuthMethodPlain, AuthMethodNone, AuthMethodLogin, AuthMethodNTLM, AuthMethodGSSAPI, AuthMethodAuto:
+	case "":
+		config.AuthMethod = AuthMethodPlain
+	default:
+		return fmt.Errorf("%s is not a valid auth method", config.AuthMethod)
+	}
+	if mode != modeValidate {
+		if err := resolveSecrets(event); err != nil {
+			return err
+		}
+		if err := checkRedisQueue(); err != nil {
+			return err
+		}
+	}
+	if len(config.SmtpUsernameFile) > 0 {
+		username, fileErr := readCredentialFile(config.SmtpUsernameFile, config.SmtpUsername, smtpUsername)
+		if fileErr != nil {
+			return fileErr
+		}
+		config.SmtpUsername = username
+	}
+	if len(config.SmtpPasswordFile) > 0 {
+		password, fileErr := readCredentialFile(config.SmtpPasswordFile, config.SmtpPassword, smtpPassword)
+		if fileErr != nil {
+			return fileErr
+		}
+		config.SmtpPassword = password
+	}
+	if len(config.VaultSecretPath) > 0 && mode != modeValidate {
+		if len(config.VaultAddress) == 0 {
+			return errors.New("--vaultAddress is required with --vaultSecretPath")
+		}
+		if err := loadVaultCredentials(); err != nil {
+			return err
+		}
+	}
+	// MX hosts take no authentication, and captured or queued emails are not
+	// sent
+	if config.AuthMethod != AuthMethodNone && config.AuthMethod != AuthMethodGSSAPI && !config.DirectDelivery && len(config.CaptureDir) == 0 &&
+		len(config.RedisURL) == 0 && mode != modeValidate {
+		if len(config.SmtpUsername) == 0 {
+			return errors.New("smtp username is empty, give it with --smtpUsername (-u), env SMTP_USERNAME (e.g. from a Sensu secret), --smtpUsernameFile or --vaultSecretPath")
+		}
+		// an OAuth 2.0 token can stand in for the password with auto
+		if len(config.SmtpPassword) == 0 && (config.AuthMethod != AuthMethodAuto || len(config.SmtpOAuth2Token) == 0) {
+			return errors.New("smtp password is empty, give it with --smtpPassword (-p), env SMTP_PASSWORD (e.g. from a Sensu secret), --smtpPasswordFile or --vaultSecretPath")
+		}
+	}
+
+	if config.Hookout && len(config.BodyTemplateFile) > 0 {
+		return errors.New("--hookout (-H) and --bodyTemplateFile (-T) are mutually exclusive, use {{.HookOutput \"name\"}} or {{range .Check.Hooks}} to include hook output in the template")
+	}
+	if len(config.BodyTemplateSHA256) > 0 && len(config.BodyTemplateFile) == 0 {
+		return errors.New("--bodyTemplateSHA256 requires --bodyTemplateFile (-T)")
+	}
+	if len(config.BodyTemplateName) > 0 && (config.Hookout || len(config.BodyTemplateFile) > 0) {
+		return errors.New("--bodyTemplateName is mutually exclusive with --hookout (-H) and --bodyTemplateFile (-T)")
+	}
+	if len(config.TLSCAFile) > 0 {
+		pool, caErr := loadCAFile(config.TLSCAFile)
+		if caErr != nil {
+			return caErr
+		}
+		tlsRootCAs = pool
+	}
+	if err := parseSMTPSource(config.LocalAddr, config.IPVersion); err != nil {
+		return err
+	}
+	if err := parseTLSOptions(config.TLSMinVersion, config.TLSCipherSuites); err != nil {
+		return err
+	}
+	if len(config.TemplateToken) > 0 && len(config.TemplateUsername) > 0 {
+		return errors.New("--templateToken and --templateUsername are mutually exclusive")
+	}
+	for _, h := range config.TemplateHeaders {
+		if _, _, err := parseTemplateHeader(h); err != nil {
+			return err
+		}
+	}
+	if len(config.TemplateCacheTTL) > 0 {
+		if len(config.TemplateCacheDir) == 0 {
+			return errors.New("--templateCacheTTL requires --templateCacheDir")
+		}
+		ttl, durationErr := time.ParseDuration(config.TemplateCacheTTL)
+		if durationErr != nil || ttl < 0 {
+			return fmt.Errorf("invalid template cache TTL %s", config.TemplateCacheTTL)
+		}
+		templateCacheDuration = ttl
+	}
+	if len(config.TemplateDelims) > 0 {
+		left, right, delimsErr := parseTemplateDelims(config.TemplateDelims)
+		if delimsErr != nil {
+			return delimsErr
+		}
+		templateLeftDelim, templateRightDelim = left, right
+	}
+	catalog, localeErr := loadTranslations(config.Locale, config.TranslationFile)
+	if localeErr != nil {
+		return localeErr
+	}
+	activeTranslations = catalog
+	// an inline template takes precedence, so that it can be given in a check
+	// annotation to override the handler's template
+	if len(config.BodyTemplate) > 0 {
+		if len(config.BodyTemplateSHA256) > 0 {
+			return errors.New("--bodyTemplate cannot be used with --bodyTemplateSHA256")
+		}
+		emailBodyTemplate = config.BodyTemplate
+	} else if len(config.BodyTemplateName) > 0 {
+		builtin, ok := builtinTemplates[config.BodyTemplateName]
+		if !ok {
+			return fmt.Errorf("%s is not a valid built-in template name", config.BodyTemplateName)
+		}
+		emailBodyTemplate = builtin
+	} else if config.Hookout {
+		emailBodyTemplate = hookoutBodyTemplate
+	} else if len(config.BodyTemplateFile) > 0 {
+		templateBytes, fileErr := readTemplateFile(config.BodyTemplateFile)
+		if fileErr != nil {
+			return fmt.Errorf("failed to read specified template file %s: %v", config.BodyTemplateFile, fileErr)
+		}
+		if len(config.BodyTemplateSHA256) > 0 {
+			if err := verifyTemplateChecksum(config.BodyTemplateFile, templateBytes, config.BodyTemplateSHA256); err != nil {
+				return err
+			}
+		}
+		emailBodyTemplate = string(templateBytes)
+	}
+	if len(config.SubjectTemplateFile) > 0 {
+		templateBytes, fileErr := readTemplateFile(config.SubjectTemplateFile)
+		if fileErr != nil {
+			return fmt.Errorf("failed to read specified subject template file %s: %v", config.SubjectTemplateFile, fileErr)
+		}
+		// the trailing newline of the file is not part of the subject
+		config.SubjectTemplate = strings.TrimRight(string(templateBytes), "\r\n")
+	}
+	if err := loadTemplatePartials(config.TemplatePartials); err != nil {
+		return err
+	}
+	if len(config.ResolvedBodyTemplateFile) > 0 {
+		templateBytes, fileErr := readTemplateFile(config.ResolvedBodyTemplateFile)
+		if fileErr != nil {
+			return fmt.Errorf("failed to read specified resolved template file %s: %v", config.ResolvedBodyTemplateFile, fileErr)
+		}
+		resolvedBodyTemplate = string(templateBytes)
+	}
+	if len(config.KeepaliveBodyTemplateFile) > 0 {
+		templateBytes, fileErr := readTemplateFile(config.KeepaliveBodyTemplateFile)
+		if fileErr != nil {
+			return fmt.Errorf("failed to read specified keepalive template file %s: %v", config.KeepaliveBodyTemplateFile, fileErr)
+		}
+		keepaliveBodyTemplate = string(templateBytes)
+	}
+	if len(config.DigestBodyTemplateFile) > 0 {
+		templateBytes, fileErr := readTemplateFile(config.DigestBodyTemplateFile)
+		if fileErr != nil {
+			return fmt.Errorf("failed to read specified digest template file %s: %v", config.DigestBodyTemplateFile, fileErr)
+		}
+		digestBodyTemplate = string(templateBytes)
+	}
+	if len(config.DigestSubjectTemplate) == 0 {
+		config.DigestSubjectTemplate = defaultDigestSubjectTemplate
+	}
+
+	if len(config.DedupWindow) > 0 {
+		if len(config.StateDir) == 0 {
+			return errors.New("--dedupWindow requires --stateDir")
+		}
+		window, durationErr := time.ParseDuration(config.DedupWindow)
+		if durationErr != nil || window < 0 {
+			return fmt.Errorf("invalid dedup window %s", config.DedupWindow)
+		}
+		dedupDuration = window
+	}
+	if len(config.ProxyGroupLabel) > 0 {
+		if len(config.StateDir) == 0 {
+			return errors.New("--proxyGroupLabel requires --stateDir")
+		}
+		window, durationErr := time.ParseDuration(config.ProxyGroupWindow)
+		if durationErr != nil || window < 0 {
+			return fmt.Errorf("invalid proxy group window %s", config.ProxyGroupWindow)
+		}
+		proxyGroupDuration = window
+	}
+	if len(config.FilterExpression) > 0 {
+		expr, exprErr := parseFilterExpression(config.FilterExpression)
+		if exprErr != nil {
+			return fmt.Errorf("invalid --%s %q: %v", filterExpression, config.FilterExpression, exprErr)
+		}
+		filterExpr = expr
+	}
+	if config.MaxEmailsPerHour < 0 {
+		return errors.New("--maxEmailsPerHour must not be negative")
+	}
+	if config.MaxEmailsPerHour > 0 && len(config.StateDir) == 0 {
+		return errors.New("--maxEmailsPerHour requires --stateDir")
+	}
+	if config.SuppressFlapping && (config.LowFlapThreshold < 0 || config.LowFlapThreshold >= config.HighFlapThreshold || config.HighFlapThreshold > 100) {
+		return fmt.Errorf("invalid flap thresholds %d and %d, 0 <= --%s < --%s <= 100", config.LowFlapThreshold, config.HighFlapThreshold, lowFlapThreshold, highFlapThreshold)
+	}
+	if config.ResolveOnlyAfterAlert && len(config.StateDir) == 0 {
+		return errors.New("--resolveOnlyAfterAlert requires --stateDir")
+	}
+	if config.Threading && len(config.StateDir) == 0 {
+		return errors.New("--threading requires --stateDir")
+	}
+	if len(config.MaintenanceFile) > 0 {
+		windows, windowsErr := loadMaintenanceWindows(config.MaintenanceFile)
+		if windowsErr != nil {
+			return windowsErr
+		}
+		for _, window := range windows {
+			if window.Action == maintenanceQueue && len(config.StateDir) == 0 {
+				return fmt.Errorf("maintenance window %s queues events, which requires --stateDir", window.Name)
+			}
+		}
+		maintenanceWindows = windows
+	}
+	if len(config.BusinessHours) > 0 {
+		if len(config.StateDir) == 0 {
+			return errors.New("--businessHours requires --stateDir")
+		}
+		hours, hoursErr := parseBusinessHours(config.BusinessHours)
+		if hoursErr != nil {
+			return hoursErr
+		}
+		businessHourRanges = hours
+	}
+	if len(config.StateDir) > 0 {
+		if err := os.MkdirAll(config.StateDir, 0700); err != nil {
+			return fmt.Errorf("failed to create state directory %s: %v", config.StateDir, err)
+		}
+	}
+
+	if config.MinOccurrences < 0 {
+		return errors.New("--minOccurrences must not be negative")
+	}
+	if config.EscalationOccurrences < 0 {
+		return errors.New("--escalationOccurrences must not be negative")
+	}
+	if (config.EscalationOccurrences > 0) != (len(config.EscalationToEmail) > 0) {
+		return errors.New("--escalationToEmail and --escalationOccurrences must be used together")
+	}
+
+	if len(config.DashboardURL) > 0 {
+		u, urlErr := url.Parse(config.DashboardURL)
+		if urlErr != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("invalid dashboard URL %s", config.DashboardURL)
+		}
+	}
+	if len(config.SilenceURL) > 0 {
+		duration, durationErr := time.ParseDuration(config.SilenceDuration)
+		if durationErr != nil || duration <= 0 {
+			return fmt.Errorf("invalid silence duration %s", config.SilenceDuration)
+		}
+		silenceLinkDuration = duration
+	}
+	if len(config.SensuAPIURL) > 0 {
+		u, urlErr := url.Parse(config.SensuAPIURL)
+		if urlErr != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("invalid Sensu API URL %s", config.SensuAPIURL)
+		}
+	}
+	if len(config.StatsdAddress) > 0 {
+		if _, _, err := net.SplitHostPort(config.StatsdAddress); err != nil {
+			return fmt.Errorf("invalid statsd address %s, must be host:port", config.StatsdAddress)
+		}
+	}
+	if len(config.FallbackWebhookURL) > 0 {
+		u, urlErr := url.Parse(config.FallbackWebhookURL)
+		if urlErr != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			// the URL of a Slack webhook is a secret, so isn't in the error
+			return errors.New("invalid fallback webhook URL, must be an http or https URL")
+		}
+	}
+	if len(config.PushgatewayURL) > 0 {
+		u, urlErr := url.Parse(config.PushgatewayURL)
+		if urlErr != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("invalid pushgateway URL %s", config.PushgatewayURL)
+		}
+	}
+	switch config.FailOn {
+	case "", FailOnAny, FailOnAll:
+	default:
+		return fmt.Errorf("invalid --failOn %q, must be %s or %s", config.FailOn, FailOnAny, FailOnAll)
+	}
+	if config.AnnotateEvent && len(config.SensuAPIURL) == 0 {
+		return errors.New("--annotateEvent requires --sensuAPIURL")
+	}
+	switch config.Silenced {
+	case "":
+	case SilencedTemplate, SilencedNote, SilencedSuppress:
+		if len(config.SensuAPIURL) == 0 {
+			return errors.New("--silenced requires --sensuAPIURL")
+		}
+	default:
+		return fmt.Errorf("invalid --silenced %q, must be %s, %s or %s", config.Silenced, SilencedTemplate, SilencedNote, SilencedSuppress)
+	}
+
+	switch config.BodyFormat {
+	case BodyFormatTemplate, BodyFormatMarkdown, BodyFormatHTML:
+	case "":
+		config.BodyFormat = BodyFormatTemplate
+	default:
+		return fmt.Errorf("%s is not a valid body format", config.BodyFormat)
+	}
+
+	switch config.ContentType {
+	case ContentPlain, ContentHTML, ContentAuto:
+	case "":
+		config.ContentType = ContentAuto
+	default:
+		return fmt.Errorf("%s is not a valid content type", config.ContentType)
+	}
+	if config.MaxBodySize < 0 {
+		return errors.New("--maxBodySize must not be negative")
+	}
+
+	if len(config.DateFormat) == 0 {
+		config.DateFormat = defaultDateFormat
+	}
+	if len(config.Timezone) > 0 {
+		loc, locErr := time.LoadLocation(config.Timezone)
+		if locErr != nil {
+			return fmt.Errorf("invalid timezone %s: %v", config.Timezone, locErr)
+		}
+		templateLocation = loc
+	}
+
+	for _, h := range config.ExtraHeaders {
+		if _, _, err := parseExtraHeader(h); err != nil {
+			return err
+		}
+	}
+
+	if len(config.Charset) == 0 {
+		config.Charset = defaultCharset
+	}
+	enc, encErr := ianaindex.MIME.Encoding(config.Charset)
+	if encErr != nil || enc == nil {
+		return fmt.Errorf("%s is not a supported charset", config.Charset)
+	}
+	if name, nameErr := ianaindex.MIME.Name(enc); nameErr == nil {
+		config.Charset = strings.ToLower(name)
+	}
+	bodyEncoding = enc
+
+	if len(config.FromEmail) > 0 {
+		fromAddr, addrErr := mail.ParseAddress(config.FromEmail)
+		if addrErr != nil {
+			return addrErr
+		}
+		config.FromEmail = fromAddr.Address
+		config.FromHeader = mailer.FormatAddress(fromAddr.String())
+	}
+	if len(config.EnvelopeFrom) > 0 {
+		envelopeAddr, addrErr := mail.ParseAddress(config.EnvelopeFrom)
+		if addrErr != nil {
+			return fmt.Errorf("invalid --%s: %v", envelopeFrom, addrErr)
+		}
+		config.EnvelopeFrom = envelopeAddr.Address
+	}
+
+	if strings.ContainsAny(config.MessageIDDomain, "<>@ \t") {
+		return fmt.Errorf("invalid --%s: %s", messageIDDomain, config.MessageIDDomain)
+	}
+
+	if len(config.DKIMPrivateKeyFile) > 0 {
+		if len(config.DKIMSelector) == 0 {
+			return errors.New("--dkimSelector is required when using --dkimPrivateKeyFile")
+		}
+		if len(config.DKIMDomain) == 0 {
+			config.DKIMDomain = fromDomain()
+		}
+		key, keyErr := loadDKIMKey(config.DKIMPrivateKeyFile)
+		if keyErr != nil {
+			return keyErr
+		}
+		dkimKey = key
+	}
+
+	if len(config.SMIMECertFile) > 0 || len(config.SMIMEKeyFile) > 0 {
+		if len(config.SMIMECertFile) == 0 || len(config.SMIMEKeyFile) == 0 {
+			return errors.New("--smimeCertFile and --smimeKeyFile must be used together")
+		}
+		signer, smimeErr := loadSMIMESigner(config.SMIMECertFile, config.SMIMEKeyFile)
+		if smimeErr != nil {
+			return smimeErr
+		}
+		smime = signer
+	}
+
+	if len(config.ContactsFile) > 0 {
+		loaded, contactsErr := loadContacts(config.ContactsFile)
+		if contactsErr != nil {
+			return contactsErr
+		}
+		contacts = loaded
+	} else if len(configSections.contacts.data) > 0 {
+		loaded, contactsErr := parseContacts(configSections.contacts.data, configSections.contacts.source)
+		if contactsErr != nil {
+			return contactsErr
+		}
+		contacts = loaded
+	}
+
+	if len(config.AddressBookFile) > 0 {
+		book, bookErr := loadAddressBook(config.AddressBookFile)
+		if bookErr != nil {
+			return bookErr
+		}
+		addressBook = book
+	} else if len(configSections.addressBook.data) > 0 {
+		book, bookErr := parseAddressBook(configSections.addressBook.data, configSections.addressBook.source)
+		if bookErr != nil {
+			return bookErr
+		}
+		addressBook = book
+	}
+
+	if len(config.LDAPURL) > 0 {
+		if !strings.HasPrefix(config.LDAPURL, "ldap://") && !strings.HasPrefix(config.LDAPURL, "ldaps://") {
+			return fmt.Errorf("--%s must be an ldap:// or ldaps:// URL", ldapURL)
+		}
+		if _, filterErr := ldap.CompileFilter(strings.Replace(config.LDAPOwnerFilter, "%s", "owner", -1)); filterErr != nil {
+			return fmt.Errorf("invalid --%s: %v", ldapOwnerFilter, filterErr)
+		}
+	}
+
+	if len(config.RoutingRulesFile) > 0 {
+		rules, rulesErr := loadRoutingRules(config.RoutingRulesFile)
+		if rulesErr != nil {
+			return rulesErr
+		}
+		routingRules = rules
+	} else if len(configSections.routingRules.data) > 0 {
+		rules, rulesErr := parseRoutingRules(configSections.routingRules.data, configSections.routingRules.source)
+		if rulesErr != nil {
+			return rulesErr
+		}
+		routingRules = rules
+	}
+
+	if len(config.PGPKeyserver) > 0 {
+		u, urlErr := url.Parse(config.PGPKeyserver)
+		if urlErr != nil || u.Scheme != "https" || len(u.Host) == 0 {
+			return fmt.Errorf("invalid PGP keyserver %s, must be an https URL", config.PGPKeyserver)
+		}
+	}
+	if len(config.PGPPublicKeyFiles) > 0 {
+		keys, pgpErr := loadPGPKeyFiles(config.PGPPublicKeyFiles)
+		if pgpErr != nil {
+			return pgpErr
+		}
+		pgpKeys = keys
+	}
+
+	patterns, err := compileRedactPatterns(config.RedactPatterns)
+	if err != nil {
+		return err
+	}
+	redactPatterns = patterns
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+
+	// keys without hash preferences require RIPEMD-160 to be available
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// loadPGPKeyFiles reads ASCII armored PGP public keys from the given files.
+func loadPGPKeyFiles(files []string) (openpgp.EntityList, error) {
+	var keys openpgp.EntityList
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PGP public key file %s", file)
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid PGP public key file %s: %v", file, err)
+		}
+		keys = append(keys, entities...)
+	}
+	return keys, nil
+}
+
+// pgpKeyserverClient queries the keyserver given with --pgpKeyserver
+var pgpKeyserverClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchPGPKeys looks up the public key of each address on an HKP keyserver.
+// Only keys with a user ID for the address are used, as a keyserver may
+// return keys that anyone uploaded for a search.
+func fetchPGPKeys(keyserver string, addresses []string) (openpgp.EntityList, error) {
+	var keys openpgp.EntityList
+	for _, address := range addresses {
+		lookup := strings.TrimSuffix(keyserver, "/") + "/pks/lookup?op=get&options=mr&search=" + url.QueryEscape(address)
+		resp, err := pgpKeyserverClient.Get(lookup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query PGP keyserver for %s: %v", address, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query PGP keyserver for %s: %v", address, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("no PGP public key found for %s on keyserver (%s)", address, resp.Status)
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PGP public key for %s from keyserver: %v", address, err)
+		}
+		matched := pgpKeysFor(entities, address)
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no PGP public key with a user ID for %s found on keyserver", address)
+		}
+		keys = append(keys, matched...)
+	}
+	return keys, nil
+}
+
+// pgpKeysFor returns the keys with a user ID for the address.
+func pgpKeysFor(entities openpgp.EntityList, address string) openpgp.EntityList {
+	var matched openpgp.EntityList
+	for _, entity := range entities {
+		for _, identity := range entity.Identities {
+			if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, address) {
+				matched = append(matched, entity)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// pgpEncrypt encrypts the MIME entity (its content headers and body) to the
+// given keys and returns it as a PGP/MIME multipart/encrypted entity.
+func pgpEncrypt(entity *mailer.Part, keys openpgp.EntityList) (*mailer.Part, error) {
+	var encrypted bytes.Buffer
+	armored, err := armor.Encode(&encrypted, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openpgp.Encrypt(armored, keys, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to PGP encrypt email: %v", err)
+	}
+	if _, err := plaintext.Write(entity.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := plaintext.Close(); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+
+	version := mailer.New7bit("application/pgp-encrypted", nil, "Version: 1\n")
+	version.Header.Add("Content-Description", "PGP/MIME version identification")
+	message := mailer.New7bit("application/octet-stream", map[string]string{"name": "encrypted.asc"}, encrypted.String()+"\n")
+	message.Header.Add("Content-Description", "OpenPGP encrypted message")
+	message.Header.Add("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": "encrypted.asc"}))
+	multipart := mailer.NewMultipart("encrypted", map[string]string{"protocol": "application/pgp-encrypted"}, version, message)
+	multipart.SetPreamble("This is an OpenPGP/MIME encrypted message (RFC 4880 and 3156)")
+	return multipart, nil
+}
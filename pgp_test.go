@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) []byte {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(w))
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestPGPEncrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgp")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recipient, err := openpgp.NewEntity("Ops", "", "ops@example.com", nil)
+	assert.NoError(t, err)
+	keyFile := filepath.Join(dir, "ops.asc")
+	assert.NoError(t, ioutil.WriteFile(keyFile, armoredPublicKey(t, recipient), 0600))
+
+	keys, err := loadPGPKeyFiles([]string{keyFile})
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	entity := "Content-Type: text/plain; charset=utf-8\r\n\r\ncheck output\r\n"
+	encryptedPart, err := pgpEncrypt(mailer.Raw([]byte(entity)), keys)
+	assert.NoError(t, err)
+	encrypted := string(encryptedPart.Bytes())
+	parts := strings.SplitN(encrypted, "\r\n\r\n", 2)
+	mediaType, params, err := mime.ParseMediaType(strings.TrimPrefix(parts[0], "Content-Type: "))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/encrypted", mediaType)
+	assert.Equal(t, "application/pgp-encrypted", params["protocol"])
+	assert.NotContains(t, encrypted, "check output")
+
+	start := strings.Index(encrypted, "-----BEGIN PGP MESSAGE-----")
+	end := strings.Index(encrypted, "-----END PGP MESSAGE-----")
+	block, err := armor.Decode(strings.NewReader(encrypted[start : end+len("-----END PGP MESSAGE-----")]))
+	assert.NoError(t, err)
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{recipient}, nil, nil)
+	assert.NoError(t, err)
+	decrypted, err := ioutil.ReadAll(md.UnverifiedBody)
+	assert.NoError(t, err)
+	assert.Equal(t, entity, string(decrypted))
+}
+
+func TestFetchPGPKeys(t *testing.T) {
+	recipient, err := openpgp.NewEntity("Ops", "", "ops@example.com", nil)
+	assert.NoError(t, err)
+	key := armoredPublicKey(t, recipient)
+	impostor, err := openpgp.NewEntity("Dev", "", "mallory@example.com", nil)
+	assert.NoError(t, err)
+	impostorKey := armoredPublicKey(t, impostor)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/pks/lookup", r.URL.Path)
+		assert.Equal(t, "get", r.URL.Query().Get("op"))
+		switch r.URL.Query().Get("search") {
+		case "ops@example.com":
+			_, _ = w.Write(key)
+			_, _ = w.Write(impostorKey)
+		case "dev@example.com":
+			_, _ = w.Write(impostorKey)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := pgpKeyserverClient
+	pgpKeyserverClient = server.Client()
+	defer func() { pgpKeyserverClient = client }()
+
+	keys, err := fetchPGPKeys(server.URL, []string{"ops@example.com"})
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, recipient.PrimaryKey.KeyId, keys[0].PrimaryKey.KeyId)
+	}
+
+	_, err = fetchPGPKeys(server.URL, []string{"ops@example.com", "dev@example.com"})
+	assert.EqualError(t, err, "no PGP public key with a user ID for dev@example.com found on keyserver")
+
+	_, err = fetchPGPKeys(server.URL, []string{"ops@example.com", "nobody@example.com"})
+	assert.Error(t, err)
+}
+
+func TestCheckArgsPGPKeyserver(t *testing.T) {
+	config.SmtpHost = "127.0.0.1"
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.ToEmail = []string{"ops@example.com"}
+	defer func() {
+		config.SmtpHost = ""
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.ToEmail = nil
+		config.PGPKeyserver = ""
+	}()
+	event := corev2.FixtureEvent("foo", "bar")
+	config.PGPKeyserver = "https://keys.openpgp.org"
+	assert.NoError(t, checkArgs(event))
+	config.PGPKeyserver = "http://keys.openpgp.org"
+	assert.EqualError(t, checkArgs(event), "invalid PGP keyserver http://keys.openpgp.org, must be an https URL")
+}
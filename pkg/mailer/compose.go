@@ -0,0 +1,44 @@
+package mailer
+
+import "time"
+
+// Email is the content of an email, from which Compose builds the message.
+type Email struct {
+	From string
+	// To and Cc are the recipients shown in the header, any bcc recipients
+	// are only in the envelope
+	To, Cc    []string
+	Subject   string
+	Date      time.Time
+	MessageID string
+	Body      *Part
+	// Attachments, if any, follow the body in a multipart/mixed entity
+	Attachments []*Part
+}
+
+// Compose returns the message of the email. It is marked as auto-generated,
+// so that vacation auto-responders do not reply to it (RFC 3834). Further
+// header fields can be added to its Header, and its Body replaced, e.g. by a
+// signed entity.
+func Compose(e Email) *Message {
+	body := e.Body
+	if len(e.Attachments) > 0 {
+		body = NewMultipart("mixed", nil, append([]*Part{body}, e.Attachments...)...)
+	}
+
+	var header Header
+	header.Add("From", e.From)
+	if len(e.To) > 0 {
+		header.AddAddressList("To", e.To)
+	} else {
+		header.Add("To", "undisclosed-recipients:;")
+	}
+	if len(e.Cc) > 0 {
+		header.AddAddressList("Cc", e.Cc)
+	}
+	header.Add("Subject", e.Subject)
+	header.Add("Date", e.Date.Format(time.RFC1123Z))
+	header.Add("Message-ID", e.MessageID)
+	header.Add("Auto-Submitted", "auto-generated")
+	return &Message{Header: header, Body: body}
+}
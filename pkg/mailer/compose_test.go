@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"bytes"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompose(t *testing.T) {
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := Compose(Email{
+		From:        "Sensu <sensu@example.com>",
+		To:          []string{"ops@example.com"},
+		Cc:          []string{"dev@example.com"},
+		Subject:     "disk full",
+		Date:        date,
+		MessageID:   "<1@example.com>",
+		Body:        NewText("text/plain", map[string]string{"charset": "utf-8"}, []byte("body")),
+		Attachments: []*Part{NewBase64("application/json", nil, []byte("{}"))},
+	})
+	m.Header.Add("Precedence", "bulk")
+
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "ops@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "dev@example.com", msg.Header.Get("Cc"))
+	assert.Equal(t, "disk full", msg.Header.Get("Subject"))
+	assert.Equal(t, date.Format(time.RFC1123Z), msg.Header.Get("Date"))
+	assert.Equal(t, "<1@example.com>", msg.Header.Get("Message-ID"))
+	assert.Equal(t, "auto-generated", msg.Header.Get("Auto-Submitted"))
+	assert.Equal(t, "bulk", msg.Header.Get("Precedence"))
+	assert.Contains(t, msg.Header.Get("Content-Type"), "multipart/mixed; ")
+
+	// without To recipients the header doesn't disclose the bcc recipients
+	m = Compose(Email{From: "sensu@example.com", Body: New7bit("text/plain", nil, "body")})
+	assert.Equal(t, "undisclosed-recipients:;", m.Header.Get("To"))
+	assert.Empty(t, m.Header.Get("Cc"))
+	assert.Equal(t, "text/plain", m.Body.Header.Get("Content-Type"))
+}
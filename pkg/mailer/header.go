@@ -0,0 +1,191 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// maxLineLength is the length header lines are folded at, per RFC 5322.
+const maxLineLength = 78
+
+// Header is an ordered list of header fields.
+type Header struct {
+	fields []field
+}
+
+type field struct {
+	name  string
+	value string
+}
+
+// Add appends an unstructured header field, such as Subject. The value is
+// sanitized and then encoded per RFC 2047 if it is not ASCII.
+func (h *Header) Add(name, value string) {
+	h.fields = append(h.fields, field{name: name, value: encodeValue(value, len(name)+2)})
+}
+
+// AddAddressList appends an address header field, such as To or Cc, with the
+// addresses formatted by FormatAddress.
+func (h *Header) AddAddressList(name string, addresses []string) {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = FormatAddress(sanitize(address))
+	}
+	h.fields = append(h.fields, field{name: name, value: strings.Join(formatted, ", ")})
+}
+
+// FormatAddress formats an address for a header field, encoding the display
+// name per RFC 2047 and converting an internationalized domain to its ASCII
+// (punycode) form. An address that cannot be parsed is returned as is.
+func FormatAddress(address string) string {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	if i := strings.LastIndex(addr.Address, "@"); i >= 0 {
+		if domain, err := idna.Lookup.ToASCII(addr.Address[i+1:]); err == nil {
+			addr.Address = addr.Address[:i+1] + domain
+		}
+	}
+	if len(addr.Name) == 0 {
+		return addr.Address
+	}
+	return addr.String()
+}
+
+// Get returns the (encoded) value of the first field with the name, or an
+// empty string if there is none.
+func (h *Header) Get(name string) string {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, name) {
+			return f.value
+		}
+	}
+	return ""
+}
+
+// Bytes returns the header fields, folded and terminated by CRLF.
+func (h *Header) Bytes() []byte {
+	var b bytes.Buffer
+	for _, f := range h.fields {
+		b.WriteString(fold(f.name + ": " + f.value))
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+// sanitize replaces line breaks, and the whitespace around them, by a single
+// space and removes any other control characters, so that a value, such as a
+// subject containing check output, can never end its header field and start
+// another or the body.
+func sanitize(value string) string {
+	var lines []string
+	for _, line := range strings.FieldsFunc(value, isLineBreak) {
+		line = strings.TrimSpace(strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) && r != '\t' {
+				return -1
+			}
+			return r
+		}, line))
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+func isLineBreak(r rune) bool {
+	switch r {
+	case '\n', '\v', '\f', '\r', '\u0085', '\u2028', '\u2029':
+		return true
+	}
+	return false
+}
+
+// encodeValue sanitizes the value and, if it is not ASCII, encodes it as Q
+// encoded words short enough for the header to be folded between them. The
+// offset is the length of the header line before the value.
+func encodeValue(value string, offset int) string {
+	value = sanitize(value)
+	for _, r := range value {
+		if r > unicode.MaxASCII {
+			return qEncode(value, offset)
+		}
+	}
+	return value
+}
+
+const (
+	maxEncodedWordLength = 75
+	encodedWordPrefix    = "=?utf-8?q?"
+	encodedWordSuffix    = "?="
+)
+
+// qEncode encodes the UTF-8 text as RFC 2047 Q encoded words separated by
+// spaces, never splitting a character across words.
+func qEncode(text string, offset int) string {
+	var (
+		words []string
+		word  strings.Builder
+	)
+	limit := maxEncodedWordLength
+	if maxLineLength-offset < limit {
+		limit = maxLineLength - offset
+	}
+	maxContent := limit - len(encodedWordPrefix) - len(encodedWordSuffix)
+	for _, r := range text {
+		var encoded string
+		switch {
+		case r == ' ':
+			encoded = "_"
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("!*+-/", r)):
+			encoded = string(r)
+		default:
+			var buf [utf8.UTFMax]byte
+			n := utf8.EncodeRune(buf[:], r)
+			for _, b := range buf[:n] {
+				encoded += fmt.Sprintf("=%02X", b)
+			}
+		}
+		if word.Len() > 0 && word.Len()+len(encoded) > maxContent {
+			words = append(words, encodedWordPrefix+word.String()+encodedWordSuffix)
+			word.Reset()
+			// later words are folded onto their own line after a space
+			maxContent = maxEncodedWordLength - len(encodedWordPrefix) - len(encodedWordSuffix)
+		}
+		word.WriteString(encoded)
+	}
+	words = append(words, encodedWordPrefix+word.String()+encodedWordSuffix)
+	return strings.Join(words, " ")
+}
+
+// fold breaks a header line at whitespace so that no line is longer than
+// maxLineLength, where possible.
+func fold(line string) string {
+	var b strings.Builder
+	// never fold before the first character of the value
+	start := strings.Index(line, ": ") + 2
+	for len(line) > maxLineLength {
+		i := strings.LastIndexAny(line[:maxLineLength+1], " \t")
+		if i < start {
+			i = strings.IndexAny(line[maxLineLength:], " \t")
+			if i < 0 {
+				break
+			}
+			i += maxLineLength
+		}
+		b.WriteString(line[:i])
+		b.WriteString("\r\n")
+		line = line[i:]
+		// a continuation line starts with the whitespace folded at
+		start = 1
+	}
+	b.WriteString(line)
+	return b.String()
+}
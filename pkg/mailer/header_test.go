@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderAdd(t *testing.T) {
+	var h Header
+	h.Add("Subject", "foo/bar is failing")
+	h.Add("X-Team", "Café")
+	h.Add("X-Output", "line one\r\n  line two\nline three")
+	assert.Equal(t, "Subject: foo/bar is failing\r\n"+
+		"X-Team: =?utf-8?q?Caf=C3=A9?=\r\n"+
+		"X-Output: line one line two line three\r\n", string(h.Bytes()))
+	assert.Equal(t, "foo/bar is failing", h.Get("subject"))
+	assert.Empty(t, h.Get("To"))
+}
+
+func TestHeaderAddAddressList(t *testing.T) {
+	var h Header
+	h.AddAddressList("To", []string{"José <jose@example.com>", "ops@example.com", "not an address"})
+	assert.Equal(t, "To: =?utf-8?q?Jos=C3=A9?= <jose@example.com>, ops@example.com, not an address\r\n", string(h.Bytes()))
+
+	addresses, err := mail.ParseAddressList(h.Get("To")[:strings.LastIndex(h.Get("To"), ",")])
+	assert.NoError(t, err)
+	assert.Equal(t, "José", addresses[0].Name)
+	assert.Equal(t, "ops@example.com", addresses[1].Address)
+}
+
+func TestHeaderFolding(t *testing.T) {
+	var h Header
+	subject := strings.TrimSpace(strings.Repeat("a long subject ", 20))
+	h.Add("Subject", subject)
+	rcpts := make([]string, 10)
+	for i := range rcpts {
+		rcpts[i] = "recipient@example.com"
+	}
+	h.AddAddressList("To", rcpts)
+	h.Add("X-Unbreakable", strings.Repeat("x", 100))
+
+	lines := strings.Split(strings.TrimSuffix(string(h.Bytes()), "\r\n"), "\r\n")
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "X-Unbreakable") {
+			assert.True(t, len(line) <= maxLineLength, "line too long: %q", line)
+		}
+	}
+	assert.Equal(t, "X-Unbreakable: "+strings.Repeat("x", 100), lines[len(lines)-1])
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(h.Bytes()) + "\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, subject, msg.Header.Get("Subject"))
+	to, err := msg.Header.AddressList("To")
+	assert.NoError(t, err)
+	assert.Len(t, to, 10)
+}
+
+func TestHeaderFoldingEncoded(t *testing.T) {
+	var h Header
+	subject := strings.TrimSpace(strings.Repeat("Crème brûlée ", 10))
+	h.Add("Subject", subject)
+	header := string(h.Bytes())
+	for _, line := range strings.Split(strings.TrimSuffix(header, "\r\n"), "\r\n") {
+		assert.True(t, len(line) <= maxLineLength, "line too long: %q", line)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(header + "\r\n"))
+	assert.NoError(t, err)
+	decoded, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, subject, decoded)
+}
+
+func TestHeaderSanitize(t *testing.T) {
+	for _, value := range []string{
+		"failing\r\nBcc: attacker@example.com",
+		"failing\nBcc: attacker@example.com",
+		"failing\rBcc: attacker@example.com",
+		"failing\u2028Bcc: attacker@example.com",
+		"failing\r\n\r\nBcc: attacker@example.com",
+		"failing Bcc: attacker@example.com",
+		"failing\x00\x1b[31m\r\n\tBcc: attacker@example.com\x7f",
+	} {
+		var h Header
+		h.Add("Subject", value)
+		h.AddAddressList("To", []string{"ops@example.com\r\nBcc: attacker@example.com"})
+		msg, err := mail.ReadMessage(strings.NewReader(string(h.Bytes()) + "\r\nbody"))
+		assert.NoError(t, err)
+		assert.Len(t, msg.Header, 2, "%q", value)
+		assert.Empty(t, msg.Header.Get("Bcc"))
+		assert.Equal(t, "ops@example.com Bcc: attacker@example.com", msg.Header.Get("To"))
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		assert.NoError(t, err)
+		assert.Regexp(t, `^failing(\[31m)? Bcc: attacker@example\.com$`, subject)
+	}
+}
+
+func TestFormatAddress(t *testing.T) {
+	assert.Equal(t, "ops@example.com", FormatAddress("ops@example.com"))
+	assert.Equal(t, "ops@example.com", FormatAddress("<ops@example.com>"))
+	assert.Equal(t, `"Ops" <ops@example.com>`, FormatAddress("Ops <ops@example.com>"))
+	assert.Equal(t, "=?utf-8?q?Jos=C3=A9?= <jose@xn--exmple-cua.com>", FormatAddress("José <jose@exämple.com>"))
+	assert.Equal(t, "not an address", FormatAddress("not an address"))
+}
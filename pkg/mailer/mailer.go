@@ -0,0 +1,170 @@
+// Package mailer builds RFC 5322 email messages with MIME entities and starts
+// the SMTP sessions they are sent in.
+//
+// Messages are built in canonical form, with CRLF line endings, header fields
+// folded at 78 characters and bodies transfer encoded so that no line is
+// longer than 76 characters. The dot-stuffing required by SMTP is left to the
+// DATA writer of net/smtp, so must not be applied to the messages here.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// Part is a MIME entity: its header and either an encoded body or, for
+// multipart entities, the parts it contains.
+type Part struct {
+	Header Header
+
+	body     []byte
+	preamble string
+	boundary string
+	parts    []*Part
+	raw      []byte
+}
+
+// NewText returns a part with the text body using quoted-printable transfer
+// encoding. The text must already be in the charset given in the params.
+func NewText(mediaType string, params map[string]string, text []byte) *Part {
+	var encoded bytes.Buffer
+	qp := quotedprintable.NewWriter(&encoded)
+	// writing to a bytes.Buffer never fails
+	_, _ = qp.Write(text)
+	_ = qp.Close()
+
+	p := &Part{body: encoded.Bytes()}
+	p.Header.Add("Content-Type", formatMediaType(mediaType, params))
+	p.Header.Add("Content-Transfer-Encoding", "quoted-printable")
+	return p
+}
+
+// NewBase64 returns a part with the data using base64 transfer encoding.
+func NewBase64(mediaType string, params map[string]string, data []byte) *Part {
+	p := &Part{body: []byte(wrapBase64(data))}
+	p.Header.Add("Content-Type", formatMediaType(mediaType, params))
+	p.Header.Add("Content-Transfer-Encoding", "base64")
+	return p
+}
+
+// New7bit returns a part with the text body, which must be 7bit ASCII with
+// lines of no more than 998 characters, such as ASCII armored data.
+func New7bit(mediaType string, params map[string]string, text string) *Part {
+	p := &Part{body: []byte(canonicalLineEndings(text))}
+	p.Header.Add("Content-Type", formatMediaType(mediaType, params))
+	return p
+}
+
+// NewMultipart returns a multipart/subtype part containing the parts.
+func NewMultipart(subtype string, params map[string]string, parts ...*Part) *Part {
+	p := &Part{boundary: newBoundary(), parts: parts}
+	multipartParams := map[string]string{"boundary": p.boundary}
+	for k, v := range params {
+		multipartParams[k] = v
+	}
+	p.Header.Add("Content-Type", formatMediaType("multipart/"+subtype, multipartParams))
+	return p
+}
+
+// Raw returns a part containing an already built entity (its header and
+// body), which is included in the message byte for byte. This is needed for
+// entities that have been signed.
+func Raw(entity []byte) *Part {
+	return &Part{raw: entity}
+}
+
+// SetPreamble sets the text shown before the first part of a multipart part
+// by mail clients that do not understand MIME.
+func (p *Part) SetPreamble(preamble string) {
+	p.preamble = canonicalLineEndings(preamble)
+}
+
+// Bytes returns the entity, its header followed by its body.
+func (p *Part) Bytes() []byte {
+	if p.raw != nil {
+		return p.raw
+	}
+	var b bytes.Buffer
+	b.Write(p.Header.Bytes())
+	b.WriteString("\r\n")
+	if p.parts == nil {
+		b.Write(p.body)
+		return b.Bytes()
+	}
+
+	// the CRLF before each boundary belongs to the boundary, not the part
+	delimiter := "--" + p.boundary
+	if len(p.preamble) > 0 {
+		b.WriteString(p.preamble + "\r\n")
+	}
+	for i, part := range p.parts {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(delimiter + "\r\n")
+		b.Write(part.Bytes())
+	}
+	b.WriteString("\r\n" + delimiter + "--\r\n")
+	return b.Bytes()
+}
+
+// Message is an email message.
+type Message struct {
+	// Header is the message header, excluding MIME-Version and the header of
+	// the body part
+	Header Header
+	Body   *Part
+}
+
+// Bytes returns the message.
+func (m *Message) Bytes() []byte {
+	var b bytes.Buffer
+	b.Write(m.Header.Bytes())
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.Write(m.Body.Bytes())
+	if !bytes.HasSuffix(b.Bytes(), []byte("\r\n")) {
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+func formatMediaType(mediaType string, params map[string]string) string {
+	if formatted := mime.FormatMediaType(mediaType, params); len(formatted) > 0 {
+		return formatted
+	}
+	return mediaType
+}
+
+// newBoundary returns a random multipart boundary. The "=_" prefix can never
+// occur in quoted-printable or base64 encoded content.
+func newBoundary() string {
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		panic(err)
+	}
+	return "=_" + hex.EncodeToString(random[:])
+}
+
+// wrapBase64 base64 encodes data, wrapping lines at 76 characters.
+func wrapBase64(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var lines []string
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+	return strings.Join(lines, "\r\n")
+}
+
+// canonicalLineEndings converts bare CR and LF line endings to CRLF.
+func canonicalLineEndings(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	return strings.Replace(text, "\n", "\r\n", -1)
+}
@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewText(t *testing.T) {
+	text := "Entity: foo\n" + strings.Repeat("output ", 20) + "\n.leading dot\n"
+	part := NewText("text/plain", map[string]string{"charset": "utf-8"}, []byte(text))
+	entity := string(part.Bytes())
+	assert.True(t, strings.HasPrefix(entity, "Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\n"))
+	for _, line := range strings.Split(entity, "\r\n") {
+		assert.True(t, len(line) <= 76, "line too long: %q", line)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(entity))
+	assert.NoError(t, err)
+	decoded, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(text, "\n", "\r\n", -1), string(decoded))
+}
+
+func TestNewBase64(t *testing.T) {
+	data := bytes.Repeat([]byte{0, 1, 2, 3, 255}, 50)
+	part := NewBase64("image/png", nil, data)
+	entity := string(part.Bytes())
+	assert.True(t, strings.HasPrefix(entity, "Content-Type: image/png\r\nContent-Transfer-Encoding: base64\r\n\r\n"))
+	body := strings.SplitN(entity, "\r\n\r\n", 2)[1]
+	for _, line := range strings.Split(body, "\r\n") {
+		assert.True(t, len(line) <= 76, "line too long: %q", line)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Replace(body, "\r\n", "", -1))
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+}
+
+func TestNewMultipart(t *testing.T) {
+	signed := "Content-Type: text/plain\r\n\r\nsigned content\r\n"
+	plain := NewText("text/plain", map[string]string{"charset": "utf-8"}, []byte("plain\n"))
+	armored := New7bit("application/octet-stream", map[string]string{"name": "encrypted.asc"}, "-----BEGIN-----\nabc\n-----END-----")
+	part := NewMultipart("mixed", map[string]string{"x-extra": "value"}, plain, Raw([]byte(signed)), armored)
+	part.SetPreamble("This is a multipart message in MIME format.")
+	entity := string(part.Bytes())
+
+	header := strings.SplitN(entity, "\r\n\r\n", 2)[0]
+	mediaType, params, err := mime.ParseMediaType(strings.TrimPrefix(header, "Content-Type: "))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	assert.Equal(t, "value", params["x-extra"])
+	assert.True(t, strings.HasPrefix(params["boundary"], "=_"))
+	assert.Contains(t, entity, "\r\n\r\nThis is a multipart message in MIME format.\r\n--"+params["boundary"]+"\r\n")
+
+	// raw parts are included unchanged, the CRLF before the boundary belongs
+	// to the boundary
+	assert.Contains(t, entity, "--"+params["boundary"]+"\r\n"+signed+"\r\n--"+params["boundary"]+"\r\n")
+	assert.True(t, strings.HasSuffix(entity, "-----END-----\r\n--"+params["boundary"]+"--\r\n"))
+
+	reader := multipart.NewReader(strings.NewReader(strings.SplitN(entity, "\r\n\r\n", 2)[1]), params["boundary"])
+	var bodies []string
+	for {
+		p, err := reader.NextRawPart()
+		if err != nil {
+			break
+		}
+		body, err := ioutil.ReadAll(p)
+		assert.NoError(t, err)
+		bodies = append(bodies, string(body))
+	}
+	assert.Equal(t, []string{"plain\r\n", "signed content\r\n", "-----BEGIN-----\r\nabc\r\n-----END-----"}, bodies)
+
+	// boundaries are unique
+	assert.NotEqual(t, params["boundary"], newBoundary())
+}
+
+func TestMessage(t *testing.T) {
+	m := &Message{Body: NewText("text/plain", map[string]string{"charset": "utf-8"}, []byte("body"))}
+	m.Header.Add("From", "sensu@example.com")
+	m.Header.AddAddressList("To", []string{"ops@example.com"})
+	m.Header.Add("Subject", "Crème brûlée "+strings.TrimSpace(strings.Repeat("is failing ", 10)))
+
+	raw := m.Bytes()
+	assert.True(t, bytes.HasSuffix(raw, []byte("\r\n")))
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "sensu@example.com", msg.Header.Get("From"))
+	assert.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+	assert.Equal(t, "text/plain; charset=utf-8", msg.Header.Get("Content-Type"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Crème brûlée "+strings.TrimSpace(strings.Repeat("is failing ", 10)), subject)
+	body, err := ioutil.ReadAll(msg.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "body\r\n", string(body))
+}
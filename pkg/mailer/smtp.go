@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// ConnectOptions are the options of starting an SMTP session.
+type ConnectOptions struct {
+	// HeloHost, if set, is the name the client greets the server with
+	HeloHost string
+	// TLSConfig is used to upgrade the connection when the server supports
+	// STARTTLS
+	TLSConfig *tls.Config
+	// RequireTLS fails the session when the server does not support STARTTLS
+	RequireTLS bool
+	// Auth, if not nil, authenticates when the server supports AUTH
+	Auth smtp.Auth
+	// Username and AuthMethod describe the Auth in the log
+	Username   string
+	AuthMethod string
+	// Negotiated, if not nil, is called once STARTTLS has been negotiated
+	Negotiated func(conn *smtp.Client, state tls.ConnectionState)
+	// Logf, if not nil, logs the steps of starting the session
+	Logf func(format string, args ...interface{})
+}
+
+func (o *ConnectOptions) logf(format string, args ...interface{}) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+// Connect starts an SMTP session with the host on the connection, upgrading
+// it with STARTTLS and authenticating when the server supports them. The
+// connection is closed if the session cannot be started.
+func Connect(netConn net.Conn, host string, opts ConnectOptions) (*smtp.Client, error) {
+	conn, err := smtp.NewClient(netConn, host)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if len(opts.HeloHost) > 0 {
+		if err := conn.Hello(opts.HeloHost); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if ok, _ := conn.Extension("STARTTLS"); ok {
+		if err := conn.StartTLS(opts.TLSConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if state, ok := conn.TLSConnectionState(); ok && opts.Negotiated != nil {
+			opts.Negotiated(conn, state)
+		}
+		if opts.TLSConfig.InsecureSkipVerify {
+			opts.logf("the server certificate was not verified")
+		}
+	} else if opts.RequireTLS {
+		conn.Close()
+		return nil, fmt.Errorf("%s does not support STARTTLS, which is required", host)
+	} else {
+		opts.logf("server does not support STARTTLS, continuing without TLS")
+	}
+	opts.logf("server supports %s", strings.Join(Extensions(conn), " "))
+
+	if ok, _ := conn.Extension("AUTH"); ok && opts.Auth != nil {
+		opts.logf("authenticating as %s using %s auth", opts.Username, opts.AuthMethod)
+		if err := conn.Auth(opts.Auth); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		opts.logf("not authenticating")
+	}
+	return conn, nil
+}
+
+// Extensions returns the commonly used SMTP extensions the server supports.
+func Extensions(conn *smtp.Client) []string {
+	var extensions []string
+	for _, ext := range []string{"STARTTLS", "AUTH", "SIZE", "8BITMIME", "SMTPUTF8", "PIPELINING", "DSN"} {
+		if ok, _ := conn.Extension(ext); ok {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTPServer answers the commands on the connection, announcing the
+// extensions, and returns the commands it received once the client quits.
+func fakeSMTPServer(conn net.Conn, extensions ...string) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		defer conn.Close()
+		var commands []string
+		r := bufio.NewReader(conn)
+		write := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		write("220 relay.example.com ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			commands = append(commands, line)
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "EHLO":
+				write("250-relay.example.com")
+				for _, ext := range extensions {
+					write("250-" + ext)
+				}
+				write("250 HELP")
+			case "AUTH":
+				write("235 authenticated")
+			case "QUIT":
+				write("221 bye")
+				received <- commands
+				return
+			default:
+				write("250 ok")
+			}
+		}
+		received <- commands
+	}()
+	return received
+}
+
+func TestConnect(t *testing.T) {
+	client, server := net.Pipe()
+	received := fakeSMTPServer(server, "AUTH PLAIN", "8BITMIME")
+	var logged []string
+	conn, err := Connect(client, "relay.example.com", ConnectOptions{
+		HeloHost:   "sensu.example.com",
+		TLSConfig:  &tls.Config{ServerName: "relay.example.com"},
+		Auth:       smtp.PlainAuth("", "sensu", "secret", "relay.example.com"),
+		Username:   "sensu",
+		AuthMethod: "plain",
+		Logf: func(format string, args ...interface{}) {
+			logged = append(logged, format)
+		},
+	})
+	// net/smtp only sends PLAIN credentials over TLS or to localhost
+	assert.EqualError(t, err, "unencrypted connection")
+	assert.Nil(t, conn)
+	assert.Contains(t, logged, "server does not support STARTTLS, continuing without TLS")
+	assert.Contains(t, logged, "authenticating as %s using %s auth")
+
+	client, server = net.Pipe()
+	received = fakeSMTPServer(server, "8BITMIME", "PIPELINING")
+	conn, err = Connect(client, "relay.example.com", ConnectOptions{HeloHost: "sensu.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8BITMIME", "PIPELINING"}, Extensions(conn))
+	assert.NoError(t, conn.Quit())
+	assert.Equal(t, []string{"EHLO sensu.example.com", "QUIT"}, <-received)
+}
+
+func TestConnectRequireTLS(t *testing.T) {
+	client, server := net.Pipe()
+	fakeSMTPServer(server, "8BITMIME")
+	_, err := Connect(client, "relay.example.com", ConnectOptions{RequireTLS: true})
+	assert.EqualError(t, err, "relay.example.com does not support STARTTLS, which is required")
+}
@@ -0,0 +1,63 @@
+package smtpsend
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// dsnRequested reports whether delivery status notifications are requested
+// and the server supports them (RFC 3461).
+func dsnRequested(conn *smtp.Client, opts *Options) bool {
+	if len(opts.DSNNotify) == 0 && len(opts.DSNReturn) == 0 {
+		return false
+	}
+	if ok, _ := conn.Extension("DSN"); !ok {
+		opts.logf("the SMTP server does not support DSN, not requesting delivery status notifications")
+		return false
+	}
+	return true
+}
+
+// dsnMailParams returns the DSN parameters for the MAIL command.
+func dsnMailParams(conn *smtp.Client, opts *Options) string {
+	if !dsnRequested(conn, opts) {
+		return ""
+	}
+	var params string
+	if len(opts.DSNReturn) > 0 {
+		params += " RET=" + opts.DSNReturn
+	}
+	if len(opts.EnvelopeID) > 0 {
+		params += " ENVID=" + XText(opts.EnvelopeID)
+	}
+	return params
+}
+
+// dsnRcptParams returns the DSN parameters for the RCPT command of the
+// address.
+func dsnRcptParams(conn *smtp.Client, address string, opts *Options) string {
+	if !dsnRequested(conn, opts) {
+		return ""
+	}
+	var params string
+	if len(opts.DSNNotify) > 0 {
+		params += " NOTIFY=" + opts.DSNNotify
+	}
+	return params + " ORCPT=rfc822;" + XText(address)
+}
+
+// XText encodes the value as an RFC 3461 xtext, in which characters outside
+// printable ASCII, '+' and '=' are written as '+' and their hex value.
+func XText(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,338 @@
+// Package smtpsend sends composed messages in an SMTP session, using the
+// PIPELINING, 8BITMIME, SMTPUTF8 and DSN extensions when the server supports
+// them.
+//
+// The session is left to the caller, which dials, secures and authenticates
+// it with net/smtp, and may send several messages in it.
+package smtpsend
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Options are the options of sending a message.
+type Options struct {
+	// EnvelopeID identifies the message in delivery status notifications
+	EnvelopeID string
+	// DSNNotify is the comma separated conditions to request delivery status
+	// notifications for: SUCCESS, FAILURE and DELAY, or NEVER
+	DSNNotify string
+	// DSNReturn is FULL or HDRS, whether notifications include the message
+	DSNReturn string
+	// VerifyRecipients probes each recipient with VRFY and RCPT, sending the
+	// message to the recipients the server accepts
+	VerifyRecipients bool
+	// Logf, if not nil, logs the steps of sending the message
+	Logf func(format string, args ...interface{})
+}
+
+func (o *Options) logf(format string, args ...interface{}) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+// InvalidRecipientsError reports the recipients the server rejected with
+// Options.VerifyRecipients.
+type InvalidRecipientsError struct {
+	Recipients []string
+	Errs       []error
+	// Sent is whether the message was sent to the other recipients
+	Sent bool
+}
+
+func (e *InvalidRecipientsError) add(recipient string, err error) {
+	e.Recipients = append(e.Recipients, recipient)
+	e.Errs = append(e.Errs, err)
+}
+
+func (e *InvalidRecipientsError) Error() string {
+	invalid := make([]string, len(e.Recipients))
+	for i, r := range e.Recipients {
+		invalid[i] = fmt.Sprintf("%s (%v)", r, e.Errs[i])
+	}
+	msg := "invalid recipients: " + strings.Join(invalid, ", ")
+	if e.Sent {
+		msg += "; the email was sent to the other recipients"
+	}
+	return msg
+}
+
+// Send sends the message from the sender to the recipients over the
+// connection. With Options.VerifyRecipients it is sent to the recipients the
+// server accepts, returning an *InvalidRecipientsError for any others.
+func Send(conn *smtp.Client, sender string, recipients []string, msg []byte, opts Options) error {
+	// without SMTPUTF8 the addresses must be ASCII
+	smtputf8, _ := conn.Extension("SMTPUTF8")
+	sender, err := Address(sender, smtputf8)
+	if err != nil {
+		return err
+	}
+	opts.logf("sending from %s to %s", sender, strings.Join(recipients, ","))
+	var invalid *InvalidRecipientsError
+	if opts.VerifyRecipients {
+		var valid []string
+		valid, invalid, err = verifyEnvelope(conn, sender, recipients, smtputf8, &opts)
+		if err != nil {
+			return err
+		}
+		if len(valid) == 0 {
+			// end the transaction started for recipients the server rejected
+			if err := conn.Reset(); err != nil {
+				return err
+			}
+			return invalid
+		}
+	} else if pipelining, _ := conn.Extension("PIPELINING"); pipelining {
+		if err := pipelineEnvelope(conn, sender, recipients, smtputf8, &opts); err != nil {
+			return err
+		}
+	} else {
+		if err := sendEnvelope(conn, sender, recipients, smtputf8, &opts); err != nil {
+			return err
+		}
+	}
+
+	data, err := conn.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(msg); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	if invalid != nil && len(invalid.Recipients) > 0 {
+		invalid.Sent = true
+		return invalid
+	}
+	return nil
+}
+
+// Address returns the address to use in the SMTP envelope. Unless the
+// server supports SMTPUTF8 an internationalized domain is converted to its
+// ASCII (punycode) form, and an address with a non-ASCII local part, which
+// cannot be converted, is an error.
+func Address(to string, smtputf8 bool) (string, error) {
+	address := EnvelopeAddress(to)
+	i := strings.LastIndex(address, "@")
+	if smtputf8 || i < 0 {
+		return address, nil
+	}
+	local, domain := address[:i], address[i+1:]
+	for _, r := range local {
+		if r >= utf8.RuneSelf {
+			return "", fmt.Errorf("%s can only be sent to by an SMTP server supporting SMTPUTF8", address)
+		}
+	}
+	asciiDomain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain in %s: %v", address, err)
+	}
+	return local + "@" + asciiDomain, nil
+}
+
+// EnvelopeAddress strips any display name from an address so that it can be
+// used in the SMTP envelope.
+func EnvelopeAddress(to string) string {
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return to
+	}
+	return addr.Address
+}
+
+// RejectedRecipient reports whether the error is the server rejecting an
+// address as unknown or invalid.
+func RejectedRecipient(err error) bool {
+	var smtpErr *textproto.Error
+	if !errors.As(err, &smtpErr) {
+		return false
+	}
+	switch smtpErr.Code {
+	case 550, 551, 553:
+		return true
+	}
+	return false
+}
+
+// verifyEnvelope starts the transaction with the sender, probing each
+// recipient with VRFY, where the server implements it, and RCPT. It returns
+// the valid recipients, along with the recipients the server rejected.
+func verifyEnvelope(conn *smtp.Client, sender string, recipients []string, smtputf8 bool, opts *Options) ([]string, *InvalidRecipientsError, error) {
+	invalid := &InvalidRecipientsError{}
+	vrfy := true
+	var candidates, addresses []string
+	for _, to := range recipients {
+		address, err := Address(to, smtputf8)
+		if err != nil {
+			invalid.add(to, err)
+			continue
+		}
+		if vrfy {
+			// 252 means the server won't verify the address, and 5xx
+			// codes other than unknown users that it doesn't support VRFY
+			err := conn.Verify(address)
+			var smtpErr *textproto.Error
+			switch {
+			case err == nil:
+			case RejectedRecipient(err):
+				opts.logf("VRFY rejected %s: %v", to, err)
+				invalid.add(to, err)
+				continue
+			case errors.As(err, &smtpErr):
+				vrfy = smtpErr.Code == 252
+			default:
+				return nil, nil, err
+			}
+		}
+		candidates = append(candidates, to)
+		addresses = append(addresses, address)
+	}
+	if len(candidates) == 0 {
+		return nil, invalid, nil
+	}
+
+	mail, err := mailCommand(conn, sender, smtputf8, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := command(conn, 250, mail); err != nil {
+		return nil, nil, err
+	}
+	var valid []string
+	for i, address := range addresses {
+		rcpt, err := rcptCommand(conn, address, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := command(conn, 25, rcpt); err != nil {
+			if !RejectedRecipient(err) {
+				return nil, nil, err
+			}
+			opts.logf("RCPT rejected %s: %v", candidates[i], err)
+			invalid.add(candidates[i], err)
+			continue
+		}
+		valid = append(valid, candidates[i])
+	}
+	return valid, invalid, nil
+}
+
+// sendEnvelope sends the MAIL and RCPT commands, one at a time.
+func sendEnvelope(conn *smtp.Client, sender string, recipients []string, smtputf8 bool, opts *Options) error {
+	mail, err := mailCommand(conn, sender, smtputf8, opts)
+	if err != nil {
+		return err
+	}
+	if err := command(conn, 250, mail); err != nil {
+		return err
+	}
+	for _, to := range recipients {
+		address, err := Address(to, smtputf8)
+		if err != nil {
+			return err
+		}
+		rcpt, err := rcptCommand(conn, address, opts)
+		if err != nil {
+			return err
+		}
+		if err := command(conn, 25, rcpt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipelineEnvelope sends the MAIL and all RCPT commands before reading any of
+// their responses, for servers supporting PIPELINING (RFC 2920), saving a
+// round trip per recipient.
+func pipelineEnvelope(conn *smtp.Client, sender string, recipients []string, smtputf8 bool, opts *Options) error {
+	mail, err := mailCommand(conn, sender, smtputf8, opts)
+	if err != nil {
+		return err
+	}
+	commands := []string{mail}
+	for _, to := range recipients {
+		address, err := Address(to, smtputf8)
+		if err != nil {
+			return err
+		}
+		rcpt, err := rcptCommand(conn, address, opts)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, rcpt)
+	}
+
+	ids := make([]uint, len(commands))
+	for i, command := range commands {
+		id, err := conn.Text.Cmd("%s", command)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	// read every response to keep the session in step, returning the first
+	// error
+	var firstErr error
+	for i, id := range ids {
+		expectCode := 25
+		if i == 0 {
+			expectCode = 250
+		}
+		conn.Text.StartResponse(id)
+		_, _, err := conn.Text.ReadResponse(expectCode)
+		conn.Text.EndResponse(id)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// mailCommand returns the MAIL command for the sender, with the parameters
+// for the extensions in use.
+func mailCommand(conn *smtp.Client, sender string, smtputf8 bool, opts *Options) (string, error) {
+	if strings.ContainsAny(sender, "\r\n") {
+		return "", errors.New("smtp: A line must not contain CR or LF")
+	}
+	command := "MAIL FROM:<" + sender + ">"
+	if ok, _ := conn.Extension("8BITMIME"); ok {
+		command += " BODY=8BITMIME"
+	}
+	if smtputf8 {
+		command += " SMTPUTF8"
+	}
+	return command + dsnMailParams(conn, opts), nil
+}
+
+// rcptCommand returns the RCPT command for the address.
+func rcptCommand(conn *smtp.Client, address string, opts *Options) (string, error) {
+	if strings.ContainsAny(address, "\r\n") {
+		return "", errors.New("smtp: A line must not contain CR or LF")
+	}
+	return "RCPT TO:<" + address + ">" + dsnRcptParams(conn, address, opts), nil
+}
+
+// command sends the command, returning an error unless the response code
+// starts with expectCode.
+func command(conn *smtp.Client, expectCode int, command string) error {
+	id, err := conn.Text.Cmd("%s", command)
+	if err != nil {
+		return err
+	}
+	conn.Text.StartResponse(id)
+	defer conn.Text.EndResponse(id)
+	_, _, err = conn.Text.ReadResponse(expectCode)
+	return err
+}
@@ -0,0 +1,133 @@
+package smtpsend
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startServer starts an SMTP server for a single session with the
+// extensions, rejecting recipients named unknown, and returns a client
+// connected to it along with the commands and message data it receives.
+func startServer(t *testing.T, extensions ...string) (*smtp.Client, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost ESMTP")
+		var session []string
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			session = append(session, line)
+			switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+			case "EHLO":
+				_ = text.PrintfLine("250-localhost")
+				for _, ext := range extensions {
+					_ = text.PrintfLine("250-%s", ext)
+				}
+				_ = text.PrintfLine("250 8BITMIME")
+			case "RCPT", "VRFY":
+				if strings.Contains(line, "unknown") {
+					_ = text.PrintfLine("550 no such user")
+				} else {
+					_ = text.PrintfLine("250 ok")
+				}
+			case "DATA":
+				_ = text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotBytes()
+				session = append(session, string(data))
+				_ = text.PrintfLine("250 queued")
+			case "QUIT":
+				_ = text.PrintfLine("221 bye")
+				received <- strings.Join(session, "\n")
+				return
+			default:
+				_ = text.PrintfLine("250 ok")
+			}
+		}
+	}()
+	conn, err := smtp.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, received
+}
+
+func TestSend(t *testing.T) {
+	for _, extensions := range [][]string{{"DSN"}, {"DSN", "PIPELINING"}} {
+		conn, received := startServer(t, extensions...)
+		assert.NoError(t, Send(conn, "Sensu <sensu@example.com>", []string{"ops+disk@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n"),
+			Options{EnvelopeID: "1234", DSNNotify: "FAILURE", DSNReturn: "HDRS"}))
+		assert.NoError(t, conn.Quit())
+		session := <-received
+		assert.Contains(t, session, "MAIL FROM:<sensu@example.com> BODY=8BITMIME RET=HDRS ENVID=1234\n", extensions)
+		assert.Contains(t, session, "RCPT TO:<ops+disk@example.com> NOTIFY=FAILURE ORCPT=rfc822;ops+2Bdisk@example.com\n", extensions)
+		assert.Contains(t, session, "disk full")
+	}
+}
+
+func TestSendVerifyRecipients(t *testing.T) {
+	conn, received := startServer(t)
+	var logged []string
+	opts := Options{VerifyRecipients: true, Logf: func(format string, args ...interface{}) {
+		logged = append(logged, format)
+	}}
+	err := Send(conn, "sensu@example.com", []string{"ops@example.com", "unknown@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n"), opts)
+	var invalid *InvalidRecipientsError
+	if assert.True(t, errors.As(err, &invalid)) {
+		assert.Equal(t, []string{"unknown@example.com"}, invalid.Recipients)
+		assert.True(t, invalid.Sent)
+		assert.True(t, RejectedRecipient(invalid.Errs[0]))
+	}
+	assert.EqualError(t, err, `invalid recipients: unknown@example.com (550 "no such user"); the email was sent to the other recipients`)
+	assert.Contains(t, logged, "VRFY rejected %s: %v")
+
+	// nothing is sent when every recipient is invalid
+	err = Send(conn, "sensu@example.com", []string{"unknown@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n"), opts)
+	assert.EqualError(t, err, `invalid recipients: unknown@example.com (550 "no such user")`)
+	assert.NoError(t, conn.Quit())
+	assert.Equal(t, 1, strings.Count(<-received, "disk full"))
+}
+
+func TestAddress(t *testing.T) {
+	for _, tc := range []struct {
+		to       string
+		smtputf8 bool
+		expected string
+	}{
+		{"ops@example.com", false, "ops@example.com"},
+		{"Ops <ops@example.com>", false, "ops@example.com"},
+		{"ops@exämple.com", false, "ops@xn--exmple-cua.com"},
+		{"ops@exämple.com", true, "ops@exämple.com"},
+		{"jösé@exämple.com", true, "jösé@exämple.com"},
+	} {
+		address, err := Address(tc.to, tc.smtputf8)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, address)
+	}
+	_, err := Address("jösé@example.com", false)
+	assert.EqualError(t, err, "jösé@example.com can only be sent to by an SMTP server supporting SMTPUTF8")
+}
+
+func TestXText(t *testing.T) {
+	assert.Equal(t, "ops@example.com", XText("ops@example.com"))
+	assert.Equal(t, "ops+2Bdisk+3Dfull+20x@example.com", XText("ops+disk=full x@example.com"))
+	assert.Equal(t, "j+C3+B6@example.com", XText("jö@example.com"))
+}
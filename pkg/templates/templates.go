@@ -0,0 +1,111 @@
+// Package templates resolves the subject, body and header templates of
+// emails, with text/template or, for HTML bodies, html/template.
+package templates
+
+import (
+	"bytes"
+	htemplate "html/template"
+	"io"
+	ttemplate "text/template"
+)
+
+// Template is a parsed text/template or html/template.
+type Template interface {
+	Execute(wr io.Writer, data interface{}) error
+}
+
+// Partial is a named template parsed along with another, included in it
+// with {{template "name" .}}.
+type Partial struct {
+	Name string
+	Text string
+}
+
+// Options are the options of parsing a template.
+type Options struct {
+	// HTML parses the template with html/template, escaping the values it
+	// inserts
+	HTML bool
+	// LeftDelim and RightDelim are the action delimiters of the template and
+	// its Partials, {{ and }} if empty
+	LeftDelim  string
+	RightDelim string
+	// Funcs are the functions available to the template and its partials
+	Funcs map[string]interface{}
+	// Builtins are partials always using the {{ and }} delimiters, parsed
+	// before the Partials, which may replace them
+	Builtins []Partial
+	// Partials are parsed along with the template
+	Partials []Partial
+}
+
+// Parse parses the template text, along with the partials of the options.
+func Parse(text string, opts Options) (Template, error) {
+	if opts.HTML {
+		t := htemplate.New("template").Delims(opts.LeftDelim, opts.RightDelim).Funcs(htemplate.FuncMap(opts.Funcs))
+		for _, partial := range opts.Builtins {
+			if _, err := t.New(partial.Name).Delims("{{", "}}").Parse(partial.Text); err != nil {
+				return nil, err
+			}
+		}
+		for _, partial := range opts.Partials {
+			if _, err := t.New(partial.Name).Parse(partial.Text); err != nil {
+				return nil, err
+			}
+		}
+		return t.Parse(text)
+	}
+	t := ttemplate.New("template").Delims(opts.LeftDelim, opts.RightDelim).Funcs(ttemplate.FuncMap(opts.Funcs))
+	for _, partial := range opts.Builtins {
+		if _, err := t.New(partial.Name).Delims("{{", "}}").Parse(partial.Text); err != nil {
+			return nil, err
+		}
+	}
+	for _, partial := range opts.Partials {
+		if _, err := t.New(partial.Name).Parse(partial.Text); err != nil {
+			return nil, err
+		}
+	}
+	return t.Parse(text)
+}
+
+// Resolve parses the template text and executes it against the data.
+func Resolve(text string, data interface{}, opts Options) (string, error) {
+	tmpl, err := Parse(text, opts)
+	if err != nil {
+		return "", err
+	}
+	var resolved bytes.Buffer
+	if err := tmpl.Execute(&resolved, data); err != nil {
+		return "", err
+	}
+	return resolved.String(), nil
+}
+
+// StatusName returns the conventional name of a check status.
+func StatusName(status uint32) string {
+	switch status {
+	case 0:
+		return "OK"
+	case 1:
+		return "WARNING"
+	case 2:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// StatusColor returns the color used to represent a check status.
+func StatusColor(status uint32) string {
+	switch status {
+	case 0:
+		return "#2e7d32"
+	case 1:
+		return "#f9a825"
+	case 2:
+		return "#c62828"
+	default:
+		return "#757575"
+	}
+}
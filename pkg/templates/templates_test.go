@@ -0,0 +1,56 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	data := map[string]interface{}{"Name": "<disk>", "Status": uint32(2)}
+	opts := Options{
+		Funcs:    map[string]interface{}{"StatusName": StatusName, "Upper": strings.ToUpper},
+		Partials: []Partial{{Name: "status", Text: "{{StatusName .Status}}"}},
+	}
+	out, err := Resolve(`{{Upper .Name}} is {{template "status" .}}`, data, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "<DISK> is CRITICAL", out)
+
+	opts.HTML = true
+	out, err = Resolve(`<b>{{.Name}}</b>`, data, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "<b>&lt;disk&gt;</b>", out)
+
+	_, err = Resolve("{{.Name", data, opts)
+	assert.Error(t, err)
+	_, err = Resolve("{{.Missing.Field}}", data, Options{})
+	assert.NoError(t, err)
+}
+
+func TestResolveDelims(t *testing.T) {
+	opts := Options{
+		LeftDelim:  "[[",
+		RightDelim: "]]",
+		Builtins:   []Partial{{Name: "builtin", Text: "{{.}}!"}},
+		Partials:   []Partial{{Name: "partial", Text: `[[template "builtin" .]]`}},
+	}
+	out, err := Resolve(`{{ [[template "partial" .]] }}`, "disk", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "{{ disk! }}", out)
+
+	// partials replace builtins of the same name
+	opts.Partials = []Partial{{Name: "builtin", Text: "[[.]]?"}}
+	out, err = Resolve(`[[template "builtin" .]]`, "disk", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "disk?", out)
+}
+
+func TestStatus(t *testing.T) {
+	assert.Equal(t, "OK", StatusName(0))
+	assert.Equal(t, "WARNING", StatusName(1))
+	assert.Equal(t, "CRITICAL", StatusName(2))
+	assert.Equal(t, "UNKNOWN", StatusName(3))
+	assert.Equal(t, "#c62828", StatusColor(2))
+	assert.Equal(t, "#757575", StatusColor(127))
+}
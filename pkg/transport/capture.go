@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// Server is a local SMTP server passing the messages it receives to a
+// Sender, such as Memory or Dir, to capture the emails of a program sending
+// them over SMTP. It accepts every message, without TLS or authentication,
+// so is meant for debugging and must only listen on local addresses.
+type Server struct {
+	// Sender receives the messages. A message it fails to send is rejected
+	// with a temporary error.
+	Sender Sender
+	// Logf, if not nil, logs each message received
+	Logf func(format string, args ...interface{})
+}
+
+// Serve accepts SMTP connections on the listener, serving each in its own
+// goroutine, until the listener fails or is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn runs the SMTP sessions of the connection.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) error {
+		return text.PrintfLine(format, args...)
+	}
+	if reply("220 %s ESMTP capture", "localhost") != nil {
+		return
+	}
+	var envelope Envelope
+	var inTransaction bool
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			err = reply("250-%s\r\n250-PIPELINING\r\n250-8BITMIME\r\n250 SMTPUTF8", "localhost")
+		case "HELO":
+			err = reply("250 %s", "localhost")
+		case "MAIL":
+			from, ok := pathArgument(arg, "FROM:")
+			if !ok {
+				err = reply("501 syntax: MAIL FROM:<address>")
+				break
+			}
+			envelope, inTransaction = Envelope{From: from}, true
+			err = reply("250 ok")
+		case "RCPT":
+			to, ok := pathArgument(arg, "TO:")
+			switch {
+			case !inTransaction:
+				err = reply("503 MAIL first")
+			case !ok || len(to) == 0:
+				err = reply("501 syntax: RCPT TO:<address>")
+			default:
+				envelope.To = append(envelope.To, to)
+				err = reply("250 ok")
+			}
+		case "DATA":
+			if len(envelope.To) == 0 {
+				err = reply("503 RCPT first")
+				break
+			}
+			if err = reply("354 end data with <CR><LF>.<CR><LF>"); err != nil {
+				break
+			}
+			var data []byte
+			if data, err = text.ReadDotBytes(); err != nil {
+				break
+			}
+			// ReadDotBytes returns bare line feeds
+			msg := []byte(strings.Replace(string(data), "\n", "\r\n", -1))
+			if sendErr := s.Sender.Send(envelope, msg); sendErr != nil {
+				err = reply("451 %s", strings.Replace(sendErr.Error(), "\n", " ", -1))
+			} else {
+				s.logf("captured a message from <%s> to <%s>", envelope.From, strings.Join(envelope.To, ">, <"))
+				err = reply("250 ok: captured")
+			}
+			envelope, inTransaction = Envelope{}, false
+		case "RSET":
+			envelope, inTransaction = Envelope{}, false
+			err = reply("250 ok")
+		case "NOOP":
+			err = reply("250 ok")
+		case "VRFY":
+			err = reply("252 cannot verify, but will accept")
+		case "QUIT":
+			_ = reply("221 bye")
+			return
+		default:
+			err = reply("502 %s is not implemented", verb)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// pathArgument returns the address of the FROM:<address> or TO:<address>
+// argument of a MAIL or RCPT command, ignoring any parameters.
+func pathArgument(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(path, '>')
+	if end < 0 {
+		return "", false
+	}
+	return path[1:end], true
+}
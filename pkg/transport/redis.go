@@ -0,0 +1,227 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueuedMessage is a message as Redis pushes it to a list, in JSON, in
+// which Message is base64 encoded.
+type QueuedMessage struct {
+	ID      string   `json:"id,omitempty"`
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Message []byte   `json:"message"`
+}
+
+// Redis is a Sender publishing each message to a Redis list or stream, for
+// an external drainer to send, decoupling the handling of events from the
+// throughput of the SMTP server. Messages are appended to a list with RPUSH
+// as a QueuedMessage, so that BLPOP takes the oldest first, or added to a
+// stream with XADD with the fields id, from, to (a JSON array) and message.
+type Redis struct {
+	// Addr is the host:port of the Redis server
+	Addr string
+	// Username, if set, and Password authenticate with AUTH
+	Username string
+	Password string
+	// DB is the database selected with SELECT, if not 0
+	DB int
+	// TLSConfig, if not nil, connects with TLS
+	TLSConfig *tls.Config
+	// Key is the list or stream the messages are published to
+	Key string
+	// Stream publishes to a stream instead of a list
+	Stream bool
+	// Timeout bounds connecting and publishing a message, 10s if zero
+	Timeout time.Duration
+}
+
+// ParseRedisURL returns a Redis for the URL redis://[[user]:password@]host[:port][/db],
+// or rediss:// to connect with TLS. The port is 6379 by default.
+func ParseRedisURL(rawurl string) (*Redis, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.New("invalid Redis URL")
+	}
+	r := &Redis{}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		r.TLSConfig = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid Redis URL scheme %q, must be redis or rediss", u.Scheme)
+	}
+	if len(u.Hostname()) == 0 {
+		return nil, errors.New("invalid Redis URL, the host is missing")
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = "6379"
+	}
+	r.Addr = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		r.Username = u.User.Username()
+		r.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); len(db) > 0 {
+		if r.DB, err = strconv.Atoi(db); err != nil || r.DB < 0 {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return r, nil
+}
+
+// RedisError is an error reply of the Redis server.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Send publishes the message to the list or stream, on a new connection.
+func (r *Redis) Send(envelope Envelope, msg []byte) error {
+	var command [][]byte
+	if r.Stream {
+		to, err := json.Marshal(envelope.To)
+		if err != nil {
+			return err
+		}
+		command = redisArgs("XADD", r.Key, "*", "id", envelope.ID, "from", envelope.From, "to", string(to))
+		command = append(command, []byte("message"), msg)
+	} else {
+		queued, err := json.Marshal(&QueuedMessage{ID: envelope.ID, From: envelope.From, To: envelope.To, Message: msg})
+		if err != nil {
+			return err
+		}
+		command = append(redisArgs("RPUSH", r.Key), queued)
+	}
+	return r.do(command)
+}
+
+// do runs the command after any AUTH and SELECT, sending them together and
+// then reading each reply.
+func (r *Redis) do(command [][]byte) error {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if r.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.Addr, r.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", r.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	var commands [][][]byte
+	if len(r.Password) > 0 {
+		if len(r.Username) > 0 {
+			commands = append(commands, redisArgs("AUTH", r.Username, r.Password))
+		} else {
+			commands = append(commands, redisArgs("AUTH", r.Password))
+		}
+	}
+	if r.DB != 0 {
+		commands = append(commands, redisArgs("SELECT", strconv.Itoa(r.DB)))
+	}
+	commands = append(commands, command)
+	w := bufio.NewWriter(conn)
+	for _, args := range commands {
+		writeRedisCommand(w, args)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// the first error, e.g. of AUTH, is the cause of any after it
+	rd := bufio.NewReader(conn)
+	for range commands {
+		if err := readRedisReply(rd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func redisArgs(args ...string) [][]byte {
+	b := make([][]byte, len(args))
+	for i, arg := range args {
+		b[i] = []byte(arg)
+	}
+	return b
+}
+
+// writeRedisCommand writes the command as a RESP array of bulk strings.
+func writeRedisCommand(w *bufio.Writer, args [][]byte) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.Write(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+// readRedisReply reads a RESP reply, discarding its value, returning a
+// RedisError for an error reply.
+func readRedisReply(r *bufio.Reader) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return errors.New("redis: invalid reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return RedisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return errors.New("redis: invalid reply")
+		}
+		if n < 0 {
+			return nil
+		}
+		_, err = io.CopyN(ioutil.Discard, r, int64(n)+2)
+		return err
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return errors.New("redis: invalid reply")
+		}
+		var firstErr error
+		for i := 0; i < n; i++ {
+			err := readRedisReply(r)
+			if _, ok := err.(RedisError); err != nil && !ok {
+				return err
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	default:
+		return errors.New("redis: invalid reply")
+	}
+}
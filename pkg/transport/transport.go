@@ -0,0 +1,109 @@
+// Package transport abstracts how composed messages are delivered, so that
+// the SMTP relay, direct delivery and other providers can be swapped, and
+// has implementations that keep or capture messages instead of sending them,
+// for testing and debugging.
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Envelope is the envelope a message is sent with.
+type Envelope struct {
+	// ID identifies the message in delivery status notifications
+	ID string
+	// From is the address bounces are sent to
+	From string
+	// To is the recipients, including any that are not in the message header
+	To []string
+}
+
+// Sender delivers composed messages.
+type Sender interface {
+	Send(envelope Envelope, msg []byte) error
+}
+
+// SenderFunc is a function used as a Sender.
+type SenderFunc func(envelope Envelope, msg []byte) error
+
+// Send calls f(envelope, msg).
+func (f SenderFunc) Send(envelope Envelope, msg []byte) error {
+	return f(envelope, msg)
+}
+
+// Message is a message kept by Memory.
+type Message struct {
+	Envelope
+	Data []byte
+}
+
+// Memory is a Sender keeping the messages in memory, for tests. It is safe
+// for concurrent use.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+	// Err, if not nil, is returned by Send instead of keeping the message
+	Err error
+}
+
+// Send keeps a copy of the message, unless m.Err is set.
+func (m *Memory) Send(envelope Envelope, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	envelope.To = append([]string(nil), envelope.To...)
+	m.messages = append(m.messages, Message{Envelope: envelope, Data: append([]byte(nil), msg...)})
+	return nil
+}
+
+// Messages returns the messages sent so far, in order.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset discards the messages sent so far.
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
+
+// Dir is a Sender writing each message to a file in the directory, named by
+// the time it was sent and with the extension .eml, so that it can be opened
+// in a mail client. The envelope is added to the top of the header as
+// X-Envelope-From and X-Envelope-To fields.
+type Dir string
+
+// Send writes the message to a new file in the directory.
+func (d Dir) Send(envelope Envelope, msg []byte) error {
+	f, err := ioutil.TempFile(string(d), time.Now().UTC().Format("20060102T150405.000000000")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "X-Envelope-From: <%s>\r\n", envelope.From)
+	fmt.Fprintf(&b, "X-Envelope-To: <%s>\r\n", strings.Join(envelope.To, ">, <"))
+	b.Write(msg)
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return f.Close()
+}
+
+// Files returns the paths of the messages in the directory, oldest first.
+func (d Dir) Files() ([]string, error) {
+	return filepath.Glob(filepath.Join(string(d), "*.eml"))
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	var sender Sender = &Memory{}
+	to := []string{"ops@example.com"}
+	assert.NoError(t, sender.Send(Envelope{ID: "1", From: "sensu@example.com", To: to}, []byte("Subject: disk\r\n\r\ndisk full\r\n")))
+	to[0] = "changed@example.com"
+	memory := sender.(*Memory)
+	assert.Equal(t, []Message{{
+		Envelope: Envelope{ID: "1", From: "sensu@example.com", To: []string{"ops@example.com"}},
+		Data:     []byte("Subject: disk\r\n\r\ndisk full\r\n"),
+	}}, memory.Messages())
+
+	memory.Err = errors.New("421 try again later")
+	assert.EqualError(t, memory.Send(Envelope{}, nil), "421 try again later")
+	assert.Len(t, memory.Messages(), 1)
+	memory.Reset()
+	assert.Empty(t, memory.Messages())
+}
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := Dir(dir)
+	assert.NoError(t, d.Send(Envelope{From: "sensu@example.com", To: []string{"ops@example.com", "bcc@example.com"}}, []byte("Subject: first\r\n\r\nfirst\r\n")))
+	assert.NoError(t, d.Send(Envelope{From: "sensu@example.com", To: []string{"ops@example.com"}}, []byte("Subject: second\r\n\r\nsecond\r\n")))
+	files, err := d.Files()
+	assert.NoError(t, err)
+	if assert.Len(t, files, 2) {
+		msg, err := ioutil.ReadFile(files[0])
+		assert.NoError(t, err)
+		assert.Equal(t, "X-Envelope-From: <sensu@example.com>\r\nX-Envelope-To: <ops@example.com>, <bcc@example.com>\r\nSubject: first\r\n\r\nfirst\r\n", string(msg))
+	}
+	assert.Error(t, Dir(dir+"/missing").Send(Envelope{}, nil))
+}
+
+func TestServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	memory := &Memory{}
+	var logged int
+	server := &Server{Sender: memory, Logf: func(string, ...interface{}) { logged++ }}
+	go func() { _ = server.Serve(listener) }()
+
+	msg := []byte("Subject: disk\r\n\r\ndisk full\r\n.leading dot\r\n")
+	assert.NoError(t, smtp.SendMail(listener.Addr().String(), nil, "sensu@example.com", []string{"ops@example.com", "bcc@example.com"}, msg))
+	assert.Equal(t, []Message{{
+		Envelope: Envelope{From: "sensu@example.com", To: []string{"ops@example.com", "bcc@example.com"}},
+		Data:     msg,
+	}}, memory.Messages())
+	assert.Equal(t, 1, logged)
+
+	// a message the sender fails to send is rejected with a temporary error
+	memory.Err = errors.New("disk full")
+	err = smtp.SendMail(listener.Addr().String(), nil, "sensu@example.com", []string{"ops@example.com"}, msg)
+	assert.EqualError(t, err, `451 "disk full"`)
+}
+
+func TestPathArgument(t *testing.T) {
+	for _, tc := range []struct {
+		arg, prefix, path string
+		ok                bool
+	}{
+		{"FROM:<sensu@example.com>", "FROM:", "sensu@example.com", true},
+		{"from: <sensu@example.com> BODY=8BITMIME", "FROM:", "sensu@example.com", true},
+		{"FROM:<>", "FROM:", "", true},
+		{"TO:ops@example.com", "TO:", "", false},
+		{"TO:<ops@example.com", "TO:", "", false},
+		{"<ops@example.com>", "TO:", "", false},
+	} {
+		path, ok := pathArgument(tc.arg, tc.prefix)
+		assert.Equal(t, tc.path, path, tc.arg)
+		assert.Equal(t, tc.ok, ok, tc.arg)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// proxyGroup is the events of the proxy entities with the same
+// --proxyGroupLabel value, held in the state directory to be sent as one
+// email.
+type proxyGroup struct {
+	Namespace string `json:"namespace"`
+	Group     string `json:"group"`
+	// First is the Unix time the first event was held
+	First int64 `json:"first"`
+	// Events are the events in their protobuf encoding, the latest of each
+	// entity/check
+	Events [][]byte `json:"events"`
+}
+
+func proxyGroupFile(namespace, group string) string {
+	return filepath.Join(config.StateDir, fmt.Sprintf("proxygroup-%x.json", sha256.Sum256([]byte(namespace+"/"+group))))
+}
+
+// proxyGroupName returns the value of --proxyGroupLabel on the event's proxy
+// entity, or an empty string if its events are not grouped.
+func proxyGroupName(event *corev2.Event) string {
+	if len(config.ProxyGroupLabel) == 0 || event.Entity.EntityClass != corev2.EntityProxyClass {
+		return ""
+	}
+	return event.Entity.Labels[config.ProxyGroupLabel]
+}
+
+func loadProxyGroup(file string) (*proxyGroup, error) {
+	groupBytes, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read proxy group: %v", err)
+	}
+	group := &proxyGroup{}
+	if err := json.Unmarshal(groupBytes, group); err != nil {
+		return nil, fmt.Errorf("failed to read proxy group: %v", err)
+	}
+	return group, nil
+}
+
+// holdProxyEvent adds the event of a grouped proxy entity to its group,
+// replacing any earlier event of the same entity and check. It reports
+// whether the event was held.
+func holdProxyEvent(event *corev2.Event, now time.Time) (bool, error) {
+	name := proxyGroupName(event)
+	if len(name) == 0 {
+		return false, nil
+	}
+	file := proxyGroupFile(event.Entity.Namespace, name)
+	group, err := loadProxyGroup(file)
+	if err != nil {
+		return false, err
+	}
+	if group == nil {
+		group = &proxyGroup{Namespace: event.Entity.Namespace, Group: name, First: now.Unix()}
+	}
+	events, err := decodeProxyGroup(group)
+	if err != nil {
+		return false, err
+	}
+	key := stateFile(event)
+	kept := group.Events[:0]
+	for i, e := range events {
+		if stateFile(e) != key {
+			kept = append(kept, group.Events[i])
+		}
+	}
+	data, err := event.Marshal()
+	if err != nil {
+		return false, fmt.Errorf("failed to write proxy group: %v", err)
+	}
+	group.Events = append(kept, data)
+	groupBytes, err := json.Marshal(group)
+	if err != nil {
+		return false, err
+	}
+	if err := writeStateFile(file, groupBytes); err != nil {
+		return false, err
+	}
+	fmt.Printf("Holding event for %s/%s with the %d events of proxy group %s\n",
+		event.Entity.Name, eventCheck(event).Name, len(group.Events), name)
+	return true, nil
+}
+
+func decodeProxyGroup(group *proxyGroup) ([]*corev2.Event, error) {
+	events := make([]*corev2.Event, len(group.Events))
+	for i, data := range group.Events {
+		events[i] = &corev2.Event{}
+		if err := events[i].Unmarshal(data); err != nil {
+			return nil, fmt.Errorf("failed to read proxy group: %v", err)
+		}
+	}
+	return events, nil
+}
+
+// flushProxyGroups sends each group whose window has passed as a single
+// digest. Like the event queue, the groups are only checked when the handler
+// runs.
+func flushProxyGroups(now time.Time) error {
+	if len(config.ProxyGroupLabel) == 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(config.StateDir, "proxygroup-*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		group, err := loadProxyGroup(file)
+		if err != nil {
+			return err
+		}
+		if group == nil || now.Before(time.Unix(group.First, 0).Add(proxyGroupDuration)) {
+			continue
+		}
+		// claim the group, so concurrent handlers don't send it twice
+		claimed := file + ".sending"
+		if err := os.Rename(file, claimed); err != nil {
+			continue
+		}
+		// events may have been added since it was read
+		if group, err = loadProxyGroup(claimed); err != nil || group == nil {
+			return err
+		}
+		events, err := decodeProxyGroup(group)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Sending %d events of proxy group %s\n", len(events), group.Group)
+		if err := sendGroupDigest(group.Group, events); err != nil {
+			// restore the group to be retried
+			_ = os.Rename(claimed, file)
+			return fmt.Errorf("failed to send proxy group %s: %v", group.Group, err)
+		}
+		if err := os.Remove(claimed); err != nil {
+			return fmt.Errorf("failed to remove proxy group: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	port, messages := startSMTPServer(t)
+	config.StateDir = dir
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.ToEmail = []string{"ops@example.com"}
+	config.Charset = defaultCharset
+	config.DigestSubjectTemplate = defaultDigestSubjectTemplate
+	config.ProxyGroupLabel = "poller"
+	proxyGroupDuration = time.Minute
+	defer func() {
+		config.StateDir = ""
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.ToEmail = nil
+		config.Charset = ""
+		config.DigestSubjectTemplate = ""
+		config.ProxyGroupLabel = ""
+		proxyGroupDuration = 0
+	}()
+
+	proxyEvent := func(entity, output string) *corev2.Event {
+		event := corev2.FixtureEvent(entity, "snmp")
+		event.Entity.EntityClass = corev2.EntityProxyClass
+		event.Entity.Labels = map[string]string{"poller": "poller01"}
+		event.Check.Status = 2
+		event.Check.Occurrences = 1
+		event.Check.Output = output
+		return event
+	}
+	start := time.Now()
+	for _, event := range []*corev2.Event{
+		proxyEvent("switch01", "timeout"),
+		proxyEvent("switch02", "timeout"),
+		proxyEvent("switch01", "still timing out"),
+	} {
+		held, err := holdProxyEvent(event, start)
+		assert.NoError(t, err)
+		assert.True(t, held)
+	}
+	// agent entities and proxy entities without the label aren't grouped
+	held, err := holdProxyEvent(corev2.FixtureEvent("server01", "disk"), start)
+	assert.NoError(t, err)
+	assert.False(t, held)
+	unlabeled := proxyEvent("switch03", "timeout")
+	unlabeled.Entity.Labels = nil
+	held, err = holdProxyEvent(unlabeled, start)
+	assert.NoError(t, err)
+	assert.False(t, held)
+
+	// nothing is sent until the window has passed
+	assert.NoError(t, flushProxyGroups(start.Add(30*time.Second)))
+	group, err := loadProxyGroup(proxyGroupFile("default", "poller01"))
+	assert.NoError(t, err)
+	assert.Len(t, group.Events, 2)
+
+	assert.NoError(t, flushProxyGroups(start.Add(time.Minute)))
+	msg := <-messages
+	assert.Contains(t, msg, "Sensu Alert Digest - poller01 - 2 events")
+	assert.Contains(t, msg, "still timing out")
+	_, err = os.Stat(proxyGroupFile("default", "poller01"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// queueFile is the file in the state directory holding the events queued
+// during maintenance windows or deferred until business hours, a JSON array of the events in their
+// (base64 encoded) protobuf encoding, which is more compact than their JSON.
+func queueFile() string {
+	return filepath.Join(config.StateDir, "queued-events.json")
+}
+
+func loadQueuedEvents() ([]*corev2.Event, error) {
+	queueBytes, err := ioutil.ReadFile(queueFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read event queue: %v", err)
+	}
+	var encoded [][]byte
+	if err := json.Unmarshal(queueBytes, &encoded); err != nil {
+		return nil, fmt.Errorf("failed to read event queue: %v", err)
+	}
+	queued := make([]*corev2.Event, len(encoded))
+	for i, data := range encoded {
+		queued[i] = &corev2.Event{}
+		if err := queued[i].Unmarshal(data); err != nil {
+			return nil, fmt.Errorf("failed to read event queue: %v", err)
+		}
+	}
+	return queued, nil
+}
+
+func saveQueuedEvents(queued []*corev2.Event) error {
+	if len(queued) == 0 {
+		if err := os.Remove(queueFile()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to write event queue: %v", err)
+		}
+		return nil
+	}
+	encoded := make([][]byte, len(queued))
+	for i, event := range queued {
+		data, err := event.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to write event queue: %v", err)
+		}
+		encoded[i] = data
+	}
+	queueBytes, err := json.Marshal(encoded)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(queueFile(), queueBytes)
+}
+
+// queueEvent adds the event to the queue, replacing any earlier event of
+// the same entity and check so only the latest status is sent.
+func queueEvent(event *corev2.Event) error {
+	queued, err := loadQueuedEvents()
+	if err != nil {
+		return err
+	}
+	key := stateFile(event)
+	kept := queued[:0]
+	for _, e := range queued {
+		if stateFile(e) != key {
+			kept = append(kept, e)
+		}
+	}
+	return saveQueuedEvents(append(kept, event))
+}
+
+// flushQueuedEvents sends the queued events that are no longer held as a
+// digest. The queue is only checked when the handler runs, so they are sent
+// with the first event handled after their maintenance window ends or
+// business hours begin. Queued events of the same entity and check as the
+// events being handled are dropped, as those are newer.
+func flushQueuedEvents(now time.Time, handling ...*corev2.Event) error {
+	if len(config.StateDir) == 0 {
+		return nil
+	}
+	queued, err := loadQueuedEvents()
+	if err != nil || len(queued) == 0 {
+		return err
+	}
+	superseded := make(map[string]bool, len(handling))
+	for _, event := range handling {
+		superseded[stateFile(event)] = true
+	}
+	var ready, waiting []*corev2.Event
+	for _, event := range queued {
+		if superseded[stateFile(event)] {
+			continue
+		}
+		if held(event, now) {
+			waiting = append(waiting, event)
+		} else {
+			ready = append(ready, event)
+		}
+	}
+	if len(ready) == 0 {
+		return saveQueuedEvents(waiting)
+	}
+	fmt.Printf("Sending %d queued events\n", len(ready))
+	if err := sendDigest(ready); err != nil {
+		return fmt.Errorf("failed to send queued events: %v", err)
+	}
+	return saveQueuedEvents(waiting)
+}
+
+// held reports whether an email for the event is held back at the time,
+// during a maintenance window or, for all but critical events, outside
+// business hours.
+func held(event *corev2.Event, now time.Time) bool {
+	return activeMaintenanceWindow(event, now) != nil || deferred(event, now)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// rateBucket is the token bucket recorded in the state directory for each
+// recipient when --maxEmailsPerHour is set.
+type rateBucket struct {
+	// Tokens is the number of emails that may currently be sent
+	Tokens float64 `json:"tokens"`
+	// Updated is the Unix time the tokens were last refilled
+	Updated int64 `json:"updated"`
+	// Suppressed is the number of emails suppressed since the last email sent
+	Suppressed int `json:"suppressed"`
+	// SuppressedSince is the Unix time of the first suppressed email
+	SuppressedSince int64 `json:"suppressed_since"`
+}
+
+// suppressedSummary is a recipient that is owed a summary of the emails
+// suppressed by rate limiting.
+type suppressedSummary struct {
+	recipient string
+	count     int
+	since     time.Time
+}
+
+func rateBucketFile(recipient string) string {
+	address := strings.ToLower(smtpsend.EnvelopeAddress(recipient))
+	return filepath.Join(config.StateDir, fmt.Sprintf("ratelimit-%x.json", sha256.Sum256([]byte(address))))
+}
+
+func loadRateBucket(recipient string, now time.Time) (*rateBucket, error) {
+	bucketBytes, err := ioutil.ReadFile(rateBucketFile(recipient))
+	if os.IsNotExist(err) {
+		return &rateBucket{Tokens: float64(config.MaxEmailsPerHour), Updated: now.Unix()}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit state: %v", err)
+	}
+	bucket := &rateBucket{}
+	if err := json.Unmarshal(bucketBytes, bucket); err != nil {
+		return nil, fmt.Errorf("failed to read rate limit state: %v", err)
+	}
+	return bucket, nil
+}
+
+func saveRateBucket(recipient string, bucket *rateBucket) error {
+	bucketBytes, err := json.Marshal(bucket)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(rateBucketFile(recipient), bucketBytes)
+}
+
+// updateRateBucket applies the update to the recipient's bucket while holding
+// its lock, as concurrent handlers would otherwise lose each other's updates
+// during exactly the alert storms rate limiting is for.
+func updateRateBucket(recipient string, now time.Time, update func(bucket *rateBucket)) error {
+	unlock, err := lockStateFile(rateBucketFile(recipient))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	bucket, err := loadRateBucket(recipient, now)
+	if err != nil {
+		return err
+	}
+	update(bucket)
+	return saveRateBucket(recipient, bucket)
+}
+
+// rateLimit takes a token from each recipient's bucket, returning the
+// recipients that may be sent the email along with any summaries owed to them
+// for emails previously suppressed.
+func rateLimit(recipients rcpts, now time.Time) (rcpts, []suppressedSummary, error) {
+	if config.MaxEmailsPerHour <= 0 {
+		return recipients, nil, nil
+	}
+
+	capacity := float64(config.MaxEmailsPerHour)
+	rate := capacity / time.Hour.Seconds()
+
+	var (
+		allowed   rcpts
+		summaries []suppressedSummary
+	)
+	for _, recipient := range recipients {
+		err := updateRateBucket(recipient, now, func(bucket *rateBucket) {
+			elapsed := now.Sub(time.Unix(bucket.Updated, 0)).Seconds()
+			if elapsed > 0 {
+				bucket.Tokens += elapsed * rate
+			}
+			if bucket.Tokens > capacity {
+				bucket.Tokens = capacity
+			}
+			bucket.Updated = now.Unix()
+
+			if bucket.Tokens < 1 {
+				if bucket.Suppressed == 0 {
+					bucket.SuppressedSince = now.Unix()
+				}
+				bucket.Suppressed++
+				fmt.Printf("Not sending email to %s: more than %d emails per hour\n", recipient, config.MaxEmailsPerHour)
+				return
+			}
+			bucket.Tokens--
+			allowed = append(allowed, recipient)
+			if bucket.Suppressed > 0 {
+				summaries = append(summaries, suppressedSummary{
+					recipient: recipient,
+					count:     bucket.Suppressed,
+					since:     time.Unix(bucket.SuppressedSince, 0),
+				})
+				bucket.Suppressed = 0
+				bucket.SuppressedSince = 0
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return allowed, summaries, nil
+}
+
+// refundRateLimit gives back the tokens rateLimit took from the recipients
+// an email failed to be sent to, so that it doesn't count against their
+// limit, and owes them again the summaries that were not sent.
+func refundRateLimit(failed rcpts, unsent []suppressedSummary, now time.Time) error {
+	if config.MaxEmailsPerHour <= 0 {
+		return nil
+	}
+	for _, recipient := range failed {
+		err := updateRateBucket(recipient, now, func(bucket *rateBucket) {
+			bucket.Tokens++
+			if bucket.Tokens > float64(config.MaxEmailsPerHour) {
+				bucket.Tokens = float64(config.MaxEmailsPerHour)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range unsent {
+		err := updateRateBucket(s.recipient, now, func(bucket *rateBucket) {
+			if bucket.Suppressed == 0 || s.since.Unix() < bucket.SuppressedSince {
+				bucket.SuppressedSince = s.since.Unix()
+			}
+			bucket.Suppressed += s.count
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRateLimited sends the email to the configured recipients that are
+// within their rate limit, followed by a summary of suppressed emails to any
+// recipient that is owed one. It reports whether the email was sent to anyone.
+func sendRateLimited(event *corev2.Event, subject, body, contentType string) (bool, error) {
+	now := time.Now()
+	var summaries []suppressedSummary
+	// a recipient in several of the lists is only charged once
+	to, cc, bcc := uniqueAcrossRcpts(eventRcpts(event))
+	lists := []rcpts{to, cc, bcc}
+	for i, list := range lists {
+		allowed, listSummaries, err := rateLimit(list, now)
+		if err != nil {
+			return false, err
+		}
+		lists[i] = allowed
+		summaries = append(summaries, listSummaries...)
+	}
+	to, cc, bcc = lists[0], lists[1], lists[2]
+	if len(to)+len(cc)+len(bcc) == 0 {
+		return false, nil
+	}
+
+	if err := deliverEmail(event, to, cc, bcc, subject, body, contentType); err != nil {
+		if refundErr := refundRateLimit(failedRecipients(envelopeRcpts(to, cc, bcc), err), summaries, now); refundErr != nil {
+			fmt.Printf("Failed to refund the rate limit: %v\n", refundErr)
+		}
+		return false, err
+	}
+
+	for i, s := range summaries {
+		summarySubject := fmt.Sprintf("Sensu Alert - %d emails suppressed", s.count)
+		summaryBody := fmt.Sprintf("%d emails to %s were suppressed since %s because more than %d emails per hour were sent.\n",
+			s.count, s.recipient, unixTime(s.since.Unix()), config.MaxEmailsPerHour)
+		if err := deliverNotice(event, s.recipient, summarySubject, summaryBody); err != nil {
+			if refundErr := refundRateLimit(nil, summaries[i:], now); refundErr != nil {
+				fmt.Printf("Failed to refund the rate limit: %v\n", refundErr)
+			}
+			return true, err
+		}
+	}
+	return true, nil
+}
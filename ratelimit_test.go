@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	config.MaxEmailsPerHour = 2
+	defer func() {
+		config.StateDir = ""
+		config.MaxEmailsPerHour = 0
+	}()
+
+	now := time.Unix(1600000000, 0)
+	recipients := rcpts{"Foo <foo@example.com>", "bar@example.com"}
+	for i := 0; i < 2; i++ {
+		allowed, summaries, err := rateLimit(recipients, now)
+		assert.NoError(t, err)
+		assert.Equal(t, recipients, allowed)
+		assert.Empty(t, summaries)
+	}
+
+	// the buckets are empty
+	allowed, summaries, err := rateLimit(recipients, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, allowed)
+	assert.Empty(t, summaries)
+	allowed, _, err = rateLimit(recipients[1:], now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, allowed)
+
+	// half an hour refills one token, the suppressed emails are summarized
+	allowed, summaries, err = rateLimit(rcpts{"foo@example.com", "bar@example.com"}, now.Add(31*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, rcpts{"foo@example.com", "bar@example.com"}, allowed)
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, "foo@example.com", summaries[0].recipient)
+		assert.Equal(t, 1, summaries[0].count)
+		assert.Equal(t, now.Add(time.Minute), summaries[0].since)
+		assert.Equal(t, 2, summaries[1].count)
+	}
+
+	// unlimited
+	config.MaxEmailsPerHour = 0
+	allowed, _, err = rateLimit(recipients, now.Add(31*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, recipients, allowed)
+}
+
+func TestRefundRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	config.MaxEmailsPerHour = 1
+	defer func() {
+		config.StateDir = ""
+		config.MaxEmailsPerHour = 0
+	}()
+
+	now := time.Unix(1600000000, 0)
+	recipients := rcpts{"foo@example.com", "bar@example.com"}
+	allowed, _, err := rateLimit(recipients, now)
+	assert.NoError(t, err)
+	assert.Equal(t, recipients, allowed)
+
+	// a failed email doesn't count against the limit
+	assert.NoError(t, refundRateLimit(rcpts{"foo@example.com"}, nil, now))
+	allowed, _, err = rateLimit(recipients, now)
+	assert.NoError(t, err)
+	assert.Equal(t, rcpts{"foo@example.com"}, allowed)
+
+	// an unsent summary is owed again, including the emails suppressed since
+	allowed, _, err = rateLimit(recipients, now)
+	assert.NoError(t, err)
+	assert.Empty(t, allowed)
+	unsent := []suppressedSummary{{recipient: "foo@example.com", count: 2, since: now.Add(-time.Minute)}}
+	assert.NoError(t, refundRateLimit(nil, unsent, now))
+	_, summaries, err := rateLimit(rcpts{"foo@example.com"}, now.Add(time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, 3, summaries[0].count)
+		assert.Equal(t, now.Add(-time.Minute), summaries[0].since)
+	}
+}
+
+func TestSendRateLimitedFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	memory := &transport.Memory{Err: errors.New("connection refused")}
+	messageSender = memory
+	config.StateDir = dir
+	config.MaxEmailsPerHour = 1
+	config.FromEmail = "sensu@example.com"
+	config.ToEmail = []string{"ops@example.com"}
+	defer func() {
+		messageSender = nil
+		config.StateDir = ""
+		config.MaxEmailsPerHour = 0
+		config.FromEmail = ""
+		config.ToEmail = nil
+	}()
+
+	event := corev2.FixtureEvent("server01", "disk")
+	sent, err := sendRateLimited(event, "disk", "disk full", ContentPlain)
+	assert.Error(t, err)
+	assert.False(t, sent)
+
+	// the failed email took no token, so the retry is sent
+	memory.Err = nil
+	sent, err = sendRateLimited(event, "disk", "disk full", ContentPlain)
+	assert.NoError(t, err)
+	assert.True(t, sent)
+	assert.Len(t, memory.Messages(), 1)
+}
+
+func TestRateLimitConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	config.MaxEmailsPerHour = 10
+	defer func() {
+		config.StateDir = ""
+		config.MaxEmailsPerHour = 0
+	}()
+
+	// concurrent handlers don't lose each other's updates
+	now := time.Unix(1600000000, 0)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sent int
+	for i := 0; i < 25; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			allowed, _, err := rateLimit(rcpts{"ops@example.com"}, now)
+			assert.NoError(t, err)
+			mu.Lock()
+			sent += len(allowed)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, sent)
+	bucket, err := loadRateBucket("ops@example.com", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 15, bucket.Suppressed)
+}
+
+func TestSendRateLimitedDuplicateRecipient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	memory := &transport.Memory{}
+	messageSender = memory
+	config.StateDir = dir
+	config.MaxEmailsPerHour = 2
+	config.FromEmail = "sensu@example.com"
+	config.ToEmail = []string{"ops@example.com"}
+	config.CcEmail = []string{"OPS@example.com", "lead@example.com"}
+	defer func() {
+		messageSender = nil
+		config.StateDir = ""
+		config.MaxEmailsPerHour = 0
+		config.FromEmail = ""
+		config.ToEmail = nil
+		config.CcEmail = nil
+	}()
+
+	// a recipient in both to and cc is only charged once per email
+	event := corev2.FixtureEvent("server01", "disk")
+	for i := 0; i < 2; i++ {
+		sent, err := sendRateLimited(event, "disk", "disk full", ContentPlain)
+		assert.NoError(t, err)
+		assert.True(t, sent)
+	}
+	messages := memory.Messages()
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, []string{"ops@example.com", "lead@example.com"}, messages[1].To)
+	}
+}
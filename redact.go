@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// compileRedactPatterns compiles the --redactPattern regular expressions.
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if len(strings.TrimSpace(pattern)) == 0 {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s %q: %v", redactPattern, pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// redactFieldsPattern returns a regular expression matching the values of
+// key=value and key: value pairs, quoted or not, for the fields.
+func redactFieldsPattern(fields []string) *regexp.Regexp {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = regexp.QuoteMeta(field)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)["']?\s*[:=]\s*("[^"]*"|'[^']*'|[^\s,;&]+)`)
+}
+
+// redactText replaces the text matched by the patterns with REDACTED. For
+// patterns with capturing groups only the groups are replaced, so e.g.
+// `token=(\S+)` keeps the name of the token.
+func redactText(text string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		matches := re.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			spans := m[:2]
+			if re.NumSubexp() > 0 {
+				spans = m[2:]
+			}
+			for i := 0; i+1 < len(spans); i += 2 {
+				// skip groups that didn't match or overlap a replaced one
+				if spans[i] < last {
+					continue
+				}
+				b.WriteString(text[last:spans[i]])
+				b.WriteString(corev2.Redacted)
+				last = spans[i+1]
+			}
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text
+}
+
+// redactEvent masks the values of the fields in the entity's redact list and
+// the text matched by --redactPattern in the output of the check and its
+// hooks. With --redactDefaultFields the default fields of Sensu are used for
+// entities without a redact list.
+func redactEvent(event *corev2.Event) {
+	if event == nil || event.Check == nil {
+		return
+	}
+	var fields []string
+	if event.Entity != nil {
+		fields = event.Entity.Redact
+	}
+	if len(fields) == 0 && config.RedactDefaultFields {
+		fields = corev2.DefaultRedactFields
+	}
+	patterns := redactPatterns
+	if len(fields) > 0 {
+		patterns = append([]*regexp.Regexp{redactFieldsPattern(fields)}, redactPatterns...)
+	}
+	if len(patterns) == 0 {
+		return
+	}
+	event.Check.Output = redactText(event.Check.Output, patterns)
+	for _, hook := range event.Check.Hooks {
+		if hook != nil {
+			hook.Output = redactText(hook.Output, patterns)
+		}
+	}
+}
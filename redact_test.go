@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactText(t *testing.T) {
+	patterns, err := compileRedactPatterns([]string{`\d{3}-\d{2}-\d{4}`, `Bearer (\S+)`})
+	assert.NoError(t, err)
+	assert.Equal(t, "ssn REDACTED, Authorization: Bearer REDACTED", redactText("ssn 123-45-6789, Authorization: Bearer abc.def", patterns))
+	assert.Equal(t, "nothing to hide", redactText("nothing to hide", patterns))
+
+	_, err = compileRedactPatterns([]string{"("})
+	assert.Error(t, err)
+}
+
+func TestRedactEvent(t *testing.T) {
+	patterns, err := compileRedactPatterns([]string{`\b\d{16}\b`})
+	assert.NoError(t, err)
+	redactPatterns = patterns
+	defer func() {
+		redactPatterns = nil
+		config.RedactDefaultFields = false
+	}()
+
+	// without a redact list only --redactPattern applies
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Entity.Redact = nil
+	event.Check.Output = "tests pass: 42, password=hunter2, card 4111111111111111"
+	redactEvent(event)
+	assert.Equal(t, "tests pass: 42, password=hunter2, card REDACTED", event.Check.Output)
+
+	config.RedactDefaultFields = true
+	event.Check.Output = `login failed for user=admin password=hunter2, api_key: "s3cr3t" card 4111111111111111`
+	event.Check.Hooks = []*corev2.Hook{{Output: `{"passwd": "letmein"}`}}
+	redactEvent(event)
+	assert.Equal(t, `login failed for user=admin password=REDACTED, api_key: REDACTED card REDACTED`, event.Check.Output)
+	assert.Equal(t, `{"passwd": REDACTED}`, event.Check.Hooks[0].Output)
+
+	event.Entity.Redact = []string{"user"}
+	event.Check.Output = "user=admin password=hunter2"
+	redactEvent(event)
+	assert.Equal(t, "user=REDACTED password=hunter2", event.Check.Output)
+}
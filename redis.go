@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+)
+
+// defaultRedisKey is the Redis list or stream the emails are published to by
+// default.
+const defaultRedisKey = "sensu-email-handler:emails"
+
+// redisQueue is the Redis queue of --redisURL the emails are published to,
+// if set.
+var redisQueue *transport.Redis
+
+// checkRedisQueue sets up the Redis queue of --redisURL, if set.
+func checkRedisQueue() error {
+	redisQueue = nil
+	if len(config.RedisURL) == 0 {
+		return nil
+	}
+	queue, err := transport.ParseRedisURL(config.RedisURL)
+	if err != nil {
+		// the URL isn't in the error, as it may hold the password
+		return fmt.Errorf("invalid --%s: %v", redisURL, err)
+	}
+	if len(config.RedisKey) == 0 {
+		return fmt.Errorf("--%s must not be empty", redisKey)
+	}
+	queue.Key = config.RedisKey
+	queue.Stream = config.RedisStream
+	redisQueue = queue
+	return nil
+}
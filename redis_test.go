@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisQueue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	commands := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// *3, then the length and value of RPUSH, the key and the message
+		r := bufio.NewReader(conn)
+		var lines []string
+		for i := 0; i < 7; i++ {
+			line, _ := r.ReadString('\n')
+			lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+		}
+		conn.Write([]byte(":1\r\n"))
+		commands <- strings.Join(lines, "\n")
+	}()
+
+	config.RedisURL = "redis://" + listener.Addr().String()
+	config.RedisKey = "emails"
+	config.FromEmail = "sensu@example.com"
+	defer func() {
+		config.RedisURL = ""
+		config.RedisKey = ""
+		config.FromEmail = ""
+		redisQueue = nil
+	}()
+	assert.NoError(t, checkRedisQueue())
+	assert.Equal(t, redisQueue, configuredSender())
+	assert.NoError(t, transmit("1", rcpts{"ops@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n")))
+	lines := strings.Split(<-commands, "\n")
+	assert.Equal(t, []string{"*3", "$5", "RPUSH", "$6", "emails"}, lines[:5])
+	var queued transport.QueuedMessage
+	assert.NoError(t, json.Unmarshal([]byte(lines[6]), &queued))
+	assert.Equal(t, []string{"ops@example.com"}, queued.To)
+	assert.Equal(t, "sensu@example.com", queued.From)
+	assert.Equal(t, "Subject: disk\r\n\r\ndisk full\r\n", string(queued.Message))
+
+	config.RedisURL = "redis://:password@"
+	err = checkRedisQueue()
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "password")
+	}
+	config.RedisURL = "redis://localhost"
+	config.RedisKey = ""
+	assert.Error(t, checkRedisQueue())
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the largest template that will be fetched from a URL
+const maxTemplateSize = 1 << 20
+
+// parsed --templateCacheTTL
+var templateCacheDuration time.Duration
+
+// templateCacheEntry is what is recorded in the template cache directory for
+// each template URL.
+type templateCacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Fetched is the Unix time the template was last fetched or revalidated
+	Fetched  int64  `json:"fetched"`
+	Template string `json:"template"`
+}
+
+// isTemplateURL returns whether a template file option is an http(s), s3 or
+// gs URL.
+func isTemplateURL(name string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://", "gs://"} {
+		if strings.HasPrefix(name, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// readTemplateFile returns the contents of a template file, which may be an
+// http(s), s3 or gs URL.
+func readTemplateFile(name string) ([]byte, error) {
+	if isTemplateURL(name) {
+		return fetchTemplate(name)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// verifyTemplateChecksum returns an error unless the template matches the hex
+// encoded SHA-256 checksum, so that a template that has been tampered with,
+// on disk or at its URL, is never executed.
+func verifyTemplateChecksum(name string, template []byte, checksum string) error {
+	sum := sha256.Sum256(template)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), strings.TrimSpace(checksum)) {
+		return fmt.Errorf("template file %s does not match its SHA-256 checksum", name)
+	}
+	return nil
+}
+
+// templateCacheFile returns the path of the cache file for the template URL.
+func templateCacheFile(templateURL string) string {
+	return filepath.Join(config.TemplateCacheDir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(templateURL))))
+}
+
+// fetchTemplate returns the template at the URL. With --templateCacheDir the
+// template is cached, used without fetching it again for --templateCacheTTL
+// and then revalidated with its ETag or Last-Modified time. The cached copy
+// is used if the URL cannot be fetched.
+func fetchTemplate(templateURL string) ([]byte, error) {
+	var cached *templateCacheEntry
+	if len(config.TemplateCacheDir) > 0 {
+		cached = loadTemplateCache(templateURL)
+	}
+	now := time.Now()
+	if cached != nil && now.Sub(time.Unix(cached.Fetched, 0)) < templateCacheDuration {
+		return []byte(cached.Template), nil
+	}
+
+	entry, err := getTemplate(templateURL, cached)
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		fmt.Printf("Using cached template: %v\n", err)
+		return []byte(cached.Template), nil
+	}
+	if len(config.TemplateCacheDir) > 0 {
+		entry.Fetched = now.Unix()
+		if err := saveTemplateCache(entry); err != nil {
+			fmt.Printf("Failed to cache template %s: %v\n", templateURL, err)
+		}
+	}
+	return []byte(entry.Template), nil
+}
+
+// getTemplate fetches the template from the URL, conditionally if there is a
+// cached copy, returning the cached copy if it has not been modified.
+func getTemplate(templateURL string, cached *templateCacheEntry) (*templateCacheEntry, error) {
+	req, err := newTemplateRequest(templateURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template: %v", err)
+	}
+	if cached != nil {
+		if len(cached.ETag) > 0 {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if len(cached.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: config.TLSSkipVerify,
+				RootCAs:            tlsRootCAs,
+			},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch template %s: %s", templateURL, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTemplateSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template: %v", err)
+	}
+	if len(data) > maxTemplateSize {
+		return nil, fmt.Errorf("template %s is larger than %d bytes", templateURL, maxTemplateSize)
+	}
+	return &templateCacheEntry{
+		URL:          templateURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Template:     string(data),
+	}, nil
+}
+
+// newTemplateRequest returns the request for the template URL, signed with
+// the AWS or Google credentials for s3 and gs URLs.
+func newTemplateRequest(templateURL string) (*http.Request, error) {
+	switch {
+	case strings.HasPrefix(templateURL, "s3://"):
+		return newS3Request(templateURL)
+	case strings.HasPrefix(templateURL, "gs://"):
+		return newGCSRequest(templateURL)
+	}
+	req, err := http.NewRequest(http.MethodGet, templateURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme == "https" {
+		setTemplateCredentials(req)
+	}
+	return req, nil
+}
+
+// setTemplateCredentials adds the --templateHeader headers and the bearer
+// token or basic auth credentials to the request. They are only sent over
+// https so they are never exposed in transit.
+func setTemplateCredentials(req *http.Request) {
+	for _, h := range config.TemplateHeaders {
+		// validated by checkArgs
+		name, value, _ := parseTemplateHeader(h)
+		req.Header.Set(name, value)
+	}
+	if len(config.TemplateToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+config.TemplateToken)
+	} else if len(config.TemplateUsername) > 0 {
+		req.SetBasicAuth(config.TemplateUsername, config.TemplatePassword)
+	}
+}
+
+// parseTemplateHeader splits a template header of the form "Name: value"
+// into its name and value.
+func parseTemplateHeader(header string) (string, string, error) {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("template header %q is not of the form \"Name: value\"", header)
+	}
+	name := strings.TrimSpace(parts[0])
+	if len(name) == 0 || strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' }) >= 0 {
+		return "", "", fmt.Errorf("template header %q has an invalid name", header)
+	}
+	return name, strings.TrimSpace(parts[1]), nil
+}
+
+// loadTemplateCache returns the cached template for the URL, or nil if it is
+// not cached or the cache file cannot be read.
+func loadTemplateCache(templateURL string) *templateCacheEntry {
+	data, err := ioutil.ReadFile(templateCacheFile(templateURL))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Failed to read cached template %s: %v\n", templateURL, err)
+		}
+		return nil
+	}
+	entry := &templateCacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil || entry.URL != templateURL {
+		fmt.Printf("Ignoring invalid cached template %s\n", templateURL)
+		return nil
+	}
+	return entry
+}
+
+// saveTemplateCache records the template in the cache directory.
+func saveTemplateCache(entry *templateCacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(templateCacheFile(entry.URL), data)
+}
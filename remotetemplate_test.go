@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchTemplate(t *testing.T) {
+	var requests, notModified int
+	body := "{{.Check.Output}}"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(body))
+	}))
+
+	dir, err := ioutil.TempDir("", "templates")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.TemplateCacheDir = dir
+	templateCacheDuration = time.Hour
+	defer func() {
+		config.TemplateCacheDir = ""
+		templateCacheDuration = 0
+	}()
+
+	// fetched once, then used from the cache within the TTL
+	for i := 0; i < 2; i++ {
+		template, err := readTemplateFile(server.URL + "/body.tmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(template))
+	}
+	assert.Equal(t, 1, requests)
+
+	// revalidated after the TTL
+	templateCacheDuration = 0
+	template, err := readTemplateFile(server.URL + "/body.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(template))
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, notModified)
+
+	// the cached copy is used when the origin is unreachable
+	server.Close()
+	template, err = readTemplateFile(server.URL + "/body.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(template))
+
+	_, err = readTemplateFile(server.URL + "/other.tmpl")
+	assert.Error(t, err)
+}
+
+func TestFetchTemplateNoCache(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/missing.tmpl" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Empty(t, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("{{.Entity.Name}}"))
+	}))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		template, err := readTemplateFile(server.URL + "/body.tmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, "{{.Entity.Name}}", string(template))
+	}
+	assert.Equal(t, 2, requests)
+
+	_, err := readTemplateFile(server.URL + "/missing.tmpl")
+	assert.EqualError(t, err, "failed to fetch template "+server.URL+"/missing.tmpl: 404 Not Found")
+}
+
+func TestFetchTemplateCredentials(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization") + "|" + r.Header.Get("X-Api-Version")))
+	})
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	dir, err := ioutil.TempDir("", "ca")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	config.TemplateHeaders = []string{"X-Api-Version: 3"}
+	config.TemplateToken = "secret"
+	defer func() {
+		config.TemplateHeaders = nil
+		config.TemplateToken = ""
+		config.TemplateUsername = ""
+		config.TemplatePassword = ""
+		tlsRootCAs = nil
+	}()
+
+	// the test server certificate is not trusted without the CA file
+	_, err = readTemplateFile(server.URL + "/body.tmpl")
+	assert.Error(t, err)
+
+	tlsRootCAs, err = loadCAFile(caFile)
+	assert.NoError(t, err)
+	template, err := readTemplateFile(server.URL + "/body.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer secret|3", string(template))
+
+	config.TemplateToken = ""
+	config.TemplateUsername = "sensu"
+	config.TemplatePassword = "password"
+	template, err = readTemplateFile(server.URL + "/body.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, "Basic c2Vuc3U6cGFzc3dvcmQ=|3", string(template))
+
+	// credentials are never sent over http
+	template, err = readTemplateFile(plainServer.URL + "/body.tmpl")
+	assert.NoError(t, err)
+	assert.Equal(t, "|", string(template))
+
+	_, err = loadCAFile(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "empty.pem"), []byte("not a certificate"), 0600))
+	_, err = loadCAFile(filepath.Join(dir, "empty.pem"))
+	assert.EqualError(t, err, "no certificates found in CA file "+filepath.Join(dir, "empty.pem"))
+}
+
+func TestParseTemplateHeader(t *testing.T) {
+	name, value, err := parseTemplateHeader("PRIVATE-TOKEN: abc:def ")
+	assert.NoError(t, err)
+	assert.Equal(t, "PRIVATE-TOKEN", name)
+	assert.Equal(t, "abc:def", value)
+	_, _, err = parseTemplateHeader("no colon")
+	assert.Error(t, err)
+	_, _, err = parseTemplateHeader("bad name: value")
+	assert.Error(t, err)
+}
+
+func TestVerifyTemplateChecksum(t *testing.T) {
+	template := []byte("{{.Check.Output}}\n")
+	checksum := "ff39756c089eba6e982fd2194b40afe3cd2e9a4e2d07ad89621e15392eeace89"
+	assert.NoError(t, verifyTemplateChecksum("body.tmpl", template, checksum))
+	assert.NoError(t, verifyTemplateChecksum("body.tmpl", template, strings.ToUpper(checksum)))
+	assert.EqualError(t, verifyTemplateChecksum("body.tmpl", []byte("{{.Check.Output}} {{.Entity.System}}\n"), checksum),
+		"template file body.tmpl does not match its SHA-256 checksum")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// deliveryResult is printed as a JSON line for each email sent when
+// --jsonResult is set.
+type deliveryResult struct {
+	Namespace         string   `json:"namespace"`
+	Entity            string   `json:"entity"`
+	Check             string   `json:"check"`
+	Recipients        []string `json:"recipients"`
+	Subject           string   `json:"subject"`
+	MessageID         string   `json:"message_id,omitempty"`
+	Success           bool     `json:"success"`
+	SMTPCode          int      `json:"smtp_code,omitempty"`
+	Spooled           bool     `json:"spooled,omitempty"`
+	InvalidRecipients []string `json:"invalid_recipients,omitempty"`
+	Duration          float64  `json:"duration"`
+	Error             string   `json:"error,omitempty"`
+}
+
+func newDeliveryResult(event *corev2.Event, recipients rcpts, subject, messageID string, duration time.Duration, err error) *deliveryResult {
+	result := &deliveryResult{
+		Namespace:  event.Entity.Namespace,
+		Entity:     event.Entity.Name,
+		Check:      eventCheck(event).Name,
+		Recipients: recipients,
+		Subject:    subject,
+		MessageID:  messageID,
+		Success:    err == nil,
+		Duration:   duration.Seconds(),
+	}
+	var smtpErr *textproto.Error
+	if err == nil {
+		// the server accepted the message data
+		result.SMTPCode = 250
+	} else {
+		result.Error = err.Error()
+		if errors.As(err, &smtpErr) {
+			result.SMTPCode = smtpErr.Code
+		}
+		var spooled *spooledError
+		result.Spooled = errors.As(err, &spooled)
+		var invalid *smtpsend.InvalidRecipientsError
+		if errors.As(err, &invalid) {
+			result.InvalidRecipients = invalid.Recipients
+		}
+	}
+	return result
+}
+
+// printResult prints the result of sending an email as a JSON line.
+func printResult(event *corev2.Event, recipients rcpts, subject, messageID string, start time.Time, err error) {
+	result := newDeliveryResult(event, recipients, subject, messageID, time.Since(start), err)
+	resultBytes, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		return
+	}
+	fmt.Println(string(resultBytes))
+}
+
+// --failOn values
+const (
+	FailOnAny = "any"
+	FailOnAll = "all"
+)
+
+// exitPartialFailure is the exit status when the email was sent to some of
+// the recipients but not others, with --failOn any
+const exitPartialFailure = 2
+
+// recipientResult is the outcome of sending an email to a recipient: sent,
+// spooled or failed.
+type recipientResult struct {
+	recipient string
+	status    string
+	err       error
+}
+
+// recipientResults are the outcomes for the recipients of the emails sent for
+// the event being handled
+var recipientResults []recipientResult
+
+// recordRecipientResults records the outcome of sending an email to each of
+// its recipients, from the recipients the error reports as invalid or
+// undelivered, if any.
+func recordRecipientResults(recipients rcpts, err error) {
+	failed := map[string]error{}
+	var invalid *smtpsend.InvalidRecipientsError
+	if errors.As(err, &invalid) {
+		for i, r := range invalid.Recipients {
+			failed[r] = invalid.Errs[i]
+		}
+	}
+	var undelivered *undeliveredError
+	if errors.As(err, &undelivered) {
+		for _, r := range undelivered.recipients {
+			failed[r] = undelivered.err
+		}
+	}
+	var spooled *spooledError
+	failedStatus := "failed"
+	if errors.As(err, &spooled) {
+		failedStatus = "spooled"
+	}
+	for _, r := range recipients {
+		result := recipientResult{recipient: r, status: "sent"}
+		if recipientErr, ok := failed[r]; ok {
+			result.status, result.err = failedStatus, recipientErr
+		} else if err != nil && len(failed) == 0 {
+			result.status, result.err = failedStatus, err
+		}
+		recipientResults = append(recipientResults, result)
+	}
+}
+
+// printRecipientResults prints the outcome for each recipient when there was
+// more than one, or the email wasn't sent.
+func printRecipientResults() {
+	if len(recipientResults) == 0 || (len(recipientResults) == 1 && recipientResults[0].status == "sent") {
+		return
+	}
+	fmt.Println("Delivery results:")
+	for _, result := range recipientResults {
+		if result.err != nil {
+			fmt.Printf("  %s: %s (%v)\n", result.recipient, result.status, result.err)
+		} else {
+			fmt.Printf("  %s: %s\n", result.recipient, result.status)
+		}
+	}
+}
+
+// deliveryExitStatus returns the exit status and error of handling an event,
+// from the error and the outcome for each recipient. When the email was sent
+// to some recipients but failed for others the handler fails with
+// exitPartialFailure with --failOn any, and succeeds with --failOn all.
+func deliveryExitStatus(results []recipientResult, err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var sent, failed int
+	for _, result := range results {
+		switch result.status {
+		case "sent", "spooled":
+			sent++
+		case "failed":
+			failed++
+		}
+	}
+	if sent == 0 || failed == 0 {
+		return 1, err
+	}
+	if config.FailOn == FailOnAll {
+		fmt.Printf("Not failing, the email was sent to %d of %d recipients: %v\n", sent, sent+failed, err)
+		return 0, nil
+	}
+	return exitPartialFailure, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDeliveryResult(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	recipients := rcpts{"ops@example.com"}
+
+	result := newDeliveryResult(event, recipients, "subject", "<id@example.com>", 1500*time.Millisecond, nil)
+	assert.Equal(t, &deliveryResult{
+		Namespace:  "default",
+		Entity:     "foo",
+		Check:      "bar",
+		Recipients: []string{"ops@example.com"},
+		Subject:    "subject",
+		MessageID:  "<id@example.com>",
+		Success:    true,
+		SMTPCode:   250,
+		Duration:   1.5,
+	}, result)
+
+	err := fmt.Errorf("failed: %w", &textproto.Error{Code: 550, Msg: "mailbox unavailable"})
+	result = newDeliveryResult(event, recipients, "subject", "", time.Second, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, 550, result.SMTPCode)
+	assert.Contains(t, result.Error, "mailbox unavailable")
+
+	invalid := &smtpsend.InvalidRecipientsError{
+		Recipients: []string{"unknown@example.com"},
+		Errs:       []error{&textproto.Error{Code: 550, Msg: "no such user"}},
+		Sent:       true,
+	}
+	result = newDeliveryResult(event, recipients, "subject", "", time.Second, invalid)
+	assert.Equal(t, []string{"unknown@example.com"}, result.InvalidRecipients)
+}
+
+func TestRecipientResults(t *testing.T) {
+	recipientResults = nil
+	defer func() {
+		recipientResults = nil
+		config.FailOn = ""
+	}()
+
+	rejected := &textproto.Error{Code: 550, Msg: "no such user"}
+	invalid := &smtpsend.InvalidRecipientsError{Recipients: []string{"b@example.com"}, Errs: []error{rejected}, Sent: true}
+	recordRecipientResults(rcpts{"a@example.com", "b@example.com"}, invalid)
+	timeout := errors.New("i/o timeout")
+	recordRecipientResults(rcpts{"c@example.org", "d@example.net"},
+		&spooledError{&undeliveredError{recipients: rcpts{"d@example.net"}, err: timeout}})
+	assert.Equal(t, []recipientResult{
+		{recipient: "a@example.com", status: "sent"},
+		{recipient: "b@example.com", status: "failed", err: rejected},
+		{recipient: "c@example.org", status: "sent"},
+		{recipient: "d@example.net", status: "spooled", err: timeout},
+	}, recipientResults)
+
+	// a partial failure has its own exit status, or doesn't fail with --failOn all
+	status, err := deliveryExitStatus(recipientResults, invalid)
+	assert.Equal(t, exitPartialFailure, status)
+	assert.Equal(t, invalid, err)
+	config.FailOn = FailOnAll
+	status, err = deliveryExitStatus(recipientResults, invalid)
+	assert.Equal(t, 0, status)
+	assert.NoError(t, err)
+
+	// as does any other error, a complete failure fails
+	recipientResults = nil
+	recordRecipientResults(rcpts{"a@example.com", "b@example.com"}, timeout)
+	assert.Equal(t, "failed", recipientResults[1].status)
+	status, err = deliveryExitStatus(recipientResults, timeout)
+	assert.Equal(t, 1, status)
+	assert.Equal(t, timeout, err)
+}
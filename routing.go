@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	"github.com/sensu/sensu-email-handler/pkg/templates"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// routingRules are loaded from --routingRulesFile
+var routingRules *routingConfig
+
+// routingConfig is the routing rules file: every rule matching an event adds
+// its destinations, and the default destinations are used when none match.
+type routingConfig struct {
+	Rules   []routingRule       `yaml:"rules"`
+	Default routingDestinations `yaml:"default"`
+}
+
+type routingRule struct {
+	Name  string              `yaml:"name"`
+	Match routingMatch        `yaml:",inline"`
+	Dest  routingDestinations `yaml:",inline"`
+}
+
+// routingMatch is what an event must match for a rule to apply. Empty fields
+// match any event, names may be globs (e.g. disk-*).
+type routingMatch struct {
+	Namespace string            `yaml:"namespace"`
+	Entity    string            `yaml:"entity"`
+	Check     string            `yaml:"check"`
+	Labels    map[string]string `yaml:"labels"`
+	// Severities are the status names matched, e.g. critical or ok
+	Severities []string `yaml:"severity"`
+}
+
+type routingDestinations struct {
+	To  []string `yaml:"to"`
+	Cc  []string `yaml:"cc"`
+	Bcc []string `yaml:"bcc"`
+}
+
+func (d routingDestinations) empty() bool {
+	return len(d.To)+len(d.Cc)+len(d.Bcc) == 0
+}
+
+// loadRoutingRules reads and validates the YAML routing rules file.
+func loadRoutingRules(file string) (*routingConfig, error) {
+	rulesBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing rules file %s: %v", file, err)
+	}
+	return parseRoutingRules(rulesBytes, "file "+file)
+}
+
+// parseRoutingRules parses and validates the routing rules from the source,
+// the file or config file they are in.
+func parseRoutingRules(rulesBytes []byte, source string) (*routingConfig, error) {
+	rules := &routingConfig{}
+	if err := yaml.UnmarshalStrict(rulesBytes, rules); err != nil {
+		return nil, fmt.Errorf("failed to parse routing rules %s: %v", source, err)
+	}
+	for i, rule := range rules.Rules {
+		name := rule.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		if rule.Dest.empty() {
+			return nil, fmt.Errorf("routing rule %s has no destinations", name)
+		}
+		for _, pattern := range []string{rule.Match.Namespace, rule.Match.Entity, rule.Match.Check} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("routing rule %s has an invalid pattern %q", name, pattern)
+			}
+		}
+		for _, severity := range rule.Match.Severities {
+			switch strings.ToUpper(severity) {
+			case "OK", "WARNING", "CRITICAL", "UNKNOWN":
+			default:
+				return nil, fmt.Errorf("routing rule %s has an invalid severity %s", name, severity)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// matches reports whether the event matches all of the rule's conditions.
+func (m routingMatch) matches(event *corev2.Event) bool {
+	patterns := []string{m.Namespace, m.Entity, m.Check}
+	check := eventCheck(event)
+	names := []string{event.Entity.Namespace, event.Entity.Name, check.Name}
+	for i, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		if ok, _ := path.Match(pattern, names[i]); !ok {
+			return false
+		}
+	}
+	for key, value := range m.Labels {
+		label, ok := check.Labels[key]
+		if !ok {
+			label, ok = event.Entity.Labels[key]
+		}
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(value, label); !matched {
+			return false
+		}
+	}
+	// metrics events have no check, so no severity
+	if len(m.Severities) > 0 && event.Check == nil {
+		return false
+	} else if len(m.Severities) > 0 {
+		status := templates.StatusName(event.Check.Status)
+		for _, severity := range m.Severities {
+			if strings.EqualFold(severity, status) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// route returns the destinations of every rule matching the event, or the
+// default destinations if none match. There are none without routing rules.
+func (c *routingConfig) route(event *corev2.Event) routingDestinations {
+	var dest routingDestinations
+	if c == nil {
+		return dest
+	}
+	for _, rule := range c.Rules {
+		if rule.Match.matches(event) {
+			dest.To = append(dest.To, rule.Dest.To...)
+			dest.Cc = append(dest.Cc, rule.Dest.Cc...)
+			dest.Bcc = append(dest.Bcc, rule.Dest.Bcc...)
+		}
+	}
+	if dest.empty() {
+		return c.Default
+	}
+	return dest
+}
+
+// eventRcpts returns the to, cc and bcc recipients of the email for the
+// event, from the routing rules if any apply, otherwise from the contacts and
+// the --toEmail, --ccEmail and --bccEmail flags. Escalated events are also
+// sent to the --escalationToEmail recipients. Aliases in the address book and
+// ldap: recipients are replaced by their addresses.
+func eventRcpts(event *corev2.Event) (rcpts, rcpts, rcpts) {
+	var to, cc, bcc rcpts
+	if dest := routingRules.route(event); !dest.empty() {
+		to, cc, bcc = newRcpts(dest.To), newRcpts(dest.Cc), newRcpts(dest.Bcc)
+	} else {
+		to, cc, bcc = toRcpts(event), newRcpts(config.CcEmail), newRcpts(config.BccEmail)
+	}
+	if escalated(event) {
+		to = append(to, newRcpts(config.EscalationToEmail)...)
+	}
+	to, cc, bcc = resolveRcpts(event, to), resolveRcpts(event, cc), resolveRcpts(event, bcc)
+	return uniqueRcpts(to), uniqueRcpts(cc), uniqueRcpts(bcc)
+}
+
+// resolveRcpts replaces the aliases and then the ldap: recipients with their
+// email addresses.
+func resolveRcpts(event *corev2.Event, recipients rcpts) rcpts {
+	return expandLDAP(event, expandAliases(ldapRcptTokens(recipients)))
+}
+
+// uniqueRcpts removes any duplicate recipients, keeping the first.
+func uniqueRcpts(recipients rcpts) rcpts {
+	seen := map[string]bool{}
+	var unique rcpts
+	for _, r := range recipients {
+		address := strings.ToLower(smtpsend.EnvelopeAddress(r))
+		if !seen[address] {
+			seen[address] = true
+			unique = append(unique, r)
+		}
+	}
+	return unique
+}
+
+// uniqueAcrossRcpts removes any duplicate recipients from the to, cc and bcc
+// lists, keeping each in the first list it occurs in.
+func uniqueAcrossRcpts(to, cc, bcc rcpts) (rcpts, rcpts, rcpts) {
+	seen := map[string]bool{}
+	lists := []rcpts{to, cc, bcc}
+	for i, list := range lists {
+		var unique rcpts
+		for _, r := range list {
+			address := strings.ToLower(smtpsend.EnvelopeAddress(r))
+			if !seen[address] {
+				seen[address] = true
+				unique = append(unique, r)
+			}
+		}
+		lists[i] = unique
+	}
+	return lists[0], lists[1], lists[2]
+}
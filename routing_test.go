@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRoutingRules = `
+rules:
+  - name: databases
+    namespace: production
+    check: "postgres-*"
+    to: [dba@example.com]
+  - name: critical
+    severity: [critical]
+    labels:
+      team: "web*"
+    to: [web-oncall@example.com]
+    cc: [web-lead@example.com, dba@example.com]
+default:
+  to: [ops@example.com]
+`
+
+func writeRoutingRules(t *testing.T, rules string) string {
+	file, err := ioutil.TempFile("", "routing")
+	assert.NoError(t, err)
+	_, err = file.WriteString(rules)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	return file.Name()
+}
+
+func TestRoutingRules(t *testing.T) {
+	file := writeRoutingRules(t, testRoutingRules)
+	defer os.Remove(file)
+	rules, err := loadRoutingRules(file)
+	assert.NoError(t, err)
+	routingRules = rules
+	defer func() { routingRules = nil }()
+
+	event := corev2.FixtureEvent("web01", "postgres-replication")
+	event.Entity.Namespace = "production"
+	event.Check.Status = 1
+	to, cc, bcc := eventRcpts(event)
+	assert.Equal(t, rcpts{"dba@example.com"}, to)
+	assert.Empty(t, cc)
+	assert.Empty(t, bcc)
+
+	// both rules match
+	event.Check.Status = 2
+	event.Entity.Labels = map[string]string{"team": "webapps"}
+	to, cc, _ = eventRcpts(event)
+	assert.Equal(t, rcpts{"dba@example.com", "web-oncall@example.com"}, to)
+	assert.Equal(t, rcpts{"web-lead@example.com", "dba@example.com"}, cc)
+
+	// nothing matches
+	event.Entity.Namespace = "default"
+	event.Entity.Labels = nil
+	to, _, _ = eventRcpts(event)
+	assert.Equal(t, rcpts{"ops@example.com"}, to)
+}
+
+func TestLoadRoutingRulesInvalid(t *testing.T) {
+	for _, rules := range []string{
+		"rules:\n  - check: foo\n",
+		"rules:\n  - check: \"[\"\n    to: [ops@example.com]\n",
+		"rules:\n  - severity: [bad]\n    to: [ops@example.com]\n",
+		"rules:\n  - chek: foo\n    to: [ops@example.com]\n",
+	} {
+		file := writeRoutingRules(t, rules)
+		_, err := loadRoutingRules(file)
+		assert.Error(t, err, rules)
+		os.Remove(file)
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	config.ToEmail = []string{"ops@example.com"}
+	config.EscalationToEmail = []string{"manager@example.com, ops@example.com"}
+	config.EscalationOccurrences = 5
+	config.EscalationSubjectPrefix = "[ESCALATED] "
+	config.SubjectTemplate = "{{.Check.Name}}"
+	defer func() {
+		config.ToEmail = nil
+		config.EscalationToEmail = nil
+		config.EscalationOccurrences = 0
+		config.EscalationSubjectPrefix = ""
+		config.SubjectTemplate = ""
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.Occurrences = 4
+	to, _, _ := eventRcpts(event)
+	assert.Equal(t, rcpts{"ops@example.com"}, to)
+	subject, _, _, err := composeEmail(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", subject)
+
+	event.Check.Occurrences = 5
+	to, _, _ = eventRcpts(event)
+	assert.Equal(t, rcpts{"ops@example.com", "manager@example.com"}, to)
+	subject, _, _, err = composeEmail(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "[ESCALATED] bar", subject)
+
+	// resolutions are not escalated
+	event.Check.Status = 0
+	to, _, _ = eventRcpts(event)
+	assert.Equal(t, rcpts{"ops@example.com"}, to)
+}
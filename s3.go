@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// the SHA-256 hash of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// newS3Request returns a signed request for the object at the s3://bucket/key
+// URL. The credentials, region and endpoint are found by the AWS SDK the same
+// way as by the AWS CLI, so S3 compatible object stores can be used too.
+func newS3Request(templateURL string) (*http.Request, error) {
+	bucket, key, err := splitBucketURL(templateURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the AWS config: %v", err)
+	}
+	region := cfg.Region
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if len(endpoint) == 0 && cfg.BaseEndpoint != nil {
+		endpoint = *cfg.BaseEndpoint
+	}
+	if len(endpoint) == 0 {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find AWS credentials: %v", err)
+	}
+
+	// path style requests work for bucket names containing dots too
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/"+encodeObjectPath(bucket)+"/"+encodeObjectPath(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := signS3Request(ctx, req, creds, region, time.Now()); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// splitBucketURL returns the bucket and object key of an s3:// or gs:// URL.
+func splitBucketURL(objectURL string) (string, string, error) {
+	i := strings.Index(objectURL, "://")
+	parts := strings.SplitN(objectURL[i+3:], "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", fmt.Errorf("%s is not of the form %s://bucket/object", objectURL, objectURL[:i])
+	}
+	return parts[0], parts[1], nil
+}
+
+// encodeObjectPath percent encodes a bucket or object name for a request
+// path as required by AWS signature version 4, leaving only the unreserved
+// characters and slashes.
+func encodeObjectPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signS3Request signs the (bodyless) request with AWS signature version 4,
+// covering the host and any headers already set. Headers added afterwards,
+// such as the conditional request headers, are sent unsigned.
+func signS3Request(ctx context.Context, req *http.Request, creds aws.Credentials, region string, now time.Time) error {
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	// the path is already encoded, and S3 does not encode it twice
+	signer := v4.NewSigner(func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = true
+	})
+	return signer.SignHTTP(ctx, creds, req, emptyPayloadHash, "s3", region, now)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// secretPrefix marks an option value naming a Sensu secret, e.g.
+// --smtpPassword secret:EMAIL_PASSWORD. Sensu passes the secrets listed in
+// the handler definition to the handler as environment variables of their
+// names.
+const secretPrefix = "secret:"
+
+// lookupSecret returns the value of a Sensu secret passed to the handler
+var lookupSecret = os.LookupEnv
+
+// secretOptions returns the credentials and tokens whose values may name a
+// Sensu secret, by option name.
+func secretOptions() map[string]*string {
+	return map[string]*string{
+		smtpUsername:       &config.SmtpUsername,
+		smtpPassword:       &config.SmtpPassword,
+		smtpOAuth2Token:    &config.SmtpOAuth2Token,
+		vaultToken:         &config.VaultToken,
+		vaultSecretID:      &config.VaultSecretID,
+		templateToken:      &config.TemplateToken,
+		templatePassword:   &config.TemplatePassword,
+		sensuAPIKey:        &config.SensuAPIKey,
+		ldapBindPassword:   &config.LDAPBindPassword,
+		fallbackWebhookURL: &config.FallbackWebhookURL,
+		redisURL:           &config.RedisURL,
+	}
+}
+
+// resolveSecrets replaces the option values naming Sensu secrets with the
+// secrets, failing when a secret was not passed to the handler. A value set by
+// an annotation of the event cannot name a secret, as the secrets are
+// environment variables of the handler and would be sent wherever the
+// annotations say.
+func resolveSecrets(event *corev2.Event) error {
+	options := secretOptions()
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := options[name]
+		if !strings.HasPrefix(*value, secretPrefix) {
+			continue
+		}
+		if annotated(event, name) {
+			return fmt.Errorf("--%s is set by an annotation, so cannot name a Sensu secret", name)
+		}
+		secretName := strings.TrimPrefix(*value, secretPrefix)
+		if len(secretName) == 0 || strings.ContainsAny(secretName, "= \t") {
+			return fmt.Errorf("--%s names an invalid Sensu secret %q", name, secretName)
+		}
+		secret, ok := lookupSecret(secretName)
+		if !ok {
+			return fmt.Errorf("--%s names the Sensu secret %s, which was not passed to the handler: add {name: %s, secret: <secret>} to the secrets of the handler definition", name, secretName, secretName)
+		}
+		if len(secret) == 0 {
+			return fmt.Errorf("the Sensu secret %s named by --%s is empty", secretName, name)
+		}
+		*value = secret
+	}
+	return nil
+}
+
+// annotated reports whether the value of the option was set by an annotation
+// of the check or entity of the event, which the SDK applies before checkArgs.
+func annotated(event *corev2.Event, name string) bool {
+	if event == nil {
+		return false
+	}
+	for _, opt := range emailConfigOptions {
+		if opt.Argument != name || len(opt.Path) == 0 {
+			continue
+		}
+		key := path.Join(config.Keyspace, opt.Path)
+		if event.Check != nil && len(event.Check.Annotations[key]) > 0 {
+			return true
+		}
+		return event.Entity != nil && len(event.Entity.Annotations[key]) > 0
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	secrets := map[string]string{"EMAIL_PASSWORD": "hunter2", "SENSU_API_KEY": "", "EMAIL_USER": "sensu"}
+	lookupSecret = func(name string) (string, bool) {
+		secret, ok := secrets[name]
+		return secret, ok
+	}
+	defer func() {
+		lookupSecret = os.LookupEnv
+		config.SmtpUsername = ""
+		config.SmtpPassword = ""
+		config.SensuAPIKey = ""
+	}()
+
+	config.SmtpUsername = "secret:EMAIL_USER"
+	config.SmtpPassword = "secret:EMAIL_PASSWORD"
+	assert.NoError(t, resolveSecrets(nil))
+	assert.Equal(t, "sensu", config.SmtpUsername)
+	assert.Equal(t, "hunter2", config.SmtpPassword)
+
+	config.SmtpPassword = "secret:SMTP_PASSWORD"
+	assert.EqualError(t, resolveSecrets(nil), "--smtpPassword names the Sensu secret SMTP_PASSWORD, which was not passed to the handler: "+
+		"add {name: SMTP_PASSWORD, secret: <secret>} to the secrets of the handler definition")
+	config.SmtpPassword = "secret:"
+	assert.EqualError(t, resolveSecrets(nil), `--smtpPassword names an invalid Sensu secret ""`)
+	config.SmtpPassword = "not-a-secret"
+	config.SensuAPIKey = "secret:SENSU_API_KEY"
+	assert.EqualError(t, resolveSecrets(nil), "the Sensu secret SENSU_API_KEY named by --sensuAPIKey is empty")
+	assert.Equal(t, "not-a-secret", config.SmtpPassword)
+
+	// annotations cannot name the secrets of the handler
+	config.SensuAPIKey = ""
+	config.SmtpUsername = "secret:EMAIL_PASSWORD"
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Entity.Annotations = map[string]string{"sensu.io/plugins/email/config/smtpUsername": "secret:EMAIL_PASSWORD"}
+	assert.EqualError(t, resolveSecrets(event), "--smtpUsername is set by an annotation, so cannot name a Sensu secret")
+	assert.Equal(t, "secret:EMAIL_PASSWORD", config.SmtpUsername)
+	event.Entity.Annotations = nil
+	event.Check.Annotations = map[string]string{"sensu.io/plugins/email/config/smtpUsername": "secret:EMAIL_PASSWORD"}
+	assert.EqualError(t, resolveSecrets(event), "--smtpUsername is set by an annotation, so cannot name a Sensu secret")
+	event.Check.Annotations = nil
+	assert.NoError(t, resolveSecrets(event))
+	assert.Equal(t, "hunter2", config.SmtpUsername)
+}
+
+func TestCheckArgsMissingCredentials(t *testing.T) {
+	config.SmtpHost = "smtp.example.com"
+	config.FromEmail = "sensu@example.com"
+	config.ToEmail = []string{"ops@example.com"}
+	defer func() {
+		config.SmtpHost = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.ToEmail = nil
+		config.AuthMethod = ""
+		config.SmtpUsername = ""
+	}()
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.EqualError(t, checkArgs(event), "smtp username is empty, give it with --smtpUsername (-u), env SMTP_USERNAME (e.g. from a Sensu secret), --smtpUsernameFile or --vaultSecretPath")
+	config.SmtpUsername = "sensu"
+	assert.EqualError(t, checkArgs(event), "smtp password is empty, give it with --smtpPassword (-p), env SMTP_PASSWORD (e.g. from a Sensu secret), --smtpPasswordFile or --vaultSecretPath")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// other non-OK events for the entity of the event being handled, fetched from
+// the Sensu API when --sensuAPIURL is set
+var relatedEvents []*corev2.Event
+
+// fetchRelatedEvents returns the other events for the event's entity that
+// are not OK, most severe first, from the Sensu backend API.
+func fetchRelatedEvents(event *corev2.Event) ([]*corev2.Event, error) {
+	var events []*corev2.Event
+	if err := getSensuAPI(event.Entity.Namespace, "events/"+url.PathEscape(event.Entity.Name), &events); err != nil {
+		return nil, err
+	}
+	var related []*corev2.Event
+	for _, e := range events {
+		if e.Check == nil || e.Check.Status == 0 || e.Check.Name == eventCheck(event).Name {
+			continue
+		}
+		related = append(related, e)
+	}
+	sort.SliceStable(related, func(i, j int) bool {
+		return severity(related[i].Check.Status) > severity(related[j].Check.Status)
+	})
+	return related, nil
+}
+
+// fetchSilences returns the active silencing entries matching the event's
+// check and any of its entity's subscriptions, from the Sensu backend API.
+func fetchSilences(event *corev2.Event) ([]*corev2.Silenced, error) {
+	var entries []*corev2.Silenced
+	if err := getSensuAPI(event.Entity.Namespace, "silenced", &entries); err != nil {
+		return nil, err
+	}
+	subscriptions := append([]string{corev2.GetEntitySubscription(event.Entity.Name)}, event.Entity.Subscriptions...)
+	now := time.Now().Unix()
+	var matching []*corev2.Silenced
+	for _, s := range entries {
+		if !s.StartSilence(now) {
+			continue
+		}
+		for _, subscription := range subscriptions {
+			if s.Matches(eventCheck(event).Name, subscription) {
+				matching = append(matching, s)
+				break
+			}
+		}
+	}
+	return matching, nil
+}
+
+// getSensuAPI gets the resource in the namespace from the Sensu backend API,
+// decoding the JSON response into v.
+func getSensuAPI(namespace, resource string, v interface{}) error {
+	resourceURL := strings.TrimSuffix(config.SensuAPIURL, "/") + "/api/core/v2/namespaces/" +
+		url.PathEscape(namespace) + "/" + resource
+	req, err := http.NewRequest(http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return err
+	}
+	if len(config.SensuAPIKey) > 0 {
+		req.Header.Set("Authorization", "Key "+config.SensuAPIKey)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query Sensu API: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to query Sensu API: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to query Sensu API: %s", resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid response from Sensu API: %v", err)
+	}
+	return nil
+}
+
+// deliveryAnnotations is the keyspace of the annotations --annotateEvent sets
+// on the check of the event
+const deliveryAnnotations = "sensu.io/plugins/email/delivery/"
+
+// annotateDelivery annotates the check of the event with the outcome of
+// sending its email: sent, spooled or failed, the time, and the message ID or
+// error, if any. Metrics events have no check, so are not annotated.
+func annotateDelivery(event *corev2.Event, messageID string, err error) error {
+	if event.Check == nil {
+		return nil
+	}
+	status := "sent"
+	var spooled *spooledError
+	if errors.As(err, &spooled) {
+		status = "spooled"
+	} else if err != nil {
+		status = "failed"
+	}
+	// null removes the annotations of an earlier delivery
+	annotations := map[string]interface{}{
+		deliveryAnnotations + "status":     status,
+		deliveryAnnotations + "time":       time.Now().UTC().Format(time.RFC3339),
+		deliveryAnnotations + "message_id": nil,
+		deliveryAnnotations + "error":      nil,
+	}
+	if len(messageID) > 0 {
+		annotations[deliveryAnnotations+"message_id"] = messageID
+	}
+	if err != nil {
+		annotations[deliveryAnnotations+"error"] = err.Error()
+	}
+	patch := map[string]interface{}{
+		"check": map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": annotations},
+		},
+	}
+	return patchSensuAPI(event.Entity.Namespace, "events/"+url.PathEscape(event.Entity.Name)+"/"+url.PathEscape(event.Check.Name), patch)
+}
+
+// suppressAnnotated returns the reason for not sending an email for an event
+// the Sensu backend published again when --annotateEvent updated it, or an
+// empty string if it should be sent. The next occurrence of the check
+// replaces the annotations.
+func suppressAnnotated(event *corev2.Event) string {
+	if !config.AnnotateEvent || event.Check == nil {
+		return ""
+	}
+	if _, ok := event.Check.Annotations[deliveryAnnotations+"status"]; ok {
+		return "it was updated with the delivery result of its email"
+	}
+	return ""
+}
+
+// patchSensuAPI updates the resource in the namespace in the Sensu backend
+// API with a JSON merge patch.
+func patchSensuAPI(namespace, resource string, patch interface{}) error {
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	resourceURL := strings.TrimSuffix(config.SensuAPIURL, "/") + "/api/core/v2/namespaces/" +
+		url.PathEscape(namespace) + "/" + resource
+	req, err := http.NewRequest(http.MethodPatch, resourceURL, bytes.NewReader(patchBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	if len(config.SensuAPIKey) > 0 {
+		req.Header.Set("Authorization", "Key "+config.SensuAPIKey)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update Sensu API: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to update Sensu API: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchRelatedEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/core/v2/namespaces/default/events/foo" || r.Header.Get("Authorization") != "Key secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"entity": {"metadata": {"name": "foo", "namespace": "default"}}, "check": {"metadata": {"name": "bar"}, "status": 2}},
+			{"entity": {"metadata": {"name": "foo", "namespace": "default"}}, "check": {"metadata": {"name": "disk"}, "status": 1, "output": "disk 90% full"}},
+			{"entity": {"metadata": {"name": "foo", "namespace": "default"}}, "check": {"metadata": {"name": "keepalive"}, "status": 0}},
+			{"entity": {"metadata": {"name": "foo", "namespace": "default"}}, "check": {"metadata": {"name": "memory"}, "status": 2}}
+		]`))
+	}))
+	defer server.Close()
+	config.SensuAPIURL = server.URL
+	config.SensuAPIKey = "secret"
+	defer func() {
+		config.SensuAPIURL = ""
+		config.SensuAPIKey = ""
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	related, err := fetchRelatedEvents(event)
+	assert.NoError(t, err)
+	if assert.Len(t, related, 2) {
+		assert.Equal(t, "memory", related[0].Check.Name)
+		assert.Equal(t, "disk", related[1].Check.Name)
+	}
+
+	relatedEvents = related
+	defer func() { relatedEvents = nil }()
+	out, err := resolveTemplate(classicTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Other active alerts on foo:\n  [CRITICAL] memory: \n  [WARNING] disk: disk 90% full\n")
+
+	config.SensuAPIKey = "wrong"
+	_, err = fetchRelatedEvents(event)
+	assert.Error(t, err)
+}
+
+func TestAnnotateDelivery(t *testing.T) {
+	var method, path, contentType string
+	var patch map[string]map[string]map[string]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &patch)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	config.SensuAPIURL = server.URL
+	config.AnnotateEvent = true
+	defer func() {
+		config.SensuAPIURL = ""
+		config.AnnotateEvent = false
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.NoError(t, annotateDelivery(event, "<1234@example.com>", nil))
+	assert.Equal(t, http.MethodPatch, method)
+	assert.Equal(t, "/api/core/v2/namespaces/default/events/foo/bar", path)
+	assert.Equal(t, "application/merge-patch+json", contentType)
+	annotations := patch["check"]["metadata"]["annotations"]
+	assert.Equal(t, "sent", annotations["sensu.io/plugins/email/delivery/status"])
+	assert.Equal(t, "<1234@example.com>", annotations["sensu.io/plugins/email/delivery/message_id"])
+	// the error of an earlier delivery is removed
+	assert.Contains(t, annotations, "sensu.io/plugins/email/delivery/error")
+	assert.Nil(t, annotations["sensu.io/plugins/email/delivery/error"])
+
+	assert.NoError(t, annotateDelivery(event, "", &spooledError{errors.New("421 try again later")}))
+	annotations = patch["check"]["metadata"]["annotations"]
+	assert.Equal(t, "spooled", annotations["sensu.io/plugins/email/delivery/status"])
+	assert.Equal(t, "421 try again later", annotations["sensu.io/plugins/email/delivery/error"])
+
+	// the event published again with the annotations isn't emailed
+	assert.Empty(t, suppressAnnotated(event))
+	event.Check.Annotations = map[string]string{"sensu.io/plugins/email/delivery/status": "sent"}
+	assert.Equal(t, "it was updated with the delivery result of its email", suppressAnnotated(event))
+}
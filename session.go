@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpIdleTimeout is how long a pooled SMTP connection may be idle before it
+// is closed instead of reused.
+var smtpIdleTimeout = time.Minute
+
+// smtpPool holds the SMTP connections kept open between emails by relay, or
+// is nil when each email gets a connection of its own.
+var smtpPool map[string]*pooledConn
+
+type pooledConn struct {
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// relayKey identifies the relay and credentials of a pooled connection.
+func relayKey() string {
+	return strings.Join([]string{config.SmtpHost, strconv.FormatUint(config.SmtpPort, 10), config.AuthMethod, config.SmtpUsername}, "\x00")
+}
+
+// openSMTP returns a connection to the SMTP server, reusing a pooled
+// connection when it is still alive.
+func openSMTP() (*smtp.Client, error) {
+	if smtpPool == nil {
+		return dialSMTP()
+	}
+	key := relayKey()
+	if pooled, ok := smtpPool[key]; ok {
+		delete(smtpPool, key)
+		if time.Since(pooled.lastUsed) < smtpIdleTimeout && pooled.client.Reset() == nil {
+			debugf("reusing the connection to %s", config.SmtpHost)
+			return pooled.client, nil
+		}
+		pooled.client.Close()
+	}
+	return dialSMTP()
+}
+
+// releaseSMTP ends the session after an email was sent, returning the
+// connection to the pool when there is one.
+func releaseSMTP(conn *smtp.Client) error {
+	if smtpPool == nil {
+		return conn.Quit()
+	}
+	key := relayKey()
+	if pooled, ok := smtpPool[key]; ok {
+		pooled.client.Close()
+	}
+	smtpPool[key] = &pooledConn{client: conn, lastUsed: time.Now()}
+	return nil
+}
+
+// poolSMTP keeps the SMTP connections open for reuse until the returned
+// function is called.
+func poolSMTP() func() {
+	if smtpPool != nil {
+		return func() {}
+	}
+	smtpPool = map[string]*pooledConn{}
+	return closeSMTPPool
+}
+
+func closeSMTPPool() {
+	for key, pooled := range smtpPool {
+		_ = pooled.client.Quit()
+		delete(smtpPool, key)
+	}
+	smtpPool = nil
+}
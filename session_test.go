@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTPSessionReuse(t *testing.T) {
+	// the server accepts a single connection, so both emails must share it
+	port, messages := startSMTPServer(t, "PIPELINING")
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+	}()
+
+	release := poolSMTP()
+	recipients := rcpts{"ops@example.com", "dev@example.com", "oncall@example.com"}
+	assert.NoError(t, transmit("", recipients, []byte("Subject: first\r\n\r\nfirst\r\n")))
+	assert.Contains(t, <-messages, "first")
+	assert.Len(t, smtpPool, 1)
+	assert.NoError(t, transmit("", recipients, []byte("Subject: second\r\n\r\nsecond\r\n")))
+	msg := <-messages
+	assert.Contains(t, msg, "Return-Path: <sensu@example.com>")
+	assert.Contains(t, msg, "second")
+	release()
+	assert.Nil(t, smtpPool)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// the active silences matching the event being handled, fetched from the
+// Sensu API when --silenced is set
+var silences []*corev2.Silenced
+
+// suppressSilenced fetches the silences matching the event, returning the
+// reason for not sending an email for it with --silenced suppress, or an
+// empty string if it should be sent. If the Sensu API can't be queried the
+// email is sent.
+func suppressSilenced(event *corev2.Event) string {
+	silences = nil
+	if len(config.Silenced) == 0 {
+		return ""
+	}
+	matching, err := fetchSilences(event)
+	if err != nil {
+		fmt.Printf("Failed to check for silences: %v\n", err)
+		return ""
+	}
+	silences = matching
+	if config.Silenced != SilencedSuppress || len(silences) == 0 {
+		return ""
+	}
+	names := make([]string, len(silences))
+	for i, s := range silences {
+		names[i] = s.Name
+	}
+	return "it is silenced by " + strings.Join(names, ", ")
+}
+
+// silenceDescription describes the silence, e.g. "This alert is silenced by
+// entity:web01:* created by admin (deploying) until it is resolved."
+func silenceDescription(s *corev2.Silenced) string {
+	description := "This alert is silenced by " + s.Name
+	if len(s.Creator) > 0 {
+		description += " created by " + s.Creator
+	}
+	if len(s.Reason) > 0 {
+		description += " (" + s.Reason + ")"
+	}
+	// the API returns the seconds left until the silence expires
+	switch {
+	case s.Expire > 0:
+		until := time.Now().Add(time.Duration(s.Expire) * time.Second).In(templateLocation)
+		description += " until " + until.Format(config.DateFormat)
+	case s.ExpireOnResolve:
+		description += " until it is resolved"
+	}
+	return description + "."
+}
+
+// addSilencedNote adds a note of the silences to the start of the body, in
+// the body's format, so recipients know the alert is already silenced.
+func addSilencedNote(body, contentType string, silences []*corev2.Silenced) string {
+	lines := make([]string, len(silences))
+	for i, s := range silences {
+		lines[i] = silenceDescription(s)
+	}
+	return addNote(body, contentType, lines)
+}
+
+// addNote adds the lines of a note to the start of the body, in the body's
+// format.
+func addNote(body, contentType string, lines []string) string {
+	switch {
+	case config.BodyFormat == BodyFormatMarkdown:
+		return "> " + strings.Join(lines, "\n>\n> ") + "\n\n" + body
+	case contentType == ContentHTML:
+		var note strings.Builder
+		for _, line := range lines {
+			fmt.Fprintf(&note, "<p><em>%s</em></p>\n", html.EscapeString(line))
+		}
+		// keep the note inside the document's body
+		lower := strings.ToLower(body)
+		if i := strings.Index(lower, "<body"); i >= 0 {
+			if j := strings.Index(lower[i:], ">"); j >= 0 {
+				end := i + j + 1
+				return body[:end] + "\n" + note.String() + body[end:]
+			}
+		}
+		return note.String() + body
+	default:
+		return strings.Join(lines, "\n") + "\n\n" + body
+	}
+}
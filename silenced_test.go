@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuppressSilenced(t *testing.T) {
+	begin := time.Now().Add(time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/core/v2/namespaces/default/silenced" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"metadata": {"name": "entity:foo:*", "namespace": "default"}, "subscription": "entity:foo", "creator": "admin", "reason": "deploying", "expire_on_resolve": true, "expire": -1},
+			{"metadata": {"name": "linux:bar", "namespace": "default"}, "subscription": "linux", "check": "bar", "expire": 3600},
+			{"metadata": {"name": "*:bar", "namespace": "default"}, "check": "bar", "expire": -1, "begin": ` + strconv.FormatInt(begin, 10) + `},
+			{"metadata": {"name": "windows:*", "namespace": "default"}, "subscription": "windows", "expire": -1},
+			{"metadata": {"name": "*:disk", "namespace": "default"}, "check": "disk", "expire": -1}
+		]`))
+	}))
+	defer server.Close()
+	config.SensuAPIURL = server.URL
+	config.Silenced = SilencedSuppress
+	defer func() {
+		config.SensuAPIURL = ""
+		config.Silenced = ""
+		silences = nil
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Entity.Subscriptions = []string{"linux"}
+	assert.Equal(t, "it is silenced by entity:foo:*, linux:bar", suppressSilenced(event))
+
+	config.Silenced = SilencedTemplate
+	assert.Empty(t, suppressSilenced(event))
+	out, err := resolveTemplate("{{range .Silenced}}{{.Name}} {{end}}", event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "entity:foo:* linux:bar ", out)
+
+	// the email is sent when the API can't be queried
+	config.Silenced = SilencedSuppress
+	config.SensuAPIURL = server.URL + "/missing"
+	assert.Empty(t, suppressSilenced(event))
+	assert.Nil(t, silences)
+}
+
+func TestAddSilencedNote(t *testing.T) {
+	config.DateFormat = time.RFC822Z
+	defer func() { config.DateFormat = "" }()
+	entries := []*corev2.Silenced{
+		{ObjectMeta: corev2.ObjectMeta{Name: "entity:foo:*"}, Creator: "admin", Reason: "deploying", ExpireOnResolve: true, Expire: -1},
+		{ObjectMeta: corev2.ObjectMeta{Name: "linux:bar"}, Expire: -1},
+	}
+	note := "This alert is silenced by entity:foo:* created by admin (deploying) until it is resolved.\n" +
+		"This alert is silenced by linux:bar."
+	assert.Equal(t, note+"\n\ndisk full", addSilencedNote("disk full", ContentPlain, entries))
+	assert.Equal(t, "<html><body class=\"alert\">\n<p><em>This alert is silenced by entity:foo:* created by admin (deploying) until it is resolved.</em></p>\n"+
+		"<p><em>This alert is silenced by linux:bar.</em></p>\n<p>disk full</p></body></html>",
+		addSilencedNote("<html><body class=\"alert\"><p>disk full</p></body></html>", ContentHTML, entries))
+
+	entries[1].Expire = 3600
+	assert.Regexp(t, `^This alert is silenced by linux:bar until \d{2} \w{3} \d{2} \d{2}:\d{2} [+-]\d{4}\.$`, silenceDescription(entries[1]))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// how long the --silenceURL link silences the check for
+var silenceLinkDuration time.Duration
+
+// silenceLinkData is the data for the --silenceURL template: the event, along
+// with how long to silence the check for.
+type silenceLinkData struct {
+	corev2.Event
+	// Duration is --silenceDuration, e.g. 2h
+	Duration string
+	// Expire is the duration in seconds, as used by Sensu silences
+	Expire int64
+	// ExpireAt is the Unix time the silence should expire
+	ExpireAt int64
+}
+
+// silenceLink resolves --silenceURL for the event. A link that fails to
+// resolve is left out, so it doesn't prevent the email being sent.
+func silenceLink(event *corev2.Event) string {
+	if len(config.SilenceURL) == 0 {
+		return ""
+	}
+	data := silenceLinkData{
+		Event:    *event,
+		Duration: config.SilenceDuration,
+		Expire:   int64(silenceLinkDuration / time.Second),
+		ExpireAt: time.Now().Add(silenceLinkDuration).Unix(),
+	}
+	link, err := resolveTemplateData(config.SilenceURL, data, ContentPlain)
+	if err != nil {
+		fmt.Printf("Not including the silence link: %v\n", err)
+		return ""
+	}
+	link = strings.TrimSpace(link)
+	if !validLink(link) {
+		fmt.Printf("Not including the silence link: invalid URL %q\n", link)
+		return ""
+	}
+	return link
+}
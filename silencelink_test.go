@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSilenceLink(t *testing.T) {
+	config.SilenceURL = "https://sensu.example.com:3000/{{.Entity.Namespace}}/silences?check={{.Check.Name}}&subscription=entity:{{.Entity.Name}}&expire={{.Expire}}"
+	config.SilenceDuration = "2h"
+	silenceLinkDuration = 2 * time.Hour
+	defer func() {
+		config.SilenceURL = ""
+		config.SilenceDuration = ""
+		silenceLinkDuration = 0
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	link := "https://sensu.example.com:3000/default/silences?check=bar&subscription=entity:foo&expire=7200"
+	assert.Equal(t, link, silenceLink(event))
+
+	out, err := resolveTemplate(classicTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "\nSilence:     "+link+" (2h)\n")
+	out, err = resolveTemplate(tableTemplate, event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Contains(t, out, `<a href="https://sensu.example.com:3000/default/silences?check=bar&amp;subscription=entity:foo&amp;expire=7200">Silence for 2h</a>`)
+
+	// resolutions don't need silencing
+	event.Check.Status = 0
+	event.Check.History = []corev2.CheckHistory{{Status: 2}, {Status: 0}}
+	out, err = resolveTemplate(classicTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.NotContains(t, out, "Silence")
+
+	config.SilenceURL = "javascript:alert({{.Check.Name}})"
+	assert.Empty(t, silenceLink(event))
+	config.SilenceURL = "{{.Nope}}"
+	assert.Empty(t, silenceLink(event))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"mime"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	"go.mozilla.org/pkcs7"
+)
+
+// smimeSigner holds the certificate chain and key used for S/MIME signing.
+type smimeSigner struct {
+	cert    *x509.Certificate
+	parents []*x509.Certificate
+	key     interface{}
+}
+
+// loadSMIMESigner reads the PEM encoded certificate (optionally followed by
+// its intermediates) and private key used for S/MIME signing.
+func loadSMIMESigner(certFile, keyFile string) (*smimeSigner, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S/MIME certificate file %s", certFile)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S/MIME key file %s", keyFile)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S/MIME certificate and key: %v", err)
+	}
+
+	signer := &smimeSigner{key: pair.PrivateKey}
+	for i, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S/MIME certificate: %v", err)
+		}
+		if i == 0 {
+			signer.cert = cert
+		} else {
+			signer.parents = append(signer.parents, cert)
+		}
+	}
+	return signer, nil
+}
+
+// sign wraps the MIME entity (its content headers and body) in a
+// multipart/signed entity carrying a detached PKCS #7 signature.
+func (s *smimeSigner) sign(entity *mailer.Part) (*mailer.Part, error) {
+	content := entity.Bytes()
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(s.cert, s.key, s.parents, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("failed to create S/MIME signature: %v", err)
+	}
+	sd.Detach()
+	signature, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S/MIME signature: %v", err)
+	}
+
+	signaturePart := mailer.NewBase64("application/pkcs7-signature", map[string]string{"name": "smime.p7s"}, signature)
+	signaturePart.Header.Add("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "smime.p7s"}))
+	// the signed entity must be included exactly as it was signed
+	signed := mailer.NewMultipart("signed", map[string]string{
+		"protocol": "application/pkcs7-signature",
+		"micalg":   "sha-256",
+	}, mailer.Raw(content), signaturePart)
+	signed.SetPreamble("This is a cryptographically signed message in MIME format.\n")
+	return signed, nil
+}
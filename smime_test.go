@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/mailer"
+	"github.com/stretchr/testify/assert"
+	"go.mozilla.org/pkcs7"
+)
+
+func TestSMIMESign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smime")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sensu@example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+
+	signer, err := loadSMIMESigner(certFile, keyFile)
+	assert.NoError(t, err)
+
+	entity := "Content-Type: text/plain; charset=utf-8\r\n\r\ncheck output\r\n"
+	signed, err := signer.sign(mailer.Raw([]byte(entity)))
+	assert.NoError(t, err)
+
+	parts := strings.SplitN(string(signed.Bytes()), "\r\n\r\n", 2)
+	mediaType, params, err := mime.ParseMediaType(strings.TrimPrefix(parts[0], "Content-Type: "))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/signed", mediaType)
+	assert.Equal(t, "application/pkcs7-signature", params["protocol"])
+
+	// the first part must be the unmodified entity, the second the signature
+	assert.Contains(t, parts[1], "--"+params["boundary"]+"\r\n"+entity+"\r\n--"+params["boundary"])
+	reader := multipart.NewReader(strings.NewReader(parts[1]), params["boundary"])
+	_, err = reader.NextPart()
+	assert.NoError(t, err)
+	sigPart, err := reader.NextPart()
+	assert.NoError(t, err)
+	sigB64, err := ioutil.ReadAll(sigPart)
+	assert.NoError(t, err)
+	sig, err := base64.StdEncoding.DecodeString(strings.Replace(string(sigB64), "\r\n", "", -1))
+	assert.NoError(t, err)
+
+	p7, err := pkcs7.Parse(sig)
+	assert.NoError(t, err)
+	p7.Content = []byte(entity)
+	assert.NoError(t, p7.Verify())
+}
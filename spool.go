@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// smtpRetryDelay is the delay before the first --smtpRetries retry, doubling
+// for each retry after it.
+var smtpRetryDelay = time.Second
+
+// staleSpoolClaim is how long after --flushSpool claimed a spooled email it is
+// returned to the spool, when the flush did not finish with it.
+var staleSpoolClaim = 10 * time.Minute
+
+// spooledMessage is a composed email that could not be delivered, written to
+// --spoolDir to be retried with --flushSpool.
+type spooledMessage struct {
+	Namespace  string   `json:"namespace"`
+	Entity     string   `json:"entity"`
+	Check      string   `json:"check"`
+	Status     uint32   `json:"status"`
+	Sender     string   `json:"sender"`
+	Recipients []string `json:"recipients"`
+	EnvelopeID string   `json:"envelope_id,omitempty"`
+	Message    []byte   `json:"message"`
+	// Spooled is the Unix time the message was first spooled
+	Spooled   int64  `json:"spooled"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+// spooledError is the delivery error of an email that was spooled.
+type spooledError struct {
+	err error
+}
+
+func (e *spooledError) Error() string {
+	return e.err.Error()
+}
+
+func (e *spooledError) Unwrap() error {
+	return e.err
+}
+
+// permanentError reports whether the SMTP server rejected the email with a
+// permanent (5xx) error, which retrying won't fix, or rejected some of its
+// recipients.
+func permanentError(err error) bool {
+	var smtpErr *textproto.Error
+	var invalid *smtpsend.InvalidRecipientsError
+	return (errors.As(err, &smtpErr) && smtpErr.Code >= 500) || errors.As(err, &invalid)
+}
+
+// transmitWithRetries transmits the message, retrying up to --smtpRetries
+// times unless the server rejects it permanently.
+func transmitWithRetries(envelopeID string, recipients rcpts, msg []byte) error {
+	err := transmit(envelopeID, recipients, msg)
+	delay := smtpRetryDelay
+	for i := 0; i < config.SmtpRetries && err != nil && !permanentError(err); i++ {
+		fmt.Printf("Failed to send email, retrying in %s: %v\n", delay, err)
+		deliveryMetrics.retries++
+		time.Sleep(delay)
+		delay *= 2
+		recipients = undeliveredRecipients(recipients, err)
+		err = transmit(envelopeID, recipients, msg)
+	}
+	return err
+}
+
+// deliverOrSpool transmits the message, spooling it when delivery fails with
+// a temporary error and --spoolDir is set. The error of a spooled message is
+// a *spooledError.
+func deliverOrSpool(event *corev2.Event, recipients rcpts, msg []byte) (err error) {
+	start := time.Now()
+	defer func() {
+		recordDelivery(time.Since(start), err)
+	}()
+	// the envelope ID of delivery status notifications
+	envelopeID := eventID(event)
+	err = transmitWithRetries(envelopeID, recipients, msg)
+	if err == nil || len(config.SpoolDir) == 0 || permanentError(err) {
+		return err
+	}
+	spooled := &spooledMessage{
+		Namespace:  event.Entity.Namespace,
+		Entity:     event.Entity.Name,
+		Check:      eventCheck(event).Name,
+		Status:     eventCheck(event).Status,
+		Sender:     envelopeSender(),
+		Recipients: undeliveredRecipients(recipients, err),
+		EnvelopeID: envelopeID,
+		Message:    msg,
+		Spooled:    time.Now().Unix(),
+		Attempts:   1,
+		LastError:  err.Error(),
+	}
+	file := filepath.Join(config.SpoolDir, fmt.Sprintf("%x.json", sha256.Sum256(msg)))
+	if spoolErr := writeSpooledMessage(file, spooled); spoolErr != nil {
+		return fmt.Errorf("%v (and failed to spool the email: %v)", err, spoolErr)
+	}
+	fmt.Printf("Spooled email for %s/%s to %s, it was not sent yet and will be retried by --flushSpool: %v\n", event.Entity.Name, eventCheck(event).Name, file, err)
+	return &spooledError{err}
+}
+
+func writeSpooledMessage(file string, spooled *spooledMessage) error {
+	spooledBytes, err := json.Marshal(spooled)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(file, spooledBytes)
+}
+
+// flushSpoolDir retries the emails in the spool directory, removing those
+// sent, permanently rejected or older than --spoolMaxAge. It returns the
+// number of emails removed and still spooled.
+func flushSpoolDir() (int, int, error) {
+	if err := recoverSpoolClaims(time.Now()); err != nil {
+		return 0, 0, err
+	}
+	files, err := filepath.Glob(filepath.Join(config.SpoolDir, "*.json"))
+	if err != nil {
+		return 0, 0, err
+	}
+	sort.Strings(files)
+	var flushed, remaining int
+	for _, file := range files {
+		// claim the file, so concurrent flushes don't send it twice, dating
+		// the claim so that it can be recovered if this flush dies
+		claimed := file + ".sending"
+		if err := os.Rename(file, claimed); err != nil {
+			continue
+		}
+		now := time.Now()
+		os.Chtimes(claimed, now, now)
+		ok, err := flushSpooledMessage(claimed)
+		if ok {
+			flushed++
+			os.Remove(claimed)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Failed to send spooled email %s: %v\n", file, err)
+		}
+		if err := os.Rename(claimed, file); err != nil {
+			return flushed, remaining, fmt.Errorf("failed to return %s to the spool: %v", file, err)
+		}
+		remaining++
+	}
+	return flushed, remaining, nil
+}
+
+// recoverSpoolClaims returns to the spool the emails claimed by a flush more
+// than staleSpoolClaim ago, which was killed or crashed before it finished
+// with them.
+func recoverSpoolClaims(now time.Time) error {
+	claims, err := filepath.Glob(filepath.Join(config.SpoolDir, "*.json.sending"))
+	if err != nil {
+		return err
+	}
+	for _, claimed := range claims {
+		info, err := os.Stat(claimed)
+		if err != nil || now.Sub(info.ModTime()) < staleSpoolClaim {
+			continue
+		}
+		file := strings.TrimSuffix(claimed, ".sending")
+		if err := os.Rename(claimed, file); err != nil {
+			return fmt.Errorf("failed to return %s to the spool: %v", file, err)
+		}
+		fmt.Printf("Returned %s to the spool, claimed by a flush that did not finish\n", file)
+	}
+	return nil
+}
+
+// flushSpooledMessage sends the spooled message through the relay of its
+// namespace, reporting whether it can be removed from the spool: it was
+// sent, or the server rejected it permanently.
+func flushSpooledMessage(file string) (bool, error) {
+	spooledBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	spooled := &spooledMessage{}
+	if err := json.Unmarshal(spooledBytes, spooled); err != nil {
+		return false, err
+	}
+
+	saved := config
+	defer func() { config = saved }()
+	if len(namespaceSettingsMap) > 0 {
+		if s, ok := lookupNamespace(namespaceSettingsMap, spooled.Namespace); ok {
+			s.apply()
+		}
+	}
+	config.EnvelopeFrom = spooled.Sender
+	spooledFor := time.Since(time.Unix(spooled.Spooled, 0))
+	if spoolMaxDuration > 0 && spooledFor > spoolMaxDuration {
+		err := fmt.Errorf("not sent within %s of being spooled: %s", spoolMaxDuration, spooled.LastError)
+		recordDelivery(0, err)
+		fmt.Printf("Dropping spooled email for %s/%s after %d attempts, %v\n", spooled.Entity, spooled.Check, spooled.Attempts, err)
+		if len(config.FallbackWebhookURL) > 0 {
+			postFallbackAlert(newSpooledFallbackAlert(spooled, err))
+		}
+		return true, nil
+	}
+	start := time.Now()
+	err = transmit(spooled.EnvelopeID, rcpts(spooled.Recipients), spooled.Message)
+	if err == nil {
+		recordDelivery(time.Since(start), nil)
+		fmt.Printf("Sent spooled email for %s/%s to %s\n", spooled.Entity, spooled.Check, strings.Join(spooled.Recipients, ", "))
+		return true, nil
+	}
+	if permanentError(err) {
+		recordDelivery(time.Since(start), err)
+		fmt.Printf("Dropping spooled email for %s/%s, rejected by the server: %v\n", spooled.Entity, spooled.Check, err)
+		if len(config.FallbackWebhookURL) > 0 {
+			postFallbackAlert(newSpooledFallbackAlert(spooled, err))
+		}
+		return true, nil
+	}
+	spooled.Recipients = undeliveredRecipients(rcpts(spooled.Recipients), err)
+	spooled.Attempts++
+	spooled.LastError = err.Error()
+	if writeErr := writeSpooledMessage(file, spooled); writeErr != nil {
+		return false, writeErr
+	}
+	return false, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermanentError(t *testing.T) {
+	assert.True(t, permanentError(&textproto.Error{Code: 550, Msg: "no such user"}))
+	assert.False(t, permanentError(&textproto.Error{Code: 451, Msg: "try again later"}))
+	assert.False(t, permanentError(errors.New("connection refused")))
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// a port nothing is listening on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closed := uint64(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	config.SpoolDir = dir
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = closed
+	config.SmtpRetries = 1
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	smtpRetryDelay = time.Millisecond
+	defer func() {
+		config.SpoolDir = ""
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.SmtpRetries = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		smtpRetryDelay = time.Second
+	}()
+
+	event := corev2.FixtureEvent("server01", "disk")
+	msg := []byte("Subject: disk\r\n\r\ndisk full\r\n")
+	err = deliverOrSpool(event, rcpts{"ops@example.com"}, msg)
+	var spooled *spooledError
+	assert.True(t, errors.As(err, &spooled))
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// still undeliverable
+	flushed, remaining, err := flushSpoolDir()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, flushed)
+	assert.Equal(t, 1, remaining)
+
+	port, messages := startSMTPServer(t)
+	config.SmtpPort = port
+	flushed, remaining, err = flushSpoolDir()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flushed)
+	assert.Equal(t, 0, remaining)
+	assert.Contains(t, <-messages, "disk full")
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.SpoolDir = dir
+	spoolMaxDuration = time.Hour
+	defer func() {
+		config.SpoolDir = ""
+		spoolMaxDuration = 0
+	}()
+
+	// dropped without being sent, as no SMTP server is configured
+	spooled := &spooledMessage{
+		Entity:     "server01",
+		Check:      "disk",
+		Recipients: []string{"ops@example.com"},
+		Message:    []byte("Subject: disk\r\n\r\ndisk full\r\n"),
+		Spooled:    time.Now().Add(-2 * time.Hour).Unix(),
+		Attempts:   5,
+		LastError:  "connection refused",
+	}
+	assert.NoError(t, writeSpooledMessage(filepath.Join(dir, "old.json"), spooled))
+	flushed, remaining, err := flushSpoolDir()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flushed)
+	assert.Equal(t, 0, remaining)
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestRecoverSpoolClaims(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.SpoolDir = dir
+	defer func() { config.SpoolDir = "" }()
+
+	stale := filepath.Join(dir, "stale.json.sending")
+	active := filepath.Join(dir, "active.json.sending")
+	for _, file := range []string{stale, active} {
+		assert.NoError(t, ioutil.WriteFile(file, []byte("{}"), 0600))
+	}
+	claimed := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(stale, claimed, claimed))
+
+	assert.NoError(t, recoverSpoolClaims(time.Now()))
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{active, filepath.Join(dir, "stale.json")}, files)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// checkState is what is recorded in the state directory for each
+// namespace/entity/check the handler has sent an email for.
+type checkState struct {
+	// Status is the check status of the last email sent
+	Status uint32 `json:"status"`
+	// LastSent is the Unix time the last email was sent
+	LastSent int64 `json:"last_sent"`
+	// MessageID is the Message-ID of the last email sent, with --threading
+	MessageID string `json:"message_id,omitempty"`
+	// ThreadID is the Message-ID of the first email of the thread, with
+	// --threading
+	ThreadID string `json:"thread_id,omitempty"`
+}
+
+// stateFile returns the path of the state file for the event.
+func stateFile(event *corev2.Event) string {
+	key := path.Join(event.Entity.Namespace, event.Entity.Name, eventCheck(event).Name)
+	return filepath.Join(config.StateDir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(key))))
+}
+
+// loadCheckState returns the recorded state for the event, or nil if nothing
+// has been recorded yet.
+func loadCheckState(event *corev2.Event) (*checkState, error) {
+	stateBytes, err := ioutil.ReadFile(stateFile(event))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+	state := &checkState{}
+	if err := json.Unmarshal(stateBytes, state); err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+	return state, nil
+}
+
+// saveCheckState records the state for the event.
+func saveCheckState(event *corev2.Event, state *checkState) error {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(stateFile(event), stateBytes)
+}
+
+// writeStateFile replaces a file in the state (or template cache) directory
+// atomically so concurrent handlers never read a partial file.
+func writeStateFile(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".state")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return nil
+}
+
+// lockStateFile takes an exclusive lock for updating the state file, waiting
+// for any concurrent handler holding it, and returns the function releasing
+// it. writeStateFile replaces the file, so the lock is taken on a lock file
+// beside it.
+func lockStateFile(file string) (func(), error) {
+	f, err := os.OpenFile(file+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock state: %v", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock state: %v", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// suppressDuplicate returns the reason for not sending an email for the event
+// when an email for the same status was already sent within the dedup window,
+// or an empty string if it should be sent.
+func suppressDuplicate(event *corev2.Event) (string, error) {
+	if len(config.StateDir) == 0 || dedupDuration == 0 {
+		return "", nil
+	}
+	state, err := loadCheckState(event)
+	if err != nil || state == nil {
+		return "", err
+	}
+	if state.Status != eventCheck(event).Status {
+		return "", nil
+	}
+	since := time.Since(time.Unix(state.LastSent, 0))
+	if since >= dedupDuration {
+		return "", nil
+	}
+	return fmt.Sprintf("an email for status %d was already sent %s ago", state.Status, since.Round(time.Second)), nil
+}
+
+// suppressUnalertedResolution returns the reason for not sending a
+// resolution email when no email for a non-OK status was sent for the
+// entity/check, or an empty string if it should be sent.
+func suppressUnalertedResolution(event *corev2.Event) (string, error) {
+	if !config.ResolveOnlyAfterAlert || event.Check == nil || event.Check.Status != 0 {
+		return "", nil
+	}
+	state, err := loadCheckState(event)
+	if err != nil {
+		return "", err
+	}
+	if state == nil || state.Status == 0 {
+		return "no alert email was sent to resolve", nil
+	}
+	return "", nil
+}
+
+// suppressUnchangedStatus returns the reason for not sending an email with
+// --onStateChangeOnly when the event's status is the same as the previous
+// one, or an empty string if it should be sent. The previous status is the
+// status last emailed, if recorded in the state directory, or else the
+// status of the previous execution in the check history. Without either, it
+// is taken to be OK.
+func suppressUnchangedStatus(event *corev2.Event) (string, error) {
+	if !config.OnStateChangeOnly || event.Check == nil {
+		return "", nil
+	}
+	previous := previousStatus(event)
+	if len(config.StateDir) > 0 {
+		state, err := loadCheckState(event)
+		if err != nil {
+			return "", err
+		}
+		if state != nil {
+			previous = state.Status
+		}
+	}
+	if previous != event.Check.Status {
+		return "", nil
+	}
+	return fmt.Sprintf("the status is still %d", previous), nil
+}
+
+// previousStatus returns the status of the check's previous execution, the
+// last entry of the history being the event's own.
+func previousStatus(event *corev2.Event) uint32 {
+	history := eventCheck(event).History
+	if len(history) < 2 {
+		return 0
+	}
+	return history[len(history)-2].Status
+}
+
+// recordSent records that an email was sent for the event, if a state
+// directory is configured, keeping the thread recorded by recordThread.
+func recordSent(event *corev2.Event) error {
+	if len(config.StateDir) == 0 {
+		return nil
+	}
+	state, err := loadCheckState(event)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &checkState{}
+	}
+	state.Status = eventCheck(event).Status
+	state.LastSent = time.Now().Unix()
+	return saveCheckState(event, state)
+}
+
+// recordThread records the Message-ID of an email sent for the event, which
+// the next email for its entity/check replies to with --threading. The first
+// one recorded starts the thread.
+func recordThread(event *corev2.Event, messageID string) error {
+	state, err := loadCheckState(event)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &checkState{}
+	}
+	if len(state.ThreadID) == 0 {
+		state.ThreadID = messageID
+	}
+	state.MessageID = messageID
+	return saveCheckState(event, state)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuppressDuplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	dedupDuration = time.Hour
+	defer func() {
+		config.StateDir = ""
+		dedupDuration = 0
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	reason, err := suppressDuplicate(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	assert.NoError(t, recordSent(event))
+	reason, err = suppressDuplicate(event)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reason)
+
+	// a different status or entity/check is not a duplicate
+	event.Check.Status = 1
+	reason, err = suppressDuplicate(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	other := corev2.FixtureEvent("foo", "baz")
+	other.Check.Status = 2
+	reason, err = suppressDuplicate(other)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	// outside of the window
+	event.Check.Status = 2
+	assert.NoError(t, saveCheckState(event, &checkState{Status: 2, LastSent: time.Now().Add(-2 * time.Hour).Unix()}))
+	reason, err = suppressDuplicate(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+}
+
+func TestSuppressUnalertedResolution(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	config.ResolveOnlyAfterAlert = true
+	defer func() {
+		config.StateDir = ""
+		config.ResolveOnlyAfterAlert = false
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 0
+	reason, err := suppressUnalertedResolution(event)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reason)
+
+	// alerts are never suppressed
+	event.Check.Status = 2
+	reason, err = suppressUnalertedResolution(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	assert.NoError(t, recordSent(event))
+	event.Check.Status = 0
+	reason, err = suppressUnalertedResolution(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	// only one resolution is sent per alert
+	assert.NoError(t, recordSent(event))
+	reason, err = suppressUnalertedResolution(event)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reason)
+}
+
+func TestSuppressUnchangedStatus(t *testing.T) {
+	config.OnStateChangeOnly = true
+	defer func() {
+		config.OnStateChangeOnly = false
+		config.StateDir = ""
+	}()
+
+	// without a state directory, the check history is used
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 1
+	event.Check.History = []corev2.CheckHistory{{Status: 0}, {Status: 1}}
+	reason, err := suppressUnchangedStatus(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	event.Check.History = append(event.Check.History, corev2.CheckHistory{Status: 1})
+	reason, err = suppressUnchangedStatus(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "the status is still 1", reason)
+	event.Check.Status = 2
+	event.Check.History = append(event.Check.History, corev2.CheckHistory{Status: 2})
+	reason, err = suppressUnchangedStatus(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+
+	// an OK event without a previous status isn't a change
+	event.Check.Status = 0
+	event.Check.History = nil
+	reason, err = suppressUnchangedStatus(event)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reason)
+
+	// the status last emailed takes precedence over the history
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config.StateDir = dir
+	event.Check.Status = 2
+	event.Check.History = []corev2.CheckHistory{{Status: 2}, {Status: 2}, {Status: 2}}
+	reason, err = suppressUnchangedStatus(event)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reason)
+	assert.NoError(t, saveCheckState(event, &checkState{Status: 1, LastSent: time.Now().Unix()}))
+	reason, err = suppressUnchangedStatus(event)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	assert.NoError(t, recordSent(event))
+	reason, err = suppressUnchangedStatus(event)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reason)
+}
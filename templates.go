@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sensu/sensu-email-handler/pkg/templates"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// builtinTemplates are the body templates selectable by name with
+// --bodyTemplateName.
+var builtinTemplates = map[string]string{
+	"classic":      classicTemplate,
+	"table":        tableTemplate,
+	"compact-html": compactHTMLTemplate,
+}
+
+// the default templates, used unless others are configured
+const (
+	defaultSubjectTemplate = "{{Translate \"Sensu Alert\"}} - {{.Entity.Name}}/{{.Check.Name}}: {{Translate .Check.State}}"
+	defaultBodyTemplate    = "{{Wrap 78 .Check.Output}}{{if .IncludeSystemInfo}}\n{{template \"systemInfo\" .}}{{end}}"
+	hookoutBodyTemplate    = "{{.Check.Output}}\n{{range .Check.Hooks}}Hook Name:  {{.Name}}\nHook Command:  {{.Command}}\n\n{{.Output}}\n\n{{end}}"
+
+	defaultKeepaliveSubjectTemplate = "{{Translate \"Sensu Alert\"}} - {{.Entity.Name}} {{if .IsResolved}}{{Translate \"is reporting again\"}}{{else}}{{Translate \"stopped reporting\"}}{{end}}"
+	defaultFlappingSubjectTemplate  = "{{Translate \"Sensu Alert\"}} - {{.Entity.Name}}/{{.Check.Name}}: {{Translate \"flapping\"}}"
+)
+
+// the delimiters of the templates given to the handler, set by
+// --templateDelims
+var templateLeftDelim, templateRightDelim = "{{", "}}"
+
+// parseTemplateDelims splits the --templateDelims value into the left and
+// right delimiters.
+func parseTemplateDelims(delims string) (string, string, error) {
+	parts := strings.Split(delims, ",")
+	if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 || len(strings.TrimSpace(parts[1])) == 0 {
+		return "", "", fmt.Errorf("template delimiters %q are not of the form \"left,right\"", delims)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+// isHandlerTemplate returns whether the template is one of the handler's own
+// default or built-in templates, which always use the standard delimiters and
+// only include the built-in partials.
+func isHandlerTemplate(templateValue string) bool {
+	switch templateValue {
+	case defaultSubjectTemplate, defaultBodyTemplate, hookoutBodyTemplate, defaultDigestSubjectTemplate, defaultDigestBodyTemplate,
+		defaultKeepaliveSubjectTemplate, keepaliveTemplate, keepaliveHTMLTemplate, defaultFlappingSubjectTemplate:
+		return true
+	}
+	for _, builtin := range builtinTemplates {
+		if templateValue == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// templatePartialList is the partials parsed along with every template.
+var templatePartialList []templates.Partial
+
+// builtinPartials are parsed along with every template, before any
+// --templatePartials, which may replace them.
+var builtinPartials = []templates.Partial{
+	{Name: "systemInfo", Text: systemInfoPartial},
+	{Name: "systemInfoHTML", Text: systemInfoHTMLPartial},
+}
+
+const systemInfoPartial = `{{Translate "System"}}:
+  {{printf "%-13s" (print (Translate "Hostname") ":")}}{{.Entity.System.Hostname}}
+  {{printf "%-13s" (print (Translate "Platform") ":")}}{{.Entity.System.Platform}} {{.Entity.System.PlatformVersion}} ({{.Entity.System.OS}})
+  {{printf "%-13s" (print (Translate "Architecture") ":")}}{{.Entity.System.Arch}}
+{{range .Entity.System.Network.Interfaces}}{{if .Addresses}}  {{printf "%-13s" (print .Name ":")}}{{range $i, $a := .Addresses}}{{if $i}}, {{end}}{{$a}}{{end}}
+{{end}}{{end}}`
+
+const systemInfoHTMLPartial = `<table cellpadding="8" cellspacing="0" style="border-collapse: collapse; min-width: 600px; margin-top: 8px;">
+  <tr><td colspan="2" style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "System"}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Hostname"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.System.Hostname}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Platform"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.System.Platform}} {{.Entity.System.PlatformVersion}} ({{.Entity.System.OS}})</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Architecture"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.System.Arch}}</td></tr>
+  {{range .Entity.System.Network.Interfaces}}{{if .Addresses}}<tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{.Name}}</td><td style="border: 1px solid #dddddd;">{{range $i, $a := .Addresses}}{{if $i}}, {{end}}{{$a}}{{end}}</td></tr>{{end}}
+  {{end}}
+</table>
+`
+
+// loadTemplatePartials reads the partial template files, and the files in any
+// directories, given by --templatePartials. Each is named by its file name
+// without the extension, so header.tmpl is included with {{template "header"
+// .}}. Files in a directory starting with a dot are ignored, as are its
+// subdirectories.
+func loadTemplatePartials(paths []string) error {
+	var files []string
+	for _, p := range paths {
+		for _, path := range strings.Split(p, ",") {
+			path = strings.TrimSpace(path)
+			if len(path) == 0 {
+				continue
+			}
+			if isTemplateURL(path) {
+				files = append(files, path)
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to read template partials %s: %v", path, err)
+			}
+			if !info.IsDir() {
+				files = append(files, path)
+				continue
+			}
+			entries, err := ioutil.ReadDir(path)
+			if err != nil {
+				return fmt.Errorf("failed to read template partials %s: %v", path, err)
+			}
+			var dirFiles []string
+			for _, entry := range entries {
+				if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+					continue
+				}
+				dirFiles = append(dirFiles, filepath.Join(path, entry.Name()))
+			}
+			sort.Strings(dirFiles)
+			files = append(files, dirFiles...)
+		}
+	}
+
+	templatePartialList = nil
+	names := make(map[string]string)
+	for _, file := range files {
+		base := filepath.Base(file)
+		if isTemplateURL(file) {
+			if u, err := url.Parse(file); err == nil {
+				base = path.Base(u.Path)
+			}
+		}
+		name := strings.TrimSuffix(base, path.Ext(base))
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("template partials %s and %s have the same name %q", other, file, name)
+		}
+		names[name] = file
+		text, err := readTemplateFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read template partial %s: %v", file, err)
+		}
+		templatePartialList = append(templatePartialList, templates.Partial{Name: name, Text: string(text)})
+	}
+	return nil
+}
+
+// fallbackSubject is the subject used, with --fallbackOnTemplateError, when
+// the subject template fails to resolve.
+func fallbackSubject(event *corev2.Event) string {
+	check := eventCheck(event)
+	return fmt.Sprintf("Sensu Alert - %s/%s: %s", event.Entity.Name, check.Name, check.State)
+}
+
+// fallbackBody is the plain text body used, with --fallbackOnTemplateError,
+// when a template fails to resolve. It contains the template error and the
+// output of the checks, so the alert is not lost.
+func fallbackBody(templateErr error, events ...*corev2.Event) string {
+	fmt.Printf("Sending fallback email: failed to resolve template: %v\n", templateErr)
+	var b strings.Builder
+	fmt.Fprintf(&b, "The email template failed to resolve: %v\n", templateErr)
+	for _, event := range events {
+		check := eventCheck(event)
+		fmt.Fprintf(&b, "\n%s/%s: %s (status %d)\n%s\n", event.Entity.Name, check.Name,
+			check.State, check.Status, check.Output)
+	}
+	return b.String()
+}
+
+// truncate shortens the text to at most n characters, ending it with "..."
+// if it was truncated, for the Truncate template function.
+func truncate(n int, text string) string {
+	if n < 0 || utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	if n <= 3 {
+		return string([]rune(text)[:n])
+	}
+	return string([]rune(text)[:n-3]) + "..."
+}
+
+// wrap word wraps the lines of the text longer than the width at spaces,
+// for the Wrap template function. Shorter lines are left as they are, and
+// words longer than the width, such as URLs, are never broken.
+func wrap(width int, text string) string {
+	if width <= 0 {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		var b strings.Builder
+		runes := []rune(line)
+		for len(runes) > width {
+			cut := -1
+			for j := width; j > 0; j-- {
+				if runes[j] == ' ' {
+					cut = j
+					break
+				}
+			}
+			for j := width + 1; cut < 0 && j < len(runes); j++ {
+				if runes[j] == ' ' {
+					cut = j
+				}
+			}
+			if cut < 0 {
+				break
+			}
+			b.WriteString(string(runes[:cut]) + "\n")
+			runes = runes[cut+1:]
+		}
+		b.WriteString(string(runes))
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// firstError returns the first of the errors that is not nil.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const classicTemplate = `{{Translate "Sensu Alert"}} - {{.Entity.Name}}/{{.Check.Name}}: {{Translate (StatusName .Check.Status)}}
+
+{{printf "%-13s" (print (Translate "Entity") ":")}}{{.Entity.Name}}
+{{printf "%-13s" (print (Translate "Check") ":")}}{{.Check.Name}}
+{{printf "%-13s" (print (Translate "Namespace") ":")}}{{.Entity.Namespace}}
+{{printf "%-13s" (print (Translate "Status") ":")}}{{Translate (StatusName .Check.Status)}} ({{.Check.Status}})
+{{printf "%-13s" (print (Translate "Occurrences") ":")}}{{.Check.Occurrences}}
+{{printf "%-13s" (print (Translate "Executed") ":")}}{{UnixTime .Check.Executed}}
+{{printf "%-13s" (print (Translate "Last OK") ":")}}{{if .Check.LastOK}}{{UnixTime .Check.LastOK}}{{else}}{{Translate "never"}}{{end}}{{if .DashboardLink}}
+{{printf "%-13s" (print (Translate "Dashboard") ":")}}{{.DashboardLink}}{{end}}{{if and .SilenceLink (not .IsResolved)}}
+{{printf "%-13s" (print (Translate "Silence") ":")}}{{.SilenceLink}} ({{.SilenceDuration}}){{end}}
+
+{{Translate "Output"}}:
+{{.Check.Output}}
+{{range .Check.Hooks}}
+{{Translate "Hook"}} {{.Name}} ({{.Command}}):
+{{.Output}}
+{{end}}
+{{Translate "History"}}: {{range .Check.History}}{{.Status}} {{end}}
+{{if .RelatedEvents}}
+{{Translate "Other active alerts on"}} {{.Entity.Name}}:
+{{range .RelatedEvents}}  [{{Translate (StatusName .Check.Status)}}] {{.Check.Name}}: {{.Check.Output}}
+{{end}}{{end}}{{if .Metrics}}{{if .Metrics.Points}}
+{{Translate "Metrics"}}:
+{{MetricsTable .Metrics}}{{end}}{{end}}{{if .IncludeSystemInfo}}
+{{template "systemInfo" .}}{{end}}`
+
+const tableTemplate = `<html>
+<body style="font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333;">
+<table cellpadding="8" cellspacing="0" style="border-collapse: collapse; min-width: 600px;">
+  <tr>
+    <td colspan="2" style="background-color: {{StatusColor .Check.Status}}; color: #ffffff; font-size: 18px; font-weight: bold;">
+      {{Translate (StatusName .Check.Status)}} - {{.Entity.Name}}/{{.Check.Name}}
+    </td>
+  </tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Entity"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.Name}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Check"}}</td><td style="border: 1px solid #dddddd;">{{.Check.Name}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Namespace"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.Namespace}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Status"}}</td><td style="border: 1px solid #dddddd;">{{Translate (StatusName .Check.Status)}} ({{.Check.Status}})</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Occurrences"}}</td><td style="border: 1px solid #dddddd;">{{.Check.Occurrences}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Executed"}}</td><td style="border: 1px solid #dddddd;">{{UnixTime .Check.Executed}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Last OK"}}</td><td style="border: 1px solid #dddddd;">{{if .Check.LastOK}}{{UnixTime .Check.LastOK}}{{else}}{{Translate "never"}}{{end}}</td></tr>
+  {{if .DashboardLink}}<tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Dashboard"}}</td><td style="border: 1px solid #dddddd;"><a href="{{.DashboardLink}}">{{Translate "View in Sensu"}}</a></td></tr>{{end}}
+  {{if and .SilenceLink (not .IsResolved)}}<tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Silence"}}</td><td style="border: 1px solid #dddddd;"><a href="{{.SilenceLink}}">{{Translate "Silence for"}} {{.SilenceDuration}}</a></td></tr>{{end}}
+  <tr>
+    <td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "History"}}</td>
+    <td style="border: 1px solid #dddddd;">{{range .Check.History}}<span title="{{UnixTime .Executed}}" style="display: inline-block; width: 12px; height: 12px; margin-right: 2px; background-color: {{StatusColor .Status}};"></span>{{end}}</td>
+  </tr>
+  <tr><td colspan="2" style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Output"}}</td></tr>
+  <tr><td colspan="2" style="border: 1px solid #dddddd;"><pre style="white-space: pre-wrap;">{{.Check.Output}}</pre></td></tr>
+  {{range .Check.Hooks}}
+  <tr><td colspan="2" style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Hook"}} {{.Name}} ({{.Command}})</td></tr>
+  <tr><td colspan="2" style="border: 1px solid #dddddd;"><pre style="white-space: pre-wrap;">{{.Output}}</pre></td></tr>
+  {{end}}
+  {{if .RelatedEvents}}
+  <tr><td colspan="2" style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Other active alerts on"}} {{.Entity.Name}}</td></tr>
+  {{range .RelatedEvents}}<tr><td style="border: 1px solid #dddddd; color: {{StatusColor .Check.Status}};">{{Translate (StatusName .Check.Status)}}</td><td style="border: 1px solid #dddddd;">{{.Check.Name}}: {{.Check.Output}}</td></tr>
+  {{end}}{{end}}
+  {{if .Metrics}}{{if .Metrics.Points}}
+  <tr><td colspan="2" style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Metrics"}}</td></tr>
+  <tr><td colspan="2" style="border: 1px solid #dddddd;">{{MetricsHTMLTable .Metrics}}</td></tr>
+  {{end}}{{end}}
+</table>
+{{if .IncludeSystemInfo}}{{template "systemInfoHTML" .}}{{end}}
+</body>
+</html>
+`
+
+const compactHTMLTemplate = `<html>
+<body style="font-family: Helvetica, Arial, sans-serif; font-size: 13px;">
+<p><span style="color: {{StatusColor .Check.Status}}; font-weight: bold;">{{Translate (StatusName .Check.Status)}}</span>
+<b>{{.Entity.Name}}/{{.Check.Name}}</b> ({{.Check.Occurrences}} {{Translate "occurrences"}}, {{Translate "executed"}} {{UnixTime .Check.Executed}})</p>
+<pre style="white-space: pre-wrap;">{{.Check.Output}}</pre>
+{{if .IncludeSystemInfo}}{{template "systemInfoHTML" .}}{{end}}
+</body>
+</html>
+`
+
+// keepaliveTemplates returns the subject and body templates for keepalive
+// events. Unless --keepaliveBodyTemplateFile is set, the built-in keepalive
+// template is used, in HTML if the regular body is.
+func keepaliveTemplates() (string, string) {
+	if len(keepaliveBodyTemplate) > 0 {
+		return config.KeepaliveSubjectTemplate, keepaliveBodyTemplate
+	}
+	if bodyContentType(emailBodyTemplate) == ContentHTML {
+		return config.KeepaliveSubjectTemplate, keepaliveHTMLTemplate
+	}
+	return config.KeepaliveSubjectTemplate, keepaliveTemplate
+}
+
+const keepaliveTemplate = `{{Translate "Entity"}} {{.Entity.Name}} {{if .IsResolved}}{{Translate "is reporting again"}}.{{else if .Entity.LastSeen}}{{Translate "stopped reporting at"}} {{UnixTime .Entity.LastSeen}}.{{else}}{{Translate "stopped reporting"}}.{{end}}
+
+{{printf "%-15s" (print (Translate "Namespace") ":")}}{{.Entity.Namespace}}
+{{printf "%-15s" (print (Translate "Last seen") ":")}}{{if .Entity.LastSeen}}{{UnixTime .Entity.LastSeen}}{{else}}{{Translate "never"}}{{end}}
+{{printf "%-15s" (print (Translate "Hostname") ":")}}{{.Entity.System.Hostname}}
+{{printf "%-15s" (print (Translate "Platform") ":")}}{{.Entity.System.Platform}} {{.Entity.System.PlatformVersion}} ({{.Entity.System.OS}}/{{.Entity.System.Arch}})
+{{printf "%-15s" (print (Translate "Addresses") ":")}}{{range .Entity.System.Network.Interfaces}}{{range .Addresses}}{{.}} {{end}}{{end}}
+{{printf "%-15s" (print (Translate "Agent") ":")}}{{.Entity.SensuAgentVersion}}
+{{printf "%-15s" (print (Translate "Subscriptions") ":")}}{{range $i, $s := .Entity.Subscriptions}}{{if $i}}, {{end}}{{$s}}{{end}}{{if .DashboardLink}}
+{{printf "%-15s" (print (Translate "Dashboard") ":")}}{{.DashboardLink}}{{end}}
+
+{{.Check.Output}}
+`
+
+const keepaliveHTMLTemplate = `<html>
+<body style="font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #333333;">
+<p style="color: {{StatusColor .Check.Status}}; font-size: 18px; font-weight: bold;">{{Translate "Entity"}} {{.Entity.Name}} {{if .IsResolved}}{{Translate "is reporting again"}}.{{else if .Entity.LastSeen}}{{Translate "stopped reporting at"}} {{UnixTime .Entity.LastSeen}}.{{else}}{{Translate "stopped reporting"}}.{{end}}</p>
+<table cellpadding="8" cellspacing="0" style="border-collapse: collapse; min-width: 600px;">
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Namespace"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.Namespace}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Last seen"}}</td><td style="border: 1px solid #dddddd;">{{if .Entity.LastSeen}}{{UnixTime .Entity.LastSeen}}{{else}}{{Translate "never"}}{{end}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Hostname"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.System.Hostname}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Platform"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.System.Platform}} {{.Entity.System.PlatformVersion}} ({{.Entity.System.OS}}/{{.Entity.System.Arch}})</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Addresses"}}</td><td style="border: 1px solid #dddddd;">{{range .Entity.System.Network.Interfaces}}{{range .Addresses}}{{.}}<br>{{end}}{{end}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Agent"}}</td><td style="border: 1px solid #dddddd;">{{.Entity.SensuAgentVersion}}</td></tr>
+  <tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Subscriptions"}}</td><td style="border: 1px solid #dddddd;">{{range $i, $s := .Entity.Subscriptions}}{{if $i}}, {{end}}{{$s}}{{end}}</td></tr>
+  {{if .DashboardLink}}<tr><td style="border: 1px solid #dddddd; font-weight: bold;">{{Translate "Dashboard"}}</td><td style="border: 1px solid #dddddd;"><a href="{{.DashboardLink}}">{{Translate "View in Sensu"}}</a></td></tr>{{end}}
+</table>
+<pre style="white-space: pre-wrap;">{{.Check.Output}}</pre>
+</body>
+</html>
+`
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/templates"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinTemplates(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.Output = "<script>alert(1)</script>"
+	event.Check.History = []corev2.CheckHistory{{Status: 0, Executed: 1}, {Status: 2, Executed: 2}}
+
+	for name, tmpl := range builtinTemplates {
+		t.Run(name, func(t *testing.T) {
+			contentType := ContentPlain
+			if strings.Contains(tmpl, "<html") {
+				contentType = ContentHTML
+			}
+			out, err := resolveTemplate(tmpl, event, contentType)
+			assert.NoError(t, err)
+			assert.Contains(t, out, "foo/bar")
+			assert.Contains(t, out, "CRITICAL")
+			if contentType == ContentHTML {
+				assert.Contains(t, out, templates.StatusColor(2))
+				assert.NotContains(t, out, "<script>")
+			}
+		})
+	}
+}
+
+func TestSystemInfo(t *testing.T) {
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Entity.System = corev2.System{
+		Hostname: "foo.example.com", OS: "linux", Platform: "ubuntu", PlatformVersion: "20.04", Arch: "amd64",
+		Network: corev2.Network{Interfaces: []corev2.NetworkInterface{
+			{Name: "lo"},
+			{Name: "eth0", Addresses: []string{"10.0.0.5/24", "fe80::1/64"}},
+		}},
+	}
+	out, err := resolveTemplate(defaultBodyTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.NotContains(t, out, "foo.example.com")
+
+	config.IncludeSystemInfo = true
+	defer func() { config.IncludeSystemInfo = false }()
+	out, err = resolveTemplate(defaultBodyTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "\nSystem:\n  Hostname:    foo.example.com\n  Platform:    ubuntu 20.04 (linux)\n"+
+		"  Architecture:amd64\n  eth0:        10.0.0.5/24, fe80::1/64\n")
+	for name, tmpl := range builtinTemplates {
+		contentType := ContentPlain
+		if strings.Contains(tmpl, "<html") {
+			contentType = ContentHTML
+		}
+		out, err := resolveTemplate(tmpl, event, contentType)
+		assert.NoError(t, err, name)
+		assert.Contains(t, out, "10.0.0.5/24, fe80::1/64", name)
+	}
+
+	// the partials can also be used by other templates
+	out, err = resolveTemplate(`<html>{{template "systemInfoHTML" .}}</html>`, event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Contains(t, out, ">foo.example.com</td>")
+}
+
+func TestTemplatePartials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partials")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { templatePartialList = nil }()
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "header.tmpl"), []byte("<h1>{{.Entity.Name}}/{{.Check.Name}}</h1>"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("{{"), 0600))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0700))
+	footer := filepath.Join(dir, "sub", "footer.html")
+	assert.NoError(t, ioutil.WriteFile(footer, []byte(`{{define "signature"}}Sensu{{end}}<p>{{template "signature"}}</p>`), 0600))
+
+	assert.NoError(t, loadTemplatePartials([]string{dir + "," + footer}))
+	assert.Len(t, templatePartialList, 2)
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = "<b>failing</b>"
+	out, err := resolveTemplate(`<html>{{template "header" .}}{{.Check.Output}}{{template "footer" .}}</html>`, event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Equal(t, "<html><h1>foo/bar</h1>&lt;b&gt;failing&lt;/b&gt;<p>Sensu</p></html>", out)
+	out, err = resolveTemplate(`{{template "signature"}}: {{.Check.Name}}`, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensu: bar", out)
+
+	err = loadTemplatePartials([]string{footer, filepath.Join(dir, "footer.tmpl")})
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "footer.tmpl"), nil, 0600))
+	err = loadTemplatePartials([]string{footer, dir})
+	assert.EqualError(t, err, "template partials "+footer+" and "+filepath.Join(dir, "footer.tmpl")+" have the same name \"footer\"")
+}
+
+func TestFallbackOnTemplateError(t *testing.T) {
+	config.SubjectTemplate = "{{.Check.Name}}"
+	emailBodyTemplate = "<html>{{.Check.Missing}}</html>"
+	defer func() {
+		config.SubjectTemplate = ""
+		config.FallbackOnTemplateError = false
+		emailBodyTemplate = "{{.Check.Output}}"
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.State = corev2.EventFailingState
+	event.Check.Output = "disk full"
+	_, _, _, err := composeEmail(event)
+	assert.Error(t, err)
+
+	config.FallbackOnTemplateError = true
+	subject, body, contentType, err := composeEmail(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", subject)
+	assert.Equal(t, ContentPlain, contentType)
+	assert.True(t, strings.HasPrefix(body, "The email template failed to resolve: "))
+	assert.Contains(t, body, "Missing")
+	assert.Contains(t, body, "\nfoo/bar: failing (status 2)\ndisk full\n")
+
+	config.SubjectTemplate = "{{.Check.Name"
+	subject, body, _, err = composeEmail(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensu Alert - foo/bar: failing", subject)
+	assert.Contains(t, body, "unclosed action")
+}
+
+func TestTemplateDelims(t *testing.T) {
+	left, right, err := parseTemplateDelims("[[, ]]")
+	assert.NoError(t, err)
+	assert.Equal(t, "[[", left)
+	assert.Equal(t, "]]", right)
+	_, _, err = parseTemplateDelims("[[")
+	assert.EqualError(t, err, `template delimiters "[[" are not of the form "left,right"`)
+	_, _, err = parseTemplateDelims(",]]")
+	assert.Error(t, err)
+
+	templateLeftDelim, templateRightDelim = left, right
+	defer func() { templateLeftDelim, templateRightDelim = "{{", "}}" }()
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = "disk full"
+	out, err := resolveTemplate("[[.Entity.Name]]: {{ upstream }} [[StatusName .Check.Status]]", event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo: {{ upstream }} OK", out)
+
+	// the handler's own templates still use the standard delimiters
+	out, err = resolveTemplate(defaultSubjectTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sensu Alert - foo/bar: passing", out)
+	out, err = resolveTemplate(builtinTemplates["compact-html"], event, ContentHTML)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "disk full")
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate(10, "short"))
+	assert.Equal(t, "disk is...", truncate(10, "disk is 95% full"))
+	assert.Equal(t, "Crème ...", truncate(9, "Crème brûlée"))
+	assert.Equal(t, "ab", truncate(2, "abcdef"))
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = strings.Repeat("x", 600)
+	out, err := resolveTemplate("{{Truncate 500 .Check.Output}}", event, ContentPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", 497)+"...", out)
+}
+
+func TestWrap(t *testing.T) {
+	assert.Equal(t, "the quick\nbrown fox\njumps", wrap(10, "the quick brown fox jumps"))
+	assert.Equal(t, "short\r\n  aligned  columns\nhttps://example.com/a/very/long/url\nnext", wrap(20, "short\r\n  aligned  columns\nhttps://example.com/a/very/long/url next"))
+	assert.Equal(t, "unchanged", wrap(0, "unchanged"))
+
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Output = strings.TrimSpace(strings.Repeat("output ", 20))
+	out, err := resolveTemplate(defaultBodyTemplate, event, ContentPlain)
+	assert.NoError(t, err)
+	for _, line := range strings.Split(out, "\n") {
+		assert.True(t, len(line) <= 78, "line too long: %q", line)
+	}
+	assert.Equal(t, event.Check.Output, strings.Replace(out, "\n", " ", -1))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// unsubscribeLink resolves --unsubscribeURL for the event. A digest covers
+// many entities/checks, so has no link. A link that cannot be resolved, or
+// isn't a valid URL, is left out rather than failing the alert.
+func unsubscribeLink(event *corev2.Event) string {
+	if len(config.UnsubscribeURL) == 0 || len(digestEvents) > 0 {
+		return ""
+	}
+	link, err := resolveTemplate(config.UnsubscribeURL, event, ContentPlain)
+	if err != nil {
+		fmt.Printf("Not including the unsubscribe link: %v\n", err)
+		return ""
+	}
+	link = strings.TrimSpace(link)
+	if !validLink(link) {
+		fmt.Printf("Not including the unsubscribe link: invalid URL %q\n", link)
+		return ""
+	}
+	return link
+}
+
+// validLink reports whether the resolved link is a URL that is safe to put in
+// a header or an email body.
+func validLink(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "mailto") &&
+		!strings.ContainsAny(link, "<>\"\r\n")
+}
+
+// addUnsubscribeFooter appends a link to silence the alert to the body, in
+// the body's format.
+func addUnsubscribeFooter(body, contentType, link string) string {
+	const text = "Silence this alert"
+	switch {
+	case config.BodyFormat == BodyFormatMarkdown:
+		return body + "\n\n---\n\n[" + text + "](<" + link + ">)\n"
+	case contentType == ContentHTML:
+		footer := fmt.Sprintf("<hr>\n<p><a href=\"%s\">%s</a></p>\n", html.EscapeString(link), text)
+		// keep the footer inside the document's body
+		if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+			return body[:i] + footer + body[i:]
+		}
+		return body + footer
+	default:
+		return body + "\n\n-- \n" + text + ": " + link + "\n"
+	}
+}
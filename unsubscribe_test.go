@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsubscribeLink(t *testing.T) {
+	defer func() {
+		config.UnsubscribeURL = ""
+		digestEvents = nil
+	}()
+	event := corev2.FixtureEvent("foo", "bar")
+	assert.Empty(t, unsubscribeLink(event))
+
+	config.UnsubscribeURL = "https://sensu.example.com/{{.Entity.Namespace}}/silencing/new?check={{.Check.Name}}&subscription=entity:{{.Entity.Name}}"
+	assert.Equal(t, "https://sensu.example.com/default/silencing/new?check=bar&subscription=entity:foo", unsubscribeLink(event))
+
+	digestEvents = []*corev2.Event{event}
+	assert.Empty(t, unsubscribeLink(event))
+	digestEvents = nil
+
+	// invalid links are left out
+	config.UnsubscribeURL = "javascript:alert(1)"
+	assert.Empty(t, unsubscribeLink(event))
+	config.UnsubscribeURL = "https://sensu.example.com/silence/{{.Check.Output}}"
+	event.Check.Output = "100%zz"
+	assert.Empty(t, unsubscribeLink(event))
+	event.Check.Output = `"quoted"`
+	assert.Empty(t, unsubscribeLink(event))
+	config.UnsubscribeURL = "https://sensu.example.com/{{.Missing}}"
+	assert.Empty(t, unsubscribeLink(event))
+}
+
+func TestAddUnsubscribeFooter(t *testing.T) {
+	link := "https://sensu.example.com/silence?a=1&b=2"
+	assert.Equal(t, "disk full\n\n-- \nSilence this alert: "+link+"\n",
+		addUnsubscribeFooter("disk full", ContentPlain, link))
+	assert.Equal(t, "<html><body><p>disk full</p><hr>\n<p><a href=\"https://sensu.example.com/silence?a=1&amp;b=2\">Silence this alert</a></p>\n</BODY></html>",
+		addUnsubscribeFooter("<html><body><p>disk full</p></BODY></html>", ContentHTML, link))
+
+	config.BodyFormat = BodyFormatMarkdown
+	defer func() { config.BodyFormat = "" }()
+	assert.Equal(t, "disk full\n\n---\n\n[Silence this alert](<"+link+">)\n",
+		addUnsubscribeFooter("disk full", ContentPlain, link))
+}
+
+func TestComposeAndTransmitUnsubscribe(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.UnsubscribeURL = "https://sensu.example.com/silence/{{.Entity.Name}}/{{.Check.Name}}"
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.UnsubscribeURL = ""
+	}()
+
+	event := corev2.FixtureEvent("foo", "bar")
+	err := composeAndTransmit(event, newMessageID(), rcpts{"ops@example.com"}, nil, nil, "disk", "disk full\n", ContentPlain)
+	assert.NoError(t, err)
+	msg, err := mail.ReadMessage(strings.NewReader(<-messages))
+	assert.NoError(t, err)
+	assert.Equal(t, "<https://sensu.example.com/silence/foo/bar>", msg.Header.Get("List-Unsubscribe"))
+
+	// the alert is still sent without an invalid link
+	port, messages = startSMTPServer(t)
+	config.SmtpPort = port
+	event.Check.Name = "100%zz"
+	assert.NoError(t, composeAndTransmit(event, newMessageID(), rcpts{"ops@example.com"}, nil, nil, "disk", "disk full\n", ContentPlain))
+	msg, err = mail.ReadMessage(strings.NewReader(<-messages))
+	assert.NoError(t, err)
+	assert.Empty(t, msg.Header.Get("List-Unsubscribe"))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// modes selected by the first command line argument instead of handling an
+// event from stdin
+const (
+	modeValidate        = "validate"
+	modeTest            = "test"
+	modeCapture         = "capture"
+	modeInit            = "init"
+	modeCheckConnection = checkConnection
+	modeFlushSpool      = flushSpool
+	modeDaemon          = listen
+)
+
+// the mode the handler is running in, empty when handling an event
+var mode string
+
+// sampleEvent is used in place of an event from stdin when running in a mode.
+const sampleEvent = `{
+  "timestamp": 1600000000,
+  "entity": {
+    "entity_class": "agent",
+    "metadata": {"name": "sample-entity", "namespace": "default"}
+  },
+  "check": {
+    "metadata": {"name": "sample-check", "namespace": "default"},
+    "command": "check-sample",
+    "interval": 60,
+    "status": 2,
+    "occurrences": 1,
+    "executed": 1600000000,
+    "issued": 1600000000,
+    "output": "CRITICAL: sample check output",
+    "state": "failing",
+    "history": [{"status": 0, "executed": 1599999940}, {"status": 2, "executed": 1600000000}]
+  }
+}`
+
+// parseMode removes the mode, if any, from the command line arguments and
+// reports whether one was given. --checkConnection, --flushSpool and --listen
+// are also modes, as no event is read from stdin when they are set.
+func parseMode() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	switch os.Args[1] {
+	case modeValidate, modeTest, modeCapture, modeInit:
+		mode = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		return true
+	}
+	for _, arg := range os.Args[1:] {
+		for _, flag := range []string{checkConnection, flushSpool, listen} {
+			if arg == "--"+flag || (strings.HasPrefix(arg, "--"+flag+"=") && arg != "--"+flag+"=false") {
+				mode = flag
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadEventFile reads an event from a JSON file.
+func loadEventFile(file string) (*corev2.Event, error) {
+	eventBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event file %s: %v", file, err)
+	}
+	event := &corev2.Event{}
+	if err := json.Unmarshal(eventBytes, event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event file %s: %v", file, err)
+	}
+	if err := event.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid event in %s: %v", file, err)
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	// the file's event replaces the sample event for --attachEventJSON
+	eventJSON = eventBytes
+	return event, nil
+}
+
+// sendTestEmail sends an email for the event (or the event in --eventFile)
+// to the configured recipients, regardless of any suppression, to verify the
+// SMTP configuration end to end, reporting the recipients to out.
+func sendTestEmail(event *corev2.Event, out io.Writer) error {
+	if len(config.EventFile) > 0 {
+		fileEvent, err := loadEventFile(config.EventFile)
+		if err != nil {
+			return err
+		}
+		event = fileEvent
+	}
+
+	subject, body, contentType, err := composeEmail(event)
+	if err != nil {
+		return err
+	}
+	to, cc, bcc := eventRcpts(event)
+	if err := deliverEmail(event, to, cc, bcc, subject, body, contentType); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Test email sent to %s\n", envelopeRcpts(to, cc, bcc))
+	return nil
+}
+
+// validateTemplates executes each configured template against the event (or
+// the event in --eventFile), printing the result and any error.
+func validateTemplates(event *corev2.Event) error {
+	if len(config.EventFile) > 0 {
+		fileEvent, err := loadEventFile(config.EventFile)
+		if err != nil {
+			return err
+		}
+		event = fileEvent
+	}
+
+	failed := 0
+	validate := func(name, templateValue string, resolve func() (string, error)) {
+		if len(templateValue) == 0 {
+			return
+		}
+		resolved, err := resolve()
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("OK   %s:\n%s\n", name, resolved)
+	}
+	validateEvent := func(name, templateValue, contentType string) {
+		validate(name, templateValue, func() (string, error) {
+			return resolveTemplate(templateValue, event, contentType)
+		})
+	}
+
+	bodyName := "body template"
+	if len(config.BodyTemplateFile) > 0 && len(config.BodyTemplate) == 0 {
+		bodyName += " " + config.BodyTemplateFile
+	}
+	subjectName := "subject template"
+	if len(config.SubjectTemplateFile) > 0 {
+		subjectName += " " + config.SubjectTemplateFile
+	}
+	validateEvent(subjectName, config.SubjectTemplate, ContentPlain)
+	validateEvent(bodyName, emailBodyTemplate, bodyContentType(emailBodyTemplate))
+	validateEvent("resolved subject template", config.ResolvedSubjectTemplate, ContentPlain)
+	validateEvent("resolved body template "+config.ResolvedBodyTemplateFile, resolvedBodyTemplate, bodyContentType(resolvedBodyTemplate))
+	keepalive := *event
+	keepaliveCheck := *event.Check
+	keepaliveCheck.Name = corev2.KeepaliveCheckName
+	keepalive.Check = &keepaliveCheck
+	validate("keepalive subject template", config.KeepaliveSubjectTemplate, func() (string, error) {
+		return resolveTemplate(config.KeepaliveSubjectTemplate, &keepalive, ContentPlain)
+	})
+	validate("keepalive body template "+config.KeepaliveBodyTemplateFile, keepaliveBodyTemplate, func() (string, error) {
+		return resolveTemplate(keepaliveBodyTemplate, &keepalive, bodyContentType(keepaliveBodyTemplate))
+	})
+	if config.SuppressFlapping {
+		validateEvent("flapping subject template", config.FlappingSubjectTemplate, ContentPlain)
+	}
+	for _, h := range config.ExtraHeaders {
+		name, value, _ := parseExtraHeader(h)
+		validateEvent("extra header "+name, value, ContentPlain)
+	}
+	validateEvent("chart image URL", config.ChartImageURL, ContentPlain)
+
+	d := newDigest([]*corev2.Event{event})
+	validate("digest subject template", config.DigestSubjectTemplate, func() (string, error) {
+		return resolveTemplateData(config.DigestSubjectTemplate, d, ContentPlain)
+	})
+	validate("digest body template", digestBodyTemplate, func() (string, error) {
+		return resolveTemplateData(digestBodyTemplate, d, bodyContentType(digestBodyTemplate))
+	})
+
+	if failed > 0 {
+		return errors.New("template validation failed")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMode(t *testing.T) {
+	args := os.Args
+	defer func() {
+		os.Args = args
+		mode = ""
+	}()
+
+	os.Args = []string{"sensu-email-handler", "-t", "foo@example.com"}
+	assert.False(t, parseMode())
+	assert.Empty(t, mode)
+
+	os.Args = []string{"sensu-email-handler", "validate", "-T", "body.tmpl"}
+	assert.True(t, parseMode())
+	assert.Equal(t, modeValidate, mode)
+	assert.Equal(t, []string{"sensu-email-handler", "-T", "body.tmpl"}, os.Args)
+}
+
+func TestValidateTemplates(t *testing.T) {
+	file, err := ioutil.TempFile("", "event")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(sampleEvent)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	event, err := loadEventFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "sample-entity", event.Entity.Name)
+	assert.Equal(t, "sample-check", event.Check.Name)
+
+	config.SubjectTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	config.EventFile = file.Name()
+	defer func() {
+		config.SubjectTemplate = ""
+		config.EventFile = ""
+		emailBodyTemplate = "{{.Check.Output}}"
+	}()
+	assert.NoError(t, validateTemplates(corev2.FixtureEvent("foo", "bar")))
+
+	emailBodyTemplate = "{{.Check.Output}}\n{{.Check.Nope}}"
+	assert.Error(t, validateTemplates(event))
+	emailBodyTemplate = "{{if .Check.Output}}"
+	assert.Error(t, validateTemplates(event))
+}
+
+func TestSendTestEmail(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.FromHeader = "<sensu@example.com>"
+	config.ToEmail = []string{"ops@example.com"}
+	config.CcEmail = []string{"lead@example.com"}
+	config.BccEmail = []string{"audit@example.com"}
+	config.SubjectTemplate = "{{.Entity.Name}}/{{.Check.Name}}"
+	config.MinOccurrences = 10
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.FromHeader = ""
+		config.ToEmail = nil
+		config.CcEmail = nil
+		config.BccEmail = nil
+		config.SubjectTemplate = ""
+		config.MinOccurrences = 0
+	}()
+
+	// suppression does not apply to test emails
+	event := corev2.FixtureEvent("foo", "bar")
+	event.Check.Status = 2
+	event.Check.Output = "test output"
+	assert.NoError(t, sendTestEmail(event, ioutil.Discard))
+	msg := <-messages
+	assert.Contains(t, msg, "To: ops@example.com\n")
+	assert.Contains(t, msg, "Cc: lead@example.com\n")
+	assert.NotContains(t, msg, "audit@example.com")
+	assert.Contains(t, msg, "Subject: foo/bar\n")
+	assert.Contains(t, msg, "test output")
+}
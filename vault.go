@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault auth methods
+const (
+	VaultAuthToken      = "token"
+	VaultAuthKubernetes = "kubernetes"
+	VaultAuthAppRole    = "approle"
+)
+
+// the service account token used to log in with Vault's Kubernetes auth
+var vaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultClient makes requests to the Vault HTTP API.
+type vaultClient struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func newVaultClient(address string) *vaultClient {
+	return &vaultClient{
+		address: strings.TrimSuffix(address, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// request makes a request to the API path, decoding the "data" or "auth" of
+// the response into result.
+func (v *vaultClient) request(method, path string, body interface{}, result interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, v.address+"/v1/"+strings.TrimPrefix(path, "/"), bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	if len(v.token) > 0 {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(respBody, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(vaultErr.Errors, ", "))
+		}
+		return errors.New(resp.Status)
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+// login authenticates to Vault with the configured auth method.
+func (v *vaultClient) login() error {
+	var body map[string]string
+	switch config.VaultAuthMethod {
+	case VaultAuthToken:
+		if len(config.VaultToken) == 0 {
+			return errors.New("--vaultToken is required for token auth")
+		}
+		v.token = config.VaultToken
+		return nil
+	case VaultAuthKubernetes:
+		jwt, err := ioutil.ReadFile(vaultKubernetesTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read Kubernetes service account token: %v", err)
+		}
+		body = map[string]string{"role": config.VaultRole, "jwt": strings.TrimSpace(string(jwt))}
+	case VaultAuthAppRole:
+		body = map[string]string{"role_id": config.VaultRole, "secret_id": config.VaultSecretID}
+	default:
+		return fmt.Errorf("%s is not a valid Vault auth method", config.VaultAuthMethod)
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.request(http.MethodPost, "auth/"+config.VaultAuthMethod+"/login", body, &login); err != nil {
+		return fmt.Errorf("failed to log in to Vault: %v", err)
+	}
+	v.token = login.Auth.ClientToken
+	return nil
+}
+
+// readSecret returns the key/value pairs of the secret, from either version
+// of the KV secrets engine.
+func (v *vaultClient) readSecret(path string) (map[string]string, error) {
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.request(http.MethodGet, path, nil, &secret); err != nil {
+		return nil, fmt.Errorf("failed to read Vault secret %s: %v", path, err)
+	}
+	data := secret.Data
+	// KV version 2 nests the secret in data and adds metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	values := make(map[string]string, len(data))
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			values[key] = s
+		}
+	}
+	return values, nil
+}
+
+// loadVaultCredentials sets the SMTP username and password from the
+// "username" and "password" keys of the Vault secret.
+func loadVaultCredentials() error {
+	if len(config.SmtpPassword) > 0 {
+		return errors.New("--smtpPassword and --vaultSecretPath are mutually exclusive")
+	}
+	v := newVaultClient(config.VaultAddress)
+	if err := v.login(); err != nil {
+		return err
+	}
+	secret, err := v.readSecret(config.VaultSecretPath)
+	if err != nil {
+		return err
+	}
+	password, ok := secret["password"]
+	if !ok {
+		return fmt.Errorf("no password in Vault secret %s", config.VaultSecretPath)
+	}
+	config.SmtpPassword = password
+	if username, ok := secret["username"]; ok && len(config.SmtpUsername) == 0 {
+		config.SmtpUsername = username
+	}
+	return nil
+}
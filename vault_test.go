@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadVaultCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login", "/v1/auth/kubernetes/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["secret_id"] != "secret-id" && body["jwt"] != "service-account-jwt" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors": ["invalid credentials"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth": {"client_token": "client-token"}}`))
+		case "/v1/secret/data/smtp":
+			if r.Header.Get("X-Vault-Token") != "client-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": {"data": {"username": "vault-user", "password": "vault-password"}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/smtp":
+			_, _ = w.Write([]byte(`{"data": {"password": "kv1-password"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config.VaultAddress = server.URL
+	config.VaultAuthMethod = VaultAuthAppRole
+	config.VaultRole = "role-id"
+	config.VaultSecretID = "secret-id"
+	config.VaultSecretPath = "secret/data/smtp"
+	defer func() {
+		config.VaultAddress = ""
+		config.VaultAuthMethod = ""
+		config.VaultToken = ""
+		config.VaultRole = ""
+		config.VaultSecretID = ""
+		config.VaultSecretPath = ""
+		config.SmtpUsername = ""
+		config.SmtpPassword = ""
+	}()
+
+	assert.NoError(t, loadVaultCredentials())
+	assert.Equal(t, "vault-user", config.SmtpUsername)
+	assert.Equal(t, "vault-password", config.SmtpPassword)
+
+	// KV version 1 with a token, and a username given on the command line
+	config.SmtpUsername = "user"
+	config.SmtpPassword = ""
+	config.VaultAuthMethod = VaultAuthToken
+	config.VaultToken = "client-token"
+	config.VaultSecretPath = "kv/smtp"
+	assert.NoError(t, loadVaultCredentials())
+	assert.Equal(t, "user", config.SmtpUsername)
+	assert.Equal(t, "kv1-password", config.SmtpPassword)
+
+	// Kubernetes auth
+	jwtFile, err := ioutil.TempFile("", "jwt")
+	assert.NoError(t, err)
+	defer os.Remove(jwtFile.Name())
+	_, _ = jwtFile.WriteString("service-account-jwt\n")
+	jwtFile.Close()
+	defaultTokenFile := vaultKubernetesTokenFile
+	vaultKubernetesTokenFile = jwtFile.Name()
+	defer func() { vaultKubernetesTokenFile = defaultTokenFile }()
+	config.SmtpPassword = ""
+	config.VaultAuthMethod = VaultAuthKubernetes
+	config.VaultSecretPath = "secret/data/smtp"
+	assert.NoError(t, loadVaultCredentials())
+	assert.Equal(t, "vault-password", config.SmtpPassword)
+
+	config.SmtpPassword = ""
+	config.VaultAuthMethod = VaultAuthAppRole
+	config.VaultSecretID = "wrong"
+	err = loadVaultCredentials()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid credentials")
+}
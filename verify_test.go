@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sensu/sensu-email-handler/pkg/smtpsend"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyRecipients(t *testing.T) {
+	port, messages := startSMTPServer(t)
+	config.SmtpHost = "127.0.0.1"
+	config.SmtpPort = port
+	config.AuthMethod = AuthMethodNone
+	config.FromEmail = "sensu@example.com"
+	config.VerifyRecipients = true
+	defer func() {
+		config.SmtpHost = ""
+		config.SmtpPort = 0
+		config.AuthMethod = ""
+		config.FromEmail = ""
+		config.VerifyRecipients = false
+	}()
+	defer poolSMTP()()
+
+	err := transmit("", rcpts{"ops@example.com", "unknown@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n"))
+	var invalid *smtpsend.InvalidRecipientsError
+	assert.True(t, errors.As(err, &invalid))
+	assert.Equal(t, []string{"unknown@example.com"}, invalid.Recipients)
+	assert.Contains(t, err.Error(), "the email was sent to the other recipients")
+	assert.True(t, permanentError(err))
+	assert.Contains(t, <-messages, "disk full")
+
+	// nothing is sent when every recipient is invalid
+	err = transmit("", rcpts{"unknown@example.com"}, []byte("Subject: disk\r\n\r\ndisk full\r\n"))
+	assert.True(t, errors.As(err, &invalid))
+	assert.NotContains(t, err.Error(), "the email was sent")
+	assert.Len(t, smtpPool, 1)
+}